@@ -21,8 +21,10 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"regexp"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/percona/pmm-client/pmm"
@@ -32,6 +34,9 @@ import (
 var (
 	admin pmm.Admin
 
+	// ctx is canceled on SIGINT or SIGTERM so in-flight API requests are aborted.
+	ctx, cancel = context.WithCancel(context.Background())
+
 	rootCmd = &cobra.Command{
 		Use: "pmm-admin",
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
@@ -44,11 +49,13 @@ var (
 				return
 			}
 
+			requireRoot(cmd)
+
 			// The version flag will not run anywhere else than on rootCmd as this flag is not persistent
 			// and we want it only here without any additional checks.
 			if flagVersion {
 				fmt.Println(pmm.Version)
-				os.Exit(0)
+				exit(0)
 			}
 
 			if flagFormat != "" {
@@ -59,39 +66,53 @@ var (
 				admin.Format = "{{ json . }}"
 			}
 
-			if path := pmm.CheckBinaries(); path != "" {
+			if path := admin.CheckBinaries(); path != "" {
 				fmt.Println("Installation problem, one of the binaries is missing:", path)
-				os.Exit(1)
+				exit(1)
 			}
 
 			// Read config file.
 			if !pmm.FileExists(pmm.ConfigFile) {
 				fmt.Println("PMM client is not configured, missing config file. Please make sure you have run 'pmm-admin config'.")
-				os.Exit(1)
+				exit(1)
 			}
 
+			// Take the lock before reading the config, so parallel invocations don't overwrite changes of each other.
+			lockCommand(cmd)
+
 			if err := admin.LoadConfig(); err != nil {
 				fmt.Printf("Error reading config file %s: %s\n", pmm.ConfigFile, err)
-				os.Exit(1)
+				exit(1)
+			}
+
+			if !admin.ServerProfileExists() {
+				fmt.Printf("Server profile %s is not configured. Please make sure you have run 'pmm-admin config --server-profile %s'.\n",
+					admin.ServerProfile, admin.ServerProfile)
+				exit(1)
 			}
 
 			// Check for required settings in config file
 			// optional settings are marked with "omitempty"
 			if admin.Config.ServerAddress == "" || admin.Config.ClientName == "" || admin.Config.ClientAddress == "" || admin.Config.BindAddress == "" {
 				fmt.Println("PMM client is not configured properly. Please make sure you have run 'pmm-admin config'.")
-				os.Exit(1)
+				exit(1)
 			}
 
 			switch cmd.Name() {
 			case
 				"info",
-				"show-passwords":
-				// above cmds should work w/o connectivity, so we return before admin.SetAPI()
+				"show-passwords",
+				"cert",
+				"summary",
+				"migrate",
+				"service-user":
+				// above cmds should work w/o connectivity, so we return before admin.SetAPI(ctx)
 				return
 			case
 				"start",
 				"stop",
-				"restart":
+				"restart",
+				"logs":
 				if flagAll {
 					// above cmds should work w/o connectivity if flagAll is set
 					return
@@ -99,18 +120,21 @@ var (
 			}
 
 			// Set APIs and check if server is alive.
-			if err := admin.SetAPI(); err != nil {
+			if err := admin.SetAPI(ctx); err != nil {
 				fmt.Printf("%s\n", err)
-				os.Exit(1)
+				exit(1)
 			}
 
+			// Services of this client on PMM server may be changed from other systems or tools too.
+			lockNode(cmd)
+
 			// Proceed to "pmm-admin repair" if requested.
 			if cmd.Name() == "repair" {
 				return
 			}
 
 			// Check for broken installation.
-			orphanedServices, missingServices := admin.CheckInstallation()
+			orphanedServices, missingServices := admin.CheckInstallation(ctx)
 			if len(orphanedServices) > 0 {
 				fmt.Printf(`We have found system services disconnected from PMM server.
 Usually, this happens when data container is wiped before all monitoring services are removed or client is uninstalled.
@@ -119,7 +143,7 @@ Orphaned local services: %s
 
 To continue, run 'pmm-admin repair' to remove orphaned services.
 `, strings.Join(orphanedServices, ", "))
-				os.Exit(1)
+				exit(1)
 			}
 			if len(missingServices) > 0 {
 				fmt.Printf(`PMM server reports services that are missing locally.
@@ -131,12 +155,12 @@ Beware, if another system with the same client name created those services, repa
 and the other system will be left with orphaned local services. If you are sure there is no other system with the same name,
 run 'pmm-admin repair' to remove orphaned services. Otherwise, please reinstall this client.
 `, strings.Join(missingServices, ", "))
-				os.Exit(1)
+				exit(1)
 			}
 		},
 		Run: func(cmd *cobra.Command, args []string) {
 			cmd.Usage()
-			os.Exit(1)
+			exit(1)
 		},
 	}
 
@@ -145,9 +169,16 @@ run 'pmm-admin repair' to remove orphaned services. Otherwise, please reinstall
 		Short: "Add service to monitoring.",
 		Long:  "This command is used to add a monitoring service.",
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
-			cmd.Root().PersistentPreRun(cmd.Root(), args)
+			cmd.Root().PersistentPreRun(cmd, args)
 			admin.ServiceName = admin.Config.ClientName
 			admin.ServicePort = flagServicePort
+			admin.Limits = flagLimits
+			labels, err := pmm.ParseLabels(flagLabels)
+			if err != nil {
+				fmt.Println(err)
+				exit(1)
+			}
+			admin.Labels = labels
 
 			// Check if we have double dash "--"
 			i := cmd.ArgsLenAtDash()
@@ -165,7 +196,7 @@ run 'pmm-admin repair' to remove orphaned services. Otherwise, please reinstall
 				args = args[:i]
 				if len(args) > 1 {
 					fmt.Printf("Too many parameters. Only service name is allowed but got: %s.\n", strings.Join(args, ", "))
-					os.Exit(1)
+					exit(1)
 				}
 				if len(args) == 1 {
 					admin.ServiceName = args[0]
@@ -174,7 +205,15 @@ run 'pmm-admin repair' to remove orphaned services. Otherwise, please reinstall
 
 			if match, _ := regexp.MatchString(pmm.NameRegex, admin.ServiceName); !match {
 				fmt.Println("Service name must be 2 to 60 characters long, contain only letters, numbers and symbols _ - . :")
-				os.Exit(1)
+				exit(1)
+			}
+		},
+		PersistentPostRun: func(cmd *cobra.Command, args []string) {
+			// Metric services on the default server are mirrored to the server profiles marked so.
+			if admin.ServerProfile == "" {
+				if err := admin.SyncMirrors(ctx); err != nil {
+					fmt.Println("WARNING: unable to mirror services to server profiles:", err)
+				}
 			}
 		},
 	}
@@ -204,51 +243,54 @@ Table statistics is automatically disabled when there are more than 10000 tables
 				fmt.Println("pmm-admin add mysql:metrics -- ", strings.Join(admin.Args, ", "))
 				fmt.Println("or")
 				fmt.Println("pmm-admin add mysql:queries -- ", strings.Join(admin.Args, ", "))
-				os.Exit(1)
+				exit(1)
 			}
 
 			// Check --query-source flag.
 			if flagM.QuerySource != "auto" && flagM.QuerySource != "slowlog" && flagM.QuerySource != "perfschema" {
 				fmt.Println("Flag --query-source can take the following values: auto, slowlog, perfschema.")
-				os.Exit(1)
+				exit(1)
 			}
 
-			err := admin.AddLinuxMetrics(flagForce)
+			warnings, err := admin.AddLinuxMetrics(ctx, flagForce)
 			if err == pmm.ErrOneLinux {
 				fmt.Println("[linux:metrics] OK, already monitoring this system.")
 			} else if err != nil {
 				fmt.Println("[linux:metrics] Error adding linux metrics:", err)
-				os.Exit(1)
+				exit(1)
 			} else {
 				fmt.Println("[linux:metrics] OK, now monitoring this system.")
 			}
+			printWarnings(warnings)
 
-			info, err := admin.DetectMySQL(flagM)
+			info, err := admin.DetectMySQL(ctx, flagM)
 			if err != nil {
 				fmt.Printf("[mysql:metrics] %s\n", err)
-				os.Exit(1)
+				exit(1)
 			}
 
-			err = admin.AddMySQLMetrics(info, flagM)
+			warnings, err = admin.AddMySQLMetrics(ctx, info, flagM)
 			if err == pmm.ErrDuplicate {
 				fmt.Println("[mysql:metrics] OK, already monitoring MySQL metrics.")
 			} else if err != nil {
 				fmt.Println("[mysql:metrics] Error adding MySQL metrics:", err)
-				os.Exit(1)
+				exit(1)
 			} else {
 				fmt.Println("[mysql:metrics] OK, now monitoring MySQL metrics using DSN", info["safe_dsn"])
 			}
+			printWarnings(warnings)
 
-			err = admin.AddMySQLQueries(info)
+			warnings, err = admin.AddMySQLQueries(ctx, info)
 			if err == pmm.ErrDuplicate {
 				fmt.Println("[mysql:queries] OK, already monitoring MySQL queries.")
 			} else if err != nil {
 				fmt.Println("[mysql:queries] Error adding MySQL queries:", err)
-				os.Exit(1)
+				exit(1)
 			} else {
 				fmt.Println("[mysql:queries] OK, now monitoring MySQL queries from", info["query_source"], "using DSN",
 					info["safe_dsn"])
 			}
+			printWarnings(warnings)
 		},
 	}
 	cmdAddLinuxMetrics = &cobra.Command{
@@ -263,11 +305,13 @@ However, you can add another one with the different name just for testing purpos
 [name] is an optional argument, by default it is set to the client name of this PMM client.
 		`,
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := admin.AddLinuxMetrics(flagForce); err != nil {
+			warnings, err := admin.AddLinuxMetrics(ctx, flagForce)
+			if err != nil {
 				fmt.Println("Error adding linux metrics:", err)
-				os.Exit(1)
+				exit(1)
 			}
 			fmt.Println("OK, now monitoring this system.")
+			printWarnings(warnings)
 		},
 	}
 	cmdAddMySQLMetrics = &cobra.Command{
@@ -288,16 +332,18 @@ Table statistics is automatically disabled when there are more than 10000 tables
   pmm-admin add mysql:metrics --password abc123 --port 3307 instance3307
   pmm-admin add mysql:metrics --user rdsuser --password abc123 --host my-rds.1234567890.us-east-1.rds.amazonaws.com my-rds`,
 		Run: func(cmd *cobra.Command, args []string) {
-			info, err := admin.DetectMySQL(flagM)
+			info, err := admin.DetectMySQL(ctx, flagM)
 			if err != nil {
 				fmt.Println(err)
-				os.Exit(1)
+				exit(1)
 			}
-			if err := admin.AddMySQLMetrics(info, flagM); err != nil {
+			warnings, err := admin.AddMySQLMetrics(ctx, info, flagM)
+			if err != nil {
 				fmt.Println("Error adding MySQL metrics:", err)
-				os.Exit(1)
+				exit(1)
 			}
 			fmt.Println("OK, now monitoring MySQL metrics using DSN", info["safe_dsn"])
+			printWarnings(warnings)
 		},
 	}
 	cmdAddMySQLQueries = &cobra.Command{
@@ -319,19 +365,21 @@ a new user 'pmm@' automatically using the given (auto-detected) MySQL credential
 			// Check --query-source flag.
 			if flagM.QuerySource != "auto" && flagM.QuerySource != "slowlog" && flagM.QuerySource != "perfschema" {
 				fmt.Println("Flag --query-source can take the following values: auto, slowlog, perfschema.")
-				os.Exit(1)
+				exit(1)
 			}
-			info, err := admin.DetectMySQL(flagM)
+			info, err := admin.DetectMySQL(ctx, flagM)
 			if err != nil {
 				fmt.Println(err)
-				os.Exit(1)
+				exit(1)
 			}
-			if err := admin.AddMySQLQueries(info); err != nil {
+			warnings, err := admin.AddMySQLQueries(ctx, info)
+			if err != nil {
 				fmt.Println("Error adding MySQL queries:", err)
-				os.Exit(1)
+				exit(1)
 			}
 			fmt.Println("OK, now monitoring MySQL queries from", info["query_source"], "using DSN",
 				info["safe_dsn"])
+			printWarnings(warnings)
 		},
 	}
 	cmdAddMongoDB = &cobra.Command{
@@ -355,45 +403,48 @@ When adding a MongoDB instance, you may provide --uri if the default one does no
 				fmt.Println("pmm-admin add mongodb:metrics -- ", strings.Join(admin.Args, ", "))
 				fmt.Println("or")
 				fmt.Println("pmm-admin add mongodb:queries -- ", strings.Join(admin.Args, ", "))
-				os.Exit(1)
+				exit(1)
 			}
 
-			err := admin.AddLinuxMetrics(flagForce)
+			warnings, err := admin.AddLinuxMetrics(ctx, flagForce)
 			if err == pmm.ErrOneLinux {
 				fmt.Println("[linux:metrics]   OK, already monitoring this system.")
 			} else if err != nil {
 				fmt.Println("[linux:metrics]   Error adding linux metrics:", err)
-				os.Exit(1)
+				exit(1)
 			} else {
 				fmt.Println("[linux:metrics]   OK, now monitoring this system.")
 			}
+			printWarnings(warnings)
 
-			buildInfo, err := admin.DetectMongoDB(flagMongoURI)
+			buildInfo, err := admin.DetectMongoDB(ctx, flagMongoURI)
 			if err != nil {
 				fmt.Printf("[mongodb:metrics] %s\n", err)
-				os.Exit(1)
+				exit(1)
 			}
-			err = admin.AddMongoDBMetrics(flagMongoURI, flagCluster)
+			warnings, err = admin.AddMongoDBMetrics(ctx, flagMongoURI, flagCluster)
 			if err == pmm.ErrDuplicate {
 				fmt.Println("[mongodb:metrics] OK, already monitoring MongoDB metrics.")
 			} else if err != nil {
 				fmt.Println("[mongodb:metrics] Error adding MongoDB metrics:", err)
-				os.Exit(1)
+				exit(1)
 			} else {
 				fmt.Println("[mongodb:metrics] OK, now monitoring MongoDB metrics using URI", pmm.SanitizeDSN(flagMongoURI))
 			}
-			err = admin.AddMongoDBQueries(buildInfo, flagMongoURI)
+			printWarnings(warnings)
+			warnings, err = admin.AddMongoDBQueries(ctx, buildInfo, flagMongoURI)
 			if err == pmm.ErrDuplicate {
 				fmt.Println("[mongodb:queries] OK, already monitoring MongoDB queries.")
 			} else if err != nil {
 				fmt.Println("[mongodb:queries] Error adding MongoDB queries:", err)
-				os.Exit(1)
+				exit(1)
 			} else {
 				fmt.Println("[mongodb:queries] OK, now monitoring MongoDB queries using URI", pmm.SanitizeDSN(flagMongoURI))
 				fmt.Println("[mongodb:queries] It is required for correct operation that profiling of monitored MongoDB databases be enabled.")
 				fmt.Println("[mongodb:queries] Note that profiling is not enabled by default because it may reduce the performance of your MongoDB server.")
 				fmt.Println("[mongodb:queries] For more information read PMM documentation (https://www.percona.com/doc/percona-monitoring-and-management/conf-mongodb.html).")
 			}
+			printWarnings(warnings)
 		},
 	}
 	cmdAddMongoDBMetrics = &cobra.Command{
@@ -408,15 +459,17 @@ When adding a MongoDB instance, you may provide --uri if the default one does no
 		Example: `  pmm-admin add mongodb:metrics
   pmm-admin add mongodb:metrics --cluster bare-metal`,
 		Run: func(cmd *cobra.Command, args []string) {
-			if _, err := admin.DetectMongoDB(flagMongoURI); err != nil {
+			if _, err := admin.DetectMongoDB(ctx, flagMongoURI); err != nil {
 				fmt.Println(err)
-				os.Exit(1)
+				exit(1)
 			}
-			if err := admin.AddMongoDBMetrics(flagMongoURI, flagCluster); err != nil {
+			warnings, err := admin.AddMongoDBMetrics(ctx, flagMongoURI, flagCluster)
+			if err != nil {
 				fmt.Println("Error adding MongoDB metrics:", err)
-				os.Exit(1)
+				exit(1)
 			}
 			fmt.Println("OK, now monitoring MongoDB metrics using URI", pmm.SanitizeDSN(flagMongoURI))
+			printWarnings(warnings)
 		},
 	}
 	cmdAddMongoDBQueries = &cobra.Command{
@@ -431,19 +484,21 @@ When adding a MongoDB instance, you may provide --uri if the default one does no
 		Example: `  pmm-admin add mongodb:queries
   pmm-admin add mongodb:queries`,
 		Run: func(cmd *cobra.Command, args []string) {
-			buildInfo, err := admin.DetectMongoDB(flagMongoURI)
+			buildInfo, err := admin.DetectMongoDB(ctx, flagMongoURI)
 			if err != nil {
 				fmt.Println(err)
-				os.Exit(1)
+				exit(1)
 			}
-			if err := admin.AddMongoDBQueries(buildInfo, flagMongoURI); err != nil {
+			warnings, err := admin.AddMongoDBQueries(ctx, buildInfo, flagMongoURI)
+			if err != nil {
 				fmt.Println("Error adding MongoDB queries:", err)
-				os.Exit(1)
+				exit(1)
 			}
 			fmt.Println("OK, now monitoring MongoDB queries using URI", pmm.SanitizeDSN(flagMongoURI))
 			fmt.Println("It is required for correct operation that profiling of monitored MongoDB databases be enabled.")
 			fmt.Println("Note that profiling is not enabled by default because it may reduce the performance of your MongoDB server.")
 			fmt.Println("For more information read PMM documentation (https://www.percona.com/doc/percona-monitoring-and-management/conf-mongodb.html).")
+			printWarnings(warnings)
 		},
 	}
 	cmdAddProxySQLMetrics = &cobra.Command{
@@ -454,15 +509,37 @@ When adding a MongoDB instance, you may provide --uri if the default one does no
 [name] is an optional argument, by default it is set to the client name of this PMM client.
 		`,
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := admin.DetectProxySQL(flagDSN); err != nil {
+			if err := admin.DetectProxySQL(ctx, flagDSN); err != nil {
 				fmt.Println(err)
-				os.Exit(1)
+				exit(1)
 			}
-			if err := admin.AddProxySQLMetrics(flagDSN); err != nil {
+			warnings, err := admin.AddProxySQLMetrics(ctx, flagDSN)
+			if err != nil {
 				fmt.Println("Error adding proxysql metrics:", err)
-				os.Exit(1)
+				exit(1)
 			}
 			fmt.Println("OK, now monitoring ProxySQL metrics using DSN", pmm.SanitizeDSN(flagDSN))
+			printWarnings(warnings)
+		},
+	}
+	cmdAddClientMetrics = &cobra.Command{
+		Use:   "pmm-client:metrics",
+		Short: "Add PMM Client self-monitoring to metrics monitoring.",
+		Long: `This command adds PMM Client itself to metrics monitoring.
+
+It exposes the state of local monitoring services, time since their last scrape by Prometheus,
+time since QAN API last reported QAN agent connected, SSL certificate expiry, config file age and pmm-admin version.
+Only one instance per client is allowed, it is named after the client name of this PMM client.
+PMM server needs a scrape job for pmm-client:metrics services to collect them.
+		`,
+		Run: func(cmd *cobra.Command, args []string) {
+			warnings, err := admin.AddClientMetrics(ctx)
+			if err != nil {
+				fmt.Println("Error adding PMM Client metrics:", err)
+				exit(1)
+			}
+			fmt.Println("OK, now monitoring PMM Client itself.")
+			printWarnings(warnings)
 		},
 	}
 	cmdAddExternalMetrics = &cobra.Command{
@@ -482,9 +559,9 @@ An optional list of instances (scrape targets) can be provided.
 				Scheme:         flagExtScheme,
 				StaticTargets:  args[1:], // first arg is admin.ServiceName
 			}
-			if err := admin.AddExternalMetrics(context.TODO(), exp); err != nil {
+			if err := admin.AddExternalMetrics(ctx, exp); err != nil {
 				fmt.Println("Error adding external metrics:", err)
-				os.Exit(1)
+				exit(1)
 			}
 			fmt.Println("External metrics added.")
 		},
@@ -497,9 +574,9 @@ An optional list of instances (scrape targets) can be provided.
 		Args: cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			targets := args[1:] // first arg is admin.ServiceName
-			if err := admin.AddExternalInstances(context.TODO(), admin.ServiceName, targets); err != nil {
+			if err := admin.AddExternalInstances(ctx, admin.ServiceName, targets); err != nil {
 				fmt.Println("Error adding external instances:", err)
-				os.Exit(1)
+				exit(1)
 			}
 			fmt.Println("External instances added.")
 		},
@@ -511,28 +588,36 @@ An optional list of instances (scrape targets) can be provided.
 		Short:   "Remove service from monitoring.",
 		Long:    "This command is used to remove one monitoring service or all.",
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
-			cmd.Root().PersistentPreRun(cmd.Root(), args)
+			cmd.Root().PersistentPreRun(cmd, args)
 			admin.ServiceName = admin.Config.ClientName
 			if len(args) > 0 {
 				admin.ServiceName = args[0]
 			}
 		},
+		PersistentPostRun: func(cmd *cobra.Command, args []string) {
+			// Metric services on the default server are mirrored to the server profiles marked so.
+			if admin.ServerProfile == "" {
+				if err := admin.SyncMirrors(ctx); err != nil {
+					fmt.Println("WARNING: unable to mirror services to server profiles:", err)
+				}
+			}
+		},
 		Run: func(cmd *cobra.Command, args []string) {
 			if flagAll {
-				count, err := admin.RemoveAllMonitoring(false)
+				count, err := admin.RemoveAllMonitoring(ctx, false)
 				if err != nil {
 					fmt.Printf("Error removing one of the services: %s\n", err)
-					os.Exit(1)
+					exit(1)
 				}
 				if count == 0 {
 					fmt.Println("OK, no services found.")
 				} else {
 					fmt.Printf("OK, %d services were removed.\n", count)
 				}
-				os.Exit(0)
+				return
 			}
 			cmd.Usage()
-			os.Exit(1)
+			exit(1)
 		},
 	}
 	cmdRemoveMySQL = &cobra.Command{
@@ -543,7 +628,7 @@ An optional list of instances (scrape targets) can be provided.
 [name] is an optional argument, by default it is set to the client name of this PMM client.
 		`,
 		Run: func(cmd *cobra.Command, args []string) {
-			err := admin.RemoveLinuxMetrics()
+			err := admin.RemoveLinuxMetrics(ctx)
 			if err == pmm.ErrNoService {
 				fmt.Printf("[linux:metrics] OK, no system %s under monitoring.\n", admin.ServiceName)
 			} else if err != nil {
@@ -552,7 +637,7 @@ An optional list of instances (scrape targets) can be provided.
 				fmt.Printf("[linux:metrics] OK, removed system %s from monitoring.\n", admin.ServiceName)
 			}
 
-			err = admin.RemoveMySQLMetrics()
+			err = admin.RemoveMySQLMetrics(ctx)
 			if err == pmm.ErrNoService {
 				fmt.Printf("[mysql:metrics] OK, no MySQL metrics %s under monitoring.\n", admin.ServiceName)
 			} else if err != nil {
@@ -561,7 +646,7 @@ An optional list of instances (scrape targets) can be provided.
 				fmt.Printf("[mysql:metrics] OK, removed MySQL metrics %s from monitoring.\n", admin.ServiceName)
 			}
 
-			err = admin.RemoveMySQLQueries()
+			err = admin.RemoveMySQLQueries(ctx)
 			if err == pmm.ErrNoService {
 				fmt.Printf("[mysql:queries] OK, no MySQL queries %s under monitoring.\n", admin.ServiceName)
 			} else if err != nil {
@@ -579,9 +664,9 @@ An optional list of instances (scrape targets) can be provided.
 [name] is an optional argument, by default it is set to the client name of this PMM client.
 		`,
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := admin.RemoveLinuxMetrics(); err != nil {
+			if err := admin.RemoveLinuxMetrics(ctx); err != nil {
 				fmt.Printf("Error removing linux metrics %s: %s\n", admin.ServiceName, err)
-				os.Exit(1)
+				exit(1)
 			}
 			fmt.Printf("OK, removed system %s from monitoring.\n", admin.ServiceName)
 		},
@@ -594,9 +679,9 @@ An optional list of instances (scrape targets) can be provided.
 [name] is an optional argument, by default it is set to the client name of this PMM client.
 		`,
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := admin.RemoveMySQLMetrics(); err != nil {
+			if err := admin.RemoveMySQLMetrics(ctx); err != nil {
 				fmt.Printf("Error removing MySQL metrics %s: %s\n", admin.ServiceName, err)
-				os.Exit(1)
+				exit(1)
 			}
 			fmt.Printf("OK, removed MySQL metrics %s from monitoring.\n", admin.ServiceName)
 		},
@@ -609,9 +694,9 @@ An optional list of instances (scrape targets) can be provided.
 [name] is an optional argument, by default it is set to the client name of this PMM client.
 		`,
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := admin.RemoveMySQLQueries(); err != nil {
+			if err := admin.RemoveMySQLQueries(ctx); err != nil {
 				fmt.Printf("Error removing MySQL queries %s: %s\n", admin.ServiceName, err)
-				os.Exit(1)
+				exit(1)
 			}
 			fmt.Printf("OK, removed MySQL queries %s from monitoring.\n", admin.ServiceName)
 		},
@@ -624,7 +709,7 @@ An optional list of instances (scrape targets) can be provided.
 [name] is an optional argument, by default it is set to the client name of this PMM client.
 		`,
 		Run: func(cmd *cobra.Command, args []string) {
-			err := admin.RemoveLinuxMetrics()
+			err := admin.RemoveLinuxMetrics(ctx)
 			if err == pmm.ErrNoService {
 				fmt.Printf("[linux:metrics]   OK, no system %s under monitoring.\n", admin.ServiceName)
 			} else if err != nil {
@@ -633,7 +718,7 @@ An optional list of instances (scrape targets) can be provided.
 				fmt.Printf("[linux:metrics]   OK, removed system %s from monitoring.\n", admin.ServiceName)
 			}
 
-			err = admin.RemoveMongoDBMetrics()
+			err = admin.RemoveMongoDBMetrics(ctx)
 			if err == pmm.ErrNoService {
 				fmt.Printf("[mongodb:metrics] OK, no MongoDB metrics %s under monitoring.\n", admin.ServiceName)
 			} else if err != nil {
@@ -642,7 +727,7 @@ An optional list of instances (scrape targets) can be provided.
 				fmt.Printf("[mongodb:metrics] OK, removed MongoDB metrics %s from monitoring.\n", admin.ServiceName)
 			}
 
-			err = admin.RemoveMongoDBQueries()
+			err = admin.RemoveMongoDBQueries(ctx)
 			if err == pmm.ErrNoService {
 				fmt.Printf("[mongodb:queries] OK, no MongoDB queries %s under monitoring.\n", admin.ServiceName)
 			} else if err != nil {
@@ -660,9 +745,9 @@ An optional list of instances (scrape targets) can be provided.
 [name] is an optional argument, by default it is set to the client name of this PMM client.
 		`,
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := admin.RemoveMongoDBMetrics(); err != nil {
+			if err := admin.RemoveMongoDBMetrics(ctx); err != nil {
 				fmt.Printf("Error removing MongoDB metrics %s: %s\n", admin.ServiceName, err)
-				os.Exit(1)
+				exit(1)
 			}
 			fmt.Printf("OK, removed MongoDB metrics %s from monitoring.\n", admin.ServiceName)
 		},
@@ -675,9 +760,9 @@ An optional list of instances (scrape targets) can be provided.
 [name] is an optional argument, by default it is set to the client name of this PMM client.
 		`,
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := admin.RemoveMongoDBQueries(); err != nil {
+			if err := admin.RemoveMongoDBQueries(ctx); err != nil {
 				fmt.Printf("Error removing MongoDB queries %s: %s\n", admin.ServiceName, err)
-				os.Exit(1)
+				exit(1)
 			}
 			fmt.Printf("OK, removed MongoDB queries %s from monitoring.\n", admin.ServiceName)
 		},
@@ -690,23 +775,35 @@ An optional list of instances (scrape targets) can be provided.
 [name] is an optional argument, by default it is set to the client name of this PMM client.
 		`,
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := admin.RemoveProxySQLMetrics(); err != nil {
+			if err := admin.RemoveProxySQLMetrics(ctx); err != nil {
 				fmt.Printf("Error removing proxysql metrics %s: %s\n", admin.ServiceName, err)
-				os.Exit(1)
+				exit(1)
 			}
 			fmt.Printf("OK, removed ProxySQL metrics %s from monitoring.\n", admin.ServiceName)
 		},
 	}
 
+	cmdRemoveClientMetrics = &cobra.Command{
+		Use:   "pmm-client:metrics",
+		Short: "Remove PMM Client self-monitoring from metrics monitoring.",
+		Long:  "This command removes PMM Client itself from metrics monitoring.",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := admin.RemoveClientMetrics(ctx); err != nil {
+				fmt.Println("Error removing PMM Client metrics:", err)
+				exit(1)
+			}
+			fmt.Println("OK, removed PMM Client from monitoring.")
+		},
+	}
 	cmdRemoveExternalMetrics = &cobra.Command{
 		Use:   "external:metrics name",
 		Short: "Remove external Prometheus exporters from metrics monitoring.",
 		Long:  `This command removes the given external Prometheus exporter from metrics monitoring.`,
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := admin.RemoveExternalMetrics(context.TODO(), admin.ServiceName); err != nil {
+			if err := admin.RemoveExternalMetrics(ctx, admin.ServiceName); err != nil {
 				fmt.Println("Error removing external metrics:", err)
-				os.Exit(1)
+				exit(1)
 			}
 			fmt.Println("External metrics removed.")
 		},
@@ -719,9 +816,9 @@ An optional list of instances (scrape targets) can be provided.
 		Args: cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			targets := args[1:] // first arg is admin.ServiceName
-			if err := admin.RemoveExternalInstances(context.TODO(), admin.ServiceName, targets); err != nil {
+			if err := admin.RemoveExternalInstances(ctx, admin.ServiceName, targets); err != nil {
 				fmt.Println("Error removing external instances:", err)
-				os.Exit(1)
+				exit(1)
 			}
 			fmt.Println("External instances removed.")
 		},
@@ -733,10 +830,12 @@ An optional list of instances (scrape targets) can be provided.
 		Short:   "List monitoring services for this system.",
 		Long:    "This command displays the list of monitoring services and their details.",
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := admin.List(); err != nil {
+			l, err := admin.List(ctx)
+			if err != nil {
 				fmt.Println("Error listing instances:", err)
-				os.Exit(1)
+				exit(1)
 			}
+			fmt.Print(l.Format(admin.Format))
 		},
 	}
 
@@ -745,7 +844,7 @@ An optional list of instances (scrape targets) can be provided.
 		Short: "Display PMM Client information (works offline).",
 		Long:  "This command displays PMM client configuration details.",
 		Run: func(cmd *cobra.Command, args []string) {
-			admin.PrintInfo()
+			fmt.Print(admin.Info().Format())
 		},
 	}
 
@@ -754,29 +853,65 @@ An optional list of instances (scrape targets) can be provided.
 		Short: "Configure PMM Client.",
 		Long: `This command configures pmm-admin to communicate with PMM server.
 
-You can enable SSL (including self-signed certificates) and HTTP basic authentication with the server.
+You can enable SSL (including self-signed certificates or certificates signed by a custom CA),
+mutual TLS with a client certificate and HTTP basic authentication with the server.
 If HTTP authentication is enabled with the server, the same credendials will be used for all metric services
 automatically to protect them.
 
-Note, resetting of server address clears up SSL and HTTP auth options if no corresponding flags are provided.`,
+Note, resetting of server address clears up SSL and HTTP auth options if no corresponding flags are provided.
+
+Additional PMM servers can be configured as named server profiles with --server-profile flag and used by any command
+with the same flag. Profile with --mirror flag gets metric services of the default server registered too.
+pmm-client:metrics accepts HTTP credentials of every server, exporters accept the ones of the default server only.`,
 		Example: `  pmm-admin config --server 192.168.56.100
   pmm-admin config --server 192.168.56.100:8000
-  pmm-admin config --server 192.168.56.100 --server-password abc123`,
+  pmm-admin config --server [fd00::100]:8000 --client-address 2001:db8::10 --bind-address fd00::10
+  pmm-admin config --server 192.168.56.100 --server-password abc123
+  pmm-admin config --server 192.168.56.100 --server-ca-file /etc/pki/ca.pem
+  pmm-admin config --server 192.168.56.100 --proxy proxy.example.com:3128 --no-proxy 10.0.0.0/8
+  pmm-admin config --server-profile staging --server 192.168.56.200 --mirror`,
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
 			// Cancel root's PersistentPreRun as we do not require config file to exist here.
 			// If the config does not exist, we will init an empty and write on Run.
+			requireRoot(cmd)
+			lockCommand(cmd)
 			if err := admin.LoadConfig(); err != nil {
 				fmt.Printf("Cannot read config file %s: %s\n", pmm.ConfigFile, err)
-				os.Exit(1)
+				exit(1)
+			}
+			// Renaming client or changing its addresses moves its services on the current PMM server.
+			// It may be unreachable if the config is being fixed, then there are no services to lock.
+			if admin.Config.ServerAddress != "" && admin.Config.ClientName != "" && admin.SetAPI(ctx) == nil {
+				lockNode(cmd)
 			}
 		},
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := admin.SetConfig(flagC, flagForce); err != nil {
+			if cmd.Flags().Changed("mirror") && admin.ServerProfile == "" {
+				fmt.Println("Flag --mirror requires --server-profile flag.")
+				exit(1)
+			}
+			labels, err := pmm.ParseLabels(flagLabels)
+			if err != nil {
+				fmt.Println(err)
+				exit(1)
+			}
+			flagC.Labels = labels
+			warnings, err := admin.SetConfig(ctx, flagC, flagForce)
+			if err != nil {
 				fmt.Printf("%s\n", err)
-				os.Exit(1)
+				exit(1)
+			}
+			printWarnings(warnings)
+			if cmd.Flags().Changed("mirror") {
+				warnings, err := admin.SetServerProfileMirror(ctx, flagMirror)
+				if err != nil {
+					fmt.Printf("%s\n", err)
+					exit(1)
+				}
+				printWarnings(warnings)
 			}
 			fmt.Print("OK, PMM server is alive.\n\n")
-			admin.ServerInfo()
+			fmt.Print(admin.ServerInfo().Format())
 		},
 	}
 
@@ -797,10 +932,12 @@ In case, some of the endpoints are in problem state, please check if the corresp
 If all endpoints are down here and 'pmm-admin list' shows all services are up,
 please check the firewall settings whether this system allows incoming connections by address:port in question.`,
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := admin.CheckNetwork(); err != nil {
+			status, err := admin.CheckNetwork(ctx)
+			if err != nil {
 				fmt.Println("Error checking network status:", err)
-				os.Exit(1)
+				exit(1)
 			}
+			fmt.Print(status.Format())
 		},
 	}
 
@@ -811,16 +948,58 @@ please check the firewall settings whether this system allows incoming connectio
 		Run: func(cmd *cobra.Command, args []string) {
 			// It's all good if PersistentPreRun didn't fail.
 			fmt.Print("OK, PMM server is alive.\n\n")
-			admin.ServerInfo()
+			fmt.Print(admin.ServerInfo().Format())
 		},
 	}
 
+	cmdLogs = &cobra.Command{
+		Use:   "logs [TYPE] [name]",
+		Short: "Show logs of monitoring service.",
+		Long: `This command shows logs of the corresponding system service or all.
+
+Logs are read from the log file of the service or journald depending on service manager.
+Logs of qan-agent are shown along with the query services.
+With --all flag, logs of all local services and qan-agent are merged and prefixed by time and service name.
+
+[name] is an optional argument, by default it is set to the client name of this PMM client.
+		`,
+		Example: `  pmm-admin logs linux:metrics db01.vm
+  pmm-admin logs mysql:queries --since 1h
+  pmm-admin logs --all --lines 100 --follow`,
+		Run: func(cmd *cobra.Command, args []string) {
+			opts := pmm.LogOptions{Follow: flagFollow, Since: flagSince, Lines: flagLines}
+			if flagAll {
+				if err := admin.AllLogs(ctx, opts, os.Stdout); err != nil {
+					fmt.Printf("Error reading logs: %s\n", err)
+					exit(1)
+				}
+				exit(0)
+			}
+
+			// Check args.
+			if len(args) == 0 {
+				fmt.Print("No service type specified.\n\n")
+				cmd.Usage()
+				exit(1)
+			}
+			svcType := args[0]
+			admin.ServiceName = admin.Config.ClientName
+			if len(args) > 1 {
+				admin.ServiceName = args[1]
+			}
+
+			if err := admin.Logs(ctx, svcType, opts, os.Stdout); err != nil {
+				fmt.Printf("Error reading logs of %s service for %s: %s\n", svcType, admin.ServiceName, err)
+				exit(1)
+			}
+		},
+	}
 	cmdShowPass = &cobra.Command{
 		Use:   "show-passwords",
 		Short: "Show PMM Client password information (works offline).",
 		Long:  "This command shows passwords stored in the config file.",
 		Run: func(cmd *cobra.Command, args []string) {
-			admin.ShowPasswords()
+			fmt.Println(admin.ShowPasswords())
 		},
 	}
 
@@ -839,11 +1018,11 @@ please check the firewall settings whether this system allows incoming connectio
 				numOfAffected, numOfAll, err := admin.StartStopAllMonitoring("start")
 				if err != nil {
 					fmt.Printf("Error starting one of the services: %s\n", err)
-					os.Exit(1)
+					exit(1)
 				}
 				if numOfAll == 0 {
 					fmt.Println("OK, no services found.")
-					os.Exit(0)
+					exit(0)
 				}
 				if numOfAffected == 0 {
 					fmt.Println("OK, all services already started. Run 'pmm-admin list' to see monitoring services.")
@@ -851,17 +1030,17 @@ please check the firewall settings whether this system allows incoming connectio
 					fmt.Printf("OK, started %d services.\n", numOfAffected)
 				}
 				// check if server is alive.
-				if err := admin.SetAPI(); err != nil {
+				if err := admin.SetAPI(ctx); err != nil {
 					fmt.Printf("%s\n", err)
 				}
-				os.Exit(0)
+				exit(0)
 			}
 
 			// Check args.
 			if len(args) == 0 {
 				fmt.Print("No service type specified.\n\n")
 				cmd.Usage()
-				os.Exit(1)
+				exit(1)
 			}
 			svcType := args[0]
 			admin.ServiceName = admin.Config.ClientName
@@ -869,10 +1048,10 @@ please check the firewall settings whether this system allows incoming connectio
 				admin.ServiceName = args[1]
 			}
 
-			affected, err := admin.StartStopMonitoring("start", svcType)
+			affected, err := admin.StartStopMonitoring(ctx, "start", svcType)
 			if err != nil {
 				fmt.Printf("Error starting %s service for %s: %s\n", svcType, admin.ServiceName, err)
-				os.Exit(1)
+				exit(1)
 			}
 			if affected {
 				fmt.Printf("OK, started %s service for %s.\n", svcType, admin.ServiceName)
@@ -896,25 +1075,25 @@ please check the firewall settings whether this system allows incoming connectio
 				numOfAffected, numOfAll, err := admin.StartStopAllMonitoring("stop")
 				if err != nil {
 					fmt.Printf("Error stopping one of the services: %s\n", err)
-					os.Exit(1)
+					exit(1)
 				}
 				if numOfAll == 0 {
 					fmt.Println("OK, no services found.")
-					os.Exit(0)
+					exit(0)
 				}
 				if numOfAffected == 0 {
 					fmt.Println("OK, all services already stopped. Run 'pmm-admin list' to see monitoring services.")
 				} else {
 					fmt.Printf("OK, stopped %d services.\n", numOfAffected)
 				}
-				os.Exit(0)
+				exit(0)
 			}
 
 			// Check args.
 			if len(args) == 0 {
 				fmt.Print("No service type specified.\n\n")
 				cmd.Usage()
-				os.Exit(1)
+				exit(1)
 			}
 			svcType := args[0]
 			admin.ServiceName = admin.Config.ClientName
@@ -922,10 +1101,10 @@ please check the firewall settings whether this system allows incoming connectio
 				admin.ServiceName = args[1]
 			}
 
-			affected, err := admin.StartStopMonitoring("stop", svcType)
+			affected, err := admin.StartStopMonitoring(ctx, "stop", svcType)
 			if err != nil {
 				fmt.Printf("Error stopping %s service for %s: %s\n", svcType, admin.ServiceName, err)
-				os.Exit(1)
+				exit(1)
 			}
 			if affected {
 				fmt.Printf("OK, stopped %s service for %s.\n", svcType, admin.ServiceName)
@@ -949,26 +1128,26 @@ please check the firewall settings whether this system allows incoming connectio
 				numOfAffected, numOfAll, err := admin.StartStopAllMonitoring("restart")
 				if err != nil {
 					fmt.Printf("Error restarting one of the services: %s\n", err)
-					os.Exit(1)
+					exit(1)
 				}
 				if numOfAll == 0 {
 					fmt.Println("OK, no services found.")
-					os.Exit(0)
+					exit(0)
 				}
 
 				fmt.Printf("OK, restarted %d services.\n", numOfAffected)
 				// check if server is alive.
-				if err := admin.SetAPI(); err != nil {
+				if err := admin.SetAPI(ctx); err != nil {
 					fmt.Printf("%s\n", err)
 				}
-				os.Exit(0)
+				exit(0)
 			}
 
 			// Check args.
 			if len(args) == 0 {
 				fmt.Print("No service type specified.\n\n")
 				cmd.Usage()
-				os.Exit(1)
+				exit(1)
 			}
 			svcType := args[0]
 			admin.ServiceName = admin.Config.ClientName
@@ -976,9 +1155,9 @@ please check the firewall settings whether this system allows incoming connectio
 				admin.ServiceName = args[1]
 			}
 
-			if _, err := admin.StartStopMonitoring("restart", svcType); err != nil {
+			if _, err := admin.StartStopMonitoring(ctx, "restart", svcType); err != nil {
 				fmt.Printf("Error restarting %s service for %s: %s\n", svcType, admin.ServiceName, err)
-				os.Exit(1)
+				exit(1)
 			}
 			fmt.Printf("OK, restarted %s service for %s.\n", svcType, admin.ServiceName)
 		},
@@ -999,7 +1178,7 @@ It is not required that metric service or name exists.
 			if len(args) == 0 {
 				fmt.Print("No service type specified.\n\n")
 				cmd.Usage()
-				os.Exit(1)
+				exit(1)
 			}
 			svcType := args[0]
 			admin.ServiceName = admin.Config.ClientName
@@ -1007,10 +1186,10 @@ It is not required that metric service or name exists.
 				admin.ServiceName = args[1]
 			}
 
-			count, err := admin.PurgeMetrics(svcType)
+			count, err := admin.PurgeMetrics(ctx, svcType)
 			if err != nil {
 				fmt.Printf("Error purging %s data for %s: %s\n", svcType, admin.ServiceName, err)
-				os.Exit(1)
+				exit(1)
 			}
 			if count == 0 {
 				fmt.Printf("OK, no data purged of %s for %s.\n", svcType, admin.ServiceName)
@@ -1028,13 +1207,343 @@ It is not required that metric service or name exists.
 It removes local services disconnected from PMM server and remote services that are missing locally.
 		`,
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := admin.RepairInstallation(); err != nil {
+			removed, err := admin.RepairInstallation(ctx)
+			if err != nil {
 				fmt.Printf("Problem repairing the installation: %s\n", err)
-				os.Exit(1)
+				exit(1)
+			}
+			if removed > 0 {
+				fmt.Printf("OK, removed %d orphaned services.\n", removed)
+			} else {
+				fmt.Println("No orphaned services found.")
 			}
 		},
 	}
 
+	cmdBackup = &cobra.Command{
+		Use:   "backup FILE",
+		Short: "Backup registration state of this client.",
+		Long: `This command saves services of this client on PMM server and locally, and QAN instances into file.
+
+Use 'pmm-admin restore' to recreate them when the host is rebuilt with the same client name.
+The file contains passwords, so keep it as secure as the config file.
+		`,
+		Example: `  pmm-admin backup /root/pmm-backup.json`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) != 1 {
+				fmt.Print("No backup file specified.\n\n")
+				cmd.Usage()
+				exit(1)
+			}
+			b, err := admin.Backup(ctx)
+			if err != nil {
+				fmt.Printf("Error making backup: %s\n", err)
+				exit(1)
+			}
+			if err := pmm.SaveBackup(args[0], b); err != nil {
+				fmt.Printf("Error writing backup file %s: %s\n", args[0], err)
+				exit(1)
+			}
+			for _, s := range b.Services {
+				if s.Config == nil {
+					fmt.Printf("Warning: %s was added by older pmm-admin, it will have to be added again after restore.\n", s.Consul.ID)
+				}
+			}
+			fmt.Printf("OK, saved %d services and %d QAN instances to %s.\n", len(b.Services), len(b.QANInstances), args[0])
+		},
+	}
+	cmdRestore = &cobra.Command{
+		Use:   "restore FILE",
+		Short: "Restore registration state of this client from backup.",
+		Long: `This command recreates services of this client from the file made by 'pmm-admin backup'.
+
+QAN instances are reconnected, so historical QAN data stays linked.
+Services both registered on PMM server and installed locally are left as is.
+The client name should be the same as the one of the client the backup was made on.
+		`,
+		Example: `  pmm-admin restore /root/pmm-backup.json`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) != 1 {
+				fmt.Print("No backup file specified.\n\n")
+				cmd.Usage()
+				exit(1)
+			}
+			b, err := pmm.LoadBackup(args[0])
+			if err != nil {
+				fmt.Println(err)
+				exit(1)
+			}
+			services, instances, err := admin.Restore(ctx, b)
+			if err != nil {
+				fmt.Printf("Error restoring %s: %s\n", args[0], err)
+				exit(1)
+			}
+			fmt.Printf("OK, restored %d services and %d QAN instances.\n", services, instances)
+		},
+	}
+	cmdMigrate = &cobra.Command{
+		Use:   "migrate",
+		Short: "Move this client to another PMM server keeping its services.",
+		Long: `This command moves this client to a new PMM server without adding the services again.
+
+Services and QAN instances are read from the current server, or from the local files if it is not reachable.
+Then the server is changed as 'pmm-admin config --server' does and everything is registered on the new one,
+including qan-agent. The client name, addresses and local ports are kept.
+		`,
+		Example: `  pmm-admin migrate --to 192.168.56.200
+  pmm-admin migrate --to 192.168.56.200:443 --server-ssl --server-password abc123`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if flagC.ServerAddress == "" {
+				fmt.Print("No new server specified, use --to flag.\n\n")
+				cmd.Usage()
+				exit(1)
+			}
+			fromServer := true
+			if err := admin.SetAPI(ctx); err != nil {
+				fmt.Printf("Current PMM server %s is not reachable, reading services from local files.\n\n", admin.Config.ServerAddress)
+				fromServer = false
+			} else {
+				lockNode(cmd)
+			}
+			services, instances, err := admin.Migrate(ctx, flagC, fromServer)
+			if err != nil {
+				fmt.Printf("Error migrating to %s: %s\n", flagC.ServerAddress, err)
+				exit(1)
+			}
+			fmt.Printf("OK, migrated %d services and %d QAN instances to %s.\n", services, instances, admin.Config.ServerAddress)
+		},
+	}
+
+	cmdCert = &cobra.Command{
+		Use:   "cert",
+		Short: "Manage SSL certificate of metric services (works offline).",
+		Long: `This command manages SSL certificate and key used by metric services to serve HTTPS.
+
+By default, self-signed certificate is generated when the first metric service is added.
+You can renew it with the different options or import the one issued by your own CA.
+All metric services using the certificate are restarted automatically after a change.`,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			cmd.Root().PersistentPreRun(cmd.Parent(), args)
+		},
+	}
+
+	cmdCertStatus = &cobra.Command{
+		Use:   "status",
+		Short: "Show SSL certificate details.",
+		Long:  "This command shows SSL certificate expiry and SANs, and warns if it expires soon or does not match client address.",
+		Run: func(cmd *cobra.Command, args []string) {
+			info, warnings, err := admin.CertStatus()
+			if err != nil {
+				fmt.Printf("Error reading SSL certificate: %s\n", err)
+				exit(1)
+			}
+			fmt.Println(info.Format())
+			printWarnings(warnings)
+		},
+	}
+
+	cmdCertRenew = &cobra.Command{
+		Use:   "renew",
+		Short: "Generate a new self-signed SSL certificate.",
+		Long: `This command generates a new self-signed SSL certificate for client and bind addresses and extra SANs.
+
+The options are saved to the config file and used for the subsequent renewals.`,
+		Example: `  pmm-admin cert renew
+  pmm-admin cert renew --lifetime 8760h --key-type ecdsa --san db01.example.com --san 10.0.0.5`,
+		Run: func(cmd *cobra.Command, args []string) {
+			count, err := admin.RenewCertificate(flagCert)
+			if err != nil {
+				fmt.Printf("Error renewing SSL certificate: %s\n", err)
+				exit(1)
+			}
+			fmt.Printf("OK, SSL certificate renewed, %d services restarted.\n", count)
+		},
+	}
+
+	cmdCertImport = &cobra.Command{
+		Use:   "import",
+		Short: "Import SSL certificate and key issued by own CA.",
+		Long: `This command installs SSL certificate and key issued by your own CA.
+
+The certificate file may contain the chain of intermediate certificates following the certificate itself.`,
+		Example: `  pmm-admin cert import --cert-file db01.crt --key-file db01.key`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if flagCertFile == "" || flagKeyFile == "" {
+				fmt.Print("Both --cert-file and --key-file flags are required.\n\n")
+				cmd.Usage()
+				exit(1)
+			}
+			count, err := admin.ImportCertificate(flagCertFile, flagKeyFile)
+			if err != nil {
+				fmt.Printf("Error importing SSL certificate: %s\n", err)
+				exit(1)
+			}
+			fmt.Printf("OK, SSL certificate imported, %d services restarted.\n", count)
+		},
+	}
+
+	cmdServeMetrics = &cobra.Command{
+		Use:    "serve-metrics",
+		Short:  "Serve PMM Client self-monitoring metrics.",
+		Long:   "This command is run by pmm-client:metrics service to serve PMM Client self-monitoring metrics.",
+		Hidden: true,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// Cancel root's PersistentPreRun as the service runs as service user, not root.
+			if err := admin.LoadConfig(); err != nil {
+				fmt.Printf("Error reading config file %s: %s\n", pmm.ConfigFile, err)
+				exit(1)
+			}
+			// The service runs unprivileged with the public copy of the config, credentials are in the auth file.
+			if flagClientMetrics.AuthFile != "" {
+				if err := admin.LoadAuthFile(flagClientMetrics.AuthFile); err != nil {
+					fmt.Printf("Error reading auth file %s: %s\n", flagClientMetrics.AuthFile, err)
+					exit(1)
+				}
+			}
+			// Metrics which require PMM server are skipped until it is reachable.
+			if err := admin.SetAPI(ctx); err != nil {
+				fmt.Printf("%s\n", err)
+			}
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			flagClientMetrics.HealthCheckError = func(err error) {
+				fmt.Printf("Unable to update health checks: %s\n", err)
+			}
+			if err := admin.ServeClientMetrics(ctx, flagClientMetrics); err != nil {
+				fmt.Printf("Error serving PMM Client metrics: %s\n", err)
+				exit(1)
+			}
+		},
+	}
+
+	cmdSummary = &cobra.Command{
+		Use:   "summary",
+		Short: "Collect diagnostic data into archive for support.",
+		Long: `This command collects diagnostic data into tar.gz archive to attach to support cases.
+
+The archive contains config file, 'list' and 'check-network' output, system service files, qan-agent config and instances,
+last lines of logs, Consul node and KV data of this client, and OS info. Passwords are removed.
+Data which can't be collected within the timeout is skipped, PMM server data is skipped if it is not reachable.
+		`,
+		Example: `  pmm-admin summary
+  pmm-admin summary --output /tmp/summary.tar.gz --collector-timeout 1m`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := admin.SetAPI(ctx); err != nil {
+				fmt.Printf("%s\n\n", err)
+			}
+			if flagSummaryOutput == "" {
+				flagSummaryOutput = fmt.Sprintf("pmm-summary-%s-%s.tar.gz", admin.Config.ClientName, time.Now().Format("20060102-150405"))
+			}
+			if err := admin.Summary(ctx, flagSummaryOutput, flagSummaryTimeout); err != nil {
+				errs, ok := err.(pmm.Errors)
+				if !ok {
+					fmt.Printf("Error writing summary: %s\n", err)
+					exit(1)
+				}
+				fmt.Println("Some data was not collected:")
+				for _, e := range errs {
+					fmt.Printf("  %s\n", e)
+				}
+				fmt.Println()
+			}
+			fmt.Println("OK, summary saved to", flagSummaryOutput)
+		},
+	}
+
+	cmdLabel = &cobra.Command{
+		Use:   "label TYPE [name] LABEL=VALUE...",
+		Short: "Change labels of monitoring service.",
+		Long: `This command sets custom Prometheus labels of monitoring service, a label with empty value is removed.
+
+Labels are kept as Consul service tags label_<name>=<value> that PMM server turns into target labels.
+Node-wide labels of all services are set with 'pmm-admin config --label'.
+
+[name] is an optional argument, by default it is set to the client name of this PMM client.
+		`,
+		Example: `  pmm-admin label mysql:metrics env=prod team=dba
+  pmm-admin label linux:metrics db01.vm az=`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				fmt.Print("No service type specified.\n\n")
+				cmd.Usage()
+				exit(1)
+			}
+			svcType, args := args[0], args[1:]
+			admin.ServiceName = admin.Config.ClientName
+			if len(args) > 0 && !strings.Contains(args[0], "=") {
+				admin.ServiceName, args = args[0], args[1:]
+			}
+			if len(args) == 0 {
+				fmt.Print("No labels specified.\n\n")
+				cmd.Usage()
+				exit(1)
+			}
+			labels, err := pmm.ParseLabels(args)
+			if err != nil {
+				fmt.Println(err)
+				exit(1)
+			}
+			if err := admin.SetServiceLabels(ctx, svcType, labels); err != nil {
+				fmt.Printf("Error changing labels of %s %s: %s\n", svcType, admin.ServiceName, err)
+				exit(1)
+			}
+			if admin.ServerProfile == "" {
+				if err := admin.SyncMirrors(ctx); err != nil {
+					fmt.Println("WARNING: unable to mirror services to server profiles:", err)
+				}
+			}
+			fmt.Printf("OK, changed labels of %s %s.\n", svcType, admin.ServiceName)
+		},
+	}
+
+	cmdHealth = &cobra.Command{
+		Use:   "health",
+		Short: "Update Consul health checks of monitoring services.",
+		Long: `This command checks monitoring services of this system and updates their Consul health checks.
+
+A service is critical if it is not running, warning if its metrics endpoint does not respond with 200 OK
+and passing otherwise. pmm-client:metrics service updates the checks periodically,
+without it this command can be run periodically, e.g. by cron.
+		`,
+		Run: func(cmd *cobra.Command, args []string) {
+			checks, err := admin.UpdateHealthChecks(ctx)
+			for _, c := range checks {
+				fmt.Printf("%-30s %-9s %s\n", c.ServiceID, c.Status, c.Output)
+			}
+			if err != nil {
+				fmt.Printf("Error updating health checks: %s\n", err)
+				exit(1)
+			}
+			if len(checks) == 0 {
+				fmt.Println("No services to check.")
+			}
+		},
+	}
+	cmdServiceUser = &cobra.Command{
+		Use:   "service-user [NAME]",
+		Short: "Run monitoring services as unprivileged system user (works offline).",
+		Long: `This command makes monitoring services run as the system user instead of root.
+
+The user is created if it does not exist. SSL certificate, qan-agent files and the file with HTTP basic auth
+credentials of metric services are given to it. With systemd, services are sandboxed too.
+Installed services are converted keeping their running state, new ones are added as the user.
+Use 'root' as the name to run services as root again.
+		`,
+		Example: `  pmm-admin service-user
+  pmm-admin service-user root`,
+		Run: func(cmd *cobra.Command, args []string) {
+			username := pmm.PMMUser
+			if len(args) > 0 {
+				username = args[0]
+			}
+			count, err := admin.SetServiceUser(username)
+			if err != nil {
+				fmt.Printf("Error converting services to run as %s: %s\n", username, err)
+				exit(1)
+			}
+			fmt.Printf("OK, services run as %s now, %d services were converted.\n", username, count)
+		},
+	}
 	cmdUninstall = &cobra.Command{
 		Use:   "uninstall",
 		Short: "Removes all monitoring services with the best effort.",
@@ -1045,15 +1554,17 @@ despite PMM server is alive or not.
 		`,
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
 			// Cancel root's PersistentPreRun as we do not require server to be alive.
+			requireRoot(cmd)
+			lockCommand(cmd)
 		},
 		Run: func(cmd *cobra.Command, args []string) {
-			count := admin.Uninstall()
+			count := admin.Uninstall(ctx)
 			if count == 0 {
 				fmt.Println("OK, no services found.")
 			} else {
 				fmt.Printf("OK, %d services were removed.\n", count)
 			}
-			os.Exit(0)
+			exit(0)
 		},
 	}
 
@@ -1062,10 +1573,28 @@ despite PMM server is alive or not.
 	flagVersion, flagJson, flagAll, flagForce bool
 
 	flagServicePort int
+	flagLimits      pmm.ServiceLimits
+	flagLabels      []string
+
+	flagFollow bool
+	flagSince  time.Duration
+	flagLines  int
 
 	flagExtInterval, flagExtTimeout time.Duration
 	flagExtPath, flagExtScheme      string
 
+	flagCert                  pmm.CertOptions
+	flagCertFile, flagKeyFile string
+
+	flagClientMetrics pmm.ClientMetricsOptions
+
+	flagSummaryOutput  string
+	flagSummaryTimeout time.Duration
+
+	flagMirror bool
+
+	flagWait time.Duration
+
 	flagM pmm.MySQLFlags
 	flagC pmm.Config
 )
@@ -1084,11 +1613,26 @@ func main() {
 		cmdStart,
 		cmdStop,
 		cmdRestart,
+		cmdLogs,
+		cmdLabel,
+		cmdHealth,
 		cmdShowPass,
 		cmdPurge,
 		cmdRepair,
+		cmdBackup,
+		cmdRestore,
+		cmdMigrate,
+		cmdCert,
+		cmdSummary,
+		cmdServiceUser,
+		cmdServeMetrics,
 		cmdUninstall,
 	)
+	cmdCert.AddCommand(
+		cmdCertStatus,
+		cmdCertRenew,
+		cmdCertImport,
+	)
 	cmdAdd.AddCommand(
 		cmdAddMySQL,
 		cmdAddLinuxMetrics,
@@ -1098,6 +1642,7 @@ func main() {
 		cmdAddMongoDBMetrics,
 		cmdAddMongoDBQueries,
 		cmdAddProxySQLMetrics,
+		cmdAddClientMetrics,
 		cmdAddExternalMetrics,
 		cmdAddExternalInstances,
 	)
@@ -1110,6 +1655,7 @@ func main() {
 		cmdRemoveMongoDBMetrics,
 		cmdRemoveMongoDBQueries,
 		cmdRemoveProxySQLMetrics,
+		cmdRemoveClientMetrics,
 		cmdRemoveExternalMetrics,
 		cmdRemoveExternalInstances,
 	)
@@ -1117,6 +1663,12 @@ func main() {
 	// Flags.
 	rootCmd.PersistentFlags().StringVarP(&pmm.ConfigFile, "config-file", "c", pmm.ConfigFile, "PMM config file")
 	rootCmd.PersistentFlags().BoolVarP(&admin.Verbose, "verbose", "", false, "verbose output")
+	rootCmd.PersistentFlags().DurationVar(&admin.Timeout, "timeout", pmm.APITimeout, "timeout of a single API request")
+	rootCmd.PersistentFlags().IntVar(&admin.Backoff.Retries, "retries", pmm.DefaultBackoff.Retries, "number of retries of idempotent API requests")
+	rootCmd.PersistentFlags().DurationVar(&admin.Backoff.Delay, "retry-delay", pmm.DefaultBackoff.Delay, "delay before the first retry, doubled for every next one")
+	rootCmd.PersistentFlags().StringVar(&admin.ServerProfile, "server-profile", "", "server profile from the config file to use instead of the default server")
+	rootCmd.PersistentFlags().DurationVar(&flagWait, "wait", 0, "how long to wait for another pmm-admin to release the lock of this client, e.g. 1m")
+	admin.Backoff.MaxDelay = pmm.DefaultBackoff.MaxDelay
 	rootCmd.Flags().BoolVarP(&flagVersion, "version", "v", false, "show version")
 
 	cmdConfig.Flags().StringVar(&flagC.ServerAddress, "server", "", "PMM server address, optionally following with the :port (default port 80 or 443 if using SSL)")
@@ -1127,9 +1679,40 @@ func main() {
 	cmdConfig.Flags().StringVar(&flagC.ServerPassword, "server-password", "", "define HTTP password configured on PMM Server")
 	cmdConfig.Flags().BoolVar(&flagC.ServerSSL, "server-ssl", false, "enable SSL to communicate with PMM Server")
 	cmdConfig.Flags().BoolVar(&flagC.ServerInsecureSSL, "server-insecure-ssl", false, "enable insecure SSL (self-signed certificate) to communicate with PMM Server")
-	cmdConfig.Flags().BoolVar(&flagForce, "force", false, "force to set client name on initial setup after uninstall with unreachable server")
+	cmdConfig.Flags().StringVar(&flagC.ServerCAFile, "server-ca-file", "", "PEM encoded CA bundle to verify PMM Server certificate with (implies --server-ssl)")
+	cmdConfig.Flags().StringVar(&flagC.ServerClientCert, "server-client-cert", "", "PEM encoded client certificate for mutual TLS with PMM Server, QAN agent connects without it")
+	cmdConfig.Flags().StringVar(&flagC.ServerClientKey, "server-client-key", "", "PEM encoded private key of the client certificate")
+	cmdConfig.Flags().StringVar(&flagC.ConsulToken, "consul-token", "", "Consul ACL token to register services with when PMM Server has ACLs enabled, or 'none' to remove it")
+	cmdConfig.Flags().StringVar(&flagC.ConsulTokenFile, "consul-token-file", "", "file to read Consul ACL token from instead of storing it in the config, or 'none' to remove it")
+	cmdConfig.Flags().StringVar(&flagC.ServerProxy, "proxy", "", "HTTP(S) proxy to reach PMM Server via, [scheme://]host:port or 'none' to remove it (defaults to HTTP(S)_PROXY environment variables)")
+	cmdConfig.Flags().StringVar(&flagC.ServerProxyUser, "proxy-user", "", "define user for proxy authentication")
+	cmdConfig.Flags().StringVar(&flagC.ServerProxyPassword, "proxy-password", "", "define password for proxy authentication")
+	cmdConfig.Flags().StringVar(&flagC.ServerNoProxy, "no-proxy", "", "comma-separated hosts, domains and CIDRs to reach directly bypassing the proxy")
+	cmdConfig.Flags().StringSliceVar(&flagC.NTPServers, "ntp-server", nil, "NTP server to check the time against, can be repeated, or 'none' to disable the check (default "+pmm.DefaultNTPServer+")")
+	cmdConfig.Flags().DurationVar(&flagC.TimeDriftThreshold, "time-drift-threshold", 0, "maximum allowed time drift between client, server and NTP (default "+pmm.DefaultTimeDriftThreshold.String()+")")
+	cmdConfig.Flags().StringVar(&flagC.ServiceManager, "service-manager", "", "service manager to install services with: auto, "+strings.Join(pmm.ServiceManagers, ", ")+" (defaults to auto-detected)")
+	cmdConfig.Flags().StringVar(&flagC.PortRange, "port-range", "", "range of ports to choose ports of services from, e.g. 42000-42999, or 'none' to remove it (default 1000 ports starting the default port of the service)")
+	cmdConfig.Flags().StringVar(&flagC.Registry, "registry", "", "registry to keep monitoring services in: "+strings.Join(pmm.Registries, ", ")+" (default consul)")
+	cmdConfig.Flags().StringArrayVar(&flagLabels, "label", nil, "node-wide custom Prometheus label of all services NAME=VALUE, can be repeated, empty value removes it")
+	cmdConfig.Flags().BoolVar(&flagMirror, "mirror", false, "register metric services of the default server on the server of --server-profile too")
+	cmdConfig.Flags().BoolVar(&flagForce, "force", false, "force to set client name on initial setup after uninstall with unreachable server, or to rename client skipping QAN instances which can't be renamed")
+
+	cmdMigrate.Flags().StringVar(&flagC.ServerAddress, "to", "", "new PMM server address, optionally following with the :port (default port 80 or 443 if using SSL)")
+	cmdMigrate.Flags().StringVar(&flagC.ServerUser, "server-user", "pmm", "define HTTP user configured on the new PMM Server")
+	cmdMigrate.Flags().StringVar(&flagC.ServerPassword, "server-password", "", "define HTTP password configured on the new PMM Server")
+	cmdMigrate.Flags().BoolVar(&flagC.ServerSSL, "server-ssl", false, "enable SSL to communicate with the new PMM Server")
+	cmdMigrate.Flags().BoolVar(&flagC.ServerInsecureSSL, "server-insecure-ssl", false, "enable insecure SSL (self-signed certificate) to communicate with the new PMM Server")
+	cmdMigrate.Flags().StringVar(&flagC.ServerCAFile, "server-ca-file", "", "PEM encoded CA bundle to verify the new PMM Server certificate with (implies --server-ssl)")
+	cmdMigrate.Flags().StringVar(&flagC.ServerClientCert, "server-client-cert", "", "PEM encoded client certificate for mutual TLS with the new PMM Server, QAN agent connects without it")
+	cmdMigrate.Flags().StringVar(&flagC.ServerClientKey, "server-client-key", "", "PEM encoded private key of the client certificate")
+	cmdMigrate.Flags().StringVar(&flagC.ConsulToken, "consul-token", "", "Consul ACL token of the new PMM Server")
+	cmdMigrate.Flags().StringVar(&flagC.ConsulTokenFile, "consul-token-file", "", "file to read Consul ACL token of the new PMM Server from")
 
 	cmdAdd.PersistentFlags().IntVar(&flagServicePort, "service-port", 0, "service port")
+	cmdAdd.PersistentFlags().StringArrayVar(&flagLabels, "label", nil, "custom Prometheus label of the service NAME=VALUE, can be repeated")
+	cmdAdd.PersistentFlags().StringVar(&flagLimits.MemoryMax, "memory-max", "", "memory limit of the service, e.g. 512M (systemd only)")
+	cmdAdd.PersistentFlags().StringVar(&flagLimits.CPUQuota, "cpu-quota", "", "CPU time quota of the service, e.g. 50% (systemd only)")
+	cmdAdd.PersistentFlags().StringVar(&flagLimits.Nice, "nice", "", "nice level of the service from -20 to 19 (systemd only)")
 
 	cmdAddLinuxMetrics.Flags().BoolVar(&flagForce, "force", false, "force to add another linux:metrics instance with different name for testing purposes")
 
@@ -1186,20 +1769,122 @@ func main() {
 	cmdList.Flags().StringVar(&flagFormat, "format", "", "print result using a Go template")
 	cmdList.Flags().BoolVar(&flagJson, "json", false, "print result as json")
 
+	cmdCertRenew.Flags().DurationVar(&flagCert.Lifetime, "lifetime", 0, "certificate lifetime (defaults to the previous one or 87600h)")
+	cmdCertRenew.Flags().StringVar(&flagCert.KeyType, "key-type", "", "key type: rsa or ecdsa (defaults to the previous one or rsa)")
+	cmdCertRenew.Flags().StringSliceVar(&flagCert.Hosts, "san", nil, "extra DNS name or IP address to include into SANs, client and bind addresses are always included")
+	cmdCertImport.Flags().StringVar(&flagCertFile, "cert-file", "", "PEM encoded certificate file")
+	cmdCertImport.Flags().StringVar(&flagKeyFile, "key-file", "", "PEM encoded private key file")
+
+	cmdServeMetrics.Flags().StringVar(&flagClientMetrics.ListenAddress, "web.listen-address", "127.0.0.1:42005", "address to listen on for metrics")
+	cmdServeMetrics.Flags().StringVar(&flagClientMetrics.AuthFile, "web.auth-file", "", "config file with server_user and server_password for HTTP basic auth")
+	cmdServeMetrics.Flags().StringVar(&flagClientMetrics.SSLCertFile, "web.ssl-cert-file", "", "SSL certificate file to serve HTTPS")
+	cmdServeMetrics.Flags().StringVar(&flagClientMetrics.SSLKeyFile, "web.ssl-key-file", "", "SSL key file to serve HTTPS")
+	cmdServeMetrics.Flags().DurationVar(&flagClientMetrics.HealthCheckInterval, "health-check-interval", pmm.DefaultHealthCheckInterval, "how often to update Consul health checks of monitoring services, 0 to disable")
+
+	cmdSummary.Flags().StringVar(&flagSummaryOutput, "output", "", "archive file to write (default pmm-summary-<client name>-<time>.tar.gz)")
+	cmdSummary.Flags().DurationVar(&flagSummaryTimeout, "collector-timeout", pmm.DefaultSummaryTimeout, "timeout of collecting a single part of the summary")
+
 	cmdStart.Flags().BoolVar(&flagAll, "all", false, "start all monitoring services")
 	cmdStop.Flags().BoolVar(&flagAll, "all", false, "stop all monitoring services")
 	cmdRestart.Flags().BoolVar(&flagAll, "all", false, "restart all monitoring services")
+	cmdLogs.Flags().BoolVar(&flagAll, "all", false, "show logs of all monitoring services and qan-agent merged by time")
+	cmdLogs.Flags().BoolVarP(&flagFollow, "follow", "f", false, "keep showing new log lines")
+	cmdLogs.Flags().DurationVar(&flagSince, "since", 0, "show log lines not older than that, e.g. 30m")
+	cmdLogs.Flags().IntVarP(&flagLines, "lines", "n", 50, "number of last log lines to show, 0 to show all")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
 
-	if os.Getuid() != 0 {
-		// skip root check if binary was build in tests
-		if pmm.Version != "gotest" {
-			fmt.Println("pmm-admin requires superuser privileges to manage system services.")
-			os.Exit(1)
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+	admin.Unlock()
+}
+
+// exit releases the locks and exits with the code.
+func exit(code int) {
+	admin.Unlock()
+	os.Exit(code)
+}
+
+// printWarnings print warnings about things that did not fail the command.
+func printWarnings(warnings []string) {
+	for _, w := range warnings {
+		fmt.Println(w)
+	}
+}
+
+// lockFreeCommands are commands which neither read-only nor change anything, so they don't take the lock.
+var lockFreeCommands = []string{"serve-metrics", "summary", "show-passwords"}
+
+// lockCommand takes the local lock if the command changes the config or services,
+// so parallel pmm-admin invocations are serialized.
+func lockCommand(cmd *cobra.Command) {
+	for _, name := range append(pmm.ReadOnlyCommands, lockFreeCommands...) {
+		if cmd.Name() == name {
+			return
 		}
 	}
+	if err := admin.Lock(ctx, cmd.CommandPath(), flagWait); err != nil {
+		fmt.Printf("%s\n", err)
+		exit(1)
+	}
+}
 
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+// lockNodeCommand check if the command changes services of this client on PMM server,
+// so it takes the lock of the client node too.
+func lockNodeCommand(cmd *cobra.Command) bool {
+	if cmd.HasParent() {
+		switch cmd.Parent().Name() {
+		case "add", "remove":
+			return true
+		}
+	}
+	switch cmd.Name() {
+	case "add", "remove", "repair", "restore", "label", "config", "migrate":
+		return true
+	}
+	return false
+}
+
+// lockNode takes the lock of the client node if the command changes its services on PMM server.
+func lockNode(cmd *cobra.Command) {
+	if !lockNodeCommand(cmd) {
+		return
+	}
+	if err := admin.LockNode(ctx, cmd.CommandPath(), flagWait); err != nil {
+		fmt.Printf("%s\n", err)
+		exit(1)
+	}
+}
+
+// requireRoot exits unless pmm-admin runs as root or the command is read-only.
+// It runs from PersistentPreRun, so the command and its flags are already parsed by cobra,
+// which prints help without getting there.
+func requireRoot(cmd *cobra.Command) {
+	// skip root check if binary was build in tests
+	if os.Getuid() == 0 || pmm.Version == "gotest" || readOnlyCommand(cmd) {
+		return
+	}
+	fmt.Println("pmm-admin requires superuser privileges to manage system services.")
+	fmt.Printf("Members of %s group can run read-only commands without them: %s.\n", pmm.PMMGroup, strings.Join(pmm.ReadOnlyCommands, ", "))
+	exit(1)
+}
+
+// readOnlyCommand check if the command is read-only or just prints the version.
+func readOnlyCommand(cmd *cobra.Command) bool {
+	if !cmd.HasParent() {
+		return true
+	}
+	for _, name := range pmm.ReadOnlyCommands {
+		if cmd.Name() == name {
+			return true
+		}
 	}
+	return false
 }
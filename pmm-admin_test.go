@@ -95,6 +95,7 @@ func TestPmmAdmin(t *testing.T) {
 		testAddLinuxMetricsWithAdditionalArgsOk,
 		testAddLinuxMetricsWithAdditionalArgsFail,
 		testCheckNetwork,
+		testCheckNetworkWithoutNTP,
 		testConfig,
 		testConfigVerbose,
 		testConfigVerboseServerNotAvailable,
@@ -160,17 +161,31 @@ Available Commands:
   start          Start monitoring service.
   stop           Stop monitoring service.
   restart        Restart monitoring service.
+  logs           Show logs of monitoring service.
+  label          Change labels of monitoring service.
+  health         Update Consul health checks of monitoring services.
   show-passwords Show PMM Client password information \(works offline\).
   purge          Purge metrics data on PMM server.
   repair         Repair installation.
+  backup         Backup registration state of this client.
+  restore        Restore registration state of this client from backup.
+  migrate        Move this client to another PMM server keeping its services.
+  cert           Manage SSL certificate of metric services \(works offline\).
+  summary        Collect diagnostic data into archive for support.
+  service-user   Run monitoring services as unprivileged system user \(works offline\).
   uninstall      Removes all monitoring services with the best effort.
   help           Help about any command
 
 Flags:
-  -c, --config-file string   PMM config file \(default ".*"\)
-  -h, --help                 help for pmm-admin
-      --verbose              verbose output
-  -v, --version              show version
+  -c, --config-file string      PMM config file \(default ".*"\)
+  -h, --help                    help for pmm-admin
+      --retries int             number of retries of idempotent API requests \(default 3\)
+      --retry-delay duration    delay before the first retry, doubled for every next one \(default 500ms\)
+      --server-profile string   server profile from the config file to use instead of the default server
+      --timeout duration        timeout of a single API request \(default 10s\)
+      --verbose                 verbose output
+  -v, --version                 show version
+      --wait duration           how long to wait for another pmm-admin to release the lock of this client, e.g. 1m
 
 Use "pmm-admin \[command\] --help" for more information about a command.
 `
@@ -578,8 +593,13 @@ Flags:
       --json            print result as json
 
 Global Flags:
-  -c, --config-file string   PMM config file \(default ".*?"\)
-      --verbose              verbose output
+  -c, --config-file string      PMM config file \(default ".*?"\)
+      --retries int             number of retries of idempotent API requests \(default 3\)
+      --retry-delay duration    delay before the first retry, doubled for every next one \(default 500ms\)
+      --server-profile string   server profile from the config file to use instead of the default server
+      --timeout duration        timeout of a single API request \(default 10s\)
+      --verbose                 verbose output
+      --wait duration           how long to wait for another pmm-admin to release the lock of this client, e.g. 1m
 `
 		assertRegexpLines(t, expected, string(output))
 	})
@@ -691,7 +711,7 @@ mysql:queries          test-client-name        -                 YES
 		output, err := cmd.CombinedOutput()
 		assert.Nil(t, err)
 
-		expected := `[{"Type":"mongodb:queries","Name":"test-client-name","Port":"-","Running":true,"DSN":"-","Options":"","SSL":"","Password":""},{"Type":"mysql:queries","Name":"test-client-name","Port":"-","Running":true,"DSN":"-","Options":"","SSL":"","Password":""}]`
+		expected := `[{"Type":"mongodb:queries","Name":"test-client-name","Port":"-","Running":true,"Health":"","DSN":"-","Options":"","Labels":"","SSL":"","Password":""},{"Type":"mysql:queries","Name":"test-client-name","Port":"-","Running":true,"Health":"","DSN":"-","Options":"","Labels":"","SSL":"","Password":""}]`
 		assert.JSONEq(t, expected, string(output))
 	})
 }
@@ -1091,6 +1111,100 @@ Request duration    | .*
 Full round trip     | .*
 
 
+* Connection: Client <-- Server
+No metric endpoints registered.
+
+`
+		assertRegexpLines(t, expected, string(output))
+	}
+}
+
+func testCheckNetworkWithoutNTP(t *testing.T, data pmmAdminData) {
+	defer func() {
+		err := os.RemoveAll(data.rootDir)
+		assert.Nil(t, err)
+	}()
+
+	// Create fake api server
+	fapi := fakeapi.New()
+	defer fapi.Close()
+	u, _ := url.Parse(fapi.URL())
+	fapi.AppendRoot()
+	fapi.AppendPrometheusAPIV1Query()
+	fapi.AppendQanAPIPing()
+	fapi.AppendConsulV1StatusLeader(fapi.Host())
+	clientName, _ := os.Hostname()
+	node := &api.CatalogNode{
+		Node: &api.Node{},
+	}
+	fapi.AppendConsulV1CatalogNode(clientName, node)
+
+	// Create fake filesystem
+	os.MkdirAll(data.rootDir+pmm.PMMBaseDir, 0777)
+	os.Create(data.rootDir + pmm.PMMBaseDir + "/node_exporter")
+	os.Create(data.rootDir + pmm.PMMBaseDir + "/mysqld_exporter")
+	os.Create(data.rootDir + pmm.PMMBaseDir + "/mongodb_exporter")
+	os.Create(data.rootDir + pmm.PMMBaseDir + "/proxysql_exporter")
+
+	os.MkdirAll(data.rootDir+pmm.AgentBaseDir+"/bin", 0777)
+	os.Create(data.rootDir + pmm.AgentBaseDir + "/bin/percona-qan-agent")
+	os.MkdirAll(data.rootDir+pmm.AgentBaseDir+"/config", 0777)
+	os.MkdirAll(data.rootDir+pmm.AgentBaseDir+"/instance", 0777)
+
+	f, _ := os.Create(data.rootDir + pmm.AgentBaseDir + "/bin/percona-qan-agent-installer")
+	f.WriteString("#!/bin/sh\n")
+	f.WriteString("echo 'it works'")
+	f.Close()
+	os.Chmod(data.rootDir+pmm.AgentBaseDir+"/bin/percona-qan-agent-installer", 0777)
+
+	f, _ = os.Create(data.rootDir + pmm.AgentBaseDir + "/config/agent.conf")
+	f.WriteString(`{"UUID":"42","ApiHostname":"somehostname","ApiPath":"/qan-api","ServerUser":"pmm"}`)
+	f.WriteString("\n")
+	f.Close()
+	os.Chmod(data.rootDir+pmm.AgentBaseDir+"/bin/percona-qan-agent-installer", 0777)
+
+	pmmConfig := pmm.Config{
+		ServerAddress: fmt.Sprintf("%s:%s", fapi.Host(), fapi.Port()),
+		ClientName:    clientName,
+		ClientAddress: "localhost",
+		BindAddress:   "localhost",
+		NTPDisabled:   true,
+	}
+	bytes, _ := yaml.Marshal(pmmConfig)
+	ioutil.WriteFile(data.rootDir+pmm.PMMBaseDir+"/pmm.yml", bytes, 0600)
+
+	// Test the command
+	{
+		cmd := exec.Command(
+			data.bin,
+			"check-network",
+		)
+
+		output, err := cmd.CombinedOutput()
+		assert.Nil(t, err)
+		expected := `PMM Network Status
+
+Server Address | ` + u.Host + `
+Client Address | localhost
+
+* System Time
+PMM Server                          | .*
+PMM Client                          | .*
+PMM Client to PMM Server Time Drift | OK
+
+* Connection: Client --> Server
+-------------------- -------\s*
+SERVER SERVICE       STATUS \s*
+-------------------- -------\s*
+Consul API           OK     \s*
+Prometheus API       OK     \s*
+Query Analytics API  OK     \s*
+
+Connection duration | .*
+Request duration    | .*
+Full round trip     | .*
+
+
 * Connection: Client <-- Server
 No metric endpoints registered.
 
@@ -1139,6 +1253,7 @@ func testAddLinuxMetricsWithAdditionalArgsOk(t *testing.T, data pmmAdminData) {
 		fapi.AppendConsulV1CatalogNode(clientName, node)
 		fapi.AppendConsulV1CatalogService()
 		fapi.AppendConsulV1CatalogRegister()
+		fapi.AppendConsulV1Session()
 
 		// Configure pmm
 		cmd := exec.Command(
@@ -1207,6 +1322,7 @@ func testAddLinuxMetricsWithAdditionalArgsFail(t *testing.T, data pmmAdminData)
 		fapi.AppendConsulV1CatalogNode(clientName, node)
 		fapi.AppendConsulV1CatalogService()
 		fapi.AppendConsulV1CatalogRegister()
+		fapi.AppendConsulV1Session()
 
 		// Configure pmm
 		cmd := exec.Command(
@@ -1276,6 +1392,7 @@ func testAddMongoDB(t *testing.T, data pmmAdminData) {
 		fapi.AppendConsulV1CatalogNode(clientName, node)
 		fapi.AppendConsulV1CatalogService()
 		fapi.AppendConsulV1CatalogRegister()
+		fapi.AppendConsulV1Session()
 		mongodbInstance := &proto.Instance{
 			Subsystem: "mongodb",
 			UUID:      "13",
@@ -1359,6 +1476,7 @@ func testAddMongoDBQueries(t *testing.T, data pmmAdminData) {
 		fapi.AppendConsulV1CatalogNode(clientName, node)
 		fapi.AppendConsulV1CatalogService()
 		fapi.AppendConsulV1CatalogRegister()
+		fapi.AppendConsulV1Session()
 		mongodbInstance := &proto.Instance{
 			Subsystem: "mongodb",
 			UUID:      "13",
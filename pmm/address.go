@@ -0,0 +1,182 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// hostPort joins host and port, IPv6 address is put in brackets.
+func hostPort(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// hostLiteral returns host as it is written before the port, IPv6 address is put in brackets.
+func hostLiteral(host string) string {
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// trimBrackets returns host without brackets IPv6 address may be written in.
+func trimBrackets(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// parseHostIP returns IP address of the host which may be in brackets or with IPv6 zone, nil if it is not an IP address.
+func parseHostIP(host string) net.IP {
+	host = trimBrackets(host)
+	if i := strings.LastIndex(host, "%"); i != -1 {
+		host = host[:i]
+	}
+	return net.ParseIP(host)
+}
+
+// normalizeAddress returns client or bind address without brackets, IPv4-mapped IPv6 address is returned as IPv4.
+func normalizeAddress(address string) string {
+	address = trimBrackets(address)
+	if ip := net.ParseIP(address); ip != nil {
+		return ip.String()
+	}
+	return address
+}
+
+// normalizeServerAddress puts IPv6 address of the server without port in brackets, so it can be used in URLs.
+func normalizeServerAddress(address string) string {
+	if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+		return "[" + address + "]"
+	}
+	return address
+}
+
+// changeAddress move services of this client to the new client and bind addresses from the config.
+// Services keep their ports and QAN instances: services are registered with the new client address,
+// exporters are rewritten to listen on the new bind address, and self-signed certificate is regenerated
+// for the new SANs. Local services are to be restarted once the config is written.
+// If any step fails, the changes made so far are rolled back, the returned undo rolls them back
+// if a later step of the caller fails.
+// Returned warnings are about SSL certificate which may need to be replaced.
+func (a *Admin) changeAddress(ctx context.Context, node *RegistryNode, oldClientAddress, oldBindAddress string) (_ []string, _ func() error, err error) {
+	var undo []func() error
+	defer func() {
+		if err == nil {
+			return
+		}
+		if e := undoAll(undo); e != nil {
+			err = fmt.Errorf("Changing address failed: %w. Rolling back failed too: %s", err, e)
+			return
+		}
+		err = fmt.Errorf("Changing address failed, changes were rolled back: %w", err)
+	}()
+
+	// The config may change before undo is called, e.g. by rename.
+	clientName := a.Config.ClientName
+	newBindAddress := a.Config.BindAddress
+
+	// Registration is the most likely to fail as PMM server may be unreachable, so it goes first.
+	for _, svc := range node.Services {
+		svc := svc
+		undo = append(undo, func() error {
+			return a.registry().RegisterService(ctx, clientName, oldClientAddress, svc)
+		})
+		if err := a.registerService(ctx, svc); err != nil {
+			return nil, nil, fmt.Errorf("Unable to register service %s with the new client address: %w", svc.ID, err)
+		}
+	}
+
+	if newBindAddress != oldBindAddress {
+		// Services rewritten before the failure are rewritten back.
+		rewritten, err := a.rewriteServicesListenAddress(a.GetLocalServices(), oldBindAddress, newBindAddress)
+		undo = append(undo, func() error {
+			_, err := a.rewriteServicesListenAddress(rewritten, newBindAddress, oldBindAddress)
+			return err
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to update local services with the new bind address: %w", err)
+		}
+	}
+
+	// Certificate issued by own CA can't be regenerated, certWarnings tell to replace it.
+	certFile, keyFile := a.paths().SSLCertFile, a.paths().SSLKeyFile
+	if info, err := readCertInfo(certFile); err == nil && info.SelfSigned {
+		oldCert, err := ioutil.ReadFile(certFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		oldKey, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := generateSSLCertificate(certFile, keyFile, a.certOptions()); err != nil {
+			return nil, nil, err
+		}
+		undo = append(undo, func() error { return replaceCertificate(certFile, keyFile, oldCert, oldKey) })
+	}
+	return a.certWarnings(), func() error { return undoAll(undo) }, nil
+}
+
+// rewriteServicesListenAddress rewrite listen address of local exporters in their system service files and saved configs.
+// It returns the services whose files were rewritten, also on failure.
+func (a *Admin) rewriteServicesListenAddress(services []string, oldAddress, newAddress string) (rewritten []string, err error) {
+	m := a.serviceManager()
+	for _, svcName := range services {
+		file := m.ServiceFile(svcName)
+		fi, err := os.Stat(file)
+		if err != nil {
+			return rewritten, err
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return rewritten, err
+		}
+		if err := ioutil.WriteFile(file, replaceListenAddress(data, oldAddress, newAddress), fi.Mode()); err != nil {
+			return rewritten, err
+		}
+		rewritten = append(rewritten, svcName)
+
+		// Saved config is optional as services installed by older pmm-admin have none.
+		svcConfig, err := a.loadServiceConfig(svcName)
+		if err != nil {
+			continue
+		}
+		for i, arg := range svcConfig.Arguments {
+			svcConfig.Arguments[i] = string(replaceListenAddress([]byte(arg), oldAddress, newAddress))
+		}
+		if err := a.saveServiceConfig(svcConfig); err != nil {
+			return rewritten, err
+		}
+	}
+	return rewritten, m.Reload()
+}
+
+// replaceListenAddress replace address of -web.listen-address and --web.listen-address flags keeping the port.
+func replaceListenAddress(data []byte, oldAddress, newAddress string) []byte {
+	re := regexp.MustCompile(`(-web\.listen-address=)` + regexp.QuoteMeta(hostLiteral(oldAddress)) + `(:\d+)`)
+	return re.ReplaceAll(data, []byte("${1}"+strings.Replace(hostLiteral(newAddress), "$", "$$", -1)+"${2}"))
+}
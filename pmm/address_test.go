@@ -0,0 +1,131 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplaceListenAddress(t *testing.T) {
+	unit := `ExecStart=/usr/local/percona/pmm-client/node_exporter -web.listen-address=10.0.0.1:42000 -collectors.enabled=diskstats
+ExecStart=/usr/local/percona/pmm-client/mysqld_exporter -web.listen-address=10.0.0.10:42002
+ExecStart=/usr/local/percona/pmm-client/pmm-admin serve-metrics --web.listen-address=10.0.0.1:42005
+Environment="DATA_SOURCE_NAME=user:pass@tcp(10.0.0.1:3306)/"`
+	expected := `ExecStart=/usr/local/percona/pmm-client/node_exporter -web.listen-address=192.168.1.5:42000 -collectors.enabled=diskstats
+ExecStart=/usr/local/percona/pmm-client/mysqld_exporter -web.listen-address=10.0.0.10:42002
+ExecStart=/usr/local/percona/pmm-client/pmm-admin serve-metrics --web.listen-address=192.168.1.5:42005
+Environment="DATA_SOURCE_NAME=user:pass@tcp(10.0.0.1:3306)/"`
+	assert.Equal(t, expected, string(replaceListenAddress([]byte(unit), "10.0.0.1", "192.168.1.5")))
+
+	// Launchd plist has every argument in its own element.
+	plist := "<string>-web.listen-address=10.0.0.1:42000</string>"
+	assert.Equal(t, "<string>-web.listen-address=10.0.0.2:42000</string>", string(replaceListenAddress([]byte(plist), "10.0.0.1", "10.0.0.2")))
+}
+
+func TestReplaceListenAddressIPv6(t *testing.T) {
+	unit := `ExecStart=/usr/local/percona/pmm-client/node_exporter -web.listen-address=[fd00::1]:42000
+ExecStart=/usr/local/percona/pmm-client/mysqld_exporter -web.listen-address=[fd00::10]:42002`
+	expected := `ExecStart=/usr/local/percona/pmm-client/node_exporter -web.listen-address=10.0.0.1:42000
+ExecStart=/usr/local/percona/pmm-client/mysqld_exporter -web.listen-address=[fd00::10]:42002`
+	assert.Equal(t, expected, string(replaceListenAddress([]byte(unit), "fd00::1", "10.0.0.1")))
+
+	plist := "<string>-web.listen-address=10.0.0.1:42000</string>"
+	assert.Equal(t, "<string>-web.listen-address=[fd00::1]:42000</string>", string(replaceListenAddress([]byte(plist), "10.0.0.1", "fd00::1")))
+}
+
+func TestHostAddresses(t *testing.T) {
+	assert.Equal(t, "10.0.0.1:42000", hostPort("10.0.0.1", 42000))
+	assert.Equal(t, "[fd00::1]:42000", hostPort("fd00::1", 42000))
+	assert.Equal(t, "db01:42000", hostPort("db01", 42000))
+	assert.Equal(t, "[fd00::1]", hostLiteral("fd00::1"))
+	assert.Equal(t, "10.0.0.1", hostLiteral("10.0.0.1"))
+
+	for address, expected := range map[string]string{
+		"10.0.0.1":        "10.0.0.1",
+		"::ffff:10.0.0.1": "10.0.0.1",
+		"[fd00::1]":       "fd00::1",
+		"FD00:0:0::1":     "fd00::1",
+		"db01":            "db01",
+		"":                "",
+	} {
+		assert.Equal(t, expected, normalizeAddress(address), "address = %s", address)
+	}
+
+	for address, expected := range map[string]string{
+		"192.168.56.100":      "192.168.56.100",
+		"192.168.56.100:8000": "192.168.56.100:8000",
+		"fd00::100":           "[fd00::100]",
+		"[fd00::100]":         "[fd00::100]",
+		"[fd00::100]:8000":    "[fd00::100]:8000",
+		"pmm.example.com":     "pmm.example.com",
+	} {
+		assert.Equal(t, expected, normalizeServerAddress(address), "address = %s", address)
+	}
+
+	assert.Equal(t, "fe80::1", parseHostIP("[fe80::1%eth0]").String())
+	assert.Nil(t, parseHostIP("db01"))
+}
+
+func TestChangeAddressRollback(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "pmm-address")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// The second service has no run script, so rewriting it fails after the first one is rewritten.
+	m := &RunitServiceManager{ServiceDir: filepath.Join(dir, "sv")}
+	script := "#!/bin/sh\nexec /usr/local/percona/pmm-client/node_exporter -web.listen-address=10.0.0.1:42000\n"
+	assert.Nil(t, writeServiceFile(m.ServiceFile("pmm-linux-metrics-42000"), script, 0755))
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "sv", "pmm-mysql-metrics-42002"), 0755))
+
+	r := &FileRegistry{File: filepath.Join(dir, "registry.json")}
+	svc := &RegistryService{ID: "linux:metrics-42000", Service: "linux:metrics", Tags: []string{"alias_db01"}, Port: 42000}
+	assert.Nil(t, r.RegisterService(ctx, "db01", "10.0.0.1", svc))
+	node, err := r.ListNodeServices(ctx, "db01")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	a := &Admin{
+		Paths:          Paths{BaseDir: dir, AgentBaseDir: dir},
+		ServiceManager: m,
+		Registry:       r,
+		Config:         &Config{ClientName: "db01", ClientAddress: "10.0.0.2", BindAddress: "10.0.0.2"},
+	}
+	_, _, err = a.changeAddress(ctx, node, "10.0.0.1", "10.0.0.1")
+	if !assert.Error(t, err) {
+		return
+	}
+	assert.True(t, strings.HasPrefix(err.Error(), "Changing address failed, changes were rolled back:"), err.Error())
+
+	// Run script and registration are back to the old addresses.
+	data, err := ioutil.ReadFile(m.ServiceFile("pmm-linux-metrics-42000"))
+	assert.Nil(t, err)
+	assert.Equal(t, script, string(data))
+	node, err = r.ListNodeServices(ctx, "db01")
+	if assert.Nil(t, err) && assert.NotNil(t, node) {
+		assert.Equal(t, "10.0.0.1", node.Address)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Backoff defines exponential backoff used to retry idempotent API requests.
+// The zero value means DefaultBackoff for Admin.Backoff, use NoRetry to disable retries.
+type Backoff struct {
+	Retries  int           // number of retries after the first attempt, negative for none
+	Delay    time.Duration // delay before the first retry, doubled for every next one
+	MaxDelay time.Duration // upper limit of the delay
+}
+
+// DefaultBackoff is used when Admin.Backoff is unset.
+var DefaultBackoff = Backoff{
+	Retries:  3,
+	Delay:    500 * time.Millisecond,
+	MaxDelay: 5 * time.Second,
+}
+
+// NoRetry sends every request once.
+var NoRetry = Backoff{Retries: -1}
+
+// delay returns the delay before the given retry, starting from 0.
+func (b Backoff) delay(retry int) time.Duration {
+	d := b.Delay
+	for i := 0; i < retry; i++ {
+		d *= 2
+		if b.MaxDelay > 0 && d >= b.MaxDelay {
+			return b.MaxDelay
+		}
+	}
+	return d
+}
+
+// retry calls f until it asks to stop, retries are exhausted or ctx is done.
+// f returns whether the call should be retried and the error of the last attempt.
+func (b Backoff) retry(ctx context.Context, f func() (bool, error)) error {
+	for i := 0; ; i++ {
+		again, err := f()
+		if !again || i >= b.Retries {
+			return err
+		}
+
+		t := time.NewTimer(b.delay(i))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// retryTransport retries idempotent requests without body on temporary failures.
+// It is used by Consul and Prometheus clients which do not retry requests themselves.
+type retryTransport struct {
+	transport http.RoundTripper
+	backoff   Backoff
+}
+
+// newRetryTransport returns transport retrying requests sent via the given one with the given backoff.
+func newRetryTransport(transport http.RoundTripper, backoff Backoff) *retryTransport {
+	return &retryTransport{
+		transport: transport,
+		backoff:   backoff,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Request with body can't be sent again as the body is consumed.
+	if !isIdempotent(req.Method, req.URL.String()) || (req.Body != nil && req.Body != http.NoBody) {
+		return t.transport.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	var resp *http.Response
+	err := t.backoff.retry(ctx, func() (bool, error) {
+		// Only the last response is returned, previous ones are discarded.
+		if resp != nil {
+			resp.Body.Close()
+		}
+		var err error
+		resp, err = t.transport.RoundTrip(req)
+		if ctx.Err() != nil {
+			return false, err
+		}
+		if err != nil {
+			return isRetriable(err), err
+		}
+		switch resp.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true, nil
+		}
+		return false, nil
+	})
+	if err != nil && resp != nil {
+		// Canceled while waiting for the next retry.
+		resp.Body.Close()
+		resp = nil
+	}
+	return resp, err
+}
+
+// CancelRequest makes retryTransport satisfy prometheus.CancelableTransport.
+// Requests are canceled via their context, so it only passes the call to the wrapped transport if it supports it.
+func (t *retryTransport) CancelRequest(req *http.Request) {
+	if c, ok := t.transport.(interface{ CancelRequest(*http.Request) }); ok {
+		c.CancelRequest(req)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Retries: 5, Delay: time.Second, MaxDelay: 5 * time.Second}
+	assert.Equal(t, time.Second, b.delay(0))
+	assert.Equal(t, 2*time.Second, b.delay(1))
+	assert.Equal(t, 4*time.Second, b.delay(2))
+	assert.Equal(t, 5*time.Second, b.delay(3))
+	assert.Equal(t, 5*time.Second, b.delay(10))
+}
+
+func TestBackoffRetry(t *testing.T) {
+	b := Backoff{Retries: 3, Delay: time.Millisecond}
+
+	t.Run("exhausted", func(t *testing.T) {
+		calls := 0
+		err := b.retry(context.Background(), func() (bool, error) {
+			calls++
+			return true, errors.New("failed")
+		})
+		assert.EqualError(t, err, "failed")
+		assert.Equal(t, 4, calls)
+	})
+
+	t.Run("stopped", func(t *testing.T) {
+		calls := 0
+		err := b.retry(context.Background(), func() (bool, error) {
+			calls++
+			return calls < 2, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		calls := 0
+		err := b.retry(ctx, func() (bool, error) {
+			calls++
+			return true, nil
+		})
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestRetryTransport(t *testing.T) {
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.Method+" "+r.URL.Path]++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	transport := NewTransport(&tls.Config{}, nil, time.Second, false)
+	b := Backoff{Retries: 2, Delay: time.Millisecond, MaxDelay: time.Millisecond}
+	client := &http.Client{Transport: newRetryTransport(transport, b)}
+
+	resp, err := client.Get(srv.URL + "/v1/status/leader")
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	resp, err = client.Post(srv.URL+"/v1/query", "application/json", strings.NewReader("{}"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+
+	client = &http.Client{Transport: newRetryTransport(transport, NoRetry)}
+	resp, err = client.Get(srv.URL + "/api/v1/query")
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+
+	// Only GET with retries enabled is sent again.
+	assert.Equal(t, 3, requests["GET /v1/status/leader"])
+	assert.Equal(t, 1, requests["POST /v1/query"])
+	assert.Equal(t, 1, requests["GET /api/v1/query"])
+}
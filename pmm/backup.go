@@ -0,0 +1,293 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/percona/kardianos-service"
+	"github.com/percona/pmm/proto"
+)
+
+// Backup is the registration state of a client: its services in the registry and locally, and QAN instances.
+// It contains passwords, so it should be kept as secure as the config file.
+type Backup struct {
+	Version      string // pmm-admin version that made the backup
+	Created      time.Time
+	ClientName   string
+	Services     []BackupService
+	QANInstances []BackupQANInstance
+}
+
+// BackupService is a monitoring service.
+type BackupService struct {
+	Consul consul.AgentService // registered service, named after the first registry
+	KV     map[string]string   // service metadata
+	Config *service.Config     // nil if the service is not installed locally
+}
+
+// BackupQANInstance is an instance of QAN with its qan-agent files.
+type BackupQANInstance struct {
+	ServiceID string          // Consul ID of the queries service
+	Instance  proto.Instance  // instance/<uuid>.json including DSN
+	QANConfig json.RawMessage // config/qan-<uuid>.conf
+}
+
+// Backup export registration state of this client.
+func (a *Admin) Backup(ctx context.Context) (*Backup, error) {
+	node, err := a.registry().ListNodeServices(ctx, a.Config.ClientName)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil || len(node.Services) == 0 {
+		return nil, ErrNoService
+	}
+
+	b := &Backup{
+		Version:    Version,
+		Created:    time.Now(),
+		ClientName: a.Config.ClientName,
+	}
+	localServices := a.GetLocalServices()
+	for _, svc := range node.Services {
+		meta, err := a.registry().ListServiceMeta(ctx, a.Config.ClientName, svc.ID, "")
+		if err != nil {
+			return nil, err
+		}
+		bs := BackupService{Consul: *toAgentService(svc), KV: meta}
+		for _, key := range sortedKeys(meta) {
+			if isQANUUIDMetaKey(key) {
+				in, err := a.backupQANInstance(svc.ID, meta[key])
+				if err != nil {
+					return nil, err
+				}
+				b.QANInstances = append(b.QANInstances, in)
+			}
+		}
+
+		// Services installed by older pmm-admin have no saved config, it is parsed from their service file then.
+		svcName := fmt.Sprintf("pmm-%s-%d", strings.Replace(svc.Service, ":", "-", 1), svc.Port)
+		if contains(localServices, svcName) {
+			if bs.Config, err = a.loadServiceConfig(svcName); err != nil {
+				return nil, fmt.Errorf("problem with service config of %s: %w", svcName, err)
+			}
+		}
+		b.Services = append(b.Services, bs)
+	}
+
+	sort.Slice(b.Services, func(i, j int) bool { return b.Services[i].Consul.ID < b.Services[j].Consul.ID })
+	sort.Slice(b.QANInstances, func(i, j int) bool { return b.QANInstances[i].Instance.UUID < b.QANInstances[j].Instance.UUID })
+	return b, nil
+}
+
+// backupQANInstance read qan-agent files of QAN instance.
+func (a *Admin) backupQANInstance(serviceID, uuid string) (BackupQANInstance, error) {
+	in := BackupQANInstance{ServiceID: serviceID}
+	instanceFile := fmt.Sprintf("%s/instance/%s.json", a.paths().AgentBaseDir, uuid)
+	bytes, err := ioutil.ReadFile(instanceFile)
+	if err != nil {
+		return in, fmt.Errorf("problem with QAN instance %s: %w", uuid, err)
+	}
+	if err := json.Unmarshal(bytes, &in.Instance); err != nil {
+		return in, fmt.Errorf("problem with QAN instance file %s: %w", instanceFile, err)
+	}
+
+	// qan-agent writes the config once QAN is started, it may be missing if it was never started.
+	if bytes, err := ioutil.ReadFile(fmt.Sprintf("%s/config/qan-%s.conf", a.paths().AgentBaseDir, uuid)); err == nil {
+		in.QANConfig = bytes
+	}
+	return in, nil
+}
+
+// Restore recreate services of this client from backup and reconnect to the same QAN instances.
+// Services both registered and installed locally are left as is. It returns the number of restored services and QAN instances.
+func (a *Admin) Restore(ctx context.Context, b *Backup) (services, instances int, err error) {
+	if b.ClientName != a.Config.ClientName {
+		return 0, 0, fmt.Errorf("Backup was made for client %s, but this client name is %s. Run 'pmm-admin config --client-name %s' first.",
+			b.ClientName, a.Config.ClientName, b.ClientName)
+	}
+
+	// Register agent first as it resets qan-agent dirs.
+	var agentID, parentUUID string
+	if len(b.QANInstances) > 0 {
+		if agentID, parentUUID, err = a.ensureAgent(ctx); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	services, errs, err := a.restoreServices(ctx, b.Services)
+	if err != nil {
+		return services, instances, err
+	}
+
+	for _, bi := range b.QANInstances {
+		if err := a.restoreQANInstance(ctx, agentID, parentUUID, bi); err != nil {
+			errs = append(errs, fmt.Errorf("QAN instance %s (%s): %w", bi.Instance.Name, bi.Instance.UUID, err))
+			continue
+		}
+		instances++
+	}
+
+	if len(errs) > 0 {
+		return services, instances, errs
+	}
+	return services, instances, nil
+}
+
+// restoreServices register services with their metadata and install the ones missing locally.
+// Services both registered and installed locally are skipped.
+// Failures of single services are returned as errs, err stops the restore.
+func (a *Admin) restoreServices(ctx context.Context, backupServices []BackupService) (services int, errs Errors, err error) {
+	node, err := a.registry().ListNodeServices(ctx, a.Config.ClientName)
+	if err != nil {
+		return 0, nil, err
+	}
+	registered := map[string]bool{}
+	if node != nil {
+		for _, svc := range node.Services {
+			registered[svc.ID] = true
+		}
+	}
+
+	localServices := a.GetLocalServices()
+	for _, bs := range backupServices {
+		svc := fromAgentService(&bs.Consul)
+		svcName := fmt.Sprintf("pmm-%s-%d", strings.Replace(svc.Service, ":", "-", 1), svc.Port)
+		if registered[svc.ID] && contains(localServices, svcName) {
+			continue
+		}
+
+		if err := a.registerService(ctx, svc); err != nil {
+			return services, errs, err
+		}
+		for _, key := range sortedKeys(bs.KV) {
+			if err := a.registry().SetServiceMeta(ctx, a.Config.ClientName, svc.ID, key, bs.KV[key]); err != nil {
+				return services, errs, err
+			}
+		}
+
+		if contains(localServices, svcName) {
+			if err := a.startService(svcName); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", svc.ID, err))
+			}
+			continue
+		}
+		if bs.Config == nil {
+			errs = append(errs, fmt.Errorf("%s: no service config in the backup, please remove and add the service again", svc.ID))
+			continue
+		}
+		if strings.Contains(strings.Join(bs.Config.Arguments, " "), "-web.ssl-cert-file") {
+			if err := a.checkSSLCertificate(); err != nil {
+				return services, errs, err
+			}
+		}
+		if err := a.installService(bs.Config); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", svc.ID, err))
+			continue
+		}
+		services++
+	}
+	return services, errs, nil
+}
+
+// restoreQANInstance undelete QAN instance, write its qan-agent files and start QAN for it.
+// The instance is moved under the current agent as it may have been registered again.
+func (a *Admin) restoreQANInstance(ctx context.Context, agentID, parentUUID string, bi BackupQANInstance) error {
+	in, err := a.getInstance(ctx, bi.Instance.UUID)
+	if err == nil {
+		in.ParentUUID = parentUUID
+		in, err = a.undeleteInstance(ctx, in)
+	}
+	if err == errNoInstance {
+		return errors.New("instance does not exist on QAN API anymore, please add it again")
+	}
+	if err != nil {
+		return err
+	}
+
+	return a.startQANInstance(ctx, agentID, in, bi)
+}
+
+// startQANInstance write qan-agent files of QAN instance from backup and start QAN for it.
+// The instance may have a different UUID than in the backup if it was created again.
+func (a *Admin) startQANInstance(ctx context.Context, agentID string, in proto.Instance, bi BackupQANInstance) error {
+	// Write instance config for qan-agent with real DSN.
+	in.DSN = bi.Instance.DSN
+	bytes, _ := json.MarshalIndent(in, "", "    ")
+	if err := ioutil.WriteFile(fmt.Sprintf("%s/instance/%s.json", a.paths().AgentBaseDir, in.UUID), bytes, 0600); err != nil {
+		return err
+	}
+
+	qanConfig := map[string]interface{}{
+		"Interval":       60,
+		"ExampleQueries": true,
+	}
+	if len(bi.QANConfig) > 0 {
+		if err := json.Unmarshal(bi.QANConfig, &qanConfig); err != nil {
+			return err
+		}
+		qanConfig["UUID"] = in.UUID
+		bytes, _ := json.MarshalIndent(qanConfig, "", "    ")
+		if err := ioutil.WriteFile(fmt.Sprintf("%s/config/qan-%s.conf", a.paths().AgentBaseDir, in.UUID), bytes, 0600); err != nil {
+			return err
+		}
+	}
+	qanConfig["UUID"] = in.UUID
+
+	return a.startQAN(ctx, agentID, qanConfig)
+}
+
+// SaveBackup write backup to file readable by owner only.
+func SaveBackup(file string, b *Backup) error {
+	bytes, err := json.MarshalIndent(b, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, bytes, 0600)
+}
+
+// LoadBackup read backup from file.
+func LoadBackup(file string) (*Backup, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	b := &Backup{}
+	if err := json.Unmarshal(bytes, b); err != nil {
+		return nil, fmt.Errorf("Invalid backup file %s: %w", file, err)
+	}
+	return b, nil
+}
+
+// contains check if slice contains the string.
+func contains(slice []string, s string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
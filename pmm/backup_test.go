@@ -0,0 +1,161 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/percona/kardianos-service"
+	"github.com/percona/pmm-client/test/fakeserver"
+	"github.com/percona/pmm/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveLoadBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmm-backup")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	b := &Backup{
+		Version:    Version,
+		ClientName: "db01",
+		Services: []BackupService{{
+			Consul: consul.AgentService{ID: "mysql:queries-0", Service: "mysql:queries", Tags: []string{"alias_db01"}},
+			KV:     map[string]string{"db01/qan_mysql_uuid": "2b6c3eb3669943c160502874036968ba"},
+			Config: &service.Config{Name: "pmm-mysql-queries-0", Arguments: []string{"-foo"}},
+		}},
+		QANInstances: []BackupQANInstance{{
+			ServiceID: "mysql:queries-0",
+			Instance:  proto.Instance{UUID: "2b6c3eb3669943c160502874036968ba", DSN: "pmm:secret@tcp(localhost:3306)/"},
+			QANConfig: []byte(`{"UUID":"2b6c3eb3669943c160502874036968ba","CollectFrom":"perfschema"}`),
+		}},
+	}
+	file := filepath.Join(dir, "backup.json")
+	assert.Nil(t, SaveBackup(file, b))
+	fi, err := os.Stat(file)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	loaded, err := LoadBackup(file)
+	assert.Nil(t, err)
+	assert.Equal(t, b.Services, loaded.Services)
+	assert.Equal(t, b.QANInstances[0].Instance.DSN, loaded.QANInstances[0].Instance.DSN)
+	assert.JSONEq(t, string(b.QANInstances[0].QANConfig), string(loaded.QANInstances[0].QANConfig))
+}
+
+func TestRestoreOtherClient(t *testing.T) {
+	admin := &Admin{Config: &Config{ClientName: "db02"}}
+	_, _, err := admin.Restore(context.Background(), &Backup{ClientName: "db01"})
+	assert.EqualError(t, err, "Backup was made for client db01, but this client name is db02. Run 'pmm-admin config --client-name db01' first.")
+}
+
+func TestRestoreServicesSkipsExisting(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "pmm-restore")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	m := &RunitServiceManager{ServiceDir: filepath.Join(dir, "sv")}
+	assert.Nil(t, writeServiceFile(m.ServiceFile("pmm-linux-metrics-42000"), "#!/bin/sh\nexec /usr/local/percona/pmm-client/node_exporter\n", 0755))
+	r := &FileRegistry{File: filepath.Join(dir, "registry.json")}
+	existing := &RegistryService{ID: "linux:metrics-42000", Service: "linux:metrics", Tags: []string{"alias_db01", "scheme_https"}, Port: 42000}
+	assert.Nil(t, r.RegisterService(ctx, "db01", "127.0.0.1", existing))
+
+	a := &Admin{Paths: Paths{BaseDir: dir, AgentBaseDir: dir}, ServiceManager: m, Registry: r, Config: &Config{ClientName: "db01", ClientAddress: "127.0.0.1"}}
+	services, errs, err := a.restoreServices(ctx, []BackupService{
+		{Consul: consul.AgentService{ID: "linux:metrics-42000", Service: "linux:metrics", Tags: []string{"alias_db01"}, Port: 42000}},
+		{Consul: consul.AgentService{ID: "mysql:metrics-42002", Service: "mysql:metrics", Tags: []string{"alias_db01"}, Port: 42002}},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, 0, services)
+	// The existing service is not touched, the missing one is registered but can't be installed without config.
+	if assert.Len(t, errs, 1) {
+		assert.EqualError(t, errs[0], "mysql:metrics-42002: no service config in the backup, please remove and add the service again")
+	}
+	node, err := r.ListNodeServices(ctx, "db01")
+	if assert.Nil(t, err) && assert.NotNil(t, node) && assert.Len(t, node.Services, 2) {
+		assert.Equal(t, existing, node.Services[0])
+		assert.Equal(t, "mysql:metrics-42002", node.Services[1].ID)
+	}
+}
+
+func TestServiceConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmm-services")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	a := &Admin{Paths: Paths{ServiceConfigDir: filepath.Join(dir, "services")}}
+
+	svcConfig := &service.Config{
+		Name:        "pmm-linux-metrics-42000",
+		Executable:  "/usr/local/percona/pmm-client/node_exporter",
+		Arguments:   []string{"-web.listen-address=127.0.0.1:42000"},
+		Environment: []string{"HTTP_PROXY=http://proxy:3128"},
+	}
+	assert.Nil(t, a.saveServiceConfig(svcConfig))
+	loaded, err := a.loadServiceConfig(svcConfig.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, svcConfig, loaded)
+
+	_, err = a.loadServiceConfig("pmm-mysql-metrics-42002")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFakeServerRestoreQANInstance(t *testing.T) {
+	ctx := context.Background()
+	srv := fakeserver.New()
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "pmm-restore")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"config", "instance"} {
+		assert.Nil(t, os.MkdirAll(filepath.Join(dir, name), 0755))
+	}
+
+	admin := New(Options{Paths: Paths{BaseDir: dir, AgentBaseDir: dir}})
+	admin.Config = &Config{ServerAddress: srv.Address(), ClientName: "db01"}
+	if !assert.Nil(t, admin.SetAPI(ctx)) {
+		return
+	}
+
+	// The agent was registered again, so the instance is under the old parent.
+	parentUUID := srv.AddInstance(proto.Instance{Subsystem: "os", Name: "db01"})
+	agentID := srv.AddInstance(proto.Instance{Subsystem: "agent", Name: "db01", ParentUUID: parentUUID})
+	uuid := srv.AddInstance(proto.Instance{Subsystem: "mysql", Name: "db01", ParentUUID: "0ld"})
+	assert.Nil(t, admin.restoreQANInstance(ctx, agentID, parentUUID, BackupQANInstance{
+		ServiceID: "mysql:queries-0",
+		Instance:  proto.Instance{UUID: uuid, DSN: "pmm:secret@tcp(localhost:3306)/"},
+	}))
+
+	for _, in := range srv.Instances() {
+		if in.UUID == uuid {
+			assert.Equal(t, parentUUID, in.ParentUUID)
+		}
+	}
+	assert.True(t, FileExists(filepath.Join(dir, "instance", uuid+".json")))
+	if assert.Len(t, srv.Commands(agentID), 1) {
+		assert.Equal(t, "StartTool", srv.Commands(agentID)[0].Cmd)
+	}
+}
@@ -0,0 +1,400 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// CertOptions defines how to generate SSL certificate of the metric services.
+type CertOptions struct {
+	Lifetime time.Duration // validity period starting from now
+	KeyType  string        // "rsa" or "ecdsa"
+	Hosts    []string      // DNS names and IP addresses to include into SANs
+}
+
+// CertInfo SSL certificate details.
+type CertInfo struct {
+	File        string
+	Subject     string
+	Issuer      string
+	SelfSigned  bool
+	KeyType     string
+	NotBefore   time.Time
+	NotAfter    time.Time
+	DNSNames    []string
+	IPAddresses []string
+}
+
+// certOptions returns certificate options from the config with defaults applied.
+// SANs always include client and bind addresses.
+func (a *Admin) certOptions() CertOptions {
+	opts := CertOptions{
+		Lifetime: a.Config.CertLifetime,
+		KeyType:  a.Config.CertKeyType,
+	}
+	if opts.Lifetime == 0 {
+		opts.Lifetime = DefaultCertLifetime
+	}
+	if opts.KeyType == "" {
+		opts.KeyType = "rsa"
+	}
+	opts.Hosts = appendUnique(opts.Hosts, a.Config.ClientAddress, a.Config.BindAddress)
+	opts.Hosts = appendUnique(opts.Hosts, a.Config.CertSANs...)
+	return opts
+}
+
+// CertStatus returns SSL certificate details of the metric services and warnings about it.
+func (a *Admin) CertStatus() (*CertInfo, []string, error) {
+	info, err := readCertInfo(a.paths().SSLCertFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, a.certWarnings(), nil
+}
+
+// Format returns certificate details as a table.
+func (info *CertInfo) Format() string {
+	validity := time.Until(info.NotAfter)
+	expiry := fmt.Sprintf("expires in %d days", int(validity.Hours()/24))
+	if validity <= 0 {
+		expiry = "expired"
+	}
+	issuer := info.Issuer
+	if info.SelfSigned {
+		issuer = "self-signed"
+	}
+	sans := append(append([]string{}, info.IPAddresses...), info.DNSNames...)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-15s | %s\n", "Certificate", info.File)
+	fmt.Fprintf(&b, "%-15s | %s\n", "Subject", info.Subject)
+	fmt.Fprintf(&b, "%-15s | %s\n", "Issuer", issuer)
+	fmt.Fprintf(&b, "%-15s | %s\n", "Key Type", info.KeyType)
+	fmt.Fprintf(&b, "%-15s | %s\n", "Valid From", info.NotBefore.Format(time.RFC3339))
+	fmt.Fprintf(&b, "%-15s | %s (%s)\n", "Valid Until", info.NotAfter.Format(time.RFC3339),
+		colorStatus(expiry, expiry, validity > CertExpiryWarning))
+	fmt.Fprintf(&b, "%-15s | %s\n", "SANs", strings.Join(sans, ", "))
+	return b.String()
+}
+
+// RenewCertificate generate a new SSL certificate and restart metric services using it.
+// Non-zero options are saved to the config and used for the subsequent renewals.
+func (a *Admin) RenewCertificate(opts CertOptions) (int, error) {
+	if opts.KeyType != "" && opts.KeyType != "rsa" && opts.KeyType != "ecdsa" {
+		return 0, fmt.Errorf("Unsupported key type %s, use rsa or ecdsa.", opts.KeyType)
+	}
+	if opts.Lifetime < 0 {
+		return 0, errors.New("Certificate lifetime should be positive.")
+	}
+	if opts.Lifetime != 0 {
+		a.Config.CertLifetime = opts.Lifetime
+	}
+	if opts.KeyType != "" {
+		a.Config.CertKeyType = opts.KeyType
+	}
+	if len(opts.Hosts) > 0 {
+		a.Config.CertSANs = opts.Hosts
+	}
+	if err := a.writeConfig(); err != nil {
+		return 0, fmt.Errorf("Unable to write config file %s: %w", a.paths().ConfigFile, err)
+	}
+
+	if err := generateSSLCertificate(a.paths().SSLCertFile, a.paths().SSLKeyFile, a.certOptions()); err != nil {
+		return 0, err
+	}
+	return a.restartSSLServices()
+}
+
+// ImportCertificate install SSL certificate and key issued by own CA and restart metric services using it.
+// Certificate file may contain the chain of intermediate certificates.
+func (a *Admin) ImportCertificate(certFile, keyFile string) (int, error) {
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		return 0, fmt.Errorf("Invalid certificate or key: %w", err)
+	}
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return 0, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := replaceCertificate(a.paths().SSLCertFile, a.paths().SSLKeyFile, certPEM, keyPEM); err != nil {
+		return 0, err
+	}
+	return a.restartSSLServices()
+}
+
+// replaceCertificate replace certificate and key files with the new content.
+// Both are written to temporary files first, so running services never read partially written ones,
+// and the files keep their owner as they may be given to the service user.
+func replaceCertificate(certFile, keyFile string, certPEM, keyPEM []byte) error {
+	var tmpFiles []string
+	defer func() {
+		for _, f := range tmpFiles {
+			os.Remove(f)
+		}
+	}()
+	files := []struct {
+		name string
+		data []byte
+	}{{certFile, certPEM}, {keyFile, keyPEM}}
+	for _, f := range files {
+		tmp, err := ioutil.TempFile(filepath.Dir(f.name), filepath.Base(f.name)+".")
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.name, err)
+		}
+		tmpFiles = append(tmpFiles, tmp.Name())
+		_, err = tmp.Write(f.data)
+		if e := tmp.Close(); err == nil {
+			err = e
+		}
+		if err == nil {
+			err = keepOwner(tmp.Name(), f.name)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.name, err)
+		}
+	}
+	for i, f := range files {
+		if err := os.Rename(tmpFiles[i], f.name); err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.name, err)
+		}
+	}
+	return nil
+}
+
+// keepOwner give file the owner of the existing one it is going to replace.
+func keepOwner(file, existing string) error {
+	fi, err := os.Stat(existing)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return os.Chown(file, int(st.Uid), int(st.Gid))
+	}
+	return nil
+}
+
+// certWarnings check SSL certificate for near expiry and client address mismatch.
+func (a *Admin) certWarnings() (warnings []string) {
+	if !FileExists(a.paths().SSLCertFile) {
+		return nil
+	}
+	cert, err := readCertificate(a.paths().SSLCertFile)
+	if err != nil {
+		return []string{fmt.Sprintf("WARNING: unable to read SSL certificate: %s", err)}
+	}
+	if validity := time.Until(cert.NotAfter); validity <= 0 {
+		warnings = append(warnings, fmt.Sprintf("WARNING: SSL certificate %s has expired on %s.", a.paths().SSLCertFile, cert.NotAfter.Format(time.RFC3339)))
+	} else if validity <= CertExpiryWarning {
+		warnings = append(warnings, fmt.Sprintf("WARNING: SSL certificate %s expires in %d days.", a.paths().SSLCertFile, int(validity.Hours()/24)))
+	}
+	if err := cert.VerifyHostname(a.Config.ClientAddress); err != nil {
+		warnings = append(warnings, fmt.Sprintf("WARNING: SSL certificate %s is not valid for client address %s.", a.paths().SSLCertFile, a.Config.ClientAddress))
+	}
+	if len(warnings) > 0 {
+		warnings = append(warnings, "Run 'pmm-admin cert renew' or 'pmm-admin cert import' to replace it.")
+	}
+	return warnings
+}
+
+// restartSSLServices restart running local services that use SSL certificate.
+func (a *Admin) restartSSLServices() (int, error) {
+	var errs Errors
+	count := 0
+	m := a.serviceManager()
+	for _, svcName := range a.GetLocalServices() {
+		data, err := ioutil.ReadFile(m.ServiceFile(svcName))
+		if err != nil || !bytes.Contains(data, []byte("-web.ssl-cert-file")) {
+			continue
+		}
+		if !a.getServiceStatus(svcName) {
+			continue
+		}
+		if err := a.stopService(svcName); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := a.startService(svcName); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		count++
+	}
+	if len(errs) > 0 {
+		return count, errs
+	}
+	return count, nil
+}
+
+// readCertificate read the first certificate from PEM file.
+func readCertificate(certFile string) (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM encoded certificate found in %s", certFile)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// readCertInfo read certificate details from PEM file.
+func readCertInfo(certFile string) (*CertInfo, error) {
+	cert, err := readCertificate(certFile)
+	if err != nil {
+		return nil, err
+	}
+	info := &CertInfo{
+		File:      certFile,
+		Subject:   pkixName(cert.Subject),
+		Issuer:    pkixName(cert.Issuer),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		DNSNames:  cert.DNSNames,
+	}
+	info.SelfSigned = bytes.Equal(cert.RawIssuer, cert.RawSubject) &&
+		cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		info.KeyType = fmt.Sprintf("RSA %d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		info.KeyType = fmt.Sprintf("ECDSA %s", key.Curve.Params().Name)
+	default:
+		info.KeyType = cert.PublicKeyAlgorithm.String()
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	return info, nil
+}
+
+// pkixName returns the common name or organization of the certificate subject or issuer.
+func pkixName(name pkix.Name) string {
+	if name.CommonName != "" {
+		return name.CommonName
+	}
+	return strings.Join(name.Organization, ", ")
+}
+
+// generateSSLCertificate generate self-signed SSL certificate and key.
+func generateSSLCertificate(certFile, keyFile string, opts CertOptions) error {
+	// Generate key.
+	var privKey crypto.Signer
+	var keyBlock *pem.Block
+	keyUsage := x509.KeyUsageDigitalSignature
+	switch opts.KeyType {
+	case "ecdsa":
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return fmt.Errorf("failed to generate private key: %w", err)
+		}
+		keyBytes, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return fmt.Errorf("failed to marshal private key: %w", err)
+		}
+		privKey = key
+		keyBlock = &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}
+	default:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return fmt.Errorf("failed to generate private key: %w", err)
+		}
+		privKey = key
+		keyBlock = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	// Generate cert.
+	lifetime := opts.Lifetime
+	if lifetime == 0 {
+		lifetime = DefaultCertLifetime
+	}
+	// Backdate the start a bit to tolerate clock skew with server.
+	notBefore := time.Now().Add(-time.Hour)
+	serialNumber, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	cert := x509.Certificate{
+		Subject:               pkix.Name{Organization: []string{"PMM Client"}},
+		SerialNumber:          serialNumber,
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(lifetime),
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range opts.Hosts {
+		if ip := parseHostIP(host); ip != nil {
+			cert.IPAddresses = append(cert.IPAddresses, ip)
+		} else if host != "" {
+			cert.DNSNames = append(cert.DNSNames, host)
+		}
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, &cert, &cert, privKey.Public(), privKey)
+	if err != nil {
+		return fmt.Errorf("failed to generate certificate: %w", err)
+	}
+
+	// Write files replacing the existing ones, so running services never read partially written ones.
+	return replaceCertificate(certFile, keyFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}), pem.EncodeToMemory(keyBlock))
+}
+
+// appendUnique append non-empty values missing in the slice.
+func appendUnique(slice []string, values ...string) []string {
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		found := false
+		for _, s := range slice {
+			if s == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			slice = append(slice, v)
+		}
+	}
+	return slice
+}
@@ -0,0 +1,133 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateSSLCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	for _, keyType := range []string{"rsa", "ecdsa"} {
+		opts := CertOptions{
+			Lifetime: 48 * time.Hour,
+			KeyType:  keyType,
+			Hosts:    []string{"192.168.1.10", "db01.example.com"},
+		}
+		assert.Nil(t, generateSSLCertificate(certFile, keyFile, opts))
+
+		_, err := tls.LoadX509KeyPair(certFile, keyFile)
+		assert.Nil(t, err)
+
+		info, err := readCertInfo(certFile)
+		assert.Nil(t, err)
+		assert.True(t, info.SelfSigned)
+		assert.Equal(t, []string{"192.168.1.10"}, info.IPAddresses)
+		assert.Equal(t, []string{"db01.example.com"}, info.DNSNames)
+		assert.WithinDuration(t, time.Now().Add(47*time.Hour), info.NotAfter, time.Minute)
+		if keyType == "ecdsa" {
+			assert.Equal(t, "ECDSA P-256", info.KeyType)
+		} else {
+			assert.Equal(t, "RSA 2048", info.KeyType)
+		}
+	}
+
+	// Renewal replaces the files instead of rewriting them, so the old ones stay intact for readers which have them open.
+	oldCert, err := ioutil.ReadFile(certFile)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Link(certFile, filepath.Join(dir, "old.pem")))
+	assert.Nil(t, generateSSLCertificate(certFile, keyFile, CertOptions{Lifetime: time.Hour}))
+	data, err := ioutil.ReadFile(filepath.Join(dir, "old.pem"))
+	assert.Nil(t, err)
+	assert.Equal(t, oldCert, data)
+}
+
+func TestReplaceCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	assert.Nil(t, generateSSLCertificate(certFile, keyFile, CertOptions{Lifetime: time.Hour, KeyType: "ecdsa"}))
+
+	// Imported certificate replaces the files as a whole.
+	src := filepath.Join(dir, "src")
+	assert.Nil(t, os.Mkdir(src, 0755))
+	assert.Nil(t, generateSSLCertificate(filepath.Join(src, "cert.pem"), filepath.Join(src, "key.pem"), CertOptions{Lifetime: time.Hour, KeyType: "rsa"}))
+	certPEM, err := ioutil.ReadFile(filepath.Join(src, "cert.pem"))
+	assert.Nil(t, err)
+	keyPEM, err := ioutil.ReadFile(filepath.Join(src, "key.pem"))
+	assert.Nil(t, err)
+	assert.Nil(t, replaceCertificate(certFile, keyFile, certPEM, keyPEM))
+
+	_, err = tls.LoadX509KeyPair(certFile, keyFile)
+	assert.Nil(t, err)
+	info, err := readCertInfo(certFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "RSA 2048", info.KeyType)
+	fi, err := os.Stat(keyFile)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode())
+
+	// No temporary files are left behind.
+	files, err := filepath.Glob(filepath.Join(dir, "server.*"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{certFile, keyFile}, files)
+}
+
+func TestGenerateSSLCertificateIPv6(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	opts := CertOptions{KeyType: "ecdsa", Hosts: []string{"2001:db8::10", "10.0.0.1", "[fd00::1]", "db01"}}
+	assert.Nil(t, generateSSLCertificate(certFile, keyFile, opts))
+
+	info, err := readCertInfo(certFile)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"2001:db8::10", "10.0.0.1", "fd00::1"}, info.IPAddresses)
+	assert.Equal(t, []string{"db01"}, info.DNSNames)
+}
+
+func TestCertOptions(t *testing.T) {
+	admin := Admin{Config: &Config{
+		ClientAddress: "1.2.3.4",
+		BindAddress:   "10.0.0.1",
+		CertSANs:      []string{"db01", "1.2.3.4"},
+	}}
+	opts := admin.certOptions()
+	assert.Equal(t, DefaultCertLifetime, opts.Lifetime)
+	assert.Equal(t, "rsa", opts.KeyType)
+	assert.Equal(t, []string{"1.2.3.4", "10.0.0.1", "db01"}, opts.Hosts)
+}
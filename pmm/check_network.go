@@ -18,140 +18,98 @@
 package pmm
 
 import (
-	"crypto/tls"
+	"bytes"
+	"context"
 	"fmt"
-	"math"
+	"io"
 	"net"
 	"net/http"
 	"regexp"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
-	"github.com/beevik/ntp"
 	"github.com/fatih/color"
-	"golang.org/x/net/context"
 )
 
+// NetworkStatus is the result of connectivity check between client and server.
+type NetworkStatus struct {
+	ServerAddress        string
+	ClientName           string
+	ClientAddress        string
+	BindAddress          string
+	Proxy                string     // proxy URL without credentials, empty if not used
+	ProxySource          string     // config or environment
+	TimeDrift            *TimeDrift // nil if it was not possible to check
+	PrometheusAPI        bool
+	QANAPI               bool
+	Connection           *ConnectionStats // nil if it was not possible to measure
+	ServerProfiles       []ServerProfileStatus
+	ConsulACL            *ConsulACLStatus // nil if there is no token and access is not denied
+	Registered           bool             // client is registered in the registry
+	Services             int              // number of services registered in the registry
+	Endpoints            []ServiceStatus  // metric endpoints, Running if Prometheus scrapes them, SSL and Password are YES, NO or -
+	CertWarnings         []string
+	PrometheusTargetsURL string
+}
+
+// ConnectionStats is the connection performance with PMM server.
+type ConnectionStats struct {
+	Connection time.Duration
+	Request    time.Duration
+	RoundTrip  time.Duration
+}
+
 // CheckNetwork check connectivity between client and server.
-func (a *Admin) CheckNetwork() error {
+func (a *Admin) CheckNetwork(ctx context.Context) (*NetworkStatus, error) {
+	s := &NetworkStatus{
+		ServerAddress: a.Config.ServerAddress,
+		ClientName:    a.Config.ClientName,
+		ClientAddress: a.Config.ClientAddress,
+		BindAddress:   a.Config.BindAddress,
+	}
+	if proxyURL := a.serverProxy(); proxyURL != nil {
+		s.ProxySource = "environment"
+		if a.Config.ServerProxy != "" {
+			s.ProxySource = "config"
+		}
+		proxyURL.User = nil
+		s.Proxy = proxyURL.String()
+	}
+
 	// Check QAN API health.
-	qanStatus := false
 	url := a.qanAPI.URL(a.serverURL, qanAPIBasePath, "ping")
-	if resp, _, err := a.qanAPI.Get(url); err == nil {
+	if resp, _, err := a.qanAPI.Get(ctx, url); err == nil {
 		if resp.StatusCode == http.StatusOK && resp.Header.Get("X-Percona-Qan-Api-Version") != "" {
-			qanStatus = true
+			s.QANAPI = true
 		}
 	}
 
 	// Check Prometheus API by retrieving all "up" time series.
-	promStatus := true
-	promData, err := a.promQueryAPI.Query(context.Background(), "up", time.Now())
-	if err != nil {
-		promStatus = false
-	}
-
-	bindAddress := ""
-	if a.Config.ClientAddress != a.Config.BindAddress {
-		bindAddress = fmt.Sprintf("(%s)", a.Config.BindAddress)
-	}
-
-	fmt.Print("PMM Network Status\n\n")
-	fmt.Printf("%-14s | %s\n", "Server Address", a.Config.ServerAddress)
-	fmt.Printf("%-14s | %s %s\n\n", "Client Address", a.Config.ClientAddress, bindAddress)
-
-	t := a.getNginxHeader("X-Server-Time")
-	if t != "" {
-		timeFormat := "2006-01-02 15:04:05 -0700 MST"
-
-		// Real time (ntp server time)
-		ntpHost := "0.pool.ntp.org"
-		ntpTime, ntpTimeErr := ntp.Time(ntpHost)
-		ntpTimeText := ""
-		if ntpTimeErr != nil {
-			ntpTimeText = fmt.Sprintf("unable to get ntp time: %s", err)
-		} else {
-			ntpTimeText = ntpTime.Format(timeFormat)
-		}
-
-		// Server time
-		color.New(color.Bold).Println("* System Time")
-		var serverTime time.Time
-		if s, err := strconv.ParseInt(t, 10, 64); err == nil {
-			serverTime = time.Unix(s, 0)
-		} else {
-			serverTime, _ = time.Parse("Monday, 02-Jan-2006 15:04:05 MST", t)
-		}
-
-		// Client Time
-		clientTime := time.Now()
-
-		// Print times
-		if ntpTimeErr == nil {
-			fmt.Printf("%-35s | %s\n", fmt.Sprintf("NTP Server (%s)", ntpHost), ntpTimeText)
-		}
-		fmt.Printf("%-35s | %s\n", "PMM Server", serverTime.Format(timeFormat))
-		fmt.Printf("%-35s | %s\n", "PMM Client", clientTime.Format(timeFormat))
-
-		allowedDriftTime := float64(60) // seconds
-		if ntpTimeErr == nil {
-			// Calculate time drift between NTP Server and PMM Server
-			drift := math.Abs(float64(serverTime.Unix()) - float64(ntpTime.Unix()))
-			fmt.Printf("%-35s | %s\n", "PMM Server Time Drift", colorStatus("OK", fmt.Sprintf("%.0fs", drift), drift <= allowedDriftTime))
-			if drift > allowedDriftTime {
-				fmt.Print("Time is out of sync. Please make sure the server time is correct to see the metrics.\n")
-			}
-			// Calculate time drift between NTP Server and PMM Client
-			drift = math.Abs(float64(clientTime.Unix()) - float64(ntpTime.Unix()))
-			fmt.Printf("%-35s | %s\n", "PMM Client Time Drift", colorStatus("OK", fmt.Sprintf("%.0fs", drift), drift <= allowedDriftTime))
-			if drift > allowedDriftTime {
-				fmt.Print("Time is out of sync. Please make sure the client time is correct to see the metrics.\n")
-			}
-		}
-
-		// Calculate time drift between server and client
-		drift := math.Abs(float64(serverTime.Unix()) - float64(clientTime.Unix()))
-		fmt.Printf("%-35s | %s\n", "PMM Client to PMM Server Time Drift", colorStatus("OK", fmt.Sprintf("%.0fs", drift), drift <= allowedDriftTime))
-		if drift > allowedDriftTime {
-			fmt.Print("Time is out of sync. Please make sure the server time is correct to see the metrics.\n")
+	promData, err := a.promQueryAPI.Query(ctx, "up", time.Now())
+	s.PrometheusAPI = err == nil
+
+	s.TimeDrift, _ = a.CheckTimeDrift(ctx)
+	s.Connection, _ = a.testNetwork(ctx)
+	s.ServerProfiles = a.ServerProfilesStatus(ctx)
+	// Consul ACLs matter only if services are registered there.
+	if a.registry().Name() == "consul" {
+		if acl := a.CheckConsulACL(ctx); acl.TokenSource != "" || acl.Denied() {
+			s.ConsulACL = acl
 		}
 	}
 
-	fmt.Println()
-	color.New(color.Bold).Println("* Connection: Client --> Server")
-	fmt.Printf("%-20s %-13s\n", strings.Repeat("-", 20), strings.Repeat("-", 7))
-	fmt.Printf("%-20s %-13s\n", "SERVER SERVICE", "STATUS")
-	fmt.Printf("%-20s %-13s\n", strings.Repeat("-", 20), strings.Repeat("-", 7))
-	// Consul is always alive if we are at this point.
-	fmt.Printf("%-20s %-13s\n", "Consul API", colorStatus("OK", "", true))
-	fmt.Printf("%-20s %-13s\n", "Prometheus API", colorStatus("OK", "DOWN", promStatus))
-	fmt.Printf("%-20s %-13s\n\n", "Query Analytics API", colorStatus("OK", "DOWN", qanStatus))
-
-	a.testNetwork()
-	fmt.Println()
-
-	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
+	node, err := a.registry().ListNodeServices(ctx, a.Config.ClientName)
 	if err != nil || node == nil {
-		fmt.Printf("%s '%s'.\n\n", noMonitoring, a.Config.ClientName)
-		return nil
+		return s, nil
 	}
-
-	if !promStatus {
-		fmt.Print("Prometheus is down. Please check if PMM server container runs properly.\n\n")
-		return nil
-	}
-
-	fmt.Println()
-	color.New(color.Bold).Println("* Connection: Client <-- Server")
-	if len(node.Services) == 0 {
-		fmt.Print("No metric endpoints registered.\n\n")
-		return nil
+	s.Registered = true
+	s.Services = len(node.Services)
+	if !s.PrometheusAPI {
+		return s, nil
 	}
 
 	// Check Prometheus endpoint status.
-	svcTable := []ServiceStatus{}
-	errStatus := false
 	for _, svc := range node.Services {
 		if !strings.HasSuffix(svc.Service, ":metrics") {
 			continue
@@ -167,18 +125,15 @@ func (a *Admin) CheckNetwork() error {
 		}
 
 		running := checkPromTargetStatus(promData.String(), name, strings.Split(svc.Service, ":")[0])
-		if !running {
-			errStatus = true
-		}
 
 		// Check protection status.
-		localStatus := getServiceStatus(fmt.Sprintf("pmm-%s-%d", strings.Replace(svc.Service, ":", "-", 1), svc.Port))
+		localStatus := a.getServiceStatus(fmt.Sprintf("pmm-%s-%d", strings.Replace(svc.Service, ":", "-", 1), svc.Port))
 		sslVal := "-"
 		protectedVal := "-"
 		if localStatus {
-			sslVal = colorStatus("YES", "NO", a.isSSLProtected(svc.Service, svc.Port))
+			sslVal = yesNo(a.isSSLProtected(ctx, svc.Service, svc.Port))
 			if a.Config.ServerUser != "" {
-				protectedVal = colorStatus("YES", "NO", a.isPasswordProtected(svc.Service, svc.Port))
+				protectedVal = yesNo(a.isPasswordProtected(ctx, svc.Service, svc.Port))
 			}
 		}
 
@@ -190,12 +145,121 @@ func (a *Admin) CheckNetwork() error {
 			SSL:      sslVal,
 			Password: protectedVal,
 		}
-		svcTable = append(svcTable, row)
+		s.Endpoints = append(s.Endpoints, row)
+	}
+	sort.Sort(sortOutput(s.Endpoints))
+
+	s.CertWarnings = a.certWarnings()
+
+	scheme := "http"
+	if a.Config.ServerInsecureSSL || a.Config.ServerSSL {
+		scheme = "https"
+	}
+	s.PrometheusTargetsURL = fmt.Sprintf("%s://%s/prometheus/targets", scheme, a.Config.ServerAddress)
+	return s, nil
+}
+
+// Format formats *NetworkStatus as text and returns result as string.
+func (s *NetworkStatus) Format() string {
+	b := &bytes.Buffer{}
+	bold := color.New(color.Bold)
+
+	bindAddress := ""
+	if s.ClientAddress != s.BindAddress {
+		bindAddress = fmt.Sprintf("(%s)", s.BindAddress)
+	}
+
+	fmt.Fprint(b, "PMM Network Status\n\n")
+	fmt.Fprintf(b, "%-14s | %s\n", "Server Address", s.ServerAddress)
+	fmt.Fprintf(b, "%-14s | %s %s\n", "Client Address", s.ClientAddress, bindAddress)
+	if s.Proxy != "" {
+		fmt.Fprintf(b, "%-14s | %s (%s)\n", "Proxy", s.Proxy, s.ProxySource)
+	}
+	fmt.Fprintln(b)
+
+	if td := s.TimeDrift; td != nil {
+		timeFormat := "2006-01-02 15:04:05 -0700 MST"
+		bold.Fprintln(b, "* System Time")
+		if td.NTPServer != "" {
+			fmt.Fprintf(b, "%-35s | %s\n", fmt.Sprintf("NTP Server (%s)", td.NTPServer), td.NTPTime.Format(timeFormat))
+		} else if td.NTPError != nil {
+			fmt.Fprintf(b, "%-35s | unable to get ntp time: %s\n", "NTP Server", td.NTPError)
+		}
+		fmt.Fprintf(b, "%-35s | %s\n", "PMM Server", td.ServerTime.Format(timeFormat))
+		fmt.Fprintf(b, "%-35s | %s\n", "PMM Client", td.ClientTime.Format(timeFormat))
+
+		if td.NTPServer != "" {
+			// Time drift between NTP Server and PMM Server
+			formatTimeDrift(b, "PMM Server Time Drift", td.ServerDrift, td.Threshold, "server")
+			// Time drift between NTP Server and PMM Client
+			formatTimeDrift(b, "PMM Client Time Drift", td.ClientDrift, td.Threshold, "client")
+		}
+		// Time drift between server and client
+		formatTimeDrift(b, "PMM Client to PMM Server Time Drift", td.ClientServerDrift, td.Threshold, "server")
+	}
+
+	fmt.Fprintln(b)
+	bold.Fprintln(b, "* Connection: Client --> Server")
+	fmt.Fprintf(b, "%-20s %-13s\n", strings.Repeat("-", 20), strings.Repeat("-", 7))
+	fmt.Fprintf(b, "%-20s %-13s\n", "SERVER SERVICE", "STATUS")
+	fmt.Fprintf(b, "%-20s %-13s\n", strings.Repeat("-", 20), strings.Repeat("-", 7))
+	// Consul is always alive if we are at this point.
+	fmt.Fprintf(b, "%-20s %-13s\n", "Consul API", colorStatus("OK", "", true))
+	fmt.Fprintf(b, "%-20s %-13s\n", "Prometheus API", colorStatus("OK", "DOWN", s.PrometheusAPI))
+	fmt.Fprintf(b, "%-20s %-13s\n\n", "Query Analytics API", colorStatus("OK", "DOWN", s.QANAPI))
+
+	if c := s.Connection; c != nil {
+		fmt.Fprintf(b, "%-19s | %v\n", "Connection duration", c.Connection)
+		fmt.Fprintf(b, "%-19s | %v\n", "Request duration", c.Request)
+		fmt.Fprintf(b, "%-19s | %v\n", "Full round trip", c.RoundTrip)
+	} else {
+		fmt.Fprintln(b, "Unable to measure the connection performance.")
+	}
+	fmt.Fprintln(b)
+
+	if acl := s.ConsulACL; acl != nil {
+		source := acl.TokenSource
+		if source == "" {
+			source = "none"
+		}
+		bold.Fprintf(b, "* Consul ACL (token: %s)\n", source)
+		fmt.Fprintf(b, "%-35s | %s\n", fmt.Sprintf("Register services of node %s", acl.Node), colorYesNo(acl.NodeWrite))
+		fmt.Fprintf(b, "%-35s | %s\n", fmt.Sprintf("Read KV prefix %s", acl.KVPrefix), colorYesNo(acl.KVRead))
+		fmt.Fprintf(b, "%-35s | %s\n", fmt.Sprintf("Write KV prefix %s", acl.KVPrefix), colorYesNo(acl.KVWrite))
+		if acl.Denied() {
+			fmt.Fprintf(b, `
+The token lacks access to the data of this client, adding and removing services will fail.
+Ask PMM server administrator for a token with node "%s" and key "%s" write policies
+and set it with 'pmm-admin config --consul-token-file'.
+`, acl.Node, acl.KVPrefix)
+		}
+		fmt.Fprintln(b)
+	}
+
+	if len(s.ServerProfiles) > 0 {
+		bold.Fprintln(b, "* Server Profiles")
+		fmt.Fprintln(b, serverProfilesTable(s.ServerProfiles))
+	}
+
+	if !s.Registered {
+		fmt.Fprintf(b, "%s '%s'.\n\n", noMonitoring, s.ClientName)
+		return b.String()
+	}
+	if !s.PrometheusAPI {
+		fmt.Fprint(b, "Prometheus is down. Please check if PMM server container runs properly.\n\n")
+		return b.String()
+	}
+
+	fmt.Fprintln(b)
+	bold.Fprintln(b, "* Connection: Client <-- Server")
+	if s.Services == 0 {
+		fmt.Fprint(b, "No metric endpoints registered.\n\n")
+		return b.String()
 	}
 
 	maxTypeLen := len("SERVICE TYPE")
 	maxNameLen := len("NAME")
-	for _, in := range svcTable {
+	for _, in := range s.Endpoints {
 		if len(in.Type) > maxTypeLen {
 			maxTypeLen = len(in.Type)
 		}
@@ -205,94 +269,128 @@ func (a *Admin) CheckNetwork() error {
 	}
 	maxTypeLen++
 	maxNameLen++
-	maxAddrLen := len(a.Config.ClientAddress) + 7
+	maxAddrLen := len(s.endpoint("00000")) + 1
 	maxStatusLen := 7
 	maxProtectedLen := 9
 	maxSSLLen := 10
-	if a.Config.ClientAddress != a.Config.BindAddress {
-		maxAddrLen = len(a.Config.ClientAddress) + len(a.Config.BindAddress) + 10
-	}
 
 	fmtPattern := "%%-%ds %%-%ds %%-%ds %%-%ds %%-%ds %%-%ds\n"
 	linefmt := fmt.Sprintf(fmtPattern, maxTypeLen, maxNameLen, maxAddrLen, maxStatusLen, maxSSLLen, maxProtectedLen)
 
-	fmt.Printf(linefmt, strings.Repeat("-", maxTypeLen), strings.Repeat("-", maxNameLen), strings.Repeat("-", maxAddrLen),
+	fmt.Fprintf(b, linefmt, strings.Repeat("-", maxTypeLen), strings.Repeat("-", maxNameLen), strings.Repeat("-", maxAddrLen),
 		strings.Repeat("-", maxStatusLen), strings.Repeat("-", maxSSLLen), strings.Repeat("-", maxProtectedLen))
-	fmt.Printf(linefmt, "SERVICE TYPE", "NAME", "REMOTE ENDPOINT", "STATUS", "HTTPS/TLS", "PASSWORD")
-	fmt.Printf(linefmt, strings.Repeat("-", maxTypeLen), strings.Repeat("-", maxNameLen), strings.Repeat("-", maxAddrLen),
+	fmt.Fprintf(b, linefmt, "SERVICE TYPE", "NAME", "REMOTE ENDPOINT", "STATUS", "HTTPS/TLS", "PASSWORD")
+	fmt.Fprintf(b, linefmt, strings.Repeat("-", maxTypeLen), strings.Repeat("-", maxNameLen), strings.Repeat("-", maxAddrLen),
 		strings.Repeat("-", maxStatusLen), strings.Repeat("-", maxSSLLen), strings.Repeat("-", maxProtectedLen))
 
-	sort.Sort(sortOutput(svcTable))
+	errStatus := false
 	maxStatusLen += 11
-	linefmt = fmt.Sprintf(fmtPattern, maxTypeLen, maxNameLen, maxAddrLen, maxStatusLen, maxSSLLen, maxProtectedLen)
-	for _, i := range svcTable {
-		if i.SSL != "-" {
-			linefmt = fmt.Sprintf(fmtPattern, maxTypeLen, maxNameLen, maxAddrLen, maxStatusLen, maxSSLLen+11, maxProtectedLen)
+	for _, i := range s.Endpoints {
+		if !i.Running {
+			errStatus = true
 		}
-		if a.Config.ClientAddress != a.Config.BindAddress {
-			fmt.Printf(linefmt, i.Type, i.Name, a.Config.ClientAddress+"-->"+a.Config.BindAddress+":"+i.Port,
-				colorStatus("OK", "DOWN", i.Running), i.SSL, i.Password)
-		} else {
-			fmt.Printf(linefmt, i.Type, i.Name, a.Config.ClientAddress+":"+i.Port,
-				colorStatus("OK", "DOWN", i.Running), i.SSL, i.Password)
+		sslLen := maxSSLLen
+		if i.SSL != "-" {
+			sslLen += 11
 		}
+		linefmt = fmt.Sprintf(fmtPattern, maxTypeLen, maxNameLen, maxAddrLen, maxStatusLen, sslLen, maxProtectedLen)
+		fmt.Fprintf(b, linefmt, i.Type, i.Name, s.endpoint(i.Port), colorStatus("OK", "DOWN", i.Running), colorYesNo(i.SSL), colorYesNo(i.Password))
+	}
 
+	if len(s.CertWarnings) > 0 {
+		fmt.Fprintln(b)
+		for _, w := range s.CertWarnings {
+			fmt.Fprintln(b, w)
+		}
 	}
 
 	if errStatus {
-		scheme := "http"
-		if a.Config.ServerInsecureSSL || a.Config.ServerSSL {
-			scheme = "https"
-		}
-		url := fmt.Sprintf("%s://%s/prometheus/targets", scheme, a.Config.ServerAddress)
-		fmt.Printf(`
+		fmt.Fprintf(b, `
 When an endpoint is down it may indicate that the corresponding service is stopped (run 'pmm-admin list' to verify).
-If it's running, check out the logs /var/log/pmm-*.log
+If it's running, check out the logs with 'pmm-admin logs TYPE [name]'
 
 When all endpoints are down but 'pmm-admin list' shows they are up and no errors in the logs,
 check the firewall settings whether this system allows incoming connections from server to address:port in question.
 
 Also you can check the endpoint status by the URL: %s
-			`, url)
-		if a.Config.ClientAddress != a.Config.BindAddress {
-			fmt.Println(`
+			`, s.PrometheusTargetsURL)
+		if s.ClientAddress != s.BindAddress {
+			fmt.Fprintln(b, `
 IMPORTANT: client and bind addresses are not the same which means you need to configure NAT/port forwarding to map them.`)
 		}
 	}
-	fmt.Println()
-	return nil
+	fmt.Fprintln(b)
+	return b.String()
+}
+
+// endpoint returns remote endpoint of the port, with the bind address it is mapped to if that differs.
+func (s *NetworkStatus) endpoint(port string) string {
+	if s.ClientAddress != s.BindAddress {
+		return hostLiteral(s.ClientAddress) + "-->" + net.JoinHostPort(s.BindAddress, port)
+	}
+	return net.JoinHostPort(s.ClientAddress, port)
+}
+
+// formatTimeDrift write time drift status and the hint if it exceeds the threshold.
+func formatTimeDrift(w io.Writer, title string, drift, threshold time.Duration, side string) {
+	ok := drift <= threshold
+	fmt.Fprintf(w, "%-35s | %s\n", title, colorStatus("OK", drift.Round(time.Second).String(), ok))
+	if !ok {
+		fmt.Fprintf(w, "Time is out of sync. Please make sure the %s time is correct to see the metrics.\n", side)
+	}
+}
+
+// yesNo returns YES or NO.
+func yesNo(ok bool) string {
+	if ok {
+		return "YES"
+	}
+	return "NO"
+}
+
+// colorYesNo returns YES or NO value colored, other values as is.
+func colorYesNo(v string) string {
+	if v != "YES" && v != "NO" {
+		return v
+	}
+	return colorStatus("YES", "NO", v == "YES")
 }
 
 // testNetwork measure round trip duration of server connection.
-func (a *Admin) testNetwork() {
-	insecureFlag := false
-	if a.Config.ServerInsecureSSL {
-		insecureFlag = true
+func (a *Admin) testNetwork(ctx context.Context) (*ConnectionStats, error) {
+	tlsConfig, err := a.serverTLSConfig()
+	if err != nil {
+		return nil, err
 	}
 
 	conn := &networkTransport{
 		dialer: &net.Dialer{
-			Timeout:   a.apiTimeout,
-			KeepAlive: a.apiTimeout,
+			Timeout:   a.Timeout,
+			KeepAlive: a.Timeout,
 		},
 	}
 	conn.rtp = &http.Transport{
 		Proxy:           http.ProxyFromEnvironment,
-		Dial:            conn.dial,
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: insecureFlag},
+		DialContext:     conn.dial,
+		TLSClientConfig: tlsConfig,
 	}
 	client := &http.Client{Transport: conn}
 
-	resp, err := client.Get(a.serverURL)
+	req, err := http.NewRequest("GET", a.serverURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
-		fmt.Println("Unable to measure the connection performance.")
-		return
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	fmt.Printf("%-19s | %v\n", "Connection duration", conn.connEnd.Sub(conn.connStart))
-	fmt.Printf("%-19s | %v\n", "Request duration", conn.reqEnd.Sub(conn.reqStart)-conn.connEnd.Sub(conn.connStart))
-	fmt.Printf("%-19s | %v\n", "Full round trip", conn.reqEnd.Sub(conn.reqStart))
+	return &ConnectionStats{
+		Connection: conn.connEnd.Sub(conn.connStart),
+		Request:    conn.reqEnd.Sub(conn.reqStart) - conn.connEnd.Sub(conn.connStart),
+		RoundTrip:  conn.reqEnd.Sub(conn.reqStart),
+	}, nil
 }
 
 type networkTransport struct {
@@ -311,9 +409,9 @@ func (conn *networkTransport) RoundTrip(r *http.Request) (*http.Response, error)
 	return resp, err
 }
 
-func (conn *networkTransport) dial(network, addr string) (net.Conn, error) {
+func (conn *networkTransport) dial(ctx context.Context, network, addr string) (net.Conn, error) {
 	conn.connStart = time.Now()
-	cn, err := conn.dialer.Dial(network, addr)
+	cn, err := conn.dialer.DialContext(ctx, network, addr)
 	conn.connEnd = time.Now()
 	return cn, err
 }
@@ -330,20 +428,19 @@ func checkPromTargetStatus(data, alias, job string) bool {
 }
 
 // isPasswordProtected check if endpoint is password protected.
-func (a *Admin) isPasswordProtected(svcType string, port int) bool {
+func (a *Admin) isPasswordProtected(ctx context.Context, svcType string, port int) bool {
 	urlPath := "metrics"
 	if svcType == "mysql:metrics" {
 		urlPath = "metrics-hr"
 	}
 	scheme := "http"
-	api := a.qanAPI
-	if a.isSSLProtected(svcType, port) {
+	// exporterAPI skips TLS verification to bypass err and check http code.
+	api := a.exporterAPI
+	if a.isSSLProtected(ctx, svcType, port) {
 		scheme = "https"
-		// Enforce InsecureSkipVerify true to bypass err and check http code.
-		api = NewAPI(true, apiTimeout, a.Verbose)
 	}
-	url := api.URL(fmt.Sprintf("%s://%s:%d", scheme, a.Config.BindAddress, port), urlPath)
-	if resp, _, err := api.Get(url); err == nil && resp.StatusCode == http.StatusUnauthorized {
+	url := api.URL(fmt.Sprintf("%s://%s", scheme, hostPort(a.Config.BindAddress, port)), urlPath)
+	if resp, _, err := api.Get(ctx, url); err == nil && resp.StatusCode == http.StatusUnauthorized {
 		return true
 	}
 
@@ -351,9 +448,9 @@ func (a *Admin) isPasswordProtected(svcType string, port int) bool {
 }
 
 // isSSLProtected check if endpoint is https/tls protected.
-func (a *Admin) isSSLProtected(svcType string, port int) bool {
-	url := a.qanAPI.URL(fmt.Sprintf("http://%s:%d", a.Config.BindAddress, port))
-	if _, _, err := a.qanAPI.Get(url); err != nil && strings.Contains(err.Error(), "malformed HTTP response") {
+func (a *Admin) isSSLProtected(ctx context.Context, svcType string, port int) bool {
+	url := a.exporterAPI.URL("http://" + hostPort(a.Config.BindAddress, port))
+	if _, _, err := a.exporterAPI.Get(ctx, url); err != nil && strings.Contains(err.Error(), "malformed HTTP response") {
 		return true
 	}
 
@@ -0,0 +1,40 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetworkStatusEndpoint(t *testing.T) {
+	for _, tc := range []struct {
+		client, bind string
+		expected     string
+	}{
+		{"10.0.0.1", "10.0.0.1", "10.0.0.1:42000"},
+		{"2001:db8::10", "2001:db8::10", "[2001:db8::10]:42000"},
+		{"1.2.3.4", "10.0.0.1", "1.2.3.4-->10.0.0.1:42000"},
+		{"2001:db8::10", "10.0.0.1", "[2001:db8::10]-->10.0.0.1:42000"},
+		{"1.2.3.4", "fd00::1", "1.2.3.4-->[fd00::1]:42000"},
+	} {
+		s := &NetworkStatus{ClientAddress: tc.client, BindAddress: tc.bind}
+		assert.Equal(t, tc.expected, s.endpoint("42000"))
+	}
+}
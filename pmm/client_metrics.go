@@ -0,0 +1,356 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/percona/kardianos-service"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// ClientMetricsOptions defines how pmm-admin serves its own metrics.
+// The options mirror the ones of the other exporters.
+type ClientMetricsOptions struct {
+	ListenAddress string // host:port to listen on
+	AuthFile      string // config file with server_user and server_password for HTTP basic auth
+	SSLCertFile   string // serve HTTPS if set along with a.paths().SSLKeyFile
+	SSLKeyFile    string
+
+	HealthCheckInterval time.Duration // how often to update Consul health checks of services, never if zero
+	HealthCheckError    func(error)   // called when health checks fail to update, they are retried on the next run
+}
+
+// AddClientMetrics add pmm-admin self-monitoring exporter to monitoring.
+// Returned warnings are about things that did not fail adding the service.
+func (a *Admin) AddClientMetrics(ctx context.Context) ([]string, error) {
+	// There could be only one self-monitoring service per client.
+	registered, err := a.getRegisteredService(ctx, "pmm-client:metrics", "")
+	if err != nil {
+		return nil, err
+	}
+	if registered != nil {
+		return nil, ErrOneClientMetrics
+	}
+
+	// Choose port.
+	var port int
+	if a.ServicePort > 0 {
+		// The port is user defined.
+		port, err = a.choosePort(ctx, a.ServicePort, true)
+	} else {
+		// Choose first port available starting the given default one.
+		port, err = a.choosePort(ctx, 42005, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Register service.
+	srv := RegistryService{
+		ID:      fmt.Sprintf("pmm-client:metrics-%d", port),
+		Service: "pmm-client:metrics",
+		Tags:    a.labelTags([]string{fmt.Sprintf("alias_%s", a.Config.ClientName), "scheme_https"}),
+		Port:    int(port),
+	}
+	if err := a.registerService(ctx, &srv); err != nil {
+		return nil, err
+	}
+
+	// Check and generate certificate if needed.
+	if err := a.checkSSLCertificate(); err != nil {
+		return nil, err
+	}
+
+	args := []string{
+		"serve-metrics",
+		fmt.Sprintf("--config-file=%s", a.paths().ConfigFile),
+		fmt.Sprintf("--web.listen-address=%s", hostPort(a.Config.BindAddress, port)),
+		fmt.Sprintf("--web.auth-file=%s", a.authFile()),
+		fmt.Sprintf("--web.ssl-cert-file=%s", a.paths().SSLCertFile),
+		fmt.Sprintf("--web.ssl-key-file=%s", a.paths().SSLKeyFile),
+	}
+
+	// Install and start service via platform service manager.
+	// Executable defaults to the current one, i.e. pmm-admin.
+	svcConfig := &service.Config{
+		Name:        fmt.Sprintf("pmm-pmm-client-metrics-%d", port),
+		DisplayName: "PMM Client self-monitoring exporter",
+		Description: "PMM Client self-monitoring exporter",
+		Arguments:   args,
+	}
+	if err := a.installService(svcConfig); err != nil {
+		// Service is not running, so it should not be left registered either.
+		a.registry().DeregisterService(ctx, a.Config.ClientName, srv.ID)
+		return nil, err
+	}
+
+	return a.initHealthCheck(ctx, &srv), nil
+}
+
+// RemoveClientMetrics remove pmm-admin self-monitoring exporter from monitoring.
+func (a *Admin) RemoveClientMetrics(ctx context.Context) error {
+	// Check if we have this service registered.
+	registered, err := a.getRegisteredService(ctx, "pmm-client:metrics", "")
+	if err != nil {
+		return err
+	}
+	if registered == nil {
+		return ErrNoService
+	}
+
+	// Deregister service.
+	if err := a.registry().DeregisterService(ctx, a.Config.ClientName, registered.ID); err != nil {
+		return err
+	}
+
+	// Stop and uninstall service.
+	if err := a.uninstallService(fmt.Sprintf("pmm-pmm-client-metrics-%d", registered.Port)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ServeClientMetrics serve pmm-admin self-monitoring metrics until ctx is done.
+// APIs are expected to be set with SetAPI, remote metrics are skipped otherwise.
+func (a *Admin) ServeClientMetrics(ctx context.Context, opts ClientMetricsOptions) error {
+	c := &clientCollector{admin: a}
+
+	// Credentials of every configured server are accepted as each one scrapes with its own.
+	var credentials []string
+	if opts.AuthFile != "" {
+		data, err := ioutil.ReadFile(opts.AuthFile)
+		if err != nil {
+			return err
+		}
+		authConfig := &Config{}
+		if err := yaml.Unmarshal(data, authConfig); err != nil {
+			return err
+		}
+		servers := []ServerProfile{authConfig.serverProfile()}
+		for _, p := range authConfig.ServerProfiles {
+			servers = append(servers, p)
+		}
+		for _, p := range servers {
+			if p.ServerUser != "" {
+				credentials = appendUnique(credentials, p.ServerUser+":"+p.ServerPassword)
+			}
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if len(credentials) > 0 {
+			u, p, ok := r.BasicAuth()
+			if !ok || !validCredentials(credentials, u+":"+p) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="PMM Client"`)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		c.collect(r.Context(), w)
+	})
+
+	// The service runs all the time, so it keeps health checks of the other services up to date.
+	if opts.HealthCheckInterval > 0 && a.qanAPI != nil {
+		go a.RunHealthChecks(ctx, opts.HealthCheckInterval, opts.HealthCheckError)
+	}
+
+	srv := &http.Server{Addr: opts.ListenAddress, Handler: mux}
+	errCh := make(chan error, 1)
+	go func() {
+		if opts.SSLCertFile != "" && opts.SSLKeyFile != "" {
+			errCh <- srv.ListenAndServeTLS(opts.SSLCertFile, opts.SSLKeyFile)
+		} else {
+			errCh <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
+
+// validCredentials check if user:password is one of credentials in constant time.
+func validCredentials(credentials []string, userPassword string) bool {
+	valid := 0
+	for _, c := range credentials {
+		valid |= subtle.ConstantTimeCompare([]byte(userPassword), []byte(c))
+	}
+	return valid == 1
+}
+
+// clientCollector collects pmm-admin self-monitoring metrics on every scrape.
+type clientCollector struct {
+	admin *Admin
+
+	mu       sync.Mutex
+	agentID  string
+	lastSeen time.Time // last scrape QAN API reported QAN agent connected
+}
+
+// collect write metrics in Prometheus text format.
+func (c *clientCollector) collect(ctx context.Context, w io.Writer) {
+	a := c.admin
+	now := time.Now()
+	buf := &bytes.Buffer{}
+
+	writeMetric(buf, "pmm_client_info", "PMM Client version.", promLabels("version", Version), 1)
+
+	// Local services state.
+	services := a.GetLocalServices()
+	sort.Strings(services)
+	fmt.Fprintf(buf, "# HELP pmm_client_service_up Whether local monitoring service is running.\n# TYPE pmm_client_service_up gauge\n")
+	for _, svcName := range services {
+		fmt.Fprintf(buf, "pmm_client_service_up%s %g\n", promLabels("service", svcName), boolValue(a.getServiceStatus(svcName)))
+	}
+
+	// Config file age.
+	if fi, err := os.Stat(a.paths().ConfigFile); err == nil {
+		writeMetric(buf, "pmm_client_config_age_seconds", "Time since the config file was modified.", "", now.Sub(fi.ModTime()).Seconds())
+	}
+
+	// SSL certificate expiry.
+	if cert, err := readCertificate(a.paths().SSLCertFile); err == nil {
+		writeMetric(buf, "pmm_client_ssl_cert_expiry_seconds", "Time left until SSL certificate of metric services expires.", "", cert.NotAfter.Sub(now).Seconds())
+	}
+
+	if a.qanAPI != nil {
+		ctx, cancel := context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+		c.collectRemote(ctx, buf, now)
+	}
+
+	w.Write(buf.Bytes())
+}
+
+// collectRemote write metrics which require PMM server.
+func (c *clientCollector) collectRemote(ctx context.Context, w io.Writer, now time.Time) {
+	a := c.admin
+
+	serverUp := a.qanAPI.Ping(ctx, a.qanAPI.URL(a.serverURL, qanAPIBasePath, "ping")) == nil
+	writeMetric(w, "pmm_client_server_up", "Whether PMM server is reachable.", "", boolValue(serverUp))
+	if !serverUp {
+		return
+	}
+
+	// Time since the last successful scrape of each metric service.
+	if node, err := a.registry().ListNodeServices(ctx, a.Config.ClientName); err == nil && node != nil {
+		fmt.Fprintf(w, "# HELP pmm_client_service_last_scrape_seconds Time since the last successful scrape of metric service by Prometheus.\n# TYPE pmm_client_service_last_scrape_seconds gauge\n")
+		for _, svc := range node.Services {
+			if !strings.HasSuffix(svc.Service, ":metrics") {
+				continue
+			}
+			for _, tag := range svc.Tags {
+				if !strings.HasPrefix(tag, "alias_") {
+					continue
+				}
+				if t, ok := a.lastScrape(ctx, strings.Split(svc.Service, ":")[0], tag[6:]); ok {
+					fmt.Fprintf(w, "pmm_client_service_last_scrape_seconds%s %g\n",
+						promLabels("type", svc.Service, "name", tag[6:]), now.Sub(t).Seconds())
+				}
+			}
+		}
+	}
+
+	// Time since QAN API reported QAN agent connected. QAN API keeps no time of the agent's last contact,
+	// so its status is polled on every scrape and the metric is as precise as the scrape interval.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.agentID == "" {
+		c.agentID, _ = getAgentID(fmt.Sprintf("%s/config/agent.conf", a.paths().AgentBaseDir))
+	}
+	if c.agentID == "" {
+		return
+	}
+	url := a.qanAPI.URL(a.serverURL, qanAPIBasePath, "agents", c.agentID, "status")
+	if resp, _, err := a.qanAPI.Get(ctx, url); err == nil && resp.StatusCode == http.StatusOK {
+		c.lastSeen = now
+	}
+	if !c.lastSeen.IsZero() {
+		writeMetric(w, "pmm_client_qan_agent_last_seen_seconds", "Time since the last scrape QAN API reported QAN agent connected.",
+			promLabels("agent_uuid", c.agentID), now.Sub(c.lastSeen).Seconds())
+	}
+}
+
+// lastScrape returns the time of the last successful scrape of Prometheus job and instance within the last hour.
+func (a *Admin) lastScrape(ctx context.Context, job, instance string) (time.Time, bool) {
+	query := fmt.Sprintf(`up{job=%q,instance=%q}[1h]`, job, instance)
+	value, err := a.promQueryAPI.Query(ctx, query, time.Now())
+	if err != nil {
+		return time.Time{}, false
+	}
+	matrix, ok := value.(model.Matrix)
+	if !ok {
+		return time.Time{}, false
+	}
+	var last model.Time
+	for _, stream := range matrix {
+		for _, v := range stream.Values {
+			if v.Value == 1 && v.Timestamp.After(last) {
+				last = v.Timestamp
+			}
+		}
+	}
+	if last == 0 {
+		return time.Time{}, false
+	}
+	return last.Time(), true
+}
+
+// writeMetric write gauge metric with a single sample in Prometheus text format.
+func writeMetric(w io.Writer, name, help, labels string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s%s %g\n", name, help, name, name, labels, value)
+}
+
+// promLabels format label pairs in Prometheus text format.
+func promLabels(pairs ...string) string {
+	var labels []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1])
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, pairs[i], value))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// boolValue returns bool as Prometheus sample value.
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
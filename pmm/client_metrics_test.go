@@ -0,0 +1,77 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/percona/kardianos-service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPromLabels(t *testing.T) {
+	assert.Equal(t, `{type="mysql:metrics",name="db01"}`, promLabels("type", "mysql:metrics", "name", "db01"))
+	assert.Equal(t, `{name="a\"b\\c\nd"}`, promLabels("name", "a\"b\\c\nd"))
+}
+
+func TestWriteMetric(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writeMetric(buf, "pmm_client_info", "PMM Client version.", promLabels("version", "1.2.3"), 1)
+	expected := `# HELP pmm_client_info PMM Client version.
+# TYPE pmm_client_info gauge
+pmm_client_info{version="1.2.3"} 1
+`
+	assert.Equal(t, expected, buf.String())
+
+	buf.Reset()
+	writeMetric(buf, "pmm_client_server_up", "Whether PMM server is reachable.", "", boolValue(false))
+	assert.Contains(t, buf.String(), "\npmm_client_server_up 0\n")
+}
+
+func TestAddClientMetricsInstallFailure(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "pmm-client")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	registry := &FileRegistry{File: filepath.Join(dir, "registry.json")}
+	admin := New(Options{
+		Paths: Paths{BaseDir: dir, AgentBaseDir: dir},
+		NewService: func(i service.Interface, c *service.Config) (service.Service, error) {
+			return nil, errors.New("no service manager")
+		},
+		Registry: registry,
+	})
+	admin.Config = &Config{ClientName: "db01", ClientAddress: "127.0.0.1", BindAddress: "127.0.0.1"}
+
+	_, err = admin.AddClientMetrics(ctx)
+	assert.EqualError(t, err, "no service manager")
+
+	// Registration is removed along with the failed service.
+	node, err := registry.ListNodeServices(ctx, "db01")
+	assert.Nil(t, err)
+	if node != nil {
+		assert.Empty(t, node.Services)
+	}
+}
@@ -18,73 +18,129 @@
 package pmm
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"regexp"
-	"strings"
+	"time"
 
-	consul "github.com/hashicorp/consul/api"
-	"github.com/percona/pmm/proto"
 	protocfg "github.com/percona/pmm/proto/config"
 	"gopkg.in/yaml.v2"
 )
 
 // Config pmm.yml config file.
 type Config struct {
-	ServerAddress     string `yaml:"server_address"`
-	ClientAddress     string `yaml:"client_address"`
-	BindAddress       string `yaml:"bind_address"`
-	ClientName        string `yaml:"client_name"`
-	MySQLPassword     string `yaml:"mysql_password,omitempty"`
-	ServerUser        string `yaml:"server_user,omitempty"`
-	ServerPassword    string `yaml:"server_password,omitempty"`
-	ServerSSL         bool   `yaml:"server_ssl,omitempty"`
-	ServerInsecureSSL bool   `yaml:"server_insecure_ssl,omitempty"`
+	ServerAddress       string        `yaml:"server_address"`
+	ClientAddress       string        `yaml:"client_address"`
+	BindAddress         string        `yaml:"bind_address"`
+	ClientName          string        `yaml:"client_name"`
+	MySQLPassword       string        `yaml:"mysql_password,omitempty"`
+	ServerUser          string        `yaml:"server_user,omitempty"`
+	ServerPassword      string        `yaml:"server_password,omitempty"`
+	ServerSSL           bool          `yaml:"server_ssl,omitempty"`
+	ServerInsecureSSL   bool          `yaml:"server_insecure_ssl,omitempty"`
+	ServerCAFile        string        `yaml:"server_ca_file,omitempty"`
+	ServerClientCert    string        `yaml:"server_client_cert,omitempty"`
+	ServerClientKey     string        `yaml:"server_client_key,omitempty"`
+	ServerProxy         string        `yaml:"server_proxy,omitempty"`
+	ServerProxyUser     string        `yaml:"server_proxy_user,omitempty"`
+	ServerProxyPassword string        `yaml:"server_proxy_password,omitempty"`
+	ServerNoProxy       string        `yaml:"server_no_proxy,omitempty"`
+	ConsulToken         string        `yaml:"consul_token,omitempty"`      // Consul ACL token
+	ConsulTokenFile     string        `yaml:"consul_token_file,omitempty"` // file to read Consul ACL token from instead
+	CertLifetime        time.Duration `yaml:"cert_lifetime,omitempty"`
+	CertKeyType         string        `yaml:"cert_key_type,omitempty"`
+	CertSANs            []string      `yaml:"cert_sans,omitempty"`
+	NTPServers          []string      `yaml:"ntp_servers,omitempty"`
+	NTPDisabled         bool          `yaml:"ntp_disabled,omitempty"`
+	TimeDriftThreshold  time.Duration `yaml:"time_drift_threshold,omitempty"`
+	ServiceManager      string        `yaml:"service_manager,omitempty"` // auto-detected if empty
+	ServiceUser         string        `yaml:"service_user,omitempty"`    // root if empty
+	Registry            string        `yaml:"registry,omitempty"`        // consul if empty
+	PortRange           string        `yaml:"port_range,omitempty"`      // allowed ports of services, e.g. 42000-42999
+
+	Labels         map[string]string        `yaml:"labels,omitempty"` // node-wide labels of all services
+	ServerProfiles map[string]ServerProfile `yaml:"server_profiles,omitempty"`
 }
 
 // LoadConfig read PMM client config file.
+// Server settings are taken from the selected server profile if any.
 func (a *Admin) LoadConfig() error {
 	a.Config = &Config{}
-	if !FileExists(ConfigFile) {
-		return nil
-	}
-	bytes, err := ioutil.ReadFile(ConfigFile)
-	if err != nil {
-		return err
-	}
-	if err := yaml.Unmarshal(bytes, a.Config); err != nil {
-		return err
+	public := false
+	if FileExists(a.paths().ConfigFile) {
+		bytes, isPublic, err := a.readConfigFile()
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(bytes, a.Config); err != nil {
+			return err
+		}
+		public = isPublic
 	}
 
 	// If not set previously, assume it equals to client address.
 	if a.Config.BindAddress == "" {
 		a.Config.BindAddress = a.Config.ClientAddress
 	}
+	if a.ServerProfile != "" {
+		if err := a.useServerProfile(); err != nil {
+			return err
+		}
+	}
+	if public && a.Config.ServerUser != "" {
+		a.Config.ServerPassword = os.Getenv(ServerPasswordEnv)
+	}
 	return nil
 }
 
 // SetConfig configure PMM client, check connectivity and write the config.
-func (a *Admin) SetConfig(cf Config, flagForce bool) error {
+// Returned warnings are about things that did not fail the change but need attention.
+func (a *Admin) SetConfig(ctx context.Context, cf Config, flagForce bool) (_ []string, err error) {
+	var warnings []string
+
+	// Changes made on the server are undone if a later step fails, so it stays in line with the config file.
+	var undo []func() error
+	defer func() {
+		if err == nil {
+			return
+		}
+		if e := undoAll(undo); e != nil {
+			err = fmt.Errorf("%w\nRolling back changes failed too: %s", err, e)
+		}
+	}()
+
 	// Server options.
 	if cf.ServerSSL && cf.ServerInsecureSSL {
-		return errors.New("Flags --server-ssl and --server-insecure-ssl are mutually exclusive.")
+		return nil, errors.New("Flags --server-ssl and --server-insecure-ssl are mutually exclusive.")
+	}
+	if cf.ServerCAFile != "" && cf.ServerInsecureSSL {
+		return nil, errors.New("Flags --server-ca-file and --server-insecure-ssl are mutually exclusive.")
+	}
+	if (cf.ServerClientCert == "") != (cf.ServerClientKey == "") {
+		return nil, errors.New("Flags --server-client-cert and --server-client-key should be used together.")
 	}
 
 	if cf.ServerAddress != "" {
-		a.Config.ServerAddress = cf.ServerAddress
+		a.Config.ServerAddress = normalizeServerAddress(cf.ServerAddress)
 		// Resetting server address clears up SSL and HTTP auth.
 		a.Config.ServerSSL = false
 		a.Config.ServerInsecureSSL = false
 		a.Config.ServerUser = ""
 		a.Config.ServerPassword = ""
+		a.Config.ServerCAFile = ""
+		a.Config.ServerClientCert = ""
+		a.Config.ServerClientKey = ""
+		a.Config.ConsulToken = ""
+		a.Config.ConsulTokenFile = ""
 	}
 	if a.Config.ServerAddress == "" {
-		return errors.New("Server address is not set. Use --server flag to set it.")
+		return nil, errors.New("Server address is not set. Use --server flag to set it.")
 	}
 
 	if cf.ServerPassword != "" {
@@ -98,16 +154,137 @@ func (a *Admin) SetConfig(cf Config, flagForce bool) error {
 	if cf.ServerInsecureSSL {
 		a.Config.ServerSSL = false
 		a.Config.ServerInsecureSSL = true
+		a.Config.ServerCAFile = ""
+	}
+	// Custom CA and client certificate imply SSL. Paths are stored absolute as pmm-admin may run from any dir.
+	if cf.ServerCAFile != "" {
+		a.Config.ServerSSL = true
+		a.Config.ServerInsecureSSL = false
+		a.Config.ServerCAFile, _ = filepath.Abs(cf.ServerCAFile)
+	}
+	if cf.ServerClientCert != "" {
+		if !a.Config.ServerInsecureSSL {
+			a.Config.ServerSSL = true
+		}
+		a.Config.ServerClientCert, _ = filepath.Abs(cf.ServerClientCert)
+		a.Config.ServerClientKey, _ = filepath.Abs(cf.ServerClientKey)
+	}
+
+	// Consul ACL token, "none" removes it.
+	if cf.ConsulToken != "" && cf.ConsulTokenFile != "" {
+		return nil, errors.New("Flags --consul-token and --consul-token-file are mutually exclusive.")
+	}
+	if cf.ConsulToken == "none" || cf.ConsulTokenFile == "none" {
+		a.Config.ConsulToken = ""
+		a.Config.ConsulTokenFile = ""
+	} else if cf.ConsulToken != "" {
+		a.Config.ConsulToken = cf.ConsulToken
+		a.Config.ConsulTokenFile = ""
+	} else if cf.ConsulTokenFile != "" {
+		file, _ := filepath.Abs(cf.ConsulTokenFile)
+		if _, err := readConsulTokenFile(file); err != nil {
+			return nil, err
+		}
+		a.Config.ConsulToken = ""
+		a.Config.ConsulTokenFile = file
+	}
+
+	// Proxy options. They are not reset with server address as they depend on the client network.
+	if cf.ServerProxy == "none" {
+		a.Config.ServerProxy = ""
+		a.Config.ServerProxyUser = ""
+		a.Config.ServerProxyPassword = ""
+		a.Config.ServerNoProxy = ""
+	} else if cf.ServerProxy != "" {
+		if _, err := parseProxy(cf.ServerProxy); err != nil {
+			return nil, err
+		}
+		a.Config.ServerProxy = cf.ServerProxy
+	}
+	if cf.ServerProxyUser != "" {
+		a.Config.ServerProxyUser = cf.ServerProxyUser
+	}
+	if cf.ServerProxyPassword != "" {
+		a.Config.ServerProxyPassword = cf.ServerProxyPassword
+	}
+	if cf.ServerNoProxy != "" {
+		a.Config.ServerNoProxy = cf.ServerNoProxy
+	}
+	if a.Config.ServerProxy == "" && (a.Config.ServerProxyUser != "" || a.Config.ServerNoProxy != "") {
+		return nil, errors.New("Proxy address is not set. Use --proxy flag to set it.")
+	}
+
+	// Time check options.
+	if len(cf.NTPServers) == 1 && cf.NTPServers[0] == "none" {
+		a.Config.NTPServers = nil
+		a.Config.NTPDisabled = true
+	} else if len(cf.NTPServers) > 0 {
+		a.Config.NTPServers = cf.NTPServers
+		a.Config.NTPDisabled = false
+	}
+	if cf.TimeDriftThreshold < 0 {
+		return nil, errors.New("Time drift threshold should be positive.")
+	}
+	if cf.TimeDriftThreshold > 0 {
+		a.Config.TimeDriftThreshold = cf.TimeDriftThreshold
+	}
+
+	// Allowed ports of services, "none" removes the range. Ports of existing services are not changed.
+	if cf.PortRange == "none" {
+		a.Config.PortRange = ""
+	} else if cf.PortRange != "" {
+		if _, err := parsePortRange(cf.PortRange); err != nil {
+			return nil, err
+		}
+		a.Config.PortRange = cf.PortRange
+	}
+
+	// Service manager is checked before anything is changed on the server.
+	// Services installed with one manager are not visible to another, so switching requires none.
+	if cf.ServiceManager != "" {
+		m, err := NewServiceManager(cf.ServiceManager, a.NewService)
+		if err != nil {
+			return nil, err
+		}
+		if current := a.serviceManager(); m.Name() != current.Name() && len(a.GetLocalServices()) > 0 {
+			return nil, fmt.Errorf("There are monitoring services installed with %s service manager. Remove them before switching to %s.", current.Name(), m.Name())
+		}
+		a.Config.ServiceManager = cf.ServiceManager
+		if cf.ServiceManager == "auto" {
+			a.Config.ServiceManager = ""
+		}
 	}
 
 	// Set APIs and check if server is alive.
-	if err := a.SetAPI(); err != nil {
-		return err
+	if err := a.SetAPI(ctx); err != nil {
+		return nil, err
+	}
+
+	// Services are not moved between registries, so switching requires none.
+	if cf.Registry != "" {
+		r, err := a.newRegistry(cf.Registry)
+		if err != nil {
+			return nil, err
+		}
+		if current := a.registry(); r.Name() != current.Name() && a.Config.ClientName != "" {
+			node, err := current.ListNodeServices(ctx, a.Config.ClientName)
+			if err != nil {
+				return nil, err
+			}
+			if node != nil && len(node.Services) > 0 {
+				return nil, fmt.Errorf("There are monitoring services registered in %s registry. Remove them before switching to %s.", current.Name(), r.Name())
+			}
+		}
+		a.Config.Registry = cf.Registry
+		if cf.Registry == "consul" {
+			a.Config.Registry = ""
+		}
 	}
 
 	// Client options.
 
 	// Client name. Initial setup.
+	renameTo := ""
 	if a.Config.ClientName == "" {
 		if cf.ClientName != "" {
 			a.Config.ClientName = cf.ClientName
@@ -116,24 +293,21 @@ func (a *Admin) SetConfig(cf Config, flagForce bool) error {
 			a.Config.ClientName = hostname
 		}
 
-		node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
+		node, err := a.registry().ListNodeServices(ctx, a.Config.ClientName)
 		if err != nil {
-			return fmt.Errorf("Unable to communicate with Consul: %s", err)
+			return nil, err
 		}
 		if node != nil && len(node.Services) > 0 {
 			if !flagForce {
-				return fmt.Errorf(`Another client with the same name '%s' detected, its address is %s.
-It has the active services so this name is not available.
-
-Specify the other one using --client-name flag.
+				return nil, newError(KindDuplicate, `Specify the other one using --client-name flag.
 
 In case this is the correct client node that was previously uninstalled with unreachable PMM server,
 you can add --force flag to proceed further. Do not use this flag otherwise.
-The orphaned remote services will be removed automatically.`,
-					a.Config.ClientName, node.Node.Address)
+The orphaned remote services will be removed automatically.`, `Another client with the same name '%s' detected, its address is %s.
+It has the active services so this name is not available.`, a.Config.ClientName, node.Address)
 			}
 			// Allow to set client name and clean missing services.
-			a.RepairInstallation()
+			a.RepairInstallation(ctx)
 		}
 	} else if cf.ClientName != "" && cf.ClientName != a.Config.ClientName {
 		// Attempt to change client name.
@@ -141,73 +315,64 @@ The orphaned remote services will be removed automatically.`,
 		newName := cf.ClientName
 
 		// Checking target name.
-		node, _, err := a.consulAPI.Catalog().Node(newName, nil)
+		node, err := a.registry().ListNodeServices(ctx, newName)
 		if err != nil {
-			return fmt.Errorf("Unable to communicate with Consul: %s", err)
+			return nil, err
 		}
 		if node != nil && len(node.Services) > 0 {
-			return fmt.Errorf(`Another client with the same name '%s' detected, its address is address %s.
+			return nil, fmt.Errorf(`Another client with the same name '%s' detected, its address is address %s.
 It has the active services so you cannot change client name as requested.`,
-				newName, node.Node.Address)
+				newName, node.Address)
 		}
 
 		// Checking source name.
-		node, _, err = a.consulAPI.Catalog().Node(oldName, nil)
+		node, err = a.registry().ListNodeServices(ctx, oldName)
 		if err != nil {
-			return fmt.Errorf("Unable to communicate with Consul: %s", err)
+			return nil, err
 		}
 
 		if node != nil && len(node.Services) > 0 {
-			if !flagForce {
-				return errors.New("This client has active services. Some data might be lost, you can add --force flag to proceed further.")
+			if match, _ := regexp.MatchString(NameRegex, newName); !match {
+				return nil, errors.New("Client name must be 2 to 60 characters long, contain only letters, numbers and symbols _ - . :")
 			}
-
-			// At the time of writing there is no easy way to rename node.
-			// To do this all services needs to be de-registered and registered again with new name
-			errs := a.renameClientNameInServices(node, oldName, newName)
-			if errs != nil {
-				log.Printf("WARNING: there were some errors, renaming partially failed: %s\n", errs)
-			}
-
-			// deregister old node
-			err := a.deregisterNode(oldName)
-			if err != nil {
-				log.Printf("Unable to deregister old node: %s\n", err)
+			// The rename is only checked here, it is done last before writing the config.
+			if _, err := a.checkRename(ctx, oldName, newName, flagForce); err != nil {
+				return nil, err
 			}
+			renameTo = newName
+		} else {
+			a.Config.ClientName = newName
 		}
-
-		a.Config.ClientName = cf.ClientName
 	}
 	if match, _ := regexp.MatchString(NameRegex, a.Config.ClientName); !match {
-		return errors.New(`Client name must be 2 to 60 characters long, contain only letters, numbers and symbols _ - . :
+		return nil, errors.New(`Client name must be 2 to 60 characters long, contain only letters, numbers and symbols _ - . :
 Use --client-name flag to set the correct one.`)
 	}
 
 	// Client address. Initial setup.
+	// IPv6 addresses are kept without brackets, they are added where the port follows.
+	cf.ClientAddress = normalizeAddress(cf.ClientAddress)
+	cf.BindAddress = normalizeAddress(cf.BindAddress)
 	isDetectedIP := false
+	addressChanged := false
+	oldClientAddress := a.Config.ClientAddress
+	oldBindAddress := a.Config.BindAddress
 	if a.Config.ClientAddress == "" {
 		if cf.ClientAddress != "" {
 			a.Config.ClientAddress = cf.ClientAddress
 		} else {
 			// Detect remote address from nginx response header.
-			a.Config.ClientAddress = a.getNginxHeader("X-Remote-IP")
+			a.Config.ClientAddress = normalizeAddress(a.getNginxHeader(ctx, "X-Remote-IP"))
 			isDetectedIP = true
 		}
 
 		if a.Config.ClientAddress == "" {
-			return errors.New("Cannot detect client address. Use --client-address flag to set it.")
+			return nil, errors.New("Cannot detect client address. Use --client-address flag to set it.")
 		}
 	} else if cf.ClientAddress != "" && cf.ClientAddress != a.Config.ClientAddress {
-		// Attempt to change client address.
-		node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
-		if err != nil {
-			return fmt.Errorf("Unable to communicate with Consul: %s", err)
-		}
-		if node != nil && len(node.Services) > 0 {
-			return errors.New("Changing of client address is allowed only if there are no services under monitoring.")
-		}
-
+		// Change client address, services are moved below.
 		a.Config.ClientAddress = cf.ClientAddress
+		addressChanged = true
 	}
 
 	// Bind address. Initial setup.
@@ -218,21 +383,14 @@ Use --client-name flag to set the correct one.`)
 			isDetectedIP = false
 		}
 	} else if cf.BindAddress != "" && cf.BindAddress != a.Config.BindAddress {
-		// Attempt to change bind address.
-		node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
-		if err != nil {
-			return fmt.Errorf("Unable to communicate with Consul: %s", err)
-		}
-		if node != nil && len(node.Services) > 0 {
-			return errors.New("Changing of bind address is allowed only if there are no services under monitoring.")
-		}
-
+		// Change bind address, services are moved below.
 		a.Config.BindAddress = cf.BindAddress
+		addressChanged = true
 	}
 
 	if !isAddressLocal(a.Config.BindAddress) {
 		if isDetectedIP {
-			return fmt.Errorf(`Detected address '%s' is not locally bound.
+			return nil, fmt.Errorf(`Detected address '%s' is not locally bound.
 This usually happens when client and server are on the different networks.
 
 Use --bind-address flag to set locally bound address, usually a private one, while client address is public.
@@ -242,7 +400,7 @@ PMM server should be able to connect to the client address '%s' which should tra
 What ports to map you can find from "pmm-admin check-network" output once you add instances to the monitoring.`,
 				a.Config.BindAddress, a.Config.ClientAddress)
 		}
-		return fmt.Errorf(`Client Address: %s
+		return nil, fmt.Errorf(`Client Address: %s
 Bind Address: %s
 
 The bind address is not locally bound.
@@ -256,42 +414,103 @@ What ports to map you can find from "pmm-admin check-network" output once you ad
 			a.Config.ClientAddress, a.Config.BindAddress)
 	}
 
+	// Move services under monitoring to the new addresses.
+	if addressChanged {
+		node, err := a.registry().ListNodeServices(ctx, a.Config.ClientName)
+		if err != nil {
+			return nil, err
+		}
+		if node != nil && len(node.Services) > 0 {
+			w, u, err := a.changeAddress(ctx, node, oldClientAddress, oldBindAddress)
+			if err != nil {
+				return nil, err
+			}
+			undo = append(undo, u)
+			warnings = append(warnings, w...)
+		}
+	}
+
 	// If agent config exists, update the options like address, SSL, password etc.
-	agentConfigFile := fmt.Sprintf("%s/config/agent.conf", AgentBaseDir)
+	agentConfigFile := fmt.Sprintf("%s/config/agent.conf", a.paths().AgentBaseDir)
 	if FileExists(agentConfigFile) {
 		if err := a.syncAgentConfig(agentConfigFile); err != nil {
-			return fmt.Errorf("Unable to update agent config %s: %s", agentConfigFile, err)
+			return nil, fmt.Errorf("Unable to update agent config %s: %w", agentConfigFile, err)
 		}
 		// Restart QAN agent for MySQL.
-		if _, err := a.StartStopMonitoring("restart", "mysql:queries"); err != nil && err != ErrNoService {
-			return fmt.Errorf("Unable to restart queries service for MySQL: %s", err)
+		if _, err := a.StartStopMonitoring(ctx, "restart", "mysql:queries"); err != nil && err != ErrNoService {
+			return nil, fmt.Errorf("Unable to restart queries service for MySQL: %w", err)
 		}
 		// Restart QAN agent for MongoDB.
-		if _, err := a.StartStopMonitoring("restart", "mongodb:queries"); err != nil && err != ErrNoService {
-			return fmt.Errorf("Unable to restart queries service for MongoDB: %s", err)
+		if _, err := a.StartStopMonitoring(ctx, "restart", "mongodb:queries"); err != nil && err != ErrNoService {
+			return nil, fmt.Errorf("Unable to restart queries service for MongoDB: %w", err)
+		}
+	}
+
+	// Node-wide labels are set on every service.
+	if err := a.setNodeLabels(ctx, cf.Labels); err != nil {
+		return nil, fmt.Errorf("Unable to set labels: %w", err)
+	}
+
+	// Rename client, it is planned again as the steps above may have changed its services.
+	var rename *renamePlan
+	if renameTo != "" {
+		rename, err = a.checkRename(ctx, a.Config.ClientName, renameTo, flagForce)
+		if err != nil {
+			return nil, err
+		}
+		u, err := a.applyRename(ctx, rename)
+		if err != nil {
+			return nil, err
 		}
+		undo = append(undo, u)
+		a.Config.ClientName = renameTo
 	}
 
 	// Write the config.
 	if err := a.writeConfig(); err != nil {
-		return fmt.Errorf("Unable to write config file %s: %s", ConfigFile, err)
+		return nil, fmt.Errorf("Unable to write config file %s: %w", a.paths().ConfigFile, err)
+	}
+	// The server is in line with the config file now, nothing is undone past this point.
+	undo = nil
+	if rename != nil {
+		warnings = append(warnings, a.cleanupRename(ctx, rename)...)
 	}
 
-	// Restart all services when resetting server address (wiping password) or changing password.
-	if cf.ServerAddress != "" || cf.ServerPassword != "" {
+	// Restart all services when resetting server address (wiping password), changing password or addresses.
+	if cf.ServerAddress != "" || cf.ServerPassword != "" || addressChanged {
 		_, _, err := a.StartStopAllMonitoring("restart")
 		if err != nil {
-			return fmt.Errorf("Error restarting one of the services: %s", err)
+			return nil, fmt.Errorf("Error restarting one of the services: %w", err)
 		}
 	}
 
+	return warnings, nil
+}
+
+// undoAll run undo functions in reverse order, all of them are run even if some fail.
+func undoAll(undo []func() error) error {
+	var errs Errors
+	for i := len(undo) - 1; i >= 0; i-- {
+		if err := undo[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
 	return nil
 }
 
 // writeConfig write config to the file.
 func (a *Admin) writeConfig() error {
-	bytes, _ := yaml.Marshal(a.Config)
-	return ioutil.WriteFile(ConfigFile, bytes, 0600)
+	config := a.configToWrite()
+	bytes, _ := yaml.Marshal(config)
+	if err := ioutil.WriteFile(a.paths().ConfigFile, bytes, 0600); err != nil {
+		return err
+	}
+	// The copy is only needed for read-only commands without superuser privileges, so it does not fail the write.
+	a.writePublicConfig(config)
+	return a.writeAuthFile(config)
 }
 
 // syncAgentConfig sync agent config.
@@ -311,154 +530,44 @@ func (a *Admin) syncAgentConfig(agentConfigFile string) error {
 	agentConf.ServerPassword = a.Config.ServerPassword
 
 	bytes, _ := json.Marshal(agentConf)
-	return ioutil.WriteFile(agentConfigFile, bytes, 0600)
+	if err := ioutil.WriteFile(agentConfigFile, bytes, 0600); err != nil {
+		return err
+	}
+	// qan-agent takes the proxy, CA bundle and client certificate from the environment.
+	return a.writeAgentEnvFile()
 }
 
 // getNginxHeader get header value from Nginx response.
-func (a *Admin) getNginxHeader(header string) string {
+func (a *Admin) getNginxHeader(ctx context.Context, header string) string {
 	url := a.qanAPI.URL(a.serverURL, "v1/status/leader")
-	resp, _, err := a.qanAPI.Get(url)
+	resp, _, err := a.qanAPI.Get(ctx, url)
 	if err != nil {
 		return ""
 	}
 	return resp.Header.Get(header)
 }
 
-// isAddressLocal check if IP address is locally bound on the system.
+// interfaceAddrs returns addresses of the system network interfaces.
+var interfaceAddrs = net.InterfaceAddrs
+
+// isAddressLocal check if IPv4 or IPv6 address is locally bound on the system.
 func isAddressLocal(myAddress string) bool {
-	ips, _ := net.InterfaceAddrs()
-	for _, ip := range ips {
-		if strings.HasPrefix(ip.String(), myAddress+"/") {
+	myIP := parseHostIP(myAddress)
+	if myIP == nil {
+		return false
+	}
+	addrs, _ := interfaceAddrs()
+	for _, addr := range addrs {
+		var ip net.IP
+		switch addr := addr.(type) {
+		case *net.IPNet:
+			ip = addr.IP
+		case *net.IPAddr:
+			ip = addr.IP
+		}
+		if ip.Equal(myIP) {
 			return true
 		}
 	}
 	return false
 }
-
-// renameClientNameInServices changes a clientName in all services
-func (a *Admin) renameClientNameInServices(node *consul.CatalogNode, oldName, newName string) (errs Errors) {
-	for _, svc := range node.Services {
-		for k, v := range svc.Tags {
-			if v == fmt.Sprintf("alias_%s", oldName) {
-				svc.Tags[k] = fmt.Sprintf("alias_%s", newName)
-			}
-		}
-
-		// Add new service to Consul.
-		reg := consul.CatalogRegistration{
-			Node:    newName,
-			Address: a.Config.ClientAddress,
-			Service: svc,
-		}
-
-		if _, err := a.consulAPI.Catalog().Register(&reg, nil); err != nil {
-			errs = append(errs, err)
-			continue
-		}
-
-		// Remove old service from Consul
-		dereg := consul.CatalogDeregistration{
-			Node:      oldName,
-			ServiceID: svc.ID,
-		}
-		if _, err := a.consulAPI.Catalog().Deregister(&dereg, nil); err != nil {
-			errs = append(errs, err)
-			continue
-		}
-
-		// Update consul key-value store
-		prefix := fmt.Sprintf("%s/%s/", oldName, svc.ID)
-		keys, _, err := a.consulAPI.KV().Keys(prefix, "", nil)
-		if err != nil {
-			errs = append(errs, err)
-			continue
-		}
-		for _, oldKey := range keys {
-			data, _, err := a.consulAPI.KV().Get(oldKey, nil)
-			if err != nil {
-				errs = append(errs, err)
-				continue
-			}
-			if data == nil {
-				continue
-			}
-
-			// Update qan instance
-			if strings.HasSuffix(oldKey, "/qan_mysql_uuid") {
-				instanceUUID := string(data.Value)
-				err := a.renameInstance(instanceUUID, oldName, newName)
-				if err != nil {
-					errs = append(errs, err)
-				}
-			}
-
-			// Update consul key
-			newKey := strings.Replace(oldKey, oldName, newName, -1)
-			if oldKey == newKey {
-				// ... but only if key is different
-				continue
-			}
-
-			data.Key = newKey
-			_, err = a.consulAPI.KV().Put(data, nil)
-			if err != nil {
-				errs = append(errs, err)
-				continue
-			}
-
-			_, err = a.consulAPI.KV().Delete(oldKey, nil)
-			if err != nil {
-				errs = append(errs, err)
-				continue
-			}
-		}
-	}
-
-	return errs
-}
-
-// deregisterNode removes node from consul
-func (a *Admin) deregisterNode(name string) error {
-	dereg := consul.CatalogDeregistration{
-		Node: name,
-	}
-	if _, err := a.consulAPI.Catalog().Deregister(&dereg, nil); err != nil {
-		return err
-	}
-	return nil
-}
-
-// renameInstance renames instance with given uuid
-func (a *Admin) renameInstance(instanceUUID, oldName, newName string) error {
-	bytes, err := ioutil.ReadFile(fmt.Sprintf("%s/instance/%s.json", AgentBaseDir, instanceUUID))
-	if err != nil {
-		return err
-	}
-
-	instance := proto.Instance{}
-	err = json.Unmarshal(bytes, &instance)
-	if err != nil {
-		return err
-	}
-
-	// change hostname if needed
-	newInstanceName := strings.Replace(instance.Name, oldName, newName, 1)
-	if newInstanceName == instance.Name {
-		return nil
-	}
-	instance.Name = newInstanceName
-
-	newBytes, err := json.MarshalIndent(instance, "", "    ")
-	if err != nil {
-		return err
-	}
-	err = a.updateInstance(instanceUUID, newBytes)
-	if err != nil {
-		return err
-	}
-	if err := ioutil.WriteFile(fmt.Sprintf("%s/instance/%s.json", AgentBaseDir, instanceUUID), newBytes, 0600); err != nil {
-		return err
-	}
-
-	return nil
-}
@@ -18,6 +18,7 @@
 package pmm
 
 import (
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -36,3 +37,31 @@ func TestIsAddressLocal(t *testing.T) {
 		assert.Equal(t, expected, isAddressLocal(ip), "ip = %s", ip)
 	}
 }
+
+func TestIsAddressLocalMixed(t *testing.T) {
+	defer func(f func() ([]net.Addr, error)) { interfaceAddrs = f }(interfaceAddrs)
+	interfaceAddrs = func() ([]net.Addr, error) {
+		var addrs []net.Addr
+		for _, s := range []string{"10.0.0.1/24", "fd00::1/64", "fe80::1/64"} {
+			ip, ipNet, _ := net.ParseCIDR(s)
+			addrs = append(addrs, &net.IPNet{IP: ip, Mask: ipNet.Mask})
+		}
+		return addrs, nil
+	}
+
+	ips := map[string]bool{
+		"10.0.0.1":        true,
+		"10.0.0.10":       false,
+		"::ffff:10.0.0.1": true,
+		"fd00::1":         true,
+		"[fd00::1]":       true,
+		"fd00:0:0::1":     true,
+		"fd00::10":        false,
+		"fe80::1%eth0":    true,
+		"fd00::":          false,
+		"db01":            false,
+	}
+	for ip, expected := range ips {
+		assert.Equal(t, expected, isAddressLocal(ip), "ip = %s", ip)
+	}
+}
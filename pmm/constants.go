@@ -18,7 +18,6 @@
 package pmm
 
 import (
-	"errors"
 	"fmt"
 	"time"
 )
@@ -26,8 +25,21 @@ import (
 const (
 	qanAPIBasePath = "qan-api"
 	noMonitoring   = "No monitoring registered for this node identified as"
-	apiTimeout     = 10 * time.Second
+	APITimeout     = 10 * time.Second
 	NameRegex      = `^[-\w:\.]{2,60}$`
+
+	DefaultCertLifetime = 10 * 365 * 24 * time.Hour
+	CertExpiryWarning   = 30 * 24 * time.Hour
+
+	DefaultNTPServer          = "0.pool.ntp.org"
+	DefaultTimeDriftThreshold = 60 * time.Second
+
+	// PMMGroup is the system group allowed to run read-only commands without superuser privileges.
+	PMMGroup = "pmm"
+	// PMMUser is the system account monitoring services run as when they are run unprivileged.
+	PMMUser = "pmm"
+	// ServerPasswordEnv is the environment variable to pass server password with when the config is read without secrets.
+	ServerPasswordEnv = "PMM_SERVER_PASSWORD"
 )
 
 var (
@@ -46,10 +58,23 @@ var (
 	SSLCertFile = fmt.Sprintf("%s/server.crt", PMMBaseDir)
 	SSLKeyFile  = fmt.Sprintf("%s/server.key", PMMBaseDir)
 
-	ErrDuplicate  = errors.New("there is already one instance with this name under monitoring.")
-	ErrNoService  = errors.New("no service found.")
-	ErrOneLinux   = errors.New("there could be only one instance of linux metrics being monitored for this system.")
-	errNoInstance = errors.New("no instance found on QAN API.")
+	// AuthFile keeps HTTP basic auth credentials only, so metric services running unprivileged can read it.
+	AuthFile = fmt.Sprintf("%s/auth.yml", PMMBaseDir)
+
+	// ServiceConfigDir keeps configs of installed system services to be able to backup and restore them.
+	ServiceConfigDir = fmt.Sprintf("%s/services", PMMBaseDir)
+
+	// RegistryFile keeps services of this client when it is configured with the file registry.
+	RegistryFile = fmt.Sprintf("%s/registry.json", PMMBaseDir)
+
+	// LockFile is locked by mutating pmm-admin commands, so parallel invocations do not race on the config and services.
+	LockFile = fmt.Sprintf("%s/pmm-admin.lock", PMMBaseDir)
+
+	ErrDuplicate        = &Error{Kind: KindDuplicate, Message: "there is already one instance with this name under monitoring."}
+	ErrNoService        = &Error{Kind: KindNotFound, Message: "no service found."}
+	ErrOneLinux         = &Error{Kind: KindDuplicate, Message: "there could be only one instance of linux metrics being monitored for this system."}
+	ErrOneClientMetrics = &Error{Kind: KindDuplicate, Message: "there could be only one instance of pmm-client metrics being monitored for this system."}
+	errNoInstance       = &Error{Kind: KindNotFound, Message: "no instance found on QAN API."}
 )
 
 const nodeExporterArgs = "-collectors.enabled=diskstats,filefd,filesystem,loadavg,meminfo,netdev,netstat,stat,time,uname,vmstat"
@@ -99,6 +124,11 @@ func (e Errors) Error() string {
 	return join(e, ", ")
 }
 
+// Unwrap returns the errors, so their kinds can be checked with ErrorKindOf.
+func (e Errors) Unwrap() []error {
+	return e
+}
+
 // join concatenates the elements of a to create a single string. The separator string
 // sep is placed between elements in the resulting string.
 func join(a []error, sep string) string {
@@ -0,0 +1,130 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// consulACLCheckKey is KV key under the client prefix used to check write access, it is never written.
+const consulACLCheckKey = ".acl-check"
+
+// ConsulACLStatus is the access of Consul ACL token to the data of this client.
+// Checks are YES, NO or - if it was not possible to check.
+type ConsulACLStatus struct {
+	TokenSource string // config, file, environment or empty if there is no token
+	Node        string // node name
+	NodeWrite   string
+	KVPrefix    string
+	KVRead      string
+	KVWrite     string
+}
+
+// Denied check if the token lacks any access.
+func (s *ConsulACLStatus) Denied() bool {
+	return s.NodeWrite == "NO" || s.KVRead == "NO" || s.KVWrite == "NO"
+}
+
+// consulToken returns Consul ACL token from the config or the token file.
+func (a *Admin) consulToken() (string, error) {
+	if a.Config.ConsulTokenFile != "" {
+		return readConsulTokenFile(a.Config.ConsulTokenFile)
+	}
+	return a.Config.ConsulToken, nil
+}
+
+// consulTokenSource returns where Consul ACL token comes from, empty if there is no token.
+func (a *Admin) consulTokenSource() string {
+	switch {
+	case a.Config.ConsulTokenFile != "":
+		return "file " + a.Config.ConsulTokenFile
+	case a.Config.ConsulToken != "":
+		return "config"
+	case os.Getenv("CONSUL_HTTP_TOKEN") != "":
+		return "environment"
+	}
+	return ""
+}
+
+// readConsulTokenFile read Consul ACL token from the first line of the file.
+func readConsulTokenFile(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		e := newError(KindPermission, "", "Unable to read Consul token file: %s.", err)
+		e.Err = err
+		return "", e
+	}
+	token := strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0])
+	if token == "" {
+		return "", fmt.Errorf("Consul token file %s is empty.", file)
+	}
+	return token, nil
+}
+
+// CheckConsulACL check if Consul ACL token allows to register services of this client and write its KV data.
+// Nothing is changed: the node is registered again as is and KV write is a check-and-set which never succeeds.
+func (a *Admin) CheckConsulACL(ctx context.Context) *ConsulACLStatus {
+	s := &ConsulACLStatus{
+		TokenSource: a.consulTokenSource(),
+		Node:        a.Config.ClientName,
+		NodeWrite:   "-",
+		KVPrefix:    consulNodePrefix(a.Config.ClientName),
+	}
+
+	_, _, err := a.consulAPI.KV().Get(s.KVPrefix+consulACLCheckKey, consulQuery(ctx))
+	s.KVRead = consulACLCheck(err)
+
+	// CAS with non-zero index fails for a missing key, but the access is checked before that.
+	p := &consul.KVPair{Key: s.KVPrefix + consulACLCheckKey, ModifyIndex: 1}
+	_, _, err = a.consulAPI.KV().CAS(p, consulWrite(ctx))
+	s.KVWrite = consulACLCheck(err)
+
+	// The node can be checked only once it is registered, as registering creates it.
+	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, consulQuery(ctx))
+	if err == nil && node != nil {
+		reg := consul.CatalogRegistration{
+			ID:              node.Node.ID,
+			Node:            node.Node.Node,
+			Address:         node.Node.Address,
+			TaggedAddresses: node.Node.TaggedAddresses,
+			NodeMeta:        node.Node.Meta,
+			Datacenter:      node.Node.Datacenter,
+		}
+		_, err = a.consulAPI.Catalog().Register(&reg, consulWrite(ctx))
+		s.NodeWrite = consulACLCheck(err)
+	}
+	return s
+}
+
+// consulACLCheck returns YES if the request succeeded, NO if it was denied and - if it failed otherwise.
+func consulACLCheck(err error) string {
+	switch {
+	case err == nil:
+		return "YES"
+	case isConsulPermissionDenied(err):
+		return "NO"
+	default:
+		return "-"
+	}
+}
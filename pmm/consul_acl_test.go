@@ -0,0 +1,125 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/percona/pmm-client/test/fakeserver"
+)
+
+func TestReadConsulTokenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmm-client")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "token")
+	assert.Nil(t, ioutil.WriteFile(file, []byte("  secret \n# comment\n"), 0600))
+	token, err := readConsulTokenFile(file)
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", token)
+
+	assert.Nil(t, ioutil.WriteFile(file, []byte("\n"), 0600))
+	_, err = readConsulTokenFile(file)
+	assert.EqualError(t, err, "Consul token file "+file+" is empty.")
+
+	_, err = readConsulTokenFile(filepath.Join(dir, "missing"))
+	assert.True(t, IsKind(err, KindPermission))
+}
+
+func TestConsulError(t *testing.T) {
+	err := consulError(errors.New("Unexpected response code: 403 (Permission denied)"))
+	assert.True(t, IsKind(err, KindPermission))
+	assert.Contains(t, err.Error(), "--consul-token")
+
+	err = consulError(errors.New("Unexpected response code: 500 (rpc error)"))
+	assert.True(t, IsKind(err, KindConnectivity))
+}
+
+func TestFakeServerConsulACL(t *testing.T) {
+	ctx := context.Background()
+	srv := fakeserver.New()
+	defer srv.Close()
+	srv.SetACL(map[string]fakeserver.ACL{
+		"secret":   {NodeWrite: []string{"db01"}, KeyWrite: []string{"db01/"}},
+		"readonly": {NodeRead: []string{""}, KeyRead: []string{""}},
+	})
+
+	dir, err := ioutil.TempDir("", "pmm-client")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "consul-token")
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600))
+
+	services := newFakeServices()
+	admin := New(Options{Paths: Paths{BaseDir: dir, AgentBaseDir: dir}, NewService: services.New})
+	admin.Config = &Config{
+		ServerAddress:   srv.Address(),
+		ClientName:      "db01",
+		ClientAddress:   "127.0.0.1",
+		BindAddress:     "127.0.0.1",
+		ConsulTokenFile: tokenFile,
+	}
+	admin.ServiceName = "db01"
+	assert.Nil(t, admin.SetAPI(ctx))
+	_, err = admin.AddLinuxMetrics(ctx, false)
+	assert.Nil(t, err)
+	assert.NotNil(t, srv.Node("db01"))
+
+	acl := admin.CheckConsulACL(ctx)
+	assert.Equal(t, &ConsulACLStatus{
+		TokenSource: "file " + tokenFile,
+		Node:        "db01",
+		NodeWrite:   "YES",
+		KVPrefix:    "db01/",
+		KVRead:      "YES",
+		KVWrite:     "YES",
+	}, acl)
+	assert.False(t, acl.Denied())
+	assert.NotContains(t, srv.KV(), "db01/"+consulACLCheckKey)
+
+	// Read-only token can't change anything.
+	admin.Config.ConsulTokenFile = ""
+	admin.Config.ConsulToken = "readonly"
+	assert.Nil(t, admin.SetAPI(ctx))
+	err = admin.RemoveLinuxMetrics(ctx)
+	assert.True(t, IsKind(err, KindPermission), "%v", err)
+	assert.NotNil(t, srv.Node("db01"))
+
+	acl = admin.CheckConsulACL(ctx)
+	assert.Equal(t, "config", acl.TokenSource)
+	assert.Equal(t, "NO", acl.NodeWrite)
+	assert.Equal(t, "YES", acl.KVRead)
+	assert.Equal(t, "NO", acl.KVWrite)
+	assert.True(t, acl.Denied())
+
+	// Without token the node is not even visible.
+	admin.Config.ConsulToken = ""
+	assert.Nil(t, admin.SetAPI(ctx))
+	acl = admin.CheckConsulACL(ctx)
+	assert.Equal(t, "-", acl.NodeWrite)
+	assert.Equal(t, "NO", acl.KVRead)
+	assert.Equal(t, "NO", acl.KVWrite)
+}
@@ -0,0 +1,125 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrorKind is the kind of failure, so callers can handle errors without matching their text.
+type ErrorKind int
+
+const (
+	KindUnknown      ErrorKind = iota
+	KindDuplicate              // service, instance or client already exists
+	KindNotFound               // service or instance does not exist
+	KindConnectivity           // PMM server or its API is not reachable
+	KindPermission             // access is denied locally or by PMM server
+	KindLocked                 // another pmm-admin holds the lock
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindDuplicate:
+		return "duplicate"
+	case KindNotFound:
+		return "not found"
+	case KindConnectivity:
+		return "connectivity"
+	case KindPermission:
+		return "permission"
+	case KindLocked:
+		return "locked"
+	default:
+		return "unknown"
+	}
+}
+
+// Error is a typed error of Admin with the hint what to do about it.
+type Error struct {
+	Kind        ErrorKind
+	Message     string
+	Remediation string // how to fix it, may be empty
+	Err         error  // underlying error, may be nil
+}
+
+// Error returns the message followed by remediation in a separate paragraph.
+func (e *Error) Error() string {
+	if e.Remediation == "" {
+		return e.Message
+	}
+	return e.Message + "\n\n" + e.Remediation
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// newError returns typed error with formatted message.
+func newError(kind ErrorKind, remediation string, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...), Remediation: remediation}
+}
+
+// ErrorKindOf returns the kind of the first typed error in err chain, KindUnknown if there is none.
+// Local permission errors, like of files and programs pmm-admin runs, are KindPermission too.
+func ErrorKindOf(err error) ErrorKind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	if errors.Is(err, os.ErrPermission) {
+		return KindPermission
+	}
+	return KindUnknown
+}
+
+// IsKind check if err is or wraps typed error of the kind.
+func IsKind(err error, kind ErrorKind) bool {
+	return ErrorKindOf(err) == kind
+}
+
+// consulError returns connectivity error of failed Consul API request,
+// or permission error if Consul ACLs deny it.
+func consulError(err error) error {
+	if isConsulPermissionDenied(err) {
+		return &Error{
+			Kind:    KindPermission,
+			Message: fmt.Sprintf("Consul on PMM server denied the request: %s", err),
+			Remediation: `Looks like Consul ACLs are enabled on PMM server and the token is missing or has no access.
+Use 'pmm-admin config --consul-token' or '--consul-token-file' to set the token with write access
+to this client node and its KV prefix, then run 'pmm-admin check-network' to check its permissions.`,
+			Err: err,
+		}
+	}
+	return &Error{
+		Kind:        KindConnectivity,
+		Message:     fmt.Sprintf("Unable to communicate with Consul: %s", err),
+		Remediation: "Run 'pmm-admin check-network' to check the connection to PMM server.",
+		Err:         err,
+	}
+}
+
+// isConsulPermissionDenied check if Consul API request failed with 403 Forbidden.
+// Consul API client does not keep the status code, it is only a part of the message.
+func isConsulPermissionDenied(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "Unexpected response code: 403")
+}
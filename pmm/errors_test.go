@@ -0,0 +1,42 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorKind(t *testing.T) {
+	assert.True(t, IsKind(ErrDuplicate, KindDuplicate))
+	assert.True(t, IsKind(ErrNoService, KindNotFound))
+	assert.True(t, IsKind(fmt.Errorf("Error adding MySQL metrics: %w", ErrOneLinux), KindDuplicate))
+	assert.True(t, IsKind(Errors{errors.New("plain"), consulError(errors.New("timeout"))}, KindConnectivity))
+	assert.True(t, IsKind(fmt.Errorf("Unable to write config file: %w", os.ErrPermission), KindPermission))
+	assert.Equal(t, KindUnknown, ErrorKindOf(errors.New("plain")))
+	assert.Equal(t, KindUnknown, ErrorKindOf(nil))
+	assert.Equal(t, "not found", KindNotFound.String())
+
+	err := newError(KindPermission, "Use 'pmm-admin config' to define server user and password.", "Unable to connect to PMM server by address: %s", "pmm")
+	assert.EqualError(t, err, "Unable to connect to PMM server by address: pmm\n\nUse 'pmm-admin config' to define server user and password.")
+	assert.Equal(t, "no service found.", ErrNoService.Error())
+}
@@ -95,7 +95,7 @@ func (a *Admin) AddExternalMetrics(ctx context.Context, ext *ExternalMetrics) er
 		},
 	})
 	if _, ok := err.(*managed.Error); err != nil && !ok {
-		return fmt.Errorf("%s\nPlease check versions of your PMM Server and PMM Client.", err)
+		return fmt.Errorf("%w\nPlease check versions of your PMM Server and PMM Client.", err)
 	}
 	return err
 }
@@ -104,7 +104,7 @@ func (a *Admin) AddExternalMetrics(ctx context.Context, ext *ExternalMetrics) er
 func (a *Admin) RemoveExternalMetrics(ctx context.Context, name string) error {
 	err := a.managedAPI.ScrapeConfigsDelete(ctx, name)
 	if _, ok := err.(*managed.Error); err != nil && !ok {
-		return fmt.Errorf("%s\nPlease check versions of your PMM Server and PMM Client.", err)
+		return fmt.Errorf("%w\nPlease check versions of your PMM Server and PMM Client.", err)
 	}
 	return err
 }
@@ -116,7 +116,7 @@ func (a *Admin) AddExternalInstances(ctx context.Context, name string, targets [
 		Targets: targets,
 	})
 	if _, ok := err.(*managed.Error); err != nil && !ok {
-		return fmt.Errorf("%s\nPlease check versions of your PMM Server and PMM Client.", err)
+		return fmt.Errorf("%w\nPlease check versions of your PMM Server and PMM Client.", err)
 	}
 	return err
 }
@@ -128,7 +128,7 @@ func (a *Admin) RemoveExternalInstances(ctx context.Context, name string, target
 		Targets: targets,
 	})
 	if _, ok := err.(*managed.Error); err != nil && !ok {
-		return fmt.Errorf("%s\nPlease check versions of your PMM Server and PMM Client.", err)
+		return fmt.Errorf("%w\nPlease check versions of your PMM Server and PMM Client.", err)
 	}
 	return err
 }
@@ -0,0 +1,238 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/percona/kardianos-service"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/percona/pmm-client/test/fakeserver"
+)
+
+// fakeServices is in-memory service backend.
+type fakeServices struct {
+	installed map[string]bool
+	running   map[string]bool
+}
+
+func newFakeServices() *fakeServices {
+	return &fakeServices{installed: map[string]bool{}, running: map[string]bool{}}
+}
+
+func (f *fakeServices) New(i service.Interface, c *service.Config) (service.Service, error) {
+	return &fakeService{f, c.Name}, nil
+}
+
+type fakeService struct {
+	f    *fakeServices
+	name string
+}
+
+func (s *fakeService) Run() error   { return nil }
+func (s *fakeService) Start() error { s.f.running[s.name] = true; return nil }
+func (s *fakeService) Stop() error  { s.f.running[s.name] = false; return nil }
+func (s *fakeService) Restart() error {
+	s.f.running[s.name] = true
+	return nil
+}
+func (s *fakeService) Install() error { s.f.installed[s.name] = true; return nil }
+func (s *fakeService) Uninstall() error {
+	delete(s.f.installed, s.name)
+	delete(s.f.running, s.name)
+	return nil
+}
+func (s *fakeService) Status() error {
+	if !s.f.running[s.name] {
+		return errors.New("not running")
+	}
+	return nil
+}
+func (s *fakeService) Logger(errs chan<- error) (service.Logger, error) {
+	return service.ConsoleLogger, nil
+}
+func (s *fakeService) SystemLogger(errs chan<- error) (service.Logger, error) {
+	return service.ConsoleLogger, nil
+}
+func (s *fakeService) String() string { return s.name }
+
+func TestFakeServerFlow(t *testing.T) {
+	ctx := context.Background()
+	srv := fakeserver.New()
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "pmm-client")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	services := newFakeServices()
+	admin := New(Options{Paths: Paths{BaseDir: dir, AgentBaseDir: dir}, NewService: services.New})
+	admin.Config = &Config{
+		ServerAddress: srv.Address(),
+		ClientName:    "db01",
+		ClientAddress: "127.0.0.1",
+		BindAddress:   "127.0.0.1",
+	}
+	admin.ServiceName = "db01"
+	assert.Nil(t, admin.SetAPI(ctx))
+
+	// Add.
+	_, err = admin.AddLinuxMetrics(ctx, false)
+	assert.Nil(t, err)
+	assert.True(t, services.running["pmm-linux-metrics-42000"])
+	node := srv.Node("db01")
+	if !assert.NotNil(t, node) {
+		return
+	}
+	assert.Equal(t, 42000, node.Services["linux:metrics-42000"].Port)
+	_, err = admin.AddLinuxMetrics(ctx, false)
+	assert.Equal(t, ErrOneLinux, err)
+	assert.True(t, IsKind(err, KindDuplicate))
+
+	// List.
+	l, err := admin.List(ctx)
+	assert.Nil(t, err)
+	if !assert.Len(t, l.Services, 1) {
+		return
+	}
+	assert.Equal(t, "linux:metrics", l.Services[0].Type)
+	assert.True(t, l.Services[0].Running)
+
+	// Check network.
+	status, err := admin.CheckNetwork(ctx)
+	assert.Nil(t, err)
+	assert.True(t, status.PrometheusAPI)
+	assert.True(t, status.QANAPI)
+	if !assert.Len(t, status.Endpoints, 1) {
+		return
+	}
+	assert.True(t, status.Endpoints[0].Running)
+	srv.SetTargetUp("linux", "db01", false)
+	status, err = admin.CheckNetwork(ctx)
+	assert.Nil(t, err)
+	assert.False(t, status.Endpoints[0].Running)
+
+	// Purge.
+	count, err := admin.PurgeMetrics(ctx, "linux:metrics")
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, count)
+	assert.Equal(t, []string{`{job="linux",instance="db01"}`}, srv.DeletedSeries())
+
+	// Remove.
+	assert.Nil(t, admin.RemoveLinuxMetrics(ctx))
+	assert.Empty(t, srv.Node("db01").Services)
+	assert.Empty(t, services.installed)
+	err = admin.RemoveLinuxMetrics(ctx)
+	assert.Equal(t, ErrNoService, err)
+	assert.True(t, IsKind(err, KindNotFound))
+}
+
+func TestFakeServerAddErrorKind(t *testing.T) {
+	ctx := context.Background()
+	srv := fakeserver.New()
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "pmm-client")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// Agent is registered by the installer which is not executable, so it fails even for root.
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "bin"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "bin", "percona-qan-agent-installer"), []byte("#!/bin/sh\n"), 0644))
+
+	services := newFakeServices()
+	admin := New(Options{Paths: Paths{BaseDir: dir, AgentBaseDir: dir}, NewService: services.New})
+	admin.Config = &Config{
+		ServerAddress: srv.Address(),
+		ClientName:    "db01",
+		ClientAddress: "127.0.0.1",
+		BindAddress:   "127.0.0.1",
+	}
+	admin.ServiceName = "db01"
+	assert.Nil(t, admin.SetAPI(ctx))
+
+	_, err = admin.AddMySQLQueries(ctx, map[string]string{"dsn": "root@tcp(127.0.0.1:3306)/", "safe_dsn": "root:***@tcp(127.0.0.1:3306)/"})
+	if !assert.Error(t, err) {
+		return
+	}
+	assert.Contains(t, err.Error(), "problem with agent registration on QAN API")
+	assert.True(t, IsKind(err, KindPermission), err.Error())
+	assert.Empty(t, services.installed)
+}
+
+func TestFakeServerAuth(t *testing.T) {
+	srv := fakeserver.New()
+	defer srv.Close()
+	srv.SetCredentials("pmm", "secret")
+
+	admin := New(Options{})
+	admin.Config = &Config{ServerAddress: srv.Address(), ServerUser: "pmm", ServerPassword: "wrong"}
+	err := admin.SetAPI(context.Background())
+	assert.True(t, IsKind(err, KindPermission), "%s", err)
+
+	admin.Config.ServerPassword = "secret"
+	assert.Nil(t, admin.SetAPI(context.Background()))
+}
+
+func TestFakeServerConfigRollback(t *testing.T) {
+	ctx := context.Background()
+	srv := fakeserver.New()
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "pmm-client")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// Config can't be written to the missing dir, so the rename and address change are undone.
+	paths := Paths{BaseDir: dir, AgentBaseDir: dir, ConfigFile: filepath.Join(dir, "missing", "pmm.yml")}
+	services := newFakeServices()
+	admin := New(Options{Paths: paths, NewService: services.New})
+	admin.Config = &Config{
+		ServerAddress: srv.Address(),
+		ClientName:    "db01",
+		ClientAddress: "127.0.0.1",
+		BindAddress:   "127.0.0.1",
+	}
+	admin.ServiceName = "db01"
+	assert.Nil(t, admin.SetAPI(ctx))
+	_, err = admin.AddLinuxMetrics(ctx, false)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	_, err = admin.SetConfig(ctx, Config{ClientName: "db02", ClientAddress: "10.0.0.2"}, false)
+	if !assert.Error(t, err) {
+		return
+	}
+	assert.Contains(t, err.Error(), "Unable to write config file")
+
+	node := srv.Node("db01")
+	if assert.NotNil(t, node) {
+		assert.Equal(t, "127.0.0.1", node.Node.Address)
+		assert.Len(t, node.Services, 1)
+	}
+	if node := srv.Node("db02"); node != nil {
+		assert.Empty(t, node.Services)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// DefaultHealthCheckInterval is how often serve-metrics updates health checks of services.
+const DefaultHealthCheckInterval = time.Minute
+
+// HealthCheck is the state of health check of a service.
+type HealthCheck struct {
+	ServiceID string
+	Status    string // passing, warning or critical
+	Output    string
+}
+
+// UpdateHealthChecks check local services of this client and update their health checks in the registry.
+// Services are critical if their system service is not running, warning if their metrics endpoint
+// does not respond with 200 OK and passing otherwise. External services are not checked.
+func (a *Admin) UpdateHealthChecks(ctx context.Context) ([]HealthCheck, error) {
+	node, err := a.registry().ListNodeServices(ctx, a.Config.ClientName)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, nil
+	}
+
+	var checks []HealthCheck
+	var errs Errors
+	for _, svc := range node.Services {
+		if strings.HasPrefix(svc.Service, "external:") {
+			continue
+		}
+		check := a.checkServiceHealth(ctx, svc, true)
+		if err := a.registerHealthCheck(ctx, svc, check); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", svc.ID, err))
+			continue
+		}
+		checks = append(checks, check)
+	}
+	if len(errs) > 0 {
+		return checks, errs
+	}
+	return checks, nil
+}
+
+// updateHealthCheck update health check of the service by its local status only.
+// It is used right after the service is installed or started when its endpoint may be not listening yet,
+// the endpoint is probed on the next run of health checks.
+func (a *Admin) updateHealthCheck(ctx context.Context, svc *RegistryService) error {
+	return a.registerHealthCheck(ctx, svc, a.checkServiceHealth(ctx, svc, false))
+}
+
+// initHealthCheck update health check of the just added service.
+// The service is already installed and registered at this point, so the failure is returned as a warning
+// rather than failing the add, the check is updated on the next run of health checks.
+func (a *Admin) initHealthCheck(ctx context.Context, svc *RegistryService) []string {
+	if err := a.updateHealthCheck(ctx, svc); err != nil {
+		return []string{fmt.Sprintf("WARNING: unable to update health check of %s: %s", svc.ID, err)}
+	}
+	return nil
+}
+
+// RunHealthChecks update health checks every interval until ctx is done.
+// Failed updates are passed to onError, if set, and retried on the next run as PMM server may be temporarily unreachable.
+func (a *Admin) RunHealthChecks(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := a.UpdateHealthChecks(ctx); err != nil && ctx.Err() == nil && onError != nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkServiceHealth returns health of a service by its local status and, if probe is set, its metrics endpoint.
+func (a *Admin) checkServiceHealth(ctx context.Context, svc *RegistryService, probe bool) HealthCheck {
+	check := HealthCheck{ServiceID: svc.ID}
+	svcName := fmt.Sprintf("pmm-%s-%d", strings.Replace(svc.Service, ":", "-", 1), svc.Port)
+	if !a.getServiceStatus(svcName) {
+		check.Status = consul.HealthCritical
+		check.Output = fmt.Sprintf("Service %s is not running.", svcName)
+		return check
+	}
+	if !probe || !strings.HasSuffix(svc.Service, ":metrics") {
+		check.Status = consul.HealthPassing
+		check.Output = fmt.Sprintf("Service %s is running.", svcName)
+		return check
+	}
+
+	u := a.metricsURL(svc)
+	resp, _, err := a.exporterAPI.Get(ctx, u.String())
+	u.User = nil
+	switch {
+	case err != nil:
+		check.Status = consul.HealthWarning
+		check.Output = fmt.Sprintf("Service %s is running, but GET %s failed: %s", svcName, u, err)
+	case resp.StatusCode != http.StatusOK:
+		check.Status = consul.HealthWarning
+		check.Output = fmt.Sprintf("Service %s is running, but GET %s returned %s.", svcName, u, resp.Status)
+	default:
+		check.Status = consul.HealthPassing
+		check.Output = fmt.Sprintf("Service %s is running, GET %s returned %s.", svcName, u, resp.Status)
+	}
+	return check
+}
+
+// metricsURL returns URL of metrics endpoint of a service with server credentials.
+func (a *Admin) metricsURL(svc *RegistryService) *url.URL {
+	u := &url.URL{
+		Scheme: "http",
+		Host:   hostPort(a.Config.BindAddress, svc.Port),
+		Path:   "/metrics",
+	}
+	for _, tag := range svc.Tags {
+		if tag == "scheme_https" {
+			u.Scheme = "https"
+		}
+	}
+	if svc.Service == "mysql:metrics" {
+		u.Path = "/metrics-hr"
+	}
+	if a.Config.ServerUser != "" {
+		u.User = url.UserPassword(a.Config.ServerUser, a.Config.ServerPassword)
+	}
+	return u
+}
+
+// registerHealthCheck set health check of a service in the registry.
+func (a *Admin) registerHealthCheck(ctx context.Context, svc *RegistryService, check HealthCheck) error {
+	return a.registry().SetServiceHealth(ctx, a.Config.ClientName, a.Config.ClientAddress, svc, check)
+}
+
+// healthChecks returns health check status of services of this client by service ID.
+func (a *Admin) healthChecks(ctx context.Context) (map[string]string, error) {
+	return a.registry().ListNodeHealth(ctx, a.Config.ClientName)
+}
@@ -0,0 +1,190 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Custom Prometheus labels of monitoring services are kept as Consul service tags label_<name>=<value>,
+// so PMM server can turn them into target labels by relabeling Consul targets, e.g.:
+//
+//   - source_labels: [__meta_consul_tags]
+//     regex: '.*,label_env=([^,]+),.*'
+//     target_label: env
+//
+// Node-wide labels from the config are added to every service of the client.
+// Labels of the service take precedence on adding it, changing node-wide label overwrites it on every service.
+const labelTagPrefix = "label_"
+
+// labelNameRe matches Prometheus label names, the ones starting with __ are reserved.
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels are set by PMM server from the other tags.
+var reservedLabels = []string{"instance", "job", "cluster"}
+
+// ParseLabels parses labels name=value, empty value removes the label.
+func ParseLabels(args []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid label %s, it should be name=value.", arg)
+		}
+		name, value := parts[0], parts[1]
+		if !labelNameRe.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("Invalid label name %s, it should start with a letter or underscore and contain only letters, numbers and underscores, names starting with __ are reserved.", name)
+		}
+		if contains(reservedLabels, name) {
+			return nil, fmt.Errorf("Label %s is reserved, it is set by PMM server.", name)
+		}
+		// Consul target tags are joined with commas.
+		if strings.Contains(value, ",") {
+			return nil, fmt.Errorf("Invalid value of label %s, it should not contain commas.", name)
+		}
+		labels[name] = value
+	}
+	return labels, nil
+}
+
+// FormatLabels formats labels as name=value sorted by name.
+func FormatLabels(labels map[string]string) string {
+	var pairs []string
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+// labelsFromTags returns labels kept in Consul service tags.
+func labelsFromTags(tags []string) map[string]string {
+	labels := map[string]string{}
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, labelTagPrefix) {
+			continue
+		}
+		if parts := strings.SplitN(tag[len(labelTagPrefix):], "=", 2); len(parts) == 2 {
+			labels[parts[0]] = parts[1]
+		}
+	}
+	return labels
+}
+
+// setLabelTags returns Consul service tags with labels set, the ones with empty value are removed.
+func setLabelTags(tags []string, labels map[string]string) []string {
+	var result []string
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, labelTagPrefix) {
+			name := strings.SplitN(tag[len(labelTagPrefix):], "=", 2)[0]
+			if _, ok := labels[name]; ok {
+				continue
+			}
+		}
+		result = append(result, tag)
+	}
+	var names []string
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if labels[name] != "" {
+			result = append(result, labelTagPrefix+name+"="+labels[name])
+		}
+	}
+	return result
+}
+
+// labelTags returns Consul tags of service to add with node-wide labels and labels of the service.
+func (a *Admin) labelTags(tags []string) []string {
+	labels := map[string]string{}
+	if a.Config != nil {
+		for name, value := range a.Config.Labels {
+			labels[name] = value
+		}
+	}
+	for name, value := range a.Labels {
+		labels[name] = value
+	}
+	return setLabelTags(tags, labels)
+}
+
+// SetServiceLabels changes labels of monitoring service by its type and name, empty value removes the label.
+func (a *Admin) SetServiceLabels(ctx context.Context, svcType string, labels map[string]string) error {
+	if err := isValidSvcType(svcType); err != nil {
+		return err
+	}
+	registered, err := a.getRegisteredService(ctx, svcType, a.ServiceName)
+	if err != nil {
+		return err
+	}
+	if registered == nil {
+		return ErrNoService
+	}
+	return a.setLabels(ctx, registered, labels)
+}
+
+// setNodeLabels changes node-wide labels in the config and on every service of the client.
+// The config is written by the caller.
+func (a *Admin) setNodeLabels(ctx context.Context, labels map[string]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+	if a.Config.Labels == nil {
+		a.Config.Labels = map[string]string{}
+	}
+	for name, value := range labels {
+		if value == "" {
+			delete(a.Config.Labels, name)
+			continue
+		}
+		a.Config.Labels[name] = value
+	}
+	if len(a.Config.Labels) == 0 {
+		a.Config.Labels = nil
+	}
+
+	node, err := a.registry().ListNodeServices(ctx, a.Config.ClientName)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return nil
+	}
+	for _, svc := range node.Services {
+		if svc.Service == "consul" {
+			continue
+		}
+		if err := a.setLabels(ctx, svc, labels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setLabels registers service again with labels changed.
+func (a *Admin) setLabels(ctx context.Context, svc *RegistryService, labels map[string]string) error {
+	srv := *svc
+	srv.Tags = setLabelTags(svc.Tags, labels)
+	return a.registerService(ctx, &srv)
+}
@@ -0,0 +1,122 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/percona/pmm-client/test/fakeserver"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels([]string{"env=prod", "team=", "az=us-east-1a", "note=a=b"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod", "team": "", "az": "us-east-1a", "note": "a=b"}, labels)
+
+	for arg, expected := range map[string]string{
+		"env":       "Invalid label env, it should be name=value.",
+		"1env=prod": "Invalid label name 1env, it should start with a letter or underscore and contain only letters, numbers and underscores, names starting with __ are reserved.",
+		"__env=x":   "Invalid label name __env, it should start with a letter or underscore and contain only letters, numbers and underscores, names starting with __ are reserved.",
+		"my-env=x":  "Invalid label name my-env, it should start with a letter or underscore and contain only letters, numbers and underscores, names starting with __ are reserved.",
+		"job=x":     "Label job is reserved, it is set by PMM server.",
+		"env=a,b":   "Invalid value of label env, it should not contain commas.",
+	} {
+		_, err := ParseLabels([]string{arg})
+		assert.EqualError(t, err, expected, arg)
+	}
+}
+
+func TestLabelTags(t *testing.T) {
+	tags := []string{"alias_db01", "scheme_https", "label_env=dev", "label_team=dba"}
+	assert.Equal(t, map[string]string{"env": "dev", "team": "dba"}, labelsFromTags(tags))
+	assert.Equal(t, "env=dev, team=dba", FormatLabels(labelsFromTags(tags)))
+
+	tags = setLabelTags(tags, map[string]string{"env": "prod", "team": "", "az": "a"})
+	assert.Equal(t, []string{"alias_db01", "scheme_https", "label_az=a", "label_env=prod"}, tags)
+
+	a := &Admin{Config: &Config{Labels: map[string]string{"env": "prod", "region": "eu"}}, Labels: map[string]string{"env": "test"}}
+	assert.Equal(t, []string{"alias_db02", "label_env=test", "label_region=eu"}, a.labelTags([]string{"alias_db02"}))
+}
+
+func TestListLabels(t *testing.T) {
+	l := &List{Services: []ServiceStatus{
+		{Type: "linux:metrics", Name: "db01", Port: "42000", DSN: "-"},
+		{Type: "mysql:metrics", Name: "db01", Port: "42002", DSN: "root:***@unix(/var/run/mysqld/mysqld.sock)", Labels: "env=prod"},
+	}}
+	lines := []string{
+		"-------------- ----- ----------- -------- ------------------------------------------- -------- ---------",
+		"SERVICE TYPE   NAME  LOCAL PORT  RUNNING  DATA SOURCE                                 OPTIONS  LABELS   ",
+		"-------------- ----- ----------- -------- ------------------------------------------- -------- ---------",
+	}
+	table := l.Table()
+	for _, line := range lines {
+		assert.Contains(t, table, line+"\n")
+	}
+	assert.Contains(t, table, "env=prod \n")
+
+	l.Services[1].Labels = ""
+	assert.NotContains(t, l.Table(), "LABELS")
+}
+
+func TestFakeServerLabels(t *testing.T) {
+	ctx := context.Background()
+	srv := fakeserver.New()
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "pmm-client")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	services := newFakeServices()
+	admin := New(Options{Paths: Paths{BaseDir: dir, AgentBaseDir: dir}, NewService: services.New})
+	admin.Config = &Config{
+		ServerAddress: srv.Address(),
+		ClientName:    "db01",
+		ClientAddress: "127.0.0.1",
+		BindAddress:   "127.0.0.1",
+		Labels:        map[string]string{"region": "eu"},
+	}
+	admin.ServiceName = "db01"
+	admin.Labels = map[string]string{"env": "dev"}
+	assert.Nil(t, admin.SetAPI(ctx))
+	_, err = admin.AddLinuxMetrics(ctx, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"alias_db01", "scheme_https", "label_env=dev", "label_region=eu"}, srv.Node("db01").Services["linux:metrics-42000"].Tags)
+
+	// Service labels.
+	assert.Nil(t, admin.SetServiceLabels(ctx, "linux:metrics", map[string]string{"env": "prod", "team": "dba"}))
+	assert.Equal(t, []string{"alias_db01", "scheme_https", "label_region=eu", "label_env=prod", "label_team=dba"}, srv.Node("db01").Services["linux:metrics-42000"].Tags)
+	assert.Equal(t, ErrNoService, admin.SetServiceLabels(ctx, "mysql:metrics", map[string]string{"env": "prod"}))
+
+	// Node-wide labels.
+	assert.Nil(t, admin.setNodeLabels(ctx, map[string]string{"region": "", "az": "a"}))
+	assert.Equal(t, map[string]string{"az": "a"}, admin.Config.Labels)
+	assert.Equal(t, []string{"alias_db01", "scheme_https", "label_env=prod", "label_team=dba", "label_az=a"}, srv.Node("db01").Services["linux:metrics-42000"].Tags)
+
+	l, err := admin.List(ctx)
+	assert.Nil(t, err)
+	if !assert.Len(t, l.Services, 1) {
+		return
+	}
+	assert.Equal(t, "az=a, env=prod, team=dba", l.Services[0].Labels)
+}
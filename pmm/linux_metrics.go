@@ -18,75 +18,71 @@
 package pmm
 
 import (
+	"context"
 	"fmt"
 
-	consul "github.com/hashicorp/consul/api"
 	"github.com/percona/kardianos-service"
 )
 
 // AddLinuxMetrics add linux service to monitoring.
-func (a *Admin) AddLinuxMetrics(force bool) error {
-	// Check if we have already this service on Consul.
+// Returned warnings are about things that did not fail adding the service.
+func (a *Admin) AddLinuxMetrics(ctx context.Context, force bool) ([]string, error) {
+	// Check if we have already this service registered.
 	// When using force, we allow adding another service with different name.
 	name := ""
 	if force {
 		name = a.ServiceName
 	}
-	consulSvc, err := a.getConsulService("linux:metrics", name)
+	registered, err := a.getRegisteredService(ctx, "linux:metrics", name)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if consulSvc != nil {
+	if registered != nil {
 		if force {
-			return ErrDuplicate
+			return nil, ErrDuplicate
 		}
-		return ErrOneLinux
+		return nil, ErrOneLinux
 	}
 
-	if err := a.checkGlobalDuplicateService("linux:metrics", a.ServiceName); err != nil {
-		return err
+	if err := a.checkGlobalDuplicateService(ctx, "linux:metrics", a.ServiceName); err != nil {
+		return nil, err
 	}
 
 	// Choose port.
 	var port int
 	if a.ServicePort > 0 {
 		// The port is user defined.
-		port, err = a.choosePort(a.ServicePort, true)
+		port, err = a.choosePort(ctx, a.ServicePort, true)
 	} else {
 		// Choose first port available starting the given default one.
-		port, err = a.choosePort(42000, false)
+		port, err = a.choosePort(ctx, 42000, false)
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Add service to Consul.
-	srv := consul.AgentService{
+	// Register service.
+	srv := RegistryService{
 		ID:      fmt.Sprintf("linux:metrics-%d", port),
 		Service: "linux:metrics",
-		Tags:    []string{fmt.Sprintf("alias_%s", a.ServiceName), "scheme_https"},
+		Tags:    a.labelTags([]string{fmt.Sprintf("alias_%s", a.ServiceName), "scheme_https"}),
 		Port:    int(port),
 	}
-	reg := consul.CatalogRegistration{
-		Node:    a.Config.ClientName,
-		Address: a.Config.ClientAddress,
-		Service: &srv,
-	}
-	if _, err := a.consulAPI.Catalog().Register(&reg, nil); err != nil {
-		return err
+	if err := a.registerService(ctx, &srv); err != nil {
+		return nil, err
 	}
 
 	// Check and generate certificate if needed.
 	if err := a.checkSSLCertificate(); err != nil {
-		return err
+		return nil, err
 	}
 
 	args := []string{
 		nodeExporterArgs,
-		fmt.Sprintf("-web.listen-address=%s:%d", a.Config.BindAddress, port),
-		fmt.Sprintf("-web.auth-file=%s", ConfigFile),
-		fmt.Sprintf("-web.ssl-cert-file=%s", SSLCertFile),
-		fmt.Sprintf("-web.ssl-key-file=%s", SSLKeyFile),
+		fmt.Sprintf("-web.listen-address=%s", hostPort(a.Config.BindAddress, port)),
+		fmt.Sprintf("-web.auth-file=%s", a.authFile()),
+		fmt.Sprintf("-web.ssl-cert-file=%s", a.paths().SSLCertFile),
+		fmt.Sprintf("-web.ssl-key-file=%s", a.paths().SSLKeyFile),
 	}
 	// Add additional args passed to pmm-admin
 	args = append(args, a.Args...)
@@ -96,38 +92,34 @@ func (a *Admin) AddLinuxMetrics(force bool) error {
 		Name:        fmt.Sprintf("pmm-linux-metrics-%d", port),
 		DisplayName: "PMM Prometheus node_exporter",
 		Description: "PMM Prometheus node_exporter",
-		Executable:  fmt.Sprintf("%s/node_exporter", PMMBaseDir),
+		Executable:  fmt.Sprintf("%s/node_exporter", a.paths().BaseDir),
 		Arguments:   args,
 	}
-	if err := installService(svcConfig); err != nil {
-		return err
+	if err := a.installService(svcConfig); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return a.initHealthCheck(ctx, &srv), nil
 }
 
 // RemoveLinuxMetrics remove linux service from monitoring.
-func (a *Admin) RemoveLinuxMetrics() error {
-	// Check if we have this service on Consul.
-	consulSvc, err := a.getConsulService("linux:metrics", a.ServiceName)
+func (a *Admin) RemoveLinuxMetrics(ctx context.Context) error {
+	// Check if we have this service registered.
+	registered, err := a.getRegisteredService(ctx, "linux:metrics", a.ServiceName)
 	if err != nil {
 		return err
 	}
-	if consulSvc == nil {
+	if registered == nil {
 		return ErrNoService
 	}
 
-	// Remove service from Consul.
-	dereg := consul.CatalogDeregistration{
-		Node:      a.Config.ClientName,
-		ServiceID: consulSvc.ID,
-	}
-	if _, err := a.consulAPI.Catalog().Deregister(&dereg, nil); err != nil {
+	// Deregister service.
+	if err := a.registry().DeregisterService(ctx, a.Config.ClientName, registered.ID); err != nil {
 		return err
 	}
 
 	// Stop and uninstall service.
-	if err := uninstallService(fmt.Sprintf("pmm-linux-metrics-%d", consulSvc.Port)); err != nil {
+	if err := a.uninstallService(fmt.Sprintf("pmm-linux-metrics-%d", registered.Port)); err != nil {
 		return err
 	}
 
@@ -26,8 +26,8 @@ import (
 	"text/tabwriter"
 
 	"github.com/docker/cli/templates"
+	"github.com/fatih/color"
 	consul "github.com/hashicorp/consul/api"
-	"github.com/percona/kardianos-service"
 )
 
 // Service status description.
@@ -38,6 +38,8 @@ type ServiceStatus struct {
 	Running  bool
 	DSN      string
 	Options  string
+	Health   string // Consul health check status, empty if the service has no check
+	Labels   string
 	SSL      string
 	Password string
 }
@@ -74,55 +76,96 @@ type List struct {
 	Services         []ServiceStatus
 	ExternalErr      string
 	ExternalServices []ExternalMetrics
+	ServerProfiles   []ServerProfileStatus
 }
 
 // Table formats *List.Services as table and returns result as string.
+// Health and labels columns are shown only if any service has them.
 func (l *List) Table() string {
-	// Print table.
-	maxTypeLen := len("SERVICE TYPE")
-	maxNameLen := len("NAME")
-	maxDSNlen := len("DATA SOURCE")
-	maxOptsLen := len("OPTIONS")
-	for _, in := range l.Services {
-		if len(in.Type) > maxTypeLen {
-			maxTypeLen = len(in.Type)
-		}
-		if len(in.Name) > maxNameLen {
-			maxNameLen = len(in.Name)
-		}
-		if len(in.DSN) > maxDSNlen {
-			maxDSNlen = len(in.DSN)
+	// Colored values are padded for the color escape codes.
+	type column struct {
+		header  string
+		value   func(ServiceStatus) string
+		color   func(ServiceStatus) string
+		escapes int // fixed padding for escape codes, the real one if zero
+		width   int
+		visible bool
+	}
+	columns := []*column{
+		{header: "SERVICE TYPE", value: func(s ServiceStatus) string { return s.Type }},
+		{header: "NAME", value: func(s ServiceStatus) string { return s.Name }},
+		{header: "LOCAL PORT", value: func(s ServiceStatus) string { return s.Port }},
+		{header: "RUNNING", value: func(s ServiceStatus) string { return yesNo(s.Running) },
+			color: func(s ServiceStatus) string { return colorStatus("YES", "NO", s.Running) }, escapes: 11},
+		{header: "HEALTH", value: func(s ServiceStatus) string { return s.Health },
+			color: func(s ServiceStatus) string { return colorHealth(s.Health) }},
+		{header: "DATA SOURCE", value: func(s ServiceStatus) string { return s.DSN }},
+		{header: "OPTIONS", value: func(s ServiceStatus) string { return s.Options }},
+		{header: "LABELS", value: func(s ServiceStatus) string { return s.Labels }},
+	}
+	var visible []*column
+	for _, c := range columns {
+		c.width = len(c.header)
+		for _, in := range l.Services {
+			v := c.value(in)
+			if len(v) > c.width {
+				c.width = len(v)
+			}
+			if v != "" {
+				c.visible = true
+			}
 		}
-		if len(in.Options) > maxOptsLen {
-			maxOptsLen = len(in.Options)
+		c.width++
+		if c.visible || (c.header != "HEALTH" && c.header != "LABELS") {
+			visible = append(visible, c)
 		}
 	}
-	maxTypeLen++
-	maxNameLen++
-	maxDSNlen++
-	maxOptsLen++
-	maxStatusLen := 8
-
-	out := ""
-
-	fmtPattern := "%%-%ds %%-%ds %%-%ds %%-%ds %%-%ds %%-%ds\n"
-	linefmt := fmt.Sprintf(fmtPattern, maxTypeLen, maxNameLen, 11, maxStatusLen, maxDSNlen, maxOptsLen)
-
-	out = out + fmt.Sprintf(linefmt, strings.Repeat("-", maxTypeLen), strings.Repeat("-", maxNameLen), strings.Repeat("-", 11),
-		strings.Repeat("-", maxStatusLen), strings.Repeat("-", maxDSNlen), strings.Repeat("-", maxOptsLen))
-	out = out + fmt.Sprintf(linefmt, "SERVICE TYPE", "NAME", "LOCAL PORT", "RUNNING", "DATA SOURCE", "OPTIONS")
-	out = out + fmt.Sprintf(linefmt, strings.Repeat("-", maxTypeLen), strings.Repeat("-", maxNameLen), strings.Repeat("-", 11),
-		strings.Repeat("-", maxStatusLen), strings.Repeat("-", maxDSNlen), strings.Repeat("-", maxOptsLen))
-
-	maxStatusLen += 11
-	linefmt = fmt.Sprintf(fmtPattern, maxTypeLen, maxNameLen, 11, maxStatusLen, maxDSNlen, maxOptsLen)
-	for _, i := range l.Services {
-		out = out + fmt.Sprintf(linefmt, i.Type, i.Name, i.Port, colorStatus("YES", "NO", i.Running), i.DSN, i.Options)
+
+	line := func(value func(c *column) string) string {
+		out := ""
+		for _, c := range visible {
+			out += fmt.Sprintf("%-*s ", c.width, value(c))
+		}
+		return strings.TrimSuffix(out, " ") + "\n"
 	}
 
+	separator := line(func(c *column) string { return strings.Repeat("-", c.width) })
+	out := separator
+	out += line(func(c *column) string { return c.header })
+	out += separator
+	for _, in := range l.Services {
+		row := ""
+		for _, c := range visible {
+			v, width := c.value(in), c.width
+			if c.color != nil {
+				colored := c.color(in)
+				if c.escapes > 0 {
+					width += c.escapes
+				} else {
+					width += len(colored) - len(v)
+				}
+				v = colored
+			}
+			row += fmt.Sprintf("%-*s ", width, v)
+		}
+		out += strings.TrimSuffix(row, " ") + "\n"
+	}
 	return out
 }
 
+// colorHealth returns Consul health check status colored by its severity.
+func colorHealth(status string) string {
+	switch status {
+	case consul.HealthPassing:
+		return color.New(color.FgGreen, color.Bold).Sprint(status)
+	case consul.HealthWarning:
+		return color.New(color.FgYellow, color.Bold).Sprint(status)
+	case consul.HealthCritical:
+		return color.New(color.FgRed, color.Bold).Sprint(status)
+	}
+	return status
+}
+
 // ExternalTable formats *List.ExternalServices as table and returns result as string.
 func (l *List) ExternalTable() string {
 	var buf bytes.Buffer
@@ -136,6 +179,11 @@ func (l *List) ExternalTable() string {
 	return buf.String()
 }
 
+// ServerProfilesTable formats *List.ServerProfiles as table and returns result as string.
+func (l *List) ServerProfilesTable() string {
+	return serverProfilesTable(l.ServerProfiles)
+}
+
 // Format formats *List with provided format template and returns result as string.
 func (l *List) Format(format string) string {
 	b := &bytes.Buffer{}
@@ -172,49 +220,52 @@ const (
 {{.Err}}{{end}}{{if .Services}}
 {{.Table}}{{end}}{{if .ExternalErr}}
 {{.ExternalErr}}{{end}}{{if .ExternalServices}}
-{{.ExternalTable}}{{end}}`
+{{.ExternalTable}}{{end}}{{if .ServerProfiles}}
+{{.ServerProfilesTable}}{{end}}`
 )
 
-// List prints to stdout all services from Consul.
-func (a *Admin) List() error {
+// List returns all services of this client from Consul along with external ones.
+// Problems getting them are reported by Err fields of the list.
+func (a *Admin) List(ctx context.Context) (*List, error) {
 	l := &List{
 		Version:    Version,
-		Platform:   service.Platform(),
-		ServerInfo: a.serverInfo(),
+		Platform:   a.serviceManager().Name(),
+		ServerInfo: a.ServerInfo(),
 	}
 
-	defer func() {
-		fmt.Print(l.Format(a.Format))
-	}()
-
 	var err error
-	l.ExternalServices, err = a.ListExternalMetrics(context.TODO())
+	l.ExternalServices, err = a.ListExternalMetrics(ctx)
 	if err != nil {
 		l.ExternalErr = err.Error() + "\n"
 	}
 
-	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
+	l.ServerProfiles = a.ServerProfilesStatus(ctx)
+
+	node, err := a.registry().ListNodeServices(ctx, a.Config.ClientName)
 	if err != nil || node == nil {
 		l.Err = fmt.Sprintf("%s '%s'.\n", noMonitoring, a.Config.ClientName)
-		return nil
+		return l, nil
 	}
 
 	if len(node.Services) == 0 {
 		l.Err = fmt.Sprint("No services under monitoring.\n")
-		return nil
+		return l, nil
 	}
 
 	// Get service data
-	svcTable := a.getSVCTable(node)
+	svcTable := a.getSVCTable(ctx, node)
 	sort.Sort(sortOutput(svcTable))
 	l.Services = svcTable
 
-	return nil
+	return l, nil
 }
 
-func (a *Admin) getSVCTable(node *consul.CatalogNode) []ServiceStatus {
+func (a *Admin) getSVCTable(ctx context.Context, node *RegistryNode) []ServiceStatus {
+	// Health checks are optional, services are listed without them if the registry does not return any.
+	health, _ := a.healthChecks(ctx)
+
 	// Parse all services except mysql:queries.
-	var queryServices []*consul.AgentService
+	var queryServices []*RegistryService
 	var svcTable []ServiceStatus
 	for _, svc := range node.Services {
 		// When server hostname == client name, we have to exclude consul.
@@ -227,31 +278,29 @@ func (a *Admin) getSVCTable(node *consul.CatalogNode) []ServiceStatus {
 			continue
 		}
 
-		status := getServiceStatus(fmt.Sprintf("pmm-%s-%d", strings.Replace(svc.Service, ":", "-", 1), svc.Port))
+		status := a.getServiceStatus(fmt.Sprintf("pmm-%s-%d", strings.Replace(svc.Service, ":", "-", 1), svc.Port))
 
 		opts := []string{}
 		name := "-"
 		dsn := "-"
-		// Get values for service from Consul KV.
-		prefix := fmt.Sprintf("%s/%s/", a.Config.ClientName, svc.ID)
-		if data, _, err := a.consulAPI.KV().List(prefix, nil); err == nil {
-			for _, kvp := range data {
-				key := kvp.Key[len(prefix):]
+		// Get values for service from its metadata.
+		if meta, err := a.registry().ListServiceMeta(ctx, a.Config.ClientName, svc.ID, ""); err == nil {
+			for _, key := range sortedKeys(meta) {
 				switch key {
-				case "dsn":
-					dsn = string(kvp.Value)
+				case metaDSN:
+					dsn = meta[key]
 				default:
-					opts = append(opts, fmt.Sprintf("%s=%s", key, kvp.Value))
+					opts = append(opts, fmt.Sprintf("%s=%s", key, meta[key]))
 				}
 			}
 		}
-		// Parse Consul service tags.
+		// Parse service tags.
 		for _, tag := range svc.Tags {
 			if strings.HasPrefix(tag, "alias_") {
 				name = tag[6:]
 				continue
 			}
-			if tag == "scheme_https" {
+			if tag == "scheme_https" || strings.HasPrefix(tag, labelTagPrefix) {
 				continue
 			}
 			tag := strings.Replace(tag, "_", "=", 1)
@@ -263,17 +312,19 @@ func (a *Admin) getSVCTable(node *consul.CatalogNode) []ServiceStatus {
 			Name:    name,
 			Port:    fmt.Sprintf("%d", svc.Port),
 			Running: status,
+			Health:  health[svc.ID],
 			DSN:     dsn,
 			Options: strings.Join(opts, ", "),
+			Labels:  FormatLabels(labelsFromTags(svc.Tags)),
 		}
 		svcTable = append(svcTable, row)
 	}
 
 	// Parse queries service.
 	for _, queryService := range queryServices {
-		status := getServiceStatus(fmt.Sprintf("pmm-%s-%d", strings.Replace(queryService.Service, ":", "-", 1), queryService.Port))
+		status := a.getServiceStatus(fmt.Sprintf("pmm-%s-%d", strings.Replace(queryService.Service, ":", "-", 1), queryService.Port))
 
-		// Get names from Consul tags.
+		// Get names from service tags.
 		names := []string{}
 		for _, tag := range queryService.Tags {
 			if strings.HasPrefix(tag, "alias_") {
@@ -284,16 +335,14 @@ func (a *Admin) getSVCTable(node *consul.CatalogNode) []ServiceStatus {
 		for _, name := range names {
 			dsn := "-"
 			opts := []string{}
-			// Get values for service from Consul KV.
-			prefix := fmt.Sprintf("%s/%s/%s/", a.Config.ClientName, queryService.ID, name)
-			if data, _, err := a.consulAPI.KV().List(prefix, nil); err == nil {
-				for _, kvp := range data {
-					key := kvp.Key[len(prefix):]
+			// Get values for instance from service metadata.
+			if meta, err := a.registry().ListServiceMeta(ctx, a.Config.ClientName, queryService.ID, instanceMetaPrefix(name)); err == nil {
+				for _, key := range sortedKeys(meta) {
 					switch key {
-					case "dsn":
-						dsn = string(kvp.Value)
-					case "qan_mysql_uuid", "qan_mongodb_uuid":
-						f := fmt.Sprintf("%s/config/qan-%s.conf", AgentBaseDir, kvp.Value)
+					case metaDSN:
+						dsn = meta[key]
+					case metaQANMySQLUUID, metaQANMongoDBUUID:
+						f := fmt.Sprintf("%s/config/qan-%s.conf", a.paths().AgentBaseDir, meta[key])
 						querySource, _ := getQuerySource(f)
 						if querySource != "" {
 							opts = append(opts, fmt.Sprintf("query_source=%s", querySource))
@@ -308,8 +357,10 @@ func (a *Admin) getSVCTable(node *consul.CatalogNode) []ServiceStatus {
 				Name:    name,
 				Port:    "-",
 				Running: status,
+				Health:  health[queryService.ID],
 				DSN:     dsn,
 				Options: strings.Join(opts, ", "),
+				Labels:  FormatLabels(labelsFromTags(queryService.Tags)),
 			}
 			svcTable = append(svcTable, row)
 		}
@@ -0,0 +1,180 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+const (
+	// consulLockKey is KV key under the client prefix holding the lock of the client node.
+	consulLockKey = ".lock"
+	// consulLockTTL is TTL of Consul session holding the lock, the lock of crashed pmm-admin expires with it.
+	consulLockTTL = "15s"
+
+	lockRemediation = "Wait for it to finish or use --wait flag to wait for the lock."
+)
+
+// lockPollInterval is how often the lock held by another pmm-admin is retried.
+var lockPollInterval = 500 * time.Millisecond
+
+// nodeLock is the lock of the client node held with Consul session.
+type nodeLock struct {
+	client  *consul.Client
+	session string
+	done    chan struct{}
+}
+
+// lockHolder describes this pmm-admin running the command for the one waiting for the lock.
+func lockHolder(command string) string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("'%s' with pid %d on %s since %s", command, os.Getpid(), hostname, time.Now().Format(time.RFC3339))
+}
+
+// waitLock calls try until it takes the lock, fails or wait expires.
+func waitLock(ctx context.Context, wait time.Duration, try func() (bool, error)) (bool, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		locked, err := try()
+		if locked || err != nil {
+			return locked, err
+		}
+		d := time.Until(deadline)
+		if d <= 0 {
+			return false, nil
+		}
+		if d > lockPollInterval {
+			d = lockPollInterval
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(d):
+		}
+	}
+}
+
+// Lock takes the local lock of mutating commands, so parallel pmm-admin invocations don't race on
+// the config, ports and services, waiting up to wait for it. The command describes the holder to others.
+func (a *Admin) Lock(ctx context.Context, command string, wait time.Duration) error {
+	if a.lockFile != nil {
+		return nil
+	}
+
+	file := a.paths().LockFile
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("Unable to open lock file %s: %w", file, err)
+	}
+	locked, err := waitLock(ctx, wait, func() (bool, error) {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == syscall.EWOULDBLOCK {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	if err != nil || !locked {
+		holder, _ := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("Unable to lock file %s: %w", file, err)
+		}
+		return newError(KindLocked, lockRemediation, "Another pmm-admin is running on this system: %s.", lockHolderOrUnknown(holder))
+	}
+
+	// The holder is only informational, so failing to write it is not an error.
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(lockHolder(command)), 0)
+	}
+	a.lockFile = f
+	return nil
+}
+
+// LockNode takes the lock of the client node on PMM server, so operations on the services of this client
+// are serialized even if they run from different systems or tools, waiting up to wait for it.
+// Only Consul registry supports it, it is no-op with others.
+func (a *Admin) LockNode(ctx context.Context, command string, wait time.Duration) error {
+	if a.nodeLock != nil || a.registry().Name() != "consul" {
+		return nil
+	}
+
+	key := consulNodePrefix(a.Config.ClientName) + consulLockKey
+	// The lock key is deleted with the session, so the lock of crashed pmm-admin does not outlive it.
+	session, _, err := a.consulAPI.Session().Create(&consul.SessionEntry{
+		Name:     fmt.Sprintf("pmm-admin %s", a.Config.ClientName),
+		TTL:      consulLockTTL,
+		Behavior: consul.SessionBehaviorDelete,
+	}, consulWrite(ctx))
+	if err != nil {
+		return consulError(err)
+	}
+	pair := &consul.KVPair{Key: key, Value: []byte(lockHolder(command)), Session: session}
+	locked, err := waitLock(ctx, wait, func() (bool, error) {
+		locked, _, err := a.consulAPI.KV().Acquire(pair, consulWrite(ctx))
+		if err != nil {
+			return false, consulError(err)
+		}
+		return locked, nil
+	})
+	if err != nil || !locked {
+		a.consulAPI.Session().Destroy(session, consulWrite(context.Background()))
+		if err != nil {
+			return err
+		}
+		var holder []byte
+		if kvp, _, err := a.consulAPI.KV().Get(key, consulQuery(ctx)); err == nil && kvp != nil {
+			holder = kvp.Value
+		}
+		return newError(KindLocked, lockRemediation, "Client %s is locked by another pmm-admin: %s.", a.Config.ClientName, lockHolderOrUnknown(holder))
+	}
+
+	done := make(chan struct{})
+	go a.consulAPI.Session().RenewPeriodic(consulLockTTL, session, consulWrite(context.Background()), done)
+	a.nodeLock = &nodeLock{client: a.consulAPI, session: session, done: done}
+	return nil
+}
+
+// Unlock releases the locks taken by Lock and LockNode.
+func (a *Admin) Unlock() {
+	if l := a.nodeLock; l != nil {
+		// Destroying the session deletes the lock key.
+		l.client.Session().Destroy(l.session, consulWrite(context.Background()))
+		close(l.done)
+		a.nodeLock = nil
+	}
+	if a.lockFile != nil {
+		a.lockFile.Close()
+		a.lockFile = nil
+	}
+}
+
+// lockHolderOrUnknown returns the description of the lock holder.
+func lockHolderOrUnknown(holder []byte) string {
+	if s := strings.TrimSpace(string(holder)); s != "" {
+		return s
+	}
+	return "unknown"
+}
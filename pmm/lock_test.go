@@ -0,0 +1,84 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/percona/pmm-client/test/fakeserver"
+)
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "pmm-client")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	lockPollInterval = 10 * time.Millisecond
+	defer func() { lockPollInterval = 500 * time.Millisecond }()
+
+	a1 := New(Options{Paths: Paths{BaseDir: dir}})
+	a2 := New(Options{Paths: Paths{BaseDir: dir}})
+	assert.Nil(t, a1.Lock(ctx, "pmm-admin add mysql", 0))
+	assert.Nil(t, a1.Lock(ctx, "pmm-admin add mysql", 0))
+
+	err = a2.Lock(ctx, "pmm-admin remove mysql", 50*time.Millisecond)
+	assert.True(t, IsKind(err, KindLocked), "%s", err)
+	assert.Contains(t, err.Error(), "Another pmm-admin is running on this system: 'pmm-admin add mysql' with pid")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		a1.Unlock()
+	}()
+	assert.Nil(t, a2.Lock(ctx, "pmm-admin remove mysql", 5*time.Second))
+	a2.Unlock()
+}
+
+func TestFakeServerLockNode(t *testing.T) {
+	ctx := context.Background()
+	srv := fakeserver.New()
+	defer srv.Close()
+
+	newAdmin := func() *Admin {
+		a := New(Options{})
+		a.Config = &Config{ServerAddress: srv.Address(), ClientName: "db01", ClientAddress: "127.0.0.1", BindAddress: "127.0.0.1"}
+		assert.Nil(t, a.SetAPI(ctx))
+		return a
+	}
+	a1, a2 := newAdmin(), newAdmin()
+
+	assert.Nil(t, a1.LockNode(ctx, "pmm-admin add mysql", 0))
+	assert.Len(t, srv.Locks(), 1)
+	assert.Contains(t, srv.KV()["db01/.lock"], "'pmm-admin add mysql'")
+
+	err := a2.LockNode(ctx, "pmm-admin repair", 0)
+	assert.True(t, IsKind(err, KindLocked), "%s", err)
+	assert.Contains(t, err.Error(), "Client db01 is locked by another pmm-admin: 'pmm-admin add mysql' with pid")
+
+	// The lock is deleted with the session.
+	a1.Unlock()
+	assert.Empty(t, srv.Locks())
+	assert.NotContains(t, srv.KV(), "db01/.lock")
+	assert.Nil(t, a2.LockNode(ctx, "pmm-admin repair", 0))
+	a2.Unlock()
+}
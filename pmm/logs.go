@@ -0,0 +1,397 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogOptions defines which log lines to show.
+type LogOptions struct {
+	Follow bool          // keep printing new lines until canceled
+	Since  time.Duration // show lines not older than that, all if zero
+	Lines  int           // show that many last lines, all if zero
+}
+
+// logSource is a log of a single program, either a file or a journald unit.
+type logSource struct {
+	Name string // name to prefix merged lines with
+	File string
+	Unit string
+}
+
+// logLine is a single log line with the time parsed from it or inherited from the previous line.
+type logLine struct {
+	Time   time.Time
+	Source string
+	Text   string
+}
+
+// logTimeLayout is the layout of time prefixing merged log lines.
+const logTimeLayout = "2006-01-02T15:04:05"
+
+// logTimeFormats are timestamp formats written by exporters, qan-agent and journalctl -o short-iso.
+var logTimeFormats = []struct {
+	prefix string // layout of the timestamp at the line start, or after `time="` for logrus lines
+	logrus bool
+}{
+	{time.RFC3339Nano, true},
+	{"2006-01-02T15:04:05-0700", false},
+	{time.RFC3339Nano, false},
+	{"2006/01/02 15:04:05.000000", false},
+	{"2006/01/02 15:04:05", false},
+	{"2006-01-02 15:04:05", false},
+}
+
+// Logs print logs of monitoring service by its type and name.
+// Logs of qan-agent itself are printed along with query services.
+func (a *Admin) Logs(ctx context.Context, svcType string, opts LogOptions, w io.Writer) error {
+	if err := isValidSvcType(svcType); err != nil {
+		return err
+	}
+
+	// Check if we have this service registered.
+	registered, err := a.getRegisteredService(ctx, svcType, a.ServiceName)
+	if err != nil {
+		return err
+	}
+	if registered == nil {
+		return ErrNoService
+	}
+
+	svcName := fmt.Sprintf("pmm-%s-%d", strings.Replace(svcType, ":", "-", 1), registered.Port)
+	src, err := a.serviceLogSource(svcName)
+	if err != nil {
+		return err
+	}
+	sources := []logSource{src}
+	if strings.HasSuffix(svcType, ":queries") {
+		sources = append(sources, a.qanAgentLogSources()...)
+	}
+	return printLogs(ctx, sources, opts, w, len(sources) > 1)
+}
+
+// AllLogs print logs of all local monitoring services and qan-agent merged by time.
+// It works without PMM server as services are found locally.
+func (a *Admin) AllLogs(ctx context.Context, opts LogOptions, w io.Writer) error {
+	var sources []logSource
+	for _, svcName := range a.GetLocalServices() {
+		src, err := a.serviceLogSource(svcName)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, src)
+	}
+	sources = append(sources, a.qanAgentLogSources()...)
+	if len(sources) == 0 {
+		return ErrNoService
+	}
+	return printLogs(ctx, sources, opts, w, true)
+}
+
+// serviceLogSource returns log source of system service depending on service manager.
+// Services installed by pmm-admin append their output to /var/log/<name>.log, while
+// systemd units created by the other tools may log to journald only.
+func (a *Admin) serviceLogSource(svcName string) (logSource, error) {
+	src := logSource{
+		Name: svcName,
+		File: serviceLogFile(svcName),
+	}
+	platform := a.serviceManager().Name()
+	switch platform {
+	case "linux-systemd":
+		if !FileExists(src.File) {
+			src.File = ""
+			src.Unit = svcName
+		}
+	case "linux-upstart":
+		// Upstart keeps console output in its own dir if job does not redirect it.
+		if upstartFile := fmt.Sprintf("%s/var/log/upstart/%s.log", RootDir, svcName); !FileExists(src.File) && FileExists(upstartFile) {
+			src.File = upstartFile
+		}
+	case "unix-systemv", "openrc", "runit", "s6", "supervisord":
+	default:
+		return src, fmt.Errorf("Reading logs is not supported for %s service manager.", platform)
+	}
+	return src, nil
+}
+
+// qanAgentLogSources returns log files qan-agent writes on its own under AgentBaseDir.
+func (a *Admin) qanAgentLogSources() []logSource {
+	var files []string
+	if data, err := ioutil.ReadFile(a.paths().AgentBaseDir + "/config/log.conf"); err == nil {
+		logConfig := struct{ File string }{}
+		if json.Unmarshal(data, &logConfig) == nil && logConfig.File != "" {
+			if !filepath.IsAbs(logConfig.File) {
+				logConfig.File = filepath.Join(a.paths().AgentBaseDir, logConfig.File)
+			}
+			files = append(files, logConfig.File)
+		}
+	}
+	found, _ := filepath.Glob(a.paths().AgentBaseDir + "/*.log")
+	for _, f := range found {
+		files = appendUnique(files, f)
+	}
+
+	var sources []logSource
+	for _, f := range files {
+		if FileExists(f) {
+			sources = append(sources, logSource{Name: "qan-agent", File: f})
+		}
+	}
+	return sources
+}
+
+// printLogs print last lines of log sources and follow them if requested.
+// Merged lines are ordered by time and prefixed with it and the source name.
+func printLogs(ctx context.Context, sources []logSource, opts LogOptions, w io.Writer, merge bool) error {
+	var since time.Time
+	if opts.Since > 0 {
+		since = time.Now().Add(-opts.Since)
+	}
+
+	var lines []logLine
+	for _, src := range sources {
+		l, err := readLog(ctx, src, since, opts.Lines)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, l...)
+	}
+	if merge {
+		sort.SliceStable(lines, func(i, j int) bool { return lines[i].Time.Before(lines[j].Time) })
+	}
+	if opts.Lines > 0 && len(lines) > opts.Lines {
+		lines = lines[len(lines)-opts.Lines:]
+	}
+	for _, line := range lines {
+		writeLogLine(w, line, merge)
+	}
+
+	if !opts.Follow {
+		return nil
+	}
+
+	// New lines are printed in the order they arrive.
+	var mu sync.Mutex
+	errCh := make(chan error, len(sources))
+	for _, src := range sources {
+		go func(src logSource) {
+			errCh <- followLog(ctx, src, func(line logLine) {
+				mu.Lock()
+				defer mu.Unlock()
+				writeLogLine(w, line, merge)
+			})
+		}(src)
+	}
+	var errs Errors
+	for range sources {
+		if err := <-errCh; err != nil && ctx.Err() == nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// writeLogLine write log line as is, or prefixed with time and source if merged.
+func writeLogLine(w io.Writer, line logLine, merge bool) {
+	if !merge {
+		fmt.Fprintln(w, line.Text)
+		return
+	}
+	ts := strings.Repeat(" ", len(logTimeLayout))
+	if !line.Time.IsZero() {
+		ts = line.Time.Format(logTimeLayout)
+	}
+	fmt.Fprintf(w, "%s %s: %s\n", ts, line.Source, line.Text)
+}
+
+// readLog read log lines not older than since, only the last n of them if n is positive.
+func readLog(ctx context.Context, src logSource, since time.Time, n int) ([]logLine, error) {
+	var r io.Reader
+	if src.Unit != "" {
+		args := []string{"-u", src.Unit, "-o", "short-iso", "--no-pager"}
+		if !since.IsZero() {
+			args = append(args, "--since", since.Format("2006-01-02 15:04:05"))
+		}
+		if n > 0 {
+			args = append(args, "-n", strconv.Itoa(n))
+		}
+		output, err := exec.CommandContext(ctx, "journalctl", args...).Output()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read journald logs of %s: %w", src.Unit, err)
+		}
+		r = strings.NewReader(string(output))
+	} else {
+		f, err := os.Open(src.File)
+		if os.IsNotExist(err) {
+			// Service has not written anything yet.
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	// The last n lines are kept in a ring buffer, so big files are not loaded as a whole.
+	var lines []logLine
+	var next int
+	var last time.Time
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := logLine{Time: last, Source: src.Name, Text: scanner.Text()}
+		if t, ok := parseLogTime(line.Text); ok {
+			line.Time, last = t, t
+		}
+		// Lines without own timestamp inherit the previous one, so multi-line records are skipped as a whole.
+		if !since.IsZero() && !line.Time.IsZero() && line.Time.Before(since) {
+			continue
+		}
+		if n > 0 && len(lines) == n {
+			lines[next] = line
+			next = (next + 1) % n
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return append(append([]logLine{}, lines[next:]...), lines[:next]...), nil
+}
+
+// followLog call fn for every new log line until ctx is done.
+func followLog(ctx context.Context, src logSource, fn func(logLine)) error {
+	if src.Unit != "" {
+		cmd := exec.CommandContext(ctx, "journalctl", "-u", src.Unit, "-o", "short-iso", "--no-pager", "-f", "-n", "0")
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("Unable to follow journald logs of %s: %w", src.Unit, err)
+		}
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			fn(newLogLine(src.Name, scanner.Text()))
+		}
+		return cmd.Wait()
+	}
+
+	// Poll the file for new data, start over if it was truncated or recreated.
+	var offset int64
+	if fi, err := os.Stat(src.File); err == nil {
+		offset = fi.Size()
+	}
+	var partial string
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		fi, err := os.Stat(src.File)
+		if err != nil || fi.Size() == offset {
+			continue
+		}
+		if fi.Size() < offset {
+			offset, partial = 0, ""
+		}
+		f, err := os.Open(src.File)
+		if err != nil {
+			continue
+		}
+		f.Seek(offset, io.SeekStart)
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		offset += int64(len(data))
+
+		chunks := strings.Split(partial+string(data), "\n")
+		partial = chunks[len(chunks)-1]
+		for _, text := range chunks[:len(chunks)-1] {
+			fn(newLogLine(src.Name, text))
+		}
+	}
+}
+
+// newLogLine returns followed log line, the time defaults to now.
+func newLogLine(source, text string) logLine {
+	t, ok := parseLogTime(text)
+	if !ok {
+		t = time.Now()
+	}
+	return logLine{Time: t, Source: source, Text: text}
+}
+
+// parseLogTime parse timestamp at the start of log line, or of logrus time field.
+func parseLogTime(line string) (time.Time, bool) {
+	for _, f := range logTimeFormats {
+		s := line
+		if f.logrus {
+			i := strings.Index(line, `time="`)
+			if i == -1 {
+				continue
+			}
+			s = line[i+6:]
+			if j := strings.Index(s, `"`); j != -1 {
+				s = s[:j]
+			}
+		} else {
+			if len(s) < len(f.prefix) {
+				continue
+			}
+			// RFC3339Nano has variable length, so cut at the first space.
+			if f.prefix == time.RFC3339Nano {
+				if i := strings.IndexByte(s, ' '); i != -1 {
+					s = s[:i]
+				}
+			} else {
+				s = s[:len(f.prefix)]
+			}
+		}
+		if t, err := time.ParseInLocation(f.prefix, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,109 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLogTime(t *testing.T) {
+	utc := time.Date(2017, 9, 12, 7, 20, 30, 0, time.UTC)
+	local := time.Date(2017, 9, 12, 10, 20, 30, 0, time.Local)
+
+	lines := map[string]time.Time{
+		`time="2017-09-12T10:20:30+03:00" level=info msg="Listening on :42000"`: utc,
+		"2017-09-12T10:20:30+0300 db01 pmm-linux-metrics-42000[123]: started":   utc,
+		"2017/09/12 10:20:30.000000 main.go:164: Starting agent...":             local,
+		"2017/09/12 10:20:30 WARNING: data-spool is full":                       local,
+		"2017-09-12 10:20:30 mongodb_exporter started":                          local,
+	}
+	for line, expected := range lines {
+		ts, ok := parseLogTime(line)
+		assert.True(t, ok, line)
+		assert.True(t, ts.Equal(expected), line)
+	}
+
+	_, ok := parseLogTime("panic: runtime error")
+	assert.False(t, ok)
+}
+
+func TestPrintLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmm-logs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file1 := filepath.Join(dir, "pmm-linux-metrics-42000.log")
+	ioutil.WriteFile(file1, []byte("2017/09/12 10:00:00 first\n2017/09/12 10:00:02 third\n"), 0600)
+	file2 := filepath.Join(dir, "qan-agent.log")
+	ioutil.WriteFile(file2, []byte("2017/09/12 10:00:01 second\ncontinuation\n"), 0600)
+	sources := []logSource{
+		{Name: "pmm-linux-metrics-42000", File: file1},
+		{Name: "qan-agent", File: file2},
+	}
+
+	buf := &bytes.Buffer{}
+	err = printLogs(context.Background(), sources[:1], LogOptions{Lines: 1}, buf, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "2017/09/12 10:00:02 third\n", buf.String())
+
+	buf.Reset()
+	err = printLogs(context.Background(), sources, LogOptions{}, buf, true)
+	assert.Nil(t, err)
+	expected := `2017-09-12T10:00:00 pmm-linux-metrics-42000: 2017/09/12 10:00:00 first
+2017-09-12T10:00:01 qan-agent: 2017/09/12 10:00:01 second
+2017-09-12T10:00:01 qan-agent: continuation
+2017-09-12T10:00:02 pmm-linux-metrics-42000: 2017/09/12 10:00:02 third
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestReadLogLastLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmm-logs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "pmm-linux-metrics-42000.log")
+	var data string
+	for i := 0; i < 10; i++ {
+		data += fmt.Sprintf("2017/09/12 10:00:0%d line %d\n", i, i)
+	}
+	ioutil.WriteFile(file, []byte(data+"continuation\n"), 0600)
+	src := logSource{Name: "pmm-linux-metrics-42000", File: file}
+
+	lines, err := readLog(context.Background(), src, time.Time{}, 3)
+	assert.Nil(t, err)
+	if assert.Len(t, lines, 3) {
+		assert.Equal(t, "2017/09/12 10:00:08 line 8", lines[0].Text)
+		assert.Equal(t, "2017/09/12 10:00:09 line 9", lines[1].Text)
+		assert.Equal(t, "continuation", lines[2].Text)
+		assert.Equal(t, lines[1].Time, lines[2].Time)
+	}
+
+	lines, err = readLog(context.Background(), src, time.Time{}, 0)
+	assert.Nil(t, err)
+	assert.Len(t, lines, 11)
+}
@@ -18,22 +18,17 @@
 package pmm
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
+	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
-	"crypto/x509/pkix"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"fmt"
-	"math/big"
-	"net"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
-	"regexp"
 	"runtime"
 	"strings"
 	"time"
@@ -49,50 +44,88 @@ import (
 
 // Admin main class.
 type Admin struct {
-	ServiceName  string
-	ServicePort  int
-	Args         []string // Args defines additional arguments to pass through to *_exporter or qan-agent
-	Config       *Config
-	Verbose      bool
-	Format       string
-	serverURL    string
-	apiTimeout   time.Duration
-	qanAPI       *API
-	consulAPI    *consul.Client
-	promQueryAPI prometheus.QueryAPI
-	managedAPI   *managed.Client
+	ServiceName    string
+	ServicePort    int
+	Args           []string // Args defines additional arguments to pass through to *_exporter or qan-agent
+	Config         *Config
+	Verbose        bool
+	Format         string
+	Timeout        time.Duration     // Timeout for PMM server API requests
+	Backoff        Backoff           // Backoff for retrying idempotent PMM server API requests, DefaultBackoff if zero, NoRetry disables retries
+	ServerProfile  string            // server profile from the config to use instead of the default server
+	Paths          Paths             // files and dirs, the package variables by default
+	Transport      http.RoundTripper // transport to PMM server, made of the config settings if nil
+	NewService     ServiceFactory    // backend of native service manager, NewService if nil
+	ServiceManager ServiceManager    // service manager, the one of the config or detected if nil
+	Limits         ServiceLimits     // resource limits of services to add
+	Labels         map[string]string // labels of services to add on top of node-wide ones
+	Registry       Registry          // service registry, the one of the config if nil
+	serverURL      string
+	qanAPI         *API
+	exporterAPI    *API
+	consulAPI      *consul.Client
+	promQueryAPI   prometheus.QueryAPI
+	managedAPI     *managed.Client
+	defaultServer  ServerProfile // default server settings while server profile is used
+	lockFile       *os.File      // held local lock
+	nodeLock       *nodeLock     // held lock of the client node
 	//promSeriesAPI prometheus.SeriesAPI
 }
 
 // SetAPI setups QAN, Consul, Prometheus, pmm-managed clients and verifies connections.
-func (a *Admin) SetAPI() error {
-	// Set default API timeout if unset.
-	if a.apiTimeout == 0 {
-		a.apiTimeout = apiTimeout
+func (a *Admin) SetAPI(ctx context.Context) error {
+	// Set default API timeout and backoff if unset.
+	if a.Timeout == 0 {
+		a.Timeout = APITimeout
+	}
+	if a.Backoff == (Backoff{}) {
+		a.Backoff = DefaultBackoff
 	}
 
 	scheme := "http"
 	helpText := ""
-	insecureTransport := &http.Transport{}
 	if a.Config.ServerInsecureSSL {
 		scheme = "https"
-		insecureTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 		helpText = "--server-insecure-ssl"
 	}
 	if a.Config.ServerSSL {
 		scheme = "https"
 		helpText = "--server-ssl"
 	}
+	tlsConfig, err := a.serverTLSConfig()
+	if err != nil {
+		return err
+	}
+	proxy, err := a.proxyFunc()
+	if err != nil {
+		return err
+	}
 
-	// QAN API.
-	a.qanAPI = NewAPI(a.Config.ServerInsecureSSL, a.apiTimeout, a.Verbose)
-	httpClient := a.qanAPI.NewClient()
+	// All the clients share one transport to keep connections to PMM server alive between requests.
+	transport := a.Transport
+	if transport == nil {
+		transport = NewTransport(tlsConfig, proxy, a.Timeout, a.Verbose)
+	}
+
+	// QAN API retries requests itself, Consul and Prometheus clients do it via the transport.
+	a.qanAPI = NewAPI(transport, a.Timeout, a.Backoff)
+	retrying := newRetryTransport(transport, a.Backoff)
+
+	// Local exporters use self-signed certificate.
+	exporterTransport := NewTransport(&tls.Config{InsecureSkipVerify: true}, nil, a.Timeout, a.Verbose)
+	a.exporterAPI = NewAPI(exporterTransport, a.Timeout, NoRetry)
 
 	// Consul API.
+	// Without the token in the config, Consul API client takes it from CONSUL_HTTP_TOKEN environment variable.
+	token, err := a.consulToken()
+	if err != nil {
+		return err
+	}
 	config := consul.Config{
 		Address:    a.Config.ServerAddress,
-		HttpClient: httpClient,
+		HttpClient: &http.Client{Timeout: a.Timeout, Transport: retrying},
 		Scheme:     scheme,
+		Token:      token,
 	}
 	var authStr string
 	if a.Config.ServerUser != "" {
@@ -108,62 +141,55 @@ func (a *Admin) SetAPI() error {
 	a.serverURL = fmt.Sprintf("%s://%s%s", scheme, authStr, a.Config.ServerAddress)
 
 	// Prometheus API.
-	cfg := prometheus.Config{Address: fmt.Sprintf("%s/prometheus", a.serverURL)}
-	// cfg.Transport = httpClient.Transport
-	// above should be used instead below but
-	// https://github.com/prometheus/client_golang/issues/292
-	if a.Config.ServerInsecureSSL {
-		cfg.Transport = insecureTransport
-	}
-	client, _ := prometheus.New(cfg)
+	// Its client requires transport to be cancelable, see https://github.com/prometheus/client_golang/issues/292
+	client, _ := prometheus.New(prometheus.Config{
+		Address:   fmt.Sprintf("%s/prometheus", a.serverURL),
+		Transport: retrying,
+	})
 	a.promQueryAPI = prometheus.NewQueryAPI(client)
 	//a.promSeriesAPI = prometheus.NewSeriesAPI(client)
 
 	// Check if server is alive.
 	qanURL := a.qanAPI.URL(a.serverURL)
-	resp, _, err := a.qanAPI.Get(qanURL)
+	resp, _, err := a.qanAPI.Get(ctx, qanURL)
 	if err != nil {
 		if strings.Contains(err.Error(), "x509: cannot validate certificate") {
-			return fmt.Errorf(`Unable to connect to PMM server by address: %s
-
-Looks like PMM server running with self-signed SSL certificate.
-Run 'pmm-admin config --server-insecure-ssl' to enable such configuration.`, a.Config.ServerAddress)
+			e := newError(KindConnectivity, `Looks like PMM server running with self-signed SSL certificate.
+Run 'pmm-admin config --server-insecure-ssl' to enable such configuration
+or 'pmm-admin config --server-ca-file' to trust the CA it is signed with.`, "Unable to connect to PMM server by address: %s", a.Config.ServerAddress)
+			e.Err = err
+			return e
 		}
-		return fmt.Errorf(`Unable to connect to PMM server by address: %s
-%s
-
-* Check if the configured address is correct.
+		e := newError(KindConnectivity, `* Check if the configured address is correct.
 * If server is running on non-default port, ensure it was specified along with the address.
 * If server is enabled for SSL or self-signed SSL, enable the corresponding option.
-* You may also check the firewall settings.`, a.Config.ServerAddress, err)
+* You may also check the firewall settings.`, "Unable to connect to PMM server by address: %s\n%s", a.Config.ServerAddress, err)
+		e.Err = err
+		return e
 	}
 
 	// Try to detect 400 (SSL) and 401 (HTTP auth).
 	if resp.StatusCode == http.StatusBadRequest {
-		return fmt.Errorf(`Unable to connect to PMM server by address: %s
-
-Looks like the server is enabled for SSL or self-signed SSL.
-Use 'pmm-admin config' to enable the corresponding SSL option.`, a.Config.ServerAddress)
+		return newError(KindConnectivity, `Looks like the server is enabled for SSL or self-signed SSL.
+Use 'pmm-admin config' to enable the corresponding SSL option.`, "Unable to connect to PMM server by address: %s", a.Config.ServerAddress)
 	}
 	if resp.StatusCode == http.StatusUnauthorized {
-		return fmt.Errorf(`Unable to connect to PMM server by address: %s
-
-Looks like the server is password protected.
-Use 'pmm-admin config' to define server user and password.`, a.Config.ServerAddress)
+		return newError(KindPermission, `Looks like the server is password protected.
+Use 'pmm-admin config' to define server user and password.`, "Unable to connect to PMM server by address: %s", a.Config.ServerAddress)
 	}
 
 	// Check Consul status.
-	if leader, err := a.consulAPI.Status().Leader(); err != nil || leader == "" {
-		return fmt.Errorf(`Unable to connect to PMM server by address: %s
-
-Even though the server is reachable it does not look to be PMM server.
-Check if the configured address is correct. %s`, a.Config.ServerAddress, err)
+	// Status().Leader() can't be canceled, so the endpoint is queried directly.
+	var leader string
+	if _, err := a.consulAPI.Raw().Query("/v1/status/leader", &leader, consulQuery(ctx)); err != nil || leader == "" {
+		return newError(KindConnectivity, fmt.Sprintf(`Even though the server is reachable it does not look to be PMM server.
+Check if the configured address is correct. %s`, err), "Unable to connect to PMM server by address: %s", a.Config.ServerAddress)
 	}
 
 	// Check if server is not password protected but client is configured so.
 	if a.Config.ServerUser != "" {
 		qanURL = fmt.Sprintf("%s://%s", scheme, a.Config.ServerAddress)
-		if resp, _, err := a.qanAPI.Get(qanURL); err == nil && resp.StatusCode == http.StatusOK {
+		if resp, _, err := a.qanAPI.Get(ctx, qanURL); err == nil && resp.StatusCode == http.StatusOK {
 			return fmt.Errorf(`This client is configured with HTTP basic authentication.
 However, PMM server is not.
 
@@ -178,19 +204,41 @@ pmm-admin config --server %s %s`, a.Config.ServerAddress, helpText)
 	if a.Config.ServerUser != "" {
 		user = url.UserPassword(a.Config.ServerUser, a.Config.ServerPassword)
 	}
-	a.managedAPI = managed.NewClient(a.Config.ServerAddress, scheme, user, a.Config.ServerInsecureSSL, a.Verbose)
+	a.managedAPI = managed.NewClient(a.Config.ServerAddress, scheme, user, transport)
 
 	return nil
 }
 
-// PrintInfo print PMM client info.
-func (a *Admin) PrintInfo() {
-	fmt.Printf("pmm-admin %s\n\n", Version)
-	a.ServerInfo()
-	fmt.Printf("%-15s | %s\n\n", "Service Manager", service.Platform())
+// Info is PMM client info.
+type Info struct {
+	Version   string
+	Server    ServerInfo
+	Platform  string // service manager
+	GoVersion string
+	GOOS      string
+	GOARCH    string
+}
+
+// Info returns PMM client info.
+func (a *Admin) Info() Info {
+	return Info{
+		Version:   Version,
+		Server:    a.ServerInfo(),
+		Platform:  a.serviceManager().Name(),
+		GoVersion: strings.Replace(runtime.Version(), "go", "", 1),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+	}
+}
 
-	fmt.Printf("%-15s | %s\n", "Go Version", strings.Replace(runtime.Version(), "go", "", 1))
-	fmt.Printf("%-15s | %s/%s\n\n", "Runtime Info", runtime.GOOS, runtime.GOARCH)
+// Format formats Info as text and returns result as string.
+func (i Info) Format() string {
+	out := fmt.Sprintf("pmm-admin %s\n\n", i.Version)
+	out += i.Server.Format()
+	out += fmt.Sprintf("%-15s | %s\n\n", "Service Manager", i.Platform)
+	out += fmt.Sprintf("%-15s | %s\n", "Go Version", i.GoVersion)
+	out += fmt.Sprintf("%-15s | %s/%s\n\n", "Runtime Info", i.GOOS, i.GOARCH)
+	return out
 }
 
 const (
@@ -210,27 +258,29 @@ type ServerInfo struct {
 	ClientBindAddress string
 }
 
-// ServerInfo print server info.
-func (a *Admin) ServerInfo() error {
-	serverInfo := a.serverInfo()
-
+// Format formats ServerInfo with ServerInfoTemplate and returns result as string.
+func (s ServerInfo) Format() string {
 	tmpl, err := templates.Parse(DefaultServerInfoTemplate)
 	if err != nil {
-		return err
+		return err.Error()
 	}
 	tmpl, err = tmpl.Parse(ServerInfoTemplate)
 	if err != nil {
-		return err
+		return err.Error()
 	}
-	if err := tmpl.Execute(os.Stdout, serverInfo); err != nil {
-		return err
+	b := &bytes.Buffer{}
+	if err := tmpl.Execute(b, s); err != nil {
+		return err.Error()
 	}
-
-	return nil
+	return b.String()
 }
 
-func (a *Admin) serverInfo() ServerInfo {
+// ServerInfo returns server and client addresses with the connection security.
+func (a *Admin) ServerInfo() ServerInfo {
 	var labels []string
+	if a.ServerProfile != "" {
+		labels = append(labels, "profile "+a.ServerProfile)
+	}
 	if a.Config.ServerInsecureSSL {
 		labels = append(labels, "insecure SSL")
 	} else if a.Config.ServerSSL {
@@ -239,6 +289,9 @@ func (a *Admin) serverInfo() ServerInfo {
 	if a.Config.ServerUser != "" {
 		labels = append(labels, "password-protected")
 	}
+	if a.Config.ServerProxy != "" {
+		labels = append(labels, "via proxy")
+	}
 	securityInfo := ""
 	if len(labels) > 0 {
 		securityInfo = fmt.Sprintf("(%s)", strings.Join(labels, ", "))
@@ -259,47 +312,49 @@ func (a *Admin) serverInfo() ServerInfo {
 }
 
 // StartStopMonitoring start/stop system service by its metric type and name.
-func (a *Admin) StartStopMonitoring(action, svcType string) (affected bool, err error) {
+func (a *Admin) StartStopMonitoring(ctx context.Context, action, svcType string) (affected bool, err error) {
 	err = isValidSvcType(svcType)
 	if err != nil {
 		return false, err
 	}
 
-	// Check if we have this service on Consul.
-	consulSvc, err := a.getConsulService(svcType, a.ServiceName)
+	// Check if we have this service registered.
+	registered, err := a.getRegisteredService(ctx, svcType, a.ServiceName)
 	if err != nil {
 		return false, err
 	}
-	if consulSvc == nil {
+	if registered == nil {
 		return false, ErrNoService
 	}
 
-	svcName := fmt.Sprintf("pmm-%s-%d", strings.Replace(svcType, ":", "-", 1), consulSvc.Port)
+	svcName := fmt.Sprintf("pmm-%s-%d", strings.Replace(svcType, ":", "-", 1), registered.Port)
 	switch action {
 	case "start":
-		if getServiceStatus(svcName) {
+		if a.getServiceStatus(svcName) {
 			// if it's already started then return
 			return false, nil
 		}
-		if err := startService(svcName); err != nil {
+		if err := a.startService(svcName); err != nil {
 			return false, err
 		}
 	case "stop":
-		if !getServiceStatus(svcName) {
+		if !a.getServiceStatus(svcName) {
 			// if it's already stopped then return
 			return false, nil
 		}
-		if err := stopService(svcName); err != nil {
+		if err := a.stopService(svcName); err != nil {
 			return false, err
 		}
 	case "restart":
-		if err := stopService(svcName); err != nil {
+		if err := a.stopService(svcName); err != nil {
 			return false, err
 		}
-		if err := startService(svcName); err != nil {
+		if err := a.startService(svcName); err != nil {
 			return false, err
 		}
 	}
+	// Failed update is not fatal as the check is updated on the next run of health checks anyway.
+	a.updateHealthCheck(ctx, registered)
 
 	return true, nil
 }
@@ -308,35 +363,35 @@ func (a *Admin) StartStopMonitoring(action, svcType string) (affected bool, err
 func (a *Admin) StartStopAllMonitoring(action string) (numOfAffected, numOfAll int, err error) {
 	var errs Errors
 
-	localServices := GetLocalServices()
+	localServices := a.GetLocalServices()
 	numOfAll = len(localServices)
 
 	for _, svcName := range localServices {
 		switch action {
 		case "start":
-			if getServiceStatus(svcName) {
+			if a.getServiceStatus(svcName) {
 				// if it's already started then continue
 				continue
 			}
-			if err := startService(svcName); err != nil {
+			if err := a.startService(svcName); err != nil {
 				errs = append(errs, err)
 				continue
 			}
 		case "stop":
-			if !getServiceStatus(svcName) {
+			if !a.getServiceStatus(svcName) {
 				// if it's already stopped then continue
 				continue
 			}
-			if err := stopService(svcName); err != nil {
+			if err := a.stopService(svcName); err != nil {
 				errs = append(errs, err)
 				continue
 			}
 		case "restart":
-			if err := stopService(svcName); err != nil {
+			if err := a.stopService(svcName); err != nil {
 				errs = append(errs, err)
 				continue
 			}
-			if err := startService(svcName); err != nil {
+			if err := a.startService(svcName); err != nil {
 				errs = append(errs, err)
 				continue
 			}
@@ -352,8 +407,8 @@ func (a *Admin) StartStopAllMonitoring(action string) (numOfAffected, numOfAll i
 }
 
 // RemoveAllMonitoring remove all the monitoring services.
-func (a *Admin) RemoveAllMonitoring(ignoreErrors bool) (uint16, error) {
-	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
+func (a *Admin) RemoveAllMonitoring(ctx context.Context, ignoreErrors bool) (uint16, error) {
+	node, err := a.registry().ListNodeServices(ctx, a.Config.ClientName)
 	if err != nil || node == nil || len(node.Services) == 0 {
 		return 0, nil
 	}
@@ -367,27 +422,31 @@ func (a *Admin) RemoveAllMonitoring(ignoreErrors bool) (uint16, error) {
 			a.ServiceName = tag[6:]
 			switch svc.Service {
 			case "linux:metrics":
-				if err := a.RemoveLinuxMetrics(); err != nil && !ignoreErrors {
+				if err := a.RemoveLinuxMetrics(ctx); err != nil && !ignoreErrors {
 					return count, err
 				}
 			case "mysql:metrics":
-				if err := a.RemoveMySQLMetrics(); err != nil && !ignoreErrors {
+				if err := a.RemoveMySQLMetrics(ctx); err != nil && !ignoreErrors {
 					return count, err
 				}
 			case "mysql:queries":
-				if err := a.RemoveMySQLQueries(); err != nil && !ignoreErrors {
+				if err := a.RemoveMySQLQueries(ctx); err != nil && !ignoreErrors {
 					return count, err
 				}
 			case "mongodb:metrics":
-				if err := a.RemoveMongoDBMetrics(); err != nil && !ignoreErrors {
+				if err := a.RemoveMongoDBMetrics(ctx); err != nil && !ignoreErrors {
 					return count, err
 				}
 			case "mongodb:queries":
-				if err := a.RemoveMongoDBQueries(); err != nil && !ignoreErrors {
+				if err := a.RemoveMongoDBQueries(ctx); err != nil && !ignoreErrors {
 					return count, err
 				}
 			case "proxysql:metrics":
-				if err := a.RemoveProxySQLMetrics(); err != nil && !ignoreErrors {
+				if err := a.RemoveProxySQLMetrics(ctx); err != nil && !ignoreErrors {
+					return count, err
+				}
+			case "pmm-client:metrics":
+				if err := a.RemoveClientMetrics(ctx); err != nil && !ignoreErrors {
 					return count, err
 				}
 			}
@@ -403,7 +462,7 @@ func (a *Admin) RemoveAllMonitoring(ignoreErrors bool) (uint16, error) {
 }
 
 // PurgeMetrics purge metrics data on the server by its metric type and name.
-func (a *Admin) PurgeMetrics(svcType string) (uint, error) {
+func (a *Admin) PurgeMetrics(ctx context.Context, svcType string) (uint, error) {
 	if svcType != "linux:metrics" && svcType != "mysql:metrics" && svcType != "mongodb:metrics" && svcType != "proxysql:metrics" {
 		return 0, errors.New(`bad service type.
 
@@ -417,7 +476,7 @@ Service type takes the following values: linux:metrics, mysql:metrics, mongodb:m
 	//	return 0, err
 	//}
 	url := a.qanAPI.URL(a.serverURL, fmt.Sprintf("prometheus/api/v1/series?match[]=%s", match))
-	_, data, err := a.qanAPI.Delete(url)
+	_, data, err := a.qanAPI.Delete(ctx, url)
 	if err != nil {
 		return 0, err
 	}
@@ -428,123 +487,124 @@ Service type takes the following values: linux:metrics, mysql:metrics, mongodb:m
 	return count, nil
 }
 
-// getConsulService get service from Consul by service type and optionally name (alias).
-func (a *Admin) getConsulService(service, name string) (*consul.AgentService, error) {
-	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
-	if err != nil || node == nil {
+// serverTLSConfig returns TLS config to communicate with PMM server
+// using custom CA and client certificate if configured.
+func (a *Admin) serverTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: a.Config.ServerInsecureSSL}
+	if a.Config.ServerCAFile != "" {
+		data, err := ioutil.ReadFile(a.Config.ServerCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("No PEM encoded certificates found in CA file %s.", a.Config.ServerCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if a.Config.ServerClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(a.Config.ServerClientCert, a.Config.ServerClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// consulQuery returns Consul query options bound to ctx.
+func consulQuery(ctx context.Context) *consul.QueryOptions {
+	return (&consul.QueryOptions{}).WithContext(ctx)
+}
+
+// consulWrite returns Consul write options bound to ctx.
+func consulWrite(ctx context.Context) *consul.WriteOptions {
+	return (&consul.WriteOptions{}).WithContext(ctx)
+}
+
+// getRegisteredService get service of this client from the registry by service type and optionally name (alias).
+func (a *Admin) getRegisteredService(ctx context.Context, service, name string) (*RegistryService, error) {
+	node, err := a.registry().ListNodeServices(ctx, a.Config.ClientName)
+	if err != nil {
 		return nil, err
 	}
+	if node == nil {
+		return nil, nil
+	}
 	for _, svc := range node.Services {
 		if svc.Service != service {
 			continue
 		}
-		if name == "" {
+		if name == "" || svc.hasAlias(name) {
 			return svc, nil
 		}
-		for _, tag := range svc.Tags {
-			if tag == fmt.Sprintf("alias_%s", name) {
-				return svc, nil
-			}
-		}
 	}
 
 	return nil, nil
 }
 
+// registerService register service of this client by its address.
+func (a *Admin) registerService(ctx context.Context, svc *RegistryService) error {
+	return a.registry().RegisterService(ctx, a.Config.ClientName, a.Config.ClientAddress, svc)
+}
+
 // checkGlobalDuplicateService check if new service is globally unique and prevent duplicate clients.
-func (a *Admin) checkGlobalDuplicateService(service, name string) error {
+func (a *Admin) checkGlobalDuplicateService(ctx context.Context, service, name string) error {
 	// Prevent duplicate clients (2 or more nodes using the same name).
 	// This should not usually happen unless the config file is edited manually.
-	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
+	node, err := a.registry().ListNodeServices(ctx, a.Config.ClientName)
 	if err != nil {
 		return err
 	}
-	if node != nil && node.Node.Address != a.Config.ClientAddress && len(node.Services) > 0 {
-		return fmt.Errorf(`another client with the same name '%s' but different address detected.
-
-This client address is %s, the other one - %s.
-Re-configure this client with the different name using 'pmm-admin config' command.`,
-			a.Config.ClientName, a.Config.ClientAddress, node.Node.Address)
+	if node != nil && node.Address != a.Config.ClientAddress && len(node.Services) > 0 {
+		return newError(KindDuplicate, fmt.Sprintf(`This client address is %s, the other one - %s.
+Re-configure this client with the different name using 'pmm-admin config' command.`, a.Config.ClientAddress, node.Address),
+			"another client with the same name '%s' but different address detected.", a.Config.ClientName)
 	}
 
 	// Check if service with the name (tag) is globally unique.
-	services, _, err := a.consulAPI.Catalog().Service(service, fmt.Sprintf("alias_%s", name), nil)
+	nodes, err := a.registry().FindServiceByAlias(ctx, service, name)
 	if err != nil {
 		return err
 	}
-	if len(services) > 0 {
-		return fmt.Errorf(`another client '%s' by address '%s' is monitoring %s instance under the name '%s'.
-
-Choose different name for this service.`,
-			services[0].Node, services[0].Address, service, name)
+	if len(nodes) > 0 {
+		return newError(KindDuplicate, "Choose different name for this service.",
+			"another client '%s' by address '%s' is monitoring %s instance under the name '%s'.",
+			nodes[0].Name, nodes[0].Address, service, name)
 	}
 
 	return nil
 }
 
-// choosePort automatically choose the port for service.
-func (a *Admin) choosePort(port int, userDefined bool) (int, error) {
-	// Check if user defined port is not used.
-	if userDefined {
-		ok, err := a.availablePort(port)
-		if err != nil {
-			return port, err
-		}
-		if ok {
-			return port, nil
-		}
-		return port, fmt.Errorf("port %d is reserved by other service. Choose the different one.", port)
-	}
-	// Find the first available port starting the default one.
-	for i := port; i < port+1000; i++ {
-		ok, err := a.availablePort(i)
-		if err != nil {
-			return port, err
-		}
-		if ok {
-			return i, nil
+// checkSSLCertificate check if SSL cert and key files exist and generate them if not or expired.
+func (a *Admin) checkSSLCertificate() error {
+	if FileExists(a.paths().SSLCertFile) && FileExists(a.paths().SSLKeyFile) {
+		// Renew expired self-signed cert, imported ones are left to the user.
+		info, err := readCertInfo(a.paths().SSLCertFile)
+		if err != nil || !info.SelfSigned || time.Now().Before(info.NotAfter) {
+			return nil
 		}
-	}
-	return port, fmt.Errorf("ports %d-%d are reserved by other services. Try to specify the other port using --service-port",
-		port, port+1000)
-}
-
-// availablePort check if port is occupied by any service on Consul.
-func (a *Admin) availablePort(port int) (bool, error) {
-	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
-	if err != nil {
-		return false, err
-	}
-	if node != nil {
-		for _, svc := range node.Services {
-			if port == svc.Port {
-				return false, nil
-			}
+		if err := generateSSLCertificate(a.paths().SSLCertFile, a.paths().SSLKeyFile, a.certOptions()); err != nil {
+			return err
 		}
-	}
-	return true, nil
-}
-
-// checkSSLCertificate check if SSL cert and key files exist and generate them if not.
-func (a *Admin) checkSSLCertificate() error {
-	if FileExists(SSLCertFile) && FileExists(SSLKeyFile) {
-		return nil
+		_, err = a.restartSSLServices()
+		return err
 	}
 
 	// Generate SSL cert and key.
-	return generateSSLCertificate(a.Config.ClientAddress, SSLCertFile, SSLKeyFile)
+	return generateSSLCertificate(a.paths().SSLCertFile, a.paths().SSLKeyFile, a.certOptions())
 }
 
 // CheckInstallation check for broken installation.
-func (a *Admin) CheckInstallation() (orphanedServices, missingServices []string) {
-	localServices := GetLocalServices()
+func (a *Admin) CheckInstallation(ctx context.Context) (orphanedServices, missingServices []string) {
+	localServices := a.GetLocalServices()
 
-	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
+	node, err := a.registry().ListNodeServices(ctx, a.Config.ClientName)
 	if err != nil || node == nil || len(node.Services) == 0 {
 		return localServices, []string{}
 	}
 
-	// Find orphaned services: local system services that are not associated with Consul services.
+	// Find orphaned services: local system services that are not associated with registered services.
 ForLoop1:
 	for _, s := range localServices {
 		for _, svc := range node.Services {
@@ -556,7 +616,7 @@ ForLoop1:
 		orphanedServices = append(orphanedServices, s)
 	}
 
-	// Find missing services: Consul services that are missing locally.
+	// Find missing services: registered services that are missing locally.
 ForLoop2:
 	for _, svc := range node.Services {
 		svcName := fmt.Sprintf("pmm-%s-%d", strings.Replace(svc.Service, ":", "-", 1), svc.Port)
@@ -572,73 +632,56 @@ ForLoop2:
 }
 
 // RepairInstallation repair installation.
-func (a *Admin) RepairInstallation() error {
-	orphanedServices, missingServices := a.CheckInstallation()
+// It returns the number of removed orphaned local and remote services.
+func (a *Admin) RepairInstallation(ctx context.Context) (removed int, err error) {
+	orphanedServices, missingServices := a.CheckInstallation(ctx)
 	// Uninstall local services.
 	for _, s := range orphanedServices {
-		if err := uninstallService(s); err != nil {
-			return err
+		if err := a.uninstallService(s); err != nil {
+			return removed, err
 		}
+		removed++
 	}
 
-	// Remove remote services from Consul.
+	// Remove remote services from the registry.
 	for _, s := range missingServices {
-		dereg := consul.CatalogDeregistration{
-			Node:      a.Config.ClientName,
-			ServiceID: s,
-		}
-		if _, err := a.consulAPI.Catalog().Deregister(&dereg, nil); err != nil {
-			return err
-		}
+		// Try to delete instances from QAN associated with queries service by its metadata.
+		meta, _ := a.registry().ListServiceMeta(ctx, a.Config.ClientName, s, "")
 
-		prefix := fmt.Sprintf("%s/%s/", a.Config.ClientName, s)
+		if err := a.registry().DeregisterService(ctx, a.Config.ClientName, s); err != nil {
+			return removed, err
+		}
+		removed++
 
-		// Try to delete instances from QAN associated with queries service on KV.
-		names, _, err := a.consulAPI.KV().Keys(prefix, "", nil)
-		if err == nil {
-			for _, name := range names {
-				for _, serviceName := range []string{"mysql", "mongodb"} {
-					if strings.HasSuffix(name, fmt.Sprintf("/qan_%s_uuid", serviceName)) {
-						data, _, err := a.consulAPI.KV().Get(name, nil)
-						if err == nil && data != nil {
-							a.deleteInstance(string(data.Value))
-						}
-						break
-					}
-				}
+		for key, value := range meta {
+			if isQANUUIDMetaKey(key) {
+				a.deleteInstance(ctx, value)
 			}
 		}
-
-		a.consulAPI.KV().DeleteTree(prefix, nil)
 	}
 
-	if len(orphanedServices) > 0 || len(missingServices) > 0 {
-		fmt.Printf("OK, removed %d orphaned services.\n", len(orphanedServices)+len(missingServices))
-	} else {
-		fmt.Println("No orphaned services found.")
-	}
-	return nil
+	return removed, nil
 }
 
 // Uninstall remove all monitoring services with the best effort.
-func (a *Admin) Uninstall() uint16 {
+func (a *Admin) Uninstall(ctx context.Context) uint16 {
 	var count uint16
-	if FileExists(ConfigFile) {
+	if FileExists(a.paths().ConfigFile) {
 		err := a.LoadConfig()
 		if err == nil {
-			a.apiTimeout = 5 * time.Second
-			if err := a.SetAPI(); err == nil {
+			a.Timeout = 5 * time.Second
+			if err := a.SetAPI(ctx); err == nil {
 				// Try remove all services normally ignoring the errors.
-				count, _ = a.RemoveAllMonitoring(true)
+				count, _ = a.RemoveAllMonitoring(ctx, true)
 			}
 		}
 	}
 
 	// Find any local PMM services and try to uninstall ignoring the errors.
-	localServices := GetLocalServices()
+	localServices := a.GetLocalServices()
 
 	for _, service := range localServices {
-		if err := uninstallService(service); err == nil {
+		if err := a.uninstallService(service); err == nil {
 			count++
 		}
 	}
@@ -646,21 +689,6 @@ func (a *Admin) Uninstall() uint16 {
 	return count
 }
 
-// GetLocalServices finds any local PMM services
-func GetLocalServices() (services []string) {
-	dir, extension := GetServiceDirAndExtension()
-
-	filesFound, _ := filepath.Glob(fmt.Sprintf("%s/pmm-*%s", dir, extension))
-	rService, _ := regexp.Compile(fmt.Sprintf("%s/(pmm-.+)%s", dir, extension))
-	for _, f := range filesFound {
-		if data := rService.FindStringSubmatch(f); data != nil {
-			services = append(services, data[1])
-		}
-	}
-
-	return services
-}
-
 // GetServiceDirAndExtension returns dir and extension used to create system service
 func GetServiceDirAndExtension() (dir, extension string) {
 	switch service.Platform() {
@@ -681,15 +709,22 @@ func GetServiceDirAndExtension() (dir, extension string) {
 	return RootDir + dir, extension
 }
 
-// ShowPasswords display passwords from config file.
-func (a *Admin) ShowPasswords() {
-	fmt.Println("HTTP basic authentication")
-	fmt.Printf("%-8s | %s\n", "User", a.Config.ServerUser)
-	fmt.Printf("%-8s | %s\n\n", "Password", a.Config.ServerPassword)
+// ShowPasswords returns passwords from config file as tables.
+func (a *Admin) ShowPasswords() string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "HTTP basic authentication")
+	fmt.Fprintf(&b, "%-8s | %s\n", "User", a.Config.ServerUser)
+	fmt.Fprintf(&b, "%-8s | %s\n\n", "Password", a.Config.ServerPassword)
+
+	if a.Config.ServerProxyUser != "" {
+		fmt.Fprintln(&b, "Proxy authentication")
+		fmt.Fprintf(&b, "%-8s | %s\n", "User", a.Config.ServerProxyUser)
+		fmt.Fprintf(&b, "%-8s | %s\n\n", "Password", a.Config.ServerProxyPassword)
+	}
 
-	fmt.Println("MySQL new user creation")
-	fmt.Printf("%-8s | %s\n", "Password", a.Config.MySQLPassword)
-	fmt.Println()
+	fmt.Fprintln(&b, "MySQL new user creation")
+	fmt.Fprintf(&b, "%-8s | %s\n", "Password", a.Config.MySQLPassword)
+	return b.String()
 }
 
 // FileExists check if file exists.
@@ -721,14 +756,14 @@ func SanitizeDSN(dsn string) string {
 }
 
 // CheckBinaries check if all PMM Client binaries are at their paths
-func CheckBinaries() string {
+func (a *Admin) CheckBinaries() string {
 	paths := []string{
-		fmt.Sprintf("%s/node_exporter", PMMBaseDir),
-		fmt.Sprintf("%s/mysqld_exporter", PMMBaseDir),
-		fmt.Sprintf("%s/mongodb_exporter", PMMBaseDir),
-		fmt.Sprintf("%s/proxysql_exporter", PMMBaseDir),
-		fmt.Sprintf("%s/bin/percona-qan-agent", AgentBaseDir),
-		fmt.Sprintf("%s/bin/percona-qan-agent-installer", AgentBaseDir),
+		fmt.Sprintf("%s/node_exporter", a.paths().BaseDir),
+		fmt.Sprintf("%s/mysqld_exporter", a.paths().BaseDir),
+		fmt.Sprintf("%s/mongodb_exporter", a.paths().BaseDir),
+		fmt.Sprintf("%s/proxysql_exporter", a.paths().BaseDir),
+		fmt.Sprintf("%s/bin/percona-qan-agent", a.paths().AgentBaseDir),
+		fmt.Sprintf("%s/bin/percona-qan-agent-installer", a.paths().AgentBaseDir),
 	}
 	for _, p := range paths {
 		if !FileExists(p) {
@@ -749,56 +784,6 @@ func colorStatus(msgOK string, msgNotOK string, ok bool) string {
 	return c(msgNotOK)
 }
 
-// generateSSLCertificate generate SSL certificate and key and write them into the files.
-func generateSSLCertificate(host, certFile, keyFile string) error {
-	// Generate key.
-	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return fmt.Errorf("failed to generate private key: %s", err)
-	}
-
-	// Generate cert.
-	notBefore, _ := time.Parse("Jan 2 15:04:05 2006", "Nov 25 15:00:00 2016")
-	notAfter, _ := time.Parse("Jan 2 15:04:05 2006", "Nov 25 15:00:00 2026")
-	serialNumber, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
-	cert := x509.Certificate{
-		Subject:               pkix.Name{Organization: []string{"PMM Client"}},
-		SerialNumber:          serialNumber,
-		NotBefore:             notBefore,
-		NotAfter:              notAfter,
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		BasicConstraintsValid: true,
-	}
-	if ip := net.ParseIP(host); ip != nil {
-		cert.IPAddresses = append(cert.IPAddresses, ip)
-	} else {
-		cert.DNSNames = append(cert.DNSNames, host)
-	}
-
-	certBytes, err := x509.CreateCertificate(rand.Reader, &cert, &cert, &privKey.PublicKey, privKey)
-	if err != nil {
-		return fmt.Errorf("failed to generate certificate: %s", err)
-	}
-
-	// Write files.
-	out, err := os.OpenFile(certFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to write %s: %s", certFile, err)
-	}
-	pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
-	out.Close()
-
-	out, err = os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to write %s: %s", keyFile, err)
-	}
-	pem.Encode(out, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privKey)})
-	out.Close()
-
-	return nil
-}
-
 var svcTypes = []string{
 	"linux:metrics",
 	"mysql:metrics",
@@ -806,6 +791,7 @@ var svcTypes = []string{
 	"mongodb:metrics",
 	"mongodb:queries",
 	"proxysql:metrics",
+	"pmm-client:metrics",
 }
 
 // isValidSvcType checks if given service type is allowed
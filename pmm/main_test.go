@@ -18,6 +18,9 @@
 package pmm
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -53,3 +56,25 @@ func TestIsValidSvcType(t *testing.T) {
 	// check invalid type
 	assert.Error(t, isValidSvcType("invalid type"))
 }
+
+func TestServerTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	assert.Nil(t, generateSSLCertificate(certFile, keyFile, CertOptions{Hosts: []string{"127.0.0.1"}}))
+
+	admin := Admin{Config: &Config{ServerCAFile: certFile, ServerClientCert: certFile, ServerClientKey: keyFile}}
+	tlsConfig, err := admin.serverTLSConfig()
+	assert.Nil(t, err)
+	assert.NotNil(t, tlsConfig.RootCAs)
+	assert.Len(t, tlsConfig.Certificates, 1)
+	assert.False(t, tlsConfig.InsecureSkipVerify)
+
+	// Key is not a certificate.
+	admin.Config = &Config{ServerCAFile: keyFile}
+	_, err = admin.serverTLSConfig()
+	assert.Error(t, err)
+}
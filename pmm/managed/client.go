@@ -20,7 +20,6 @@ package managed
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -28,8 +27,6 @@ import (
 	"net/http"
 	"net/url"
 	"path"
-
-	"github.com/percona/pmm-client/pmm/utils"
 )
 
 type Error struct {
@@ -49,19 +46,11 @@ type Client struct {
 	basePath string
 }
 
-func NewClient(host string, scheme string, user *url.Userinfo, insecureSSL bool, verbose bool) *Client {
-	transport := &http.Transport{}
-	if insecureSSL {
-		transport.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true,
-		}
-	}
+// NewClient creates pmm-managed API client on top of the given transport shared with other PMM Server clients.
+func NewClient(host string, scheme string, user *url.Userinfo, transport http.RoundTripper) *Client {
 	client := &http.Client{
 		Transport: transport,
 	}
-	if verbose {
-		client.Transport = utils.NewDebugRoundTripper(client.Transport)
-	}
 
 	return &Client{
 		client:   client,
@@ -0,0 +1,254 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/percona/pmm/proto"
+)
+
+// Migrate move this client to a new PMM server keeping its services and QAN instances.
+// The current state is read from the current server if it is reachable, i.e. APIs were set with SetAPI successfully,
+// or from the local service configs and qan-agent instance files otherwise. Then the server is switched with the given
+// server options as 'pmm-admin config' does, qan-agent is registered on it and everything is registered again.
+// It returns the number of migrated services and QAN instances.
+func (a *Admin) Migrate(ctx context.Context, to Config, fromServer bool) (services, instances int, err error) {
+	var b *Backup
+	if fromServer {
+		b, err = a.Backup(ctx)
+	} else {
+		b, err = a.localBackup(ctx, a.Config.ClientName)
+	}
+	if err == ErrNoService {
+		b, err = &Backup{ClientName: a.Config.ClientName}, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("Unable to read services of this client: %w", err)
+	}
+
+	// Client name and addresses are kept, only the server is changed.
+	to.ClientName, to.ClientAddress, to.BindAddress = "", "", ""
+	// Without renaming and moving services there is nothing to warn about.
+	if _, err := a.SetConfig(ctx, to, false); err != nil {
+		return 0, 0, err
+	}
+
+	var errs Errors
+	uuids := map[string]string{}
+	if len(b.QANInstances) > 0 {
+		// The agent is unknown to the new server, so it is registered again rewriting agent.conf.
+		agentID, parentUUID, err := a.ensureAgent(ctx)
+		if err != nil {
+			return 0, 0, err
+		}
+		// Running qan-agent should pick up the new agent.conf before QAN is started.
+		for _, bs := range b.Services {
+			if strings.HasSuffix(bs.Consul.Service, ":queries") {
+				svcName := fmt.Sprintf("pmm-%s-%d", strings.Replace(bs.Consul.Service, ":", "-", 1), bs.Consul.Port)
+				a.stopService(svcName)
+				if err := a.startService(svcName); err != nil {
+					return 0, 0, fmt.Errorf("Unable to restart %s: %w", svcName, err)
+				}
+			}
+		}
+		for _, bi := range b.QANInstances {
+			uuid, err := a.migrateQANInstance(ctx, agentID, parentUUID, bi)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("QAN instance %s (%s): %w", bi.Instance.Name, bi.Instance.UUID, err))
+				continue
+			}
+			uuids[bi.Instance.UUID] = uuid
+			instances++
+		}
+	}
+
+	// Point queries services to the QAN instances on the new server.
+	for _, bs := range b.Services {
+		for key, value := range bs.KV {
+			if uuid, ok := uuids[value]; ok && isQANUUIDMetaKey(key) {
+				bs.KV[key] = uuid
+			}
+		}
+	}
+	_, serviceErrs, err := a.restoreServices(ctx, b.Services)
+	if err != nil {
+		return 0, instances, err
+	}
+	errs = append(errs, serviceErrs...)
+	services = len(b.Services) - len(serviceErrs)
+
+	if len(errs) > 0 {
+		return services, instances, errs
+	}
+	return services, instances, nil
+}
+
+// migrateQANInstance reuse QAN instance on the new server if it has the same one, or create it, and start QAN for it.
+// It returns UUID of the instance on the new server.
+func (a *Admin) migrateQANInstance(ctx context.Context, agentID, parentUUID string, bi BackupQANInstance) (string, error) {
+	in, err := a.getInstance(ctx, bi.Instance.UUID)
+	if err == nil {
+		in.ParentUUID = parentUUID
+		in, err = a.undeleteInstance(ctx, in)
+	}
+	if err == errNoInstance {
+		in = bi.Instance
+		in.UUID = ""
+		in.ParentUUID = parentUUID
+		in.DSN = SanitizeDSN(bi.Instance.DSN)
+		in.Created = time.Time{}
+		in.Deleted = time.Time{}
+		in, err = a.createInstance(ctx, in)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := a.startQANInstance(ctx, agentID, in, bi); err != nil {
+		return "", err
+	}
+	return in.UUID, nil
+}
+
+// localBackup returns registration state of this client made of local files as the server is not available.
+// Services keep their tags if the registry of the server can still be read, e.g. only QAN API is down.
+// Otherwise queries services are named after their QAN instances and the others after the client.
+// Consul KV has QAN instance data only.
+func (a *Admin) localBackup(ctx context.Context, clientName string) (*Backup, error) {
+	registered := a.registeredTags(ctx, clientName)
+	b := &Backup{
+		Version:    Version,
+		Created:    time.Now(),
+		ClientName: clientName,
+	}
+
+	// QAN instances by subsystem.
+	instances := map[string][]BackupQANInstance{}
+	files, _ := filepath.Glob(a.paths().AgentBaseDir + "/instance/*.json")
+	for _, f := range files {
+		uuid := strings.TrimSuffix(filepath.Base(f), ".json")
+		bytes, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var in proto.Instance
+		if err := json.Unmarshal(bytes, &in); err != nil {
+			return nil, fmt.Errorf("problem with QAN instance file %s: %w", f, err)
+		}
+		if in.Subsystem != "mysql" && in.Subsystem != "mongo" {
+			// Agent and OS instances are created by agent registration.
+			continue
+		}
+		bi, err := a.backupQANInstance("", uuid)
+		if err != nil {
+			return nil, err
+		}
+		instances[in.Subsystem] = append(instances[in.Subsystem], bi)
+	}
+
+	for _, svcName := range a.GetLocalServices() {
+		svcType, port, ok := parseServiceName(svcName)
+		if !ok {
+			continue
+		}
+		svcConfig, _ := a.loadServiceConfig(svcName)
+		svc := consul.AgentService{
+			ID:      fmt.Sprintf("%s-%d", svcType, port),
+			Service: svcType,
+			Port:    port,
+		}
+		bs := BackupService{KV: map[string]string{}, Config: svcConfig}
+
+		switch svcType {
+		case "mysql:queries", "mongodb:queries":
+			subsystem, key := "mysql", metaQANMySQLUUID
+			if svcType == "mongodb:queries" {
+				subsystem, key = "mongo", metaQANMongoDBUUID
+			}
+			for _, bi := range instances[subsystem] {
+				bi.ServiceID = svc.ID
+				svc.Tags = append(svc.Tags, fmt.Sprintf("alias_%s", bi.Instance.Name))
+				bs.KV[instanceMetaKey(bi.Instance.Name, metaDSN)] = SanitizeDSN(bi.Instance.DSN)
+				bs.KV[instanceMetaKey(bi.Instance.Name, key)] = bi.Instance.UUID
+				b.QANInstances = append(b.QANInstances, bi)
+			}
+		default:
+			if _, ok := registered[svc.ID]; ok {
+				break
+			}
+			svc.Tags = []string{fmt.Sprintf("alias_%s", clientName)}
+			if data, err := ioutil.ReadFile(a.serviceManager().ServiceFile(svcName)); err == nil && strings.Contains(string(data), "-web.ssl-cert-file") {
+				svc.Tags = append(svc.Tags, "scheme_https")
+			}
+		}
+		if tags, ok := registered[svc.ID]; ok {
+			svc.Tags = tags
+		}
+		bs.Consul = svc
+		b.Services = append(b.Services, bs)
+	}
+	if len(b.Services) == 0 {
+		return nil, ErrNoService
+	}
+	return b, nil
+}
+
+// registeredTags returns tags of services of the client by service ID as they are registered on the current server,
+// nil if its registry can't be read.
+func (a *Admin) registeredTags(ctx context.Context, clientName string) map[string][]string {
+	// Consul client is not set if SetAPI failed before it.
+	if a.consulAPI == nil && a.registry().Name() == "consul" {
+		return nil
+	}
+	node, err := a.registry().ListNodeServices(ctx, clientName)
+	if err != nil || node == nil {
+		return nil
+	}
+	tags := map[string][]string{}
+	for _, svc := range node.Services {
+		tags[svc.ID] = svc.Tags
+	}
+	return tags
+}
+
+// parseServiceName parse service type and port from local service name pmm-<type with ':' replaced by '-'>-<port>.
+func parseServiceName(svcName string) (svcType string, port int, ok bool) {
+	name := strings.TrimPrefix(svcName, "pmm-")
+	i := strings.LastIndex(name, "-")
+	if i == -1 {
+		return "", 0, false
+	}
+	port, err := strconv.Atoi(name[i+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	name = name[:i]
+	j := strings.LastIndex(name, "-")
+	if j == -1 {
+		return "", 0, false
+	}
+	return name[:j] + ":" + name[j+1:], port, true
+}
@@ -0,0 +1,73 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseServiceName(t *testing.T) {
+	for svcName, expected := range map[string]struct {
+		svcType string
+		port    int
+		ok      bool
+	}{
+		"pmm-linux-metrics-42000":      {"linux:metrics", 42000, true},
+		"pmm-mysql-queries-0":          {"mysql:queries", 0, true},
+		"pmm-pmm-client-metrics-42005": {"pmm-client:metrics", 42005, true},
+		"pmm-mysql-metrics":            {"", 0, false},
+		"pmm-42000":                    {"", 0, false},
+	} {
+		svcType, port, ok := parseServiceName(svcName)
+		assert.Equal(t, expected.svcType, svcType, svcName)
+		assert.Equal(t, expected.port, port, svcName)
+		assert.Equal(t, expected.ok, ok, svcName)
+	}
+}
+
+func TestLocalBackup(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "pmm-migrate")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	m := &RunitServiceManager{ServiceDir: filepath.Join(dir, "sv")}
+	for _, name := range []string{"pmm-linux-metrics-42000", "pmm-mysql-metrics-42002"} {
+		assert.Nil(t, writeServiceFile(m.ServiceFile(name), "#!/bin/sh\nexec /usr/local/percona/pmm-client/exporter\n", 0755))
+	}
+	r := &FileRegistry{File: filepath.Join(dir, "registry.json")}
+	svc := &RegistryService{ID: "mysql:metrics-42002", Service: "mysql:metrics", Tags: []string{"alias_custom", "scheme_https"}, Port: 42002}
+	assert.Nil(t, r.RegisterService(ctx, "db01", "127.0.0.1", svc))
+
+	a := &Admin{Paths: Paths{BaseDir: dir, AgentBaseDir: dir}, ServiceManager: m, Registry: r, Config: &Config{}}
+	b, err := a.localBackup(ctx, "db01")
+	if !assert.Nil(t, err) || !assert.Len(t, b.Services, 2) {
+		return
+	}
+	// Registered service keeps its alias, the other one is named after the client.
+	assert.Equal(t, "linux:metrics-42000", b.Services[0].Consul.ID)
+	assert.Equal(t, []string{"alias_db01"}, b.Services[0].Consul.Tags)
+	assert.Equal(t, "mysql:metrics-42002", b.Services[1].Consul.ID)
+	assert.Equal(t, []string{"alias_custom", "scheme_https"}, b.Services[1].Consul.Tags)
+}
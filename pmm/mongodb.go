@@ -18,6 +18,7 @@
 package pmm
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -26,10 +27,10 @@ import (
 )
 
 // DetectMongoDB verifies MongoDB connection.
-func (a *Admin) DetectMongoDB(uri string) (mgo.BuildInfo, error) {
+func (a *Admin) DetectMongoDB(ctx context.Context, uri string) (mgo.BuildInfo, error) {
 	dialInfo, err := pmgo.ParseURL(uri)
 	if err != nil {
-		return mgo.BuildInfo{}, fmt.Errorf("Bad MongoDB uri %s: %s", uri, err)
+		return mgo.BuildInfo{}, fmt.Errorf("Bad MongoDB uri %s: %w", uri, err)
 	}
 
 	dialInfo.Direct = true
@@ -37,14 +38,14 @@ func (a *Admin) DetectMongoDB(uri string) (mgo.BuildInfo, error) {
 	dialer := pmgo.NewDialer()
 	session, err := dialer.DialWithInfo(dialInfo)
 	if err != nil {
-		return mgo.BuildInfo{}, fmt.Errorf("Cannot connect to MongoDB using uri %s: %s", uri, err)
+		return mgo.BuildInfo{}, fmt.Errorf("Cannot connect to MongoDB using uri %s: %w", uri, err)
 	}
 	defer session.Close()
 	session.SetMode(mgo.Eventual, true)
 
 	buildInfo, err := session.BuildInfo()
 	if err != nil {
-		return mgo.BuildInfo{}, fmt.Errorf("Cannot get buildInfo() for MongoDB using uri %s: %s", uri, err)
+		return mgo.BuildInfo{}, fmt.Errorf("Cannot get buildInfo() for MongoDB using uri %s: %w", uri, err)
 	}
 
 	return buildInfo, nil
@@ -18,118 +18,95 @@
 package pmm
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
-	"time"
 
-	consul "github.com/hashicorp/consul/api"
 	"github.com/percona/kardianos-service"
 	"github.com/percona/pmm/proto"
 	"gopkg.in/mgo.v2"
 )
 
 // AddMongoDBQueries add mongodb instance to Query Analytics.
-func (a *Admin) AddMongoDBQueries(buildInfo mgo.BuildInfo, uri string) error {
+// Returned warnings are about things that did not fail adding the service.
+func (a *Admin) AddMongoDBQueries(ctx context.Context, buildInfo mgo.BuildInfo, uri string) ([]string, error) {
 	serviceType := "mongodb:queries"
 	dsn := uri
 	safeDSN := SanitizeDSN(uri)
 
-	// Check if we have already this service on Consul.
-	consulSvc, err := a.getConsulService(serviceType, a.ServiceName)
+	// Check if we have already this service registered.
+	registered, err := a.getRegisteredService(ctx, serviceType, a.ServiceName)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if consulSvc != nil {
-		return ErrDuplicate
+	if registered != nil {
+		return nil, ErrDuplicate
 	}
 
-	if err := a.checkGlobalDuplicateService(serviceType, a.ServiceName); err != nil {
-		return err
+	if err := a.checkGlobalDuplicateService(ctx, serviceType, a.ServiceName); err != nil {
+		return nil, err
 	}
 
 	// Now check if there are any existing services of given service type.
-	consulSvc, err = a.getConsulService(serviceType, "")
+	registered, err = a.getRegisteredService(ctx, serviceType, "")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Register agent if config file does not exist.
-	agentConfigFile := fmt.Sprintf("%s/config/agent.conf", AgentBaseDir)
-	if !FileExists(agentConfigFile) {
-		if err := a.registerAgent(); err != nil {
-			return err
-		}
-	}
-
-	agentID, err := getAgentID(agentConfigFile)
+	// Register agent if needed and get parent_uuid of agent instance.
+	agentID, parentUUID, err := a.ensureAgent(ctx)
 	if err != nil {
-		return err
-	}
-	// Get parent_uuid of agent instance.
-	parentUUID, err := a.getAgentInstance(agentID)
-	if err == errNoInstance {
-		// If agent is orphaned, let's re-register it.
-		if err := a.registerAgent(); err != nil {
-			return err
-		}
-		// Get new agent id.
-		agentID, err = getAgentID(agentConfigFile)
-		if err != nil {
-			return err
-		}
-		// Get parent_uuid again.
-		parentUUID, err = a.getAgentInstance(agentID)
-		if err != nil {
-			return err
-		}
-	} else if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Check if related instance exists or try to re-use the existing one.
-	instance, err := a.getMongoDBInstance(a.ServiceName, parentUUID)
+	instance, err := a.getMongoDBInstance(ctx, a.ServiceName, parentUUID)
 	if err == errNoInstance {
 		// Create new instance on QAN.
-		instance, err = a.createMongoDBInstance(buildInfo, safeDSN, parentUUID)
+		instance, err = a.createMongoDBInstance(ctx, buildInfo, safeDSN, parentUUID)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	} else if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Write instance config for qan-agent with real DSN.
 	instance.DSN = dsn
 	bytes, _ := json.MarshalIndent(instance, "", "    ")
-	if err := ioutil.WriteFile(fmt.Sprintf("%s/instance/%s.json", AgentBaseDir, instance.UUID), bytes, 0600); err != nil {
-		return err
+	if err := ioutil.WriteFile(fmt.Sprintf("%s/instance/%s.json", a.paths().AgentBaseDir, instance.UUID), bytes, 0600); err != nil {
+		return nil, err
 	}
 
 	// Choose port.
 	port := 0
 	// Don't install service if we have already another one.
 	// 1 agent handles multiple instances for QAN.
-	if consulSvc == nil {
+	if registered == nil {
 		// Install and start service via platform service manager.
 		// We have to run agent before adding it to QAN.
 		svcConfig := &service.Config{
 			Name:        fmt.Sprintf("pmm-mongodb-queries-%d", port),
 			DisplayName: "PMM MongoDB Query Analytics agent",
 			Description: "PMM MongoDB Query Analytics agent",
-			Executable:  fmt.Sprintf("%s/bin/percona-qan-agent", AgentBaseDir),
+			Executable:  fmt.Sprintf("%s/bin/percona-qan-agent", a.paths().AgentBaseDir),
 			Arguments:   a.Args,
+			Option:      service.KeyValue{optionEnvironmentFile: a.agentEnvFile()},
 		}
-		if err := installService(svcConfig); err != nil {
-			return err
+		if err := a.writeAgentEnvFile(); err != nil {
+			return nil, err
+		}
+		if err := a.installService(svcConfig); err != nil {
+			return nil, err
 		}
 	} else {
-		port = consulSvc.Port
+		port = registered.Port
 		// Ensure qan-agent is started if service exists, otherwise it won't be enabled for QAN.
-		if err := startService(fmt.Sprintf("pmm-mongodb-queries-%d", port)); err != nil {
-			return err
+		if err := a.startService(fmt.Sprintf("pmm-mongodb-queries-%d", port)); err != nil {
+			return nil, err
 		}
 	}
 
@@ -139,8 +116,8 @@ func (a *Admin) AddMongoDBQueries(buildInfo mgo.BuildInfo, uri string) error {
 		"Interval":       60,
 		"ExampleQueries": true,
 	}
-	if err := a.startQAN(agentID, qanConfig); err != nil {
-		return err
+	if err := a.startQAN(ctx, agentID, qanConfig); err != nil {
+		return nil, err
 	}
 
 	tags := []string{
@@ -148,89 +125,77 @@ func (a *Admin) AddMongoDBQueries(buildInfo mgo.BuildInfo, uri string) error {
 		"scheme_https", // What this do?
 	}
 	// For existing service, we append a new alias_ tag.
-	if consulSvc != nil {
-		tags = append(consulSvc.Tags, tags...)
+	if registered != nil {
+		tags = append(registered.Tags, tags...)
 	}
 
-	// Add or update service to Consul.
+	// Register or update service.
 	serviceID := fmt.Sprintf("%s-%d", serviceType, port)
-	srv := consul.AgentService{
+	srv := RegistryService{
 		ID:      serviceID,
 		Service: serviceType,
-		Tags:    tags,
+		Tags:    a.labelTags(tags),
 		Port:    port,
 	}
-	reg := consul.CatalogRegistration{
-		Node:    a.Config.ClientName,
-		Address: a.Config.ClientAddress,
-		Service: &srv,
-	}
-	if _, err := a.consulAPI.Catalog().Register(&reg, nil); err != nil {
-		return err
+	if err := a.registerService(ctx, &srv); err != nil {
+		return nil, err
 	}
 
-	// Add info to Consul KV.
-	d := &consul.KVPair{
-		Key:   fmt.Sprintf("%s/%s/%s/dsn", a.Config.ClientName, serviceID, a.ServiceName),
-		Value: []byte(safeDSN),
-	}
-	a.consulAPI.KV().Put(d, nil)
-	d = &consul.KVPair{
-		Key:   fmt.Sprintf("%s/%s/%s/qan_mongodb_uuid", a.Config.ClientName, serviceID, a.ServiceName),
-		Value: []byte(instance.UUID),
-	}
-	a.consulAPI.KV().Put(d, nil)
+	// Add info to service metadata.
+	a.registry().SetServiceMeta(ctx, a.Config.ClientName, serviceID, instanceMetaKey(a.ServiceName, metaDSN), safeDSN)
+	a.registry().SetServiceMeta(ctx, a.Config.ClientName, serviceID, instanceMetaKey(a.ServiceName, metaQANMongoDBUUID), instance.UUID)
 
-	return nil
+	return a.initHealthCheck(ctx, &srv), nil
 }
 
 // RemoveMongoDBQueries remove mongodb instance from QAN.
-func (a *Admin) RemoveMongoDBQueries() error {
+func (a *Admin) RemoveMongoDBQueries(ctx context.Context) error {
 	serviceType := "mongodb:queries"
 
-	// Check if we have this service on Consul.
-	consulSvc, err := a.getConsulService(serviceType, a.ServiceName)
+	// Check if we have this service registered.
+	registered, err := a.getRegisteredService(ctx, serviceType, a.ServiceName)
 	if err != nil {
 		return err
 	}
-	if consulSvc == nil {
+	if registered == nil {
 		return ErrNoService
 	}
 
 	// Ensure qan-agent is started, otherwise it will be an error to stop QAN.
-	if err := startService(fmt.Sprintf("pmm-mongodb-queries-%d", consulSvc.Port)); err != nil {
+	if err := a.startService(fmt.Sprintf("pmm-mongodb-queries-%d", registered.Port)); err != nil {
 		return err
 	}
 
-	// Get UUID of instance the agent is monitoring from KV.
-	key := fmt.Sprintf("%s/%s/%s/qan_mongodb_uuid", a.Config.ClientName, consulSvc.ID, a.ServiceName)
-	data, _, err := a.consulAPI.KV().Get(key, nil)
+	// Get UUID of instance the agent is monitoring from service metadata.
+	key := instanceMetaKey(a.ServiceName, metaQANMongoDBUUID)
+	uuid, err := a.registry().GetServiceMeta(ctx, a.Config.ClientName, registered.ID, key)
 	if err != nil {
 		return err
 	}
-	uuid := string(data.Value)
+	if uuid == "" {
+		return fmt.Errorf("can't get key %s", key)
+	}
 
 	// Stop QAN for this instance on the local agent.
-	agentConfigFile := fmt.Sprintf("%s/config/agent.conf", AgentBaseDir)
+	agentConfigFile := fmt.Sprintf("%s/config/agent.conf", a.paths().AgentBaseDir)
 	agentID, err := getAgentID(agentConfigFile)
 	if err != nil {
 		return err
 	}
-	if err := a.stopQAN(agentID, uuid); err != nil {
+	if err := a.stopQAN(ctx, agentID, uuid); err != nil {
 		return err
 	}
 
 	// Delete instance.
-	if err := a.deleteInstance(uuid); err != nil {
+	if err := a.deleteInstance(ctx, uuid); err != nil {
 		return err
 	}
 
-	prefix := fmt.Sprintf("%s/%s/%s/", a.Config.ClientName, consulSvc.ID, a.ServiceName)
-	a.consulAPI.KV().DeleteTree(prefix, nil)
+	a.registry().DeleteServiceMeta(ctx, a.Config.ClientName, registered.ID, instanceMetaPrefix(a.ServiceName))
 
-	// Remove queries service from Consul only if we have only 1 tag alias_ (the instance in question).
+	// Deregister queries service only if we have only 1 tag alias_ (the instance in question).
 	var tags []string
-	for _, tag := range consulSvc.Tags {
+	for _, tag := range registered.Tags {
 		if strings.HasPrefix(tag, "alias_") {
 			if tag != fmt.Sprintf("alias_%s", a.ServiceName) {
 				tags = append(tags, tag)
@@ -238,28 +203,19 @@ func (a *Admin) RemoveMongoDBQueries() error {
 		}
 	}
 	if len(tags) == 0 {
-		// Remove service from Consul.
-		dereg := consul.CatalogDeregistration{
-			Node:      a.Config.ClientName,
-			ServiceID: consulSvc.ID,
-		}
-		if _, err := a.consulAPI.Catalog().Deregister(&dereg, nil); err != nil {
+		// Deregister service.
+		if err := a.registry().DeregisterService(ctx, a.Config.ClientName, registered.ID); err != nil {
 			return err
 		}
 
 		// Stop and uninstall service.
-		if err := uninstallService(fmt.Sprintf("pmm-mongodb-queries-%d", consulSvc.Port)); err != nil {
+		if err := a.uninstallService(fmt.Sprintf("pmm-mongodb-queries-%d", registered.Port)); err != nil {
 			return err
 		}
 	} else {
 		// Remove tag from service.
-		consulSvc.Tags = tags
-		reg := consul.CatalogRegistration{
-			Node:    a.Config.ClientName,
-			Address: a.Config.ClientAddress,
-			Service: consulSvc,
-		}
-		if _, err := a.consulAPI.Catalog().Register(&reg, nil); err != nil {
+		registered.Tags = tags
+		if err := a.registerService(ctx, registered); err != nil {
 			return err
 		}
 	}
@@ -268,11 +224,11 @@ func (a *Admin) RemoveMongoDBQueries() error {
 }
 
 // getMongoDBInstance get or re-use mongodb instance from QAN API and return it.
-func (a *Admin) getMongoDBInstance(name, parentUUID string) (proto.Instance, error) {
+func (a *Admin) getMongoDBInstance(ctx context.Context, name, parentUUID string) (proto.Instance, error) {
 	var in proto.Instance
 	url := a.qanAPI.URL(a.serverURL, qanAPIBasePath, "instances",
 		fmt.Sprintf("?type=mongo&name=%s&parent_uuid=%s", name, parentUUID))
-	resp, bytes, err := a.qanAPI.Get(url)
+	resp, bytes, err := a.qanAPI.Get(ctx, url)
 	if err != nil {
 		return in, err
 	}
@@ -293,42 +249,11 @@ func (a *Admin) getMongoDBInstance(name, parentUUID string) (proto.Instance, err
 	}
 
 	// Instance exists, let's undelete it.
-	in.Deleted = time.Unix(1, 0)
-	cmdBytes, _ := json.Marshal(in)
-	url = a.qanAPI.URL(a.serverURL, qanAPIBasePath, "instances", in.UUID)
-	resp, content, err := a.qanAPI.Put(url, cmdBytes)
-	if err != nil {
-		return in, err
-	}
-	if resp.StatusCode != http.StatusNoContent {
-		return in, a.qanAPI.Error("PUT", url, resp.StatusCode, http.StatusNoContent, content)
-
-	}
-
-	// Ensure it was undeleted.
-	// QAN API 1.0.4 didn't support changing "deleted" field.
-	url = a.qanAPI.URL(a.serverURL, qanAPIBasePath, "instances", in.UUID)
-	resp, bytes, err = a.qanAPI.Get(url)
-	if err != nil {
-		return in, err
-	}
-	if resp.StatusCode != http.StatusOK {
-		return in, a.qanAPI.Error("GET", url, resp.StatusCode, http.StatusOK, bytes)
-	}
-
-	if err := json.Unmarshal(bytes, &in); err != nil {
-		return in, err
-	}
-	// If it's not "1970-01-01 00:00:00 +0000 UTC", it was left deleted.
-	if in.Deleted.Year() != 1970 {
-		return in, errNoInstance
-	}
-
-	return in, nil
+	return a.undeleteInstance(ctx, in)
 }
 
 // createMongoDBInstance create mongodb instance on QAN API and return it.
-func (a *Admin) createMongoDBInstance(buildInfo mgo.BuildInfo, safeDSN, parentUUID string) (proto.Instance, error) {
+func (a *Admin) createMongoDBInstance(ctx context.Context, buildInfo mgo.BuildInfo, safeDSN, parentUUID string) (proto.Instance, error) {
 	in := proto.Instance{
 		Subsystem:  "mongo",
 		ParentUUID: parentUUID,
@@ -337,32 +262,5 @@ func (a *Admin) createMongoDBInstance(buildInfo mgo.BuildInfo, safeDSN, parentUU
 		Distro:     "MongoDB",
 		Version:    buildInfo.Version,
 	}
-	inBytes, _ := json.Marshal(in)
-	url := a.qanAPI.URL(a.serverURL, qanAPIBasePath, "instances")
-	resp, content, err := a.qanAPI.Post(url, inBytes)
-	if err != nil {
-		return in, err
-	}
-	if resp.StatusCode != http.StatusCreated {
-		return in, a.qanAPI.Error("POST", url, resp.StatusCode, http.StatusCreated, content)
-	}
-
-	// The URI of the new instance is reported in the Location header, fetch it to get UUID assigned.
-	// Do not call the returned URL as QAN API returns an invalid one.
-	var bytes []byte
-	t := strings.Split(resp.Header.Get("Location"), "/")
-	url = a.qanAPI.URL(url, t[len(t)-1])
-	resp, bytes, err = a.qanAPI.Get(url)
-	if err != nil {
-		return in, err
-	}
-	if resp.StatusCode != http.StatusOK {
-		return in, a.qanAPI.Error("GET", url, resp.StatusCode, http.StatusOK, bytes)
-	}
-
-	if err := json.Unmarshal(bytes, &in); err != nil {
-		return in, err
-	}
-
-	return in, err
+	return a.createInstance(ctx, in)
 }
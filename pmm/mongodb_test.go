@@ -18,6 +18,7 @@
 package pmm
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -25,7 +26,7 @@ import (
 
 func TestAdmin_DetectMongoDB(t *testing.T) {
 	admin := Admin{}
-	buildInfo, err := admin.DetectMongoDB("")
+	buildInfo, err := admin.DetectMongoDB(context.Background(), "")
 	assert.Nil(t, err)
 	assert.NotEmpty(t, buildInfo)
 }
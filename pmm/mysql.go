@@ -18,6 +18,7 @@
 package pmm
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -55,7 +56,7 @@ type MySQLFlags struct {
 }
 
 // DetectMySQL detect MySQL, create user if needed, return DSN and MySQL info strings.
-func (a *Admin) DetectMySQL(mf MySQLFlags) (map[string]string, error) {
+func (a *Admin) DetectMySQL(ctx context.Context, mf MySQLFlags) (map[string]string, error) {
 	// Check for invalid mix of flags.
 	if mf.Socket != "" && mf.Host != "" {
 		return nil, errors.New("Flags --socket and --host are mutually exclusive.")
@@ -79,7 +80,7 @@ func (a *Admin) DetectMySQL(mf MySQLFlags) (map[string]string, error) {
 	// Populate defaults to DSN for missing options.
 	userDSN, err := userDSN.AutoDetect()
 	if err != nil && err != dsn.ErrNoSocket {
-		err = fmt.Errorf("Problem with MySQL auto-detection: %s", err)
+		err = fmt.Errorf("Problem with MySQL auto-detection: %w", err)
 		return nil, err
 	}
 
@@ -95,7 +96,7 @@ func (a *Admin) DetectMySQL(mf MySQLFlags) (map[string]string, error) {
 		pmmDSN := userDSN
 		pmmDSN.Username = "pmm"
 		pmmDSN.Password = a.Config.MySQLPassword
-		if err := testConnection(pmmDSN.String()); err == nil {
+		if err := testConnection(ctx, pmmDSN.String()); err == nil {
 			//fmt.Println("Using stored credentials, DSN is", pmmDSN.String())
 			accessOK = true
 			userDSN = pmmDSN
@@ -106,7 +107,7 @@ func (a *Admin) DetectMySQL(mf MySQLFlags) (map[string]string, error) {
 
 	// If the above fails, test MySQL access simply using detected credentials.
 	if !accessOK {
-		if err := testConnection(userDSN.String()); err != nil {
+		if err := testConnection(ctx, userDSN.String()); err != nil {
 			err = fmt.Errorf("Cannot connect to MySQL: %s\n\n%s\n%s", err,
 				"Verify that MySQL user exists and has the correct privileges.",
 				"Use additional flags --user, --password, --host, --port, --socket if needed.")
@@ -132,7 +133,7 @@ func (a *Admin) DetectMySQL(mf MySQLFlags) (map[string]string, error) {
 
 	// Create a new MySQL user.
 	if mf.CreateUser {
-		userDSN, err = createMySQLUser(db, userDSN, mf)
+		userDSN, err = createMySQLUser(ctx, db, userDSN, mf)
 		if err != nil {
 			return nil, err
 		}
@@ -150,7 +151,7 @@ func (a *Admin) DetectMySQL(mf MySQLFlags) (map[string]string, error) {
 	return info, nil
 }
 
-func createMySQLUser(db *sql.DB, userDSN dsn.DSN, mf MySQLFlags) (dsn.DSN, error) {
+func createMySQLUser(ctx context.Context, db *sql.DB, userDSN dsn.DSN, mf MySQLFlags) (dsn.DSN, error) {
 	// New DSN has same host:port or socket, but different user and pass.
 	userDSN.Username = "pmm"
 	if mf.CreateUserPassword != "" {
@@ -183,8 +184,8 @@ func createMySQLUser(db *sql.DB, userDSN dsn.DSN, mf MySQLFlags) (dsn.DSN, error
 	}
 
 	// Verify new MySQL user works. If this fails, the new DSN or grant statements are wrong.
-	if err := testConnection(userDSN.String()); err != nil {
-		err = fmt.Errorf("Problem creating a new MySQL user. Insufficient privileges: %s", err)
+	if err := testConnection(ctx, userDSN.String()); err != nil {
+		err = fmt.Errorf("Problem creating a new MySQL user. Insufficient privileges: %w", err)
 		return dsn.DSN{}, err
 	}
 
@@ -253,14 +254,14 @@ func makeGrants(dsn dsn.DSN, hosts []string, conn uint16) []string {
 	return grants
 }
 
-func testConnection(dsn string) error {
+func testConnection(ctx context.Context, dsn string) error {
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	if err = db.Ping(); err != nil {
+	if err = db.PingContext(ctx); err != nil {
 		return err
 	}
 
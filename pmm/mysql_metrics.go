@@ -18,44 +18,45 @@
 package pmm
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
 
-	consul "github.com/hashicorp/consul/api"
 	"github.com/percona/kardianos-service"
 )
 
 // AddMySQLMetrics add mysql metrics service to monitoring.
-func (a *Admin) AddMySQLMetrics(info map[string]string, mf MySQLFlags) error {
+// Returned warnings are about things that did not fail adding the service.
+func (a *Admin) AddMySQLMetrics(ctx context.Context, info map[string]string, mf MySQLFlags) ([]string, error) {
 	serviceType := "mysql:metrics"
 
-	// Check if we have already this service on Consul.
-	consulSvc, err := a.getConsulService(serviceType, a.ServiceName)
+	// Check if we have already this service registered.
+	registered, err := a.getRegisteredService(ctx, serviceType, a.ServiceName)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if consulSvc != nil {
-		return ErrDuplicate
+	if registered != nil {
+		return nil, ErrDuplicate
 	}
 
-	if err := a.checkGlobalDuplicateService(serviceType, a.ServiceName); err != nil {
-		return err
+	if err := a.checkGlobalDuplicateService(ctx, serviceType, a.ServiceName); err != nil {
+		return nil, err
 	}
 
 	// Choose port.
 	port := 0
 	if a.ServicePort > 0 {
 		// The port is user defined.
-		port, err = a.choosePort(a.ServicePort, true)
+		port, err = a.choosePort(ctx, a.ServicePort, true)
 	} else {
 		// Choose first port available starting the given default one.
-		port, err = a.choosePort(42002, false)
+		port, err = a.choosePort(ctx, 42002, false)
 	}
 	// We consider the first port available as okay despite 3 mysql services.
 	// @todo What above comments means?
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Opts to disable.
@@ -75,21 +76,16 @@ func (a *Admin) AddMySQLMetrics(info map[string]string, mf MySQLFlags) error {
 	}
 	tags := []string{fmt.Sprintf("alias_%s", a.ServiceName), "scheme_https"}
 
-	// Add service to Consul.
+	// Register service.
 	serviceID := fmt.Sprintf("%s-%d", serviceType, port)
-	srv := consul.AgentService{
+	srv := RegistryService{
 		ID:      serviceID,
 		Service: serviceType,
-		Tags:    tags,
+		Tags:    a.labelTags(tags),
 		Port:    port,
 	}
-	reg := consul.CatalogRegistration{
-		Node:    a.Config.ClientName,
-		Address: a.Config.ClientAddress,
-		Service: &srv,
-	}
-	if _, err := a.consulAPI.Catalog().Register(&reg, nil); err != nil {
-		return err
+	if err := a.registerService(ctx, &srv); err != nil {
+		return nil, err
 	}
 
 	// Disable exporter options if set so.
@@ -104,26 +100,22 @@ func (a *Admin) AddMySQLMetrics(info map[string]string, mf MySQLFlags) error {
 			}
 		}
 
-		// Add info to Consul KV.
-		d := &consul.KVPair{Key: fmt.Sprintf("%s/%s/%s", a.Config.ClientName, serviceID, o),
-			Value: []byte("OFF")}
-		a.consulAPI.KV().Put(d, nil)
+		// Add info to service metadata.
+		a.registry().SetServiceMeta(ctx, a.Config.ClientName, serviceID, o, "OFF")
 	}
 
-	d := &consul.KVPair{Key: fmt.Sprintf("%s/%s/dsn", a.Config.ClientName, serviceID),
-		Value: []byte(info["safe_dsn"])}
-	a.consulAPI.KV().Put(d, nil)
+	a.registry().SetServiceMeta(ctx, a.Config.ClientName, serviceID, metaDSN, info["safe_dsn"])
 
 	// Check and generate certificate if needed.
 	if err := a.checkSSLCertificate(); err != nil {
-		return err
+		return nil, err
 	}
 
 	args = append(args,
-		fmt.Sprintf("-web.listen-address=%s:%d", a.Config.BindAddress, port),
-		fmt.Sprintf("-web.auth-file=%s", ConfigFile),
-		fmt.Sprintf("-web.ssl-cert-file=%s", SSLCertFile),
-		fmt.Sprintf("-web.ssl-key-file=%s", SSLKeyFile),
+		fmt.Sprintf("-web.listen-address=%s", hostPort(a.Config.BindAddress, port)),
+		fmt.Sprintf("-web.auth-file=%s", a.authFile()),
+		fmt.Sprintf("-web.ssl-cert-file=%s", a.paths().SSLCertFile),
+		fmt.Sprintf("-web.ssl-key-file=%s", a.paths().SSLKeyFile),
 	)
 	// Add additional args passed to pmm-admin
 	args = append(args, a.Args...)
@@ -133,44 +125,37 @@ func (a *Admin) AddMySQLMetrics(info map[string]string, mf MySQLFlags) error {
 		Name:        fmt.Sprintf("pmm-mysql-metrics-%d", port),
 		DisplayName: fmt.Sprintf("PMM Prometheus mysqld_exporter %d", port),
 		Description: fmt.Sprintf("PMM Prometheus mysqld_exporter %d", port),
-		Executable:  fmt.Sprintf("%s/mysqld_exporter", PMMBaseDir),
+		Executable:  fmt.Sprintf("%s/mysqld_exporter", a.paths().BaseDir),
 		Arguments:   args,
 		Environment: []string{fmt.Sprintf("DATA_SOURCE_NAME=%s", info["dsn"])},
 	}
-	if err := installService(svcConfig); err != nil {
-		return err
+	if err := a.installService(svcConfig); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return a.initHealthCheck(ctx, &srv), nil
 }
 
 // RemoveMySQLMetrics remove mysql metrics service from monitoring.
-func (a *Admin) RemoveMySQLMetrics() error {
+func (a *Admin) RemoveMySQLMetrics(ctx context.Context) error {
 	serviceType := "mysql:metrics"
 
-	// Check if we have this service on Consul.
-	consulSvc, err := a.getConsulService(serviceType, a.ServiceName)
+	// Check if we have this service registered.
+	registered, err := a.getRegisteredService(ctx, serviceType, a.ServiceName)
 	if err != nil {
 		return err
 	}
-	if consulSvc == nil {
+	if registered == nil {
 		return ErrNoService
 	}
 
-	// Remove service from Consul.
-	dereg := consul.CatalogDeregistration{
-		Node:      a.Config.ClientName,
-		ServiceID: consulSvc.ID,
-	}
-	if _, err := a.consulAPI.Catalog().Deregister(&dereg, nil); err != nil {
+	// Deregister service.
+	if err := a.registry().DeregisterService(ctx, a.Config.ClientName, registered.ID); err != nil {
 		return err
 	}
 
-	prefix := fmt.Sprintf("%s/%s/", a.Config.ClientName, consulSvc.ID)
-	a.consulAPI.KV().DeleteTree(prefix, nil)
-
 	// Stop and uninstall service.
-	if err := uninstallService(fmt.Sprintf("pmm-mysql-metrics-%d", consulSvc.Port)); err != nil {
+	if err := a.uninstallService(fmt.Sprintf("pmm-mysql-metrics-%d", registered.Port)); err != nil {
 		return err
 	}
 
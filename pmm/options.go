@@ -0,0 +1,127 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// Paths are the files and dirs of PMM client.
+// Empty ones default to the package variables, or to the standard layout under BaseDir if it is set.
+type Paths struct {
+	BaseDir          string // dir of exporters, PMMBaseDir by default
+	AgentBaseDir     string // dir of qan-agent
+	ConfigFile       string
+	SSLCertFile      string
+	SSLKeyFile       string
+	AuthFile         string // HTTP basic auth credentials of metric services running unprivileged
+	ServiceConfigDir string
+	RegistryFile     string // services of the file registry
+	LockFile         string // lock of mutating pmm-admin commands
+}
+
+// withDefaults returns paths with empty ones set to their defaults.
+func (p Paths) withDefaults() Paths {
+	if p.BaseDir == "" {
+		p.BaseDir = PMMBaseDir
+		if p.ConfigFile == "" {
+			p.ConfigFile = ConfigFile
+		}
+		if p.SSLCertFile == "" {
+			p.SSLCertFile = SSLCertFile
+		}
+		if p.SSLKeyFile == "" {
+			p.SSLKeyFile = SSLKeyFile
+		}
+		if p.AuthFile == "" {
+			p.AuthFile = AuthFile
+		}
+		if p.ServiceConfigDir == "" {
+			p.ServiceConfigDir = ServiceConfigDir
+		}
+		if p.RegistryFile == "" {
+			p.RegistryFile = RegistryFile
+		}
+		if p.LockFile == "" {
+			p.LockFile = LockFile
+		}
+	}
+	if p.AgentBaseDir == "" {
+		p.AgentBaseDir = AgentBaseDir
+	}
+	if p.ConfigFile == "" {
+		p.ConfigFile = filepath.Join(p.BaseDir, "pmm.yml")
+	}
+	if p.SSLCertFile == "" {
+		p.SSLCertFile = filepath.Join(p.BaseDir, "server.crt")
+	}
+	if p.SSLKeyFile == "" {
+		p.SSLKeyFile = filepath.Join(p.BaseDir, "server.key")
+	}
+	if p.AuthFile == "" {
+		p.AuthFile = filepath.Join(p.BaseDir, "auth.yml")
+	}
+	if p.ServiceConfigDir == "" {
+		p.ServiceConfigDir = filepath.Join(p.BaseDir, "services")
+	}
+	if p.RegistryFile == "" {
+		p.RegistryFile = filepath.Join(p.BaseDir, "registry.json")
+	}
+	if p.LockFile == "" {
+		p.LockFile = filepath.Join(p.BaseDir, "pmm-admin.lock")
+	}
+	return p
+}
+
+// paths returns the paths Admin works with.
+func (a *Admin) paths() Paths {
+	return a.Paths.withDefaults()
+}
+
+// Options are the settings of Admin used as a library.
+type Options struct {
+	Paths      Paths
+	Timeout    time.Duration     // timeout for PMM server API requests, APITimeout by default
+	Backoff    Backoff           // backoff for retrying idempotent requests, DefaultBackoff if zero, NoRetry disables retries
+	Verbose    bool              // dump requests and responses
+	Transport  http.RoundTripper // transport to PMM server, made of the config TLS and proxy settings by default
+	NewService ServiceFactory    // backend of native service manager, NewService by default
+
+	// ServiceManager installs and controls system services, the one of the config or detected by default.
+	ServiceManager ServiceManager
+	// Registry keeps services of the client, the one of the config by default.
+	Registry Registry
+}
+
+// New returns Admin with the options.
+// Its config is expected to be read with LoadConfig or set to Config before use.
+func New(opts Options) *Admin {
+	return &Admin{
+		Paths:      opts.Paths,
+		Timeout:    opts.Timeout,
+		Backoff:    opts.Backoff,
+		Verbose:    opts.Verbose,
+		Transport:  opts.Transport,
+		NewService: opts.NewService,
+
+		ServiceManager: opts.ServiceManager,
+		Registry:       opts.Registry,
+	}
+}
@@ -0,0 +1,52 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaths(t *testing.T) {
+	a := New(Options{})
+	assert.Equal(t, Paths{
+		BaseDir:          PMMBaseDir,
+		AgentBaseDir:     AgentBaseDir,
+		ConfigFile:       ConfigFile,
+		SSLCertFile:      SSLCertFile,
+		SSLKeyFile:       SSLKeyFile,
+		AuthFile:         AuthFile,
+		ServiceConfigDir: ServiceConfigDir,
+		RegistryFile:     RegistryFile,
+		LockFile:         LockFile,
+	}, a.paths())
+
+	a = New(Options{Paths: Paths{BaseDir: "/opt/pmm", ConfigFile: "/etc/pmm.yml"}})
+	assert.Equal(t, Paths{
+		BaseDir:          "/opt/pmm",
+		AgentBaseDir:     AgentBaseDir,
+		ConfigFile:       "/etc/pmm.yml",
+		SSLCertFile:      "/opt/pmm/server.crt",
+		SSLKeyFile:       "/opt/pmm/server.key",
+		AuthFile:         "/opt/pmm/auth.yml",
+		ServiceConfigDir: "/opt/pmm/services",
+		RegistryFile:     "/opt/pmm/registry.json",
+		LockFile:         "/opt/pmm/pmm-admin.lock",
+	}, a.paths())
+}
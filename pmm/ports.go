@@ -0,0 +1,236 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var (
+	// ephemeralPortRangeFile is the kernel's range of ports for outgoing connections.
+	ephemeralPortRangeFile = "/proc/sys/net/ipv4/ip_local_port_range"
+	// reservedPortsFile is the list of ports excluded from the ephemeral range.
+	reservedPortsFile = "/proc/sys/net/ipv4/ip_local_reserved_ports"
+)
+
+// portRange is the inclusive range of ports.
+type portRange struct {
+	min, max int
+}
+
+// contains check if port is in the range.
+func (r portRange) contains(port int) bool {
+	return port >= r.min && port <= r.max
+}
+
+func (r portRange) String() string {
+	if r.min == r.max {
+		return strconv.Itoa(r.min)
+	}
+	return fmt.Sprintf("%d-%d", r.min, r.max)
+}
+
+// parsePortRange parses the range like 42000-42999 or a single port.
+func parsePortRange(s string) (portRange, error) {
+	var r portRange
+	var err1, err2 error
+	parts := strings.SplitN(strings.TrimSpace(s), "-", 2)
+	r.min, err1 = strconv.Atoi(strings.TrimSpace(parts[0]))
+	r.max, err2 = r.min, nil
+	if len(parts) == 2 {
+		r.max, err2 = strconv.Atoi(strings.TrimSpace(parts[1]))
+	}
+	if err1 != nil || err2 != nil || r.min < 1 || r.max > 65535 || r.min > r.max {
+		return r, fmt.Errorf("Invalid port range %s, it should be like 42000-42999.", s)
+	}
+	return r, nil
+}
+
+// ephemeralPorts are the ports the kernel picks for outgoing connections,
+// a service listening on such a port fails to start if one of them took it.
+type ephemeralPorts struct {
+	portRange
+	reserved []portRange
+}
+
+// contains check if port is ephemeral, nil contains none.
+func (e *ephemeralPorts) contains(port int) bool {
+	if e == nil || !e.portRange.contains(port) {
+		return false
+	}
+	for _, r := range e.reserved {
+		if r.contains(port) {
+			return false
+		}
+	}
+	return true
+}
+
+// readEphemeralPorts returns the ephemeral ports of the kernel, nil if they are unknown.
+func readEphemeralPorts() *ephemeralPorts {
+	data, err := ioutil.ReadFile(ephemeralPortRangeFile)
+	if err != nil {
+		return nil
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return nil
+	}
+	r, err := parsePortRange(fields[0] + "-" + fields[1])
+	if err != nil {
+		return nil
+	}
+	e := &ephemeralPorts{portRange: r}
+	if data, err := ioutil.ReadFile(reservedPortsFile); err == nil {
+		for _, s := range strings.Split(strings.TrimSpace(string(data)), ",") {
+			if r, err := parsePortRange(s); err == nil {
+				e.reserved = append(e.reserved, r)
+			}
+		}
+	}
+	return e
+}
+
+// portInUse check if another process listens on the port of the bind address.
+// If it is not possible to check, the port is considered free.
+func portInUse(address string, port int) bool {
+	l, err := net.Listen("tcp", hostPort(address, port))
+	if err != nil {
+		return errors.Is(err, syscall.EADDRINUSE)
+	}
+	l.Close()
+	return false
+}
+
+// allowedPortRange returns the range of ports services are allowed to use, nil if any port is allowed.
+func (a *Admin) allowedPortRange() (*portRange, error) {
+	if a.Config.PortRange == "" {
+		return nil, nil
+	}
+	r, err := parsePortRange(a.Config.PortRange)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// registeredPorts returns the ports of registered services of this client.
+func (a *Admin) registeredPorts(ctx context.Context) (map[int]bool, error) {
+	node, err := a.registry().ListNodeServices(ctx, a.Config.ClientName)
+	if err != nil {
+		return nil, err
+	}
+	ports := map[int]bool{}
+	if node != nil {
+		for _, svc := range node.Services {
+			ports[svc.Port] = true
+		}
+	}
+	return ports, nil
+}
+
+// choosePort automatically choose the port for service.
+// It should not be registered by another service of this client, be in the allowed range if it is configured
+// and not be listened on by another process. Ephemeral ports are only chosen if there are no others,
+// as the default ports are in the default ephemeral range of Linux unless they are reserved.
+func (a *Admin) choosePort(ctx context.Context, port int, userDefined bool) (int, error) {
+	registered, err := a.registeredPorts(ctx)
+	if err != nil {
+		return port, err
+	}
+	allowed, err := a.allowedPortRange()
+	if err != nil {
+		return port, err
+	}
+
+	// Check if user defined port is not used.
+	if userDefined {
+		if registered[port] {
+			return port, fmt.Errorf("port %d is reserved by other service. Choose the different one.", port)
+		}
+		if allowed != nil && !allowed.contains(port) {
+			return port, fmt.Errorf("port %d is out of the allowed range %s. Choose the different one.", port, allowed)
+		}
+		if portInUse(a.Config.BindAddress, port) {
+			return port, fmt.Errorf("port %d is in use by other process on %s. Choose the different one.", port, a.Config.BindAddress)
+		}
+		return port, nil
+	}
+
+	// Scan the allowed range starting the default port if it is there, or 1000 ports starting the default one.
+	scan := portRange{port, port + 999}
+	if scan.max > 65535 {
+		scan.max = 65535
+	}
+	if allowed != nil {
+		scan = *allowed
+	}
+	start := scan.min
+	if scan.contains(port) {
+		start = port
+	}
+	ports := make([]int, 0, scan.max-scan.min+1)
+	for i := start; i <= scan.max; i++ {
+		ports = append(ports, i)
+	}
+	for i := scan.min; i < start; i++ {
+		ports = append(ports, i)
+	}
+
+	ephemeral := readEphemeralPorts()
+	var free []int
+	var reserved, inUse int
+	for _, i := range ports {
+		switch {
+		case registered[i]:
+			reserved++
+		case ephemeral.contains(i):
+			free = append(free, i)
+		case portInUse(a.Config.BindAddress, i):
+			inUse++
+		default:
+			return i, nil
+		}
+	}
+	for _, i := range free {
+		if !portInUse(a.Config.BindAddress, i) {
+			return i, nil
+		}
+		inUse++
+	}
+
+	var reasons []string
+	if reserved > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d reserved by other services", reserved))
+	}
+	if inUse > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d in use by other processes on %s", inUse, a.Config.BindAddress))
+	}
+	hint := "Try to specify the other port using --service-port"
+	if allowed != nil {
+		hint += " or change the allowed range using 'pmm-admin config --port-range'"
+	}
+	return port, fmt.Errorf("ports %s are not available: %s. %s.", scan, strings.Join(reasons, ", "), hint)
+}
@@ -0,0 +1,133 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePortRange(t *testing.T) {
+	for s, expected := range map[string]portRange{
+		"42000-42999":    {42000, 42999},
+		" 42000 - 42001": {42000, 42001},
+		"42000":          {42000, 42000},
+	} {
+		r, err := parsePortRange(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, expected, r, s)
+	}
+	for _, s := range []string{"", "abc", "42001-42000", "0-10", "65000-70000", "1-2-3"} {
+		_, err := parsePortRange(s)
+		assert.EqualError(t, err, fmt.Sprintf("Invalid port range %s, it should be like 42000-42999.", s))
+	}
+}
+
+func TestEphemeralPorts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmm-client")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	defer func(f1, f2 string) { ephemeralPortRangeFile, reservedPortsFile = f1, f2 }(ephemeralPortRangeFile, reservedPortsFile)
+	ephemeralPortRangeFile = filepath.Join(dir, "ip_local_port_range")
+	reservedPortsFile = filepath.Join(dir, "ip_local_reserved_ports")
+
+	assert.Nil(t, readEphemeralPorts())
+	assert.False(t, readEphemeralPorts().contains(42000))
+
+	assert.Nil(t, ioutil.WriteFile(ephemeralPortRangeFile, []byte("32768\t60999\n"), 0644))
+	e := readEphemeralPorts()
+	assert.True(t, e.contains(42000))
+	assert.False(t, e.contains(9100))
+
+	assert.Nil(t, ioutil.WriteFile(reservedPortsFile, []byte("42000-42005,50000\n"), 0644))
+	e = readEphemeralPorts()
+	assert.False(t, e.contains(42000))
+	assert.False(t, e.contains(50000))
+	assert.True(t, e.contains(42006))
+}
+
+func TestChoosePort(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "pmm-client")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	defer func(f string) { ephemeralPortRangeFile = f }(ephemeralPortRangeFile)
+	ephemeralPortRangeFile = filepath.Join(dir, "ip_local_port_range")
+
+	// The first port is in use by another process, the second one is registered.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+	if port+3 > 65535 {
+		t.Skip("no ports after the listened one")
+	}
+	registry := &FileRegistry{File: filepath.Join(dir, "registry.json")}
+	svc := &RegistryService{ID: "mysql:metrics-1", Service: "mysql:metrics", Port: port + 1}
+	assert.Nil(t, registry.RegisterService(ctx, "db01", "127.0.0.1", svc))
+	admin := &Admin{
+		Config:   &Config{ClientName: "db01", BindAddress: "127.0.0.1", PortRange: fmt.Sprintf("%d-%d", port, port+3)},
+		Registry: registry,
+	}
+
+	p, err := admin.choosePort(ctx, port, false)
+	assert.Nil(t, err)
+	assert.Equal(t, port+2, p)
+
+	// Ephemeral ports are chosen only if there are no others.
+	assert.Nil(t, ioutil.WriteFile(ephemeralPortRangeFile, []byte(fmt.Sprintf("%d %d\n", port+2, port+2)), 0644))
+	p, err = admin.choosePort(ctx, port, false)
+	assert.Nil(t, err)
+	assert.Equal(t, port+3, p)
+	assert.Nil(t, ioutil.WriteFile(ephemeralPortRangeFile, []byte(fmt.Sprintf("%d %d\n", port, port+3)), 0644))
+	p, err = admin.choosePort(ctx, port+3, false)
+	assert.Nil(t, err)
+	assert.Equal(t, port+3, p)
+
+	// The default port out of the allowed range.
+	p, err = admin.choosePort(ctx, 42000, false)
+	assert.Nil(t, err)
+	assert.Equal(t, port+2, p)
+
+	// User defined ports.
+	_, err = admin.choosePort(ctx, port, true)
+	assert.EqualError(t, err, fmt.Sprintf("port %d is in use by other process on 127.0.0.1. Choose the different one.", port))
+	_, err = admin.choosePort(ctx, port+1, true)
+	assert.EqualError(t, err, fmt.Sprintf("port %d is reserved by other service. Choose the different one.", port+1))
+	_, err = admin.choosePort(ctx, port+4, true)
+	assert.EqualError(t, err, fmt.Sprintf("port %d is out of the allowed range %d-%d. Choose the different one.", port+4, port, port+3))
+	p, err = admin.choosePort(ctx, port+3, true)
+	assert.Nil(t, err)
+	assert.Equal(t, port+3, p)
+
+	// Exhausted range.
+	admin.Config.PortRange = fmt.Sprintf("%d-%d", port, port+1)
+	_, err = admin.choosePort(ctx, port, false)
+	expected := fmt.Sprintf("ports %d-%d are not available: 1 reserved by other services, 1 in use by other processes on 127.0.0.1. "+
+		"Try to specify the other port using --service-port or change the allowed range using 'pmm-admin config --port-range'.", port, port+1)
+	assert.EqualError(t, err, expected)
+}
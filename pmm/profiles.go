@@ -0,0 +1,360 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// ServerProfile is a named PMM server in pmm.yml, used instead of the default one with --server-profile flag.
+type ServerProfile struct {
+	ServerAddress       string `yaml:"server_address"`
+	ServerUser          string `yaml:"server_user,omitempty"`
+	ServerPassword      string `yaml:"server_password,omitempty"`
+	ServerSSL           bool   `yaml:"server_ssl,omitempty"`
+	ServerInsecureSSL   bool   `yaml:"server_insecure_ssl,omitempty"`
+	ServerCAFile        string `yaml:"server_ca_file,omitempty"`
+	ServerClientCert    string `yaml:"server_client_cert,omitempty"`
+	ServerClientKey     string `yaml:"server_client_key,omitempty"`
+	ServerProxy         string `yaml:"server_proxy,omitempty"`
+	ServerProxyUser     string `yaml:"server_proxy_user,omitempty"`
+	ServerProxyPassword string `yaml:"server_proxy_password,omitempty"`
+	ServerNoProxy       string `yaml:"server_no_proxy,omitempty"`
+	ConsulToken         string `yaml:"consul_token,omitempty"`
+	ConsulTokenFile     string `yaml:"consul_token_file,omitempty"`
+	Mirror              bool   `yaml:"mirror,omitempty"` // metric services of the default server are registered here too
+}
+
+// ServerProfileStatus is the state of this client on PMM server of a profile.
+type ServerProfileStatus struct {
+	Name          string
+	ServerAddress string
+	Mirror        bool
+	Services      int // services of this client registered on the server
+	Err           string
+}
+
+// serverProfile returns server settings of the config.
+func (c *Config) serverProfile() ServerProfile {
+	return ServerProfile{
+		ServerAddress:       c.ServerAddress,
+		ServerUser:          c.ServerUser,
+		ServerPassword:      c.ServerPassword,
+		ServerSSL:           c.ServerSSL,
+		ServerInsecureSSL:   c.ServerInsecureSSL,
+		ServerCAFile:        c.ServerCAFile,
+		ServerClientCert:    c.ServerClientCert,
+		ServerClientKey:     c.ServerClientKey,
+		ServerProxy:         c.ServerProxy,
+		ServerProxyUser:     c.ServerProxyUser,
+		ServerProxyPassword: c.ServerProxyPassword,
+		ServerNoProxy:       c.ServerNoProxy,
+		ConsulToken:         c.ConsulToken,
+		ConsulTokenFile:     c.ConsulTokenFile,
+	}
+}
+
+// setServerProfile replace server settings of the config.
+func (c *Config) setServerProfile(p ServerProfile) {
+	c.ServerAddress = p.ServerAddress
+	c.ServerUser = p.ServerUser
+	c.ServerPassword = p.ServerPassword
+	c.ServerSSL = p.ServerSSL
+	c.ServerInsecureSSL = p.ServerInsecureSSL
+	c.ServerCAFile = p.ServerCAFile
+	c.ServerClientCert = p.ServerClientCert
+	c.ServerClientKey = p.ServerClientKey
+	c.ServerProxy = p.ServerProxy
+	c.ServerProxyUser = p.ServerProxyUser
+	c.ServerProxyPassword = p.ServerProxyPassword
+	c.ServerNoProxy = p.ServerNoProxy
+	c.ConsulToken = p.ConsulToken
+	c.ConsulTokenFile = p.ConsulTokenFile
+}
+
+// useServerProfile switch the config to the selected server profile.
+// A missing profile starts empty to be set up with 'pmm-admin config'.
+func (a *Admin) useServerProfile() error {
+	if match, _ := regexp.MatchString(NameRegex, a.ServerProfile); !match {
+		return errors.New("Server profile name must be 2 to 60 characters long, contain only letters, numbers and symbols _ - . :")
+	}
+	a.defaultServer = a.Config.serverProfile()
+	a.Config.setServerProfile(a.Config.ServerProfiles[a.ServerProfile])
+	return nil
+}
+
+// ServerProfileExists check if the selected server profile is in the config, the default server always is.
+func (a *Admin) ServerProfileExists() bool {
+	if a.ServerProfile == "" {
+		return true
+	}
+	_, ok := a.Config.ServerProfiles[a.ServerProfile]
+	return ok
+}
+
+// configToWrite returns the config to write with the selected profile moved back to its own section.
+func (a *Admin) configToWrite() Config {
+	config := *a.Config
+	if a.ServerProfile == "" {
+		return config
+	}
+	config.ServerProfiles = map[string]ServerProfile{}
+	for name, p := range a.Config.ServerProfiles {
+		config.ServerProfiles[name] = p
+	}
+	p := a.Config.serverProfile()
+	p.Mirror = a.Config.ServerProfiles[a.ServerProfile].Mirror
+	config.ServerProfiles[a.ServerProfile] = p
+	config.setServerProfile(a.defaultServer)
+	return config
+}
+
+// SetServerProfileMirror set whether metric services of the default server are registered on the selected server profile too,
+// and sync them if so. The auth file keeps credentials of every server, so the mirror may use its own.
+// Returned warnings are about exporters which accept HTTP basic auth credentials of the default server only.
+func (a *Admin) SetServerProfileMirror(ctx context.Context, mirror bool) ([]string, error) {
+	if a.ServerProfile == "" {
+		return nil, errors.New("Flag --mirror requires --server-profile flag.")
+	}
+	var warnings []string
+	if mirror && (a.Config.ServerUser != a.defaultServer.ServerUser || a.Config.ServerPassword != a.defaultServer.ServerPassword) {
+		warnings = append(warnings, fmt.Sprintf(`WARNING: server profile %s uses HTTP credentials other than the default server.
+pmm-client:metrics accepts credentials of every server, but exporters accept the ones of the default server only.`, a.ServerProfile))
+	}
+	if a.Config.ServerProfiles == nil {
+		a.Config.ServerProfiles = map[string]ServerProfile{}
+	}
+	p := a.Config.ServerProfiles[a.ServerProfile]
+	p.Mirror = mirror
+	a.Config.ServerProfiles[a.ServerProfile] = p
+	if err := a.writeConfig(); err != nil {
+		return nil, fmt.Errorf("Unable to write config file %s: %w", a.paths().ConfigFile, err)
+	}
+	if !mirror {
+		return nil, nil
+	}
+	return warnings, a.SyncMirrors(ctx)
+}
+
+// serverProfileNames returns names of server profiles other than the selected one, "" stands for the default server.
+func (a *Admin) serverProfileNames() []string {
+	var names []string
+	if a.ServerProfile != "" {
+		names = append(names, "")
+	}
+	for name := range a.Config.ServerProfiles {
+		if name != a.ServerProfile {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// serverProfileByName returns server profile by name, "" stands for the default server.
+func (a *Admin) serverProfileByName(name string) ServerProfile {
+	switch name {
+	case a.ServerProfile:
+		// The selected one may be changed and not written yet.
+		p := a.Config.serverProfile()
+		p.Mirror = a.Config.ServerProfiles[name].Mirror
+		return p
+	case "":
+		return a.defaultServer
+	default:
+		return a.Config.ServerProfiles[name]
+	}
+}
+
+// serverAdmin returns Admin of this client working with PMM server of the profile, "" stands for the default server.
+func (a *Admin) serverAdmin(ctx context.Context, name string) (*Admin, error) {
+	config := *a.Config
+	config.setServerProfile(a.serverProfileByName(name))
+	b := &Admin{
+		ServiceName:    a.ServiceName,
+		ServicePort:    a.ServicePort,
+		Args:           a.Args,
+		Config:         &config,
+		Verbose:        a.Verbose,
+		Format:         a.Format,
+		Timeout:        a.Timeout,
+		Backoff:        a.Backoff,
+		ServerProfile:  name,
+		Paths:          a.Paths,
+		Transport:      a.Transport,
+		NewService:     a.NewService,
+		ServiceManager: a.ServiceManager,
+		Limits:         a.Limits,
+		Labels:         a.Labels,
+		Registry:       a.Registry,
+		defaultServer:  a.serverProfileByName(""),
+	}
+	if err := b.SetAPI(ctx); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// isMirroredService check if service is mirrored to the other servers.
+// Queries services are not as qan-agent reports to a single server.
+func isMirroredService(svcType string) bool {
+	return svcType != "consul" && !strings.HasSuffix(svcType, ":queries")
+}
+
+// SyncMirrors register metric services of this client on mirror server profiles the same way as on the default server,
+// and deregister the ones which are not there anymore.
+func (a *Admin) SyncMirrors(ctx context.Context) error {
+	var mirrors []string
+	for name, p := range a.Config.ServerProfiles {
+		if p.Mirror {
+			mirrors = append(mirrors, name)
+		}
+	}
+	if len(mirrors) == 0 {
+		return nil
+	}
+	sort.Strings(mirrors)
+
+	src := a
+	if a.ServerProfile != "" {
+		var err error
+		if src, err = a.serverAdmin(ctx, ""); err != nil {
+			return fmt.Errorf("default server: %w", err)
+		}
+	}
+	node, err := src.registry().ListNodeServices(ctx, a.Config.ClientName)
+	if err != nil {
+		return fmt.Errorf("default server: %w", err)
+	}
+	services := map[string]*RegistryService{}
+	meta := map[string]map[string]string{}
+	if node != nil {
+		for _, svc := range node.Services {
+			if !isMirroredService(svc.Service) {
+				continue
+			}
+			services[svc.ID] = svc
+			if meta[svc.ID], err = src.registry().ListServiceMeta(ctx, a.Config.ClientName, svc.ID, ""); err != nil {
+				return fmt.Errorf("default server: %w", err)
+			}
+		}
+	}
+
+	var errs Errors
+	for _, name := range mirrors {
+		dst := a
+		if name != a.ServerProfile {
+			if dst, err = a.serverAdmin(ctx, name); err != nil {
+				errs = append(errs, fmt.Errorf("server profile %s: %w", name, err))
+				continue
+			}
+		}
+		if err := dst.mirrorServices(ctx, services, meta); err != nil {
+			errs = append(errs, fmt.Errorf("server profile %s: %w", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// mirrorServices make mirrored services of this client and their metadata the same as given.
+func (a *Admin) mirrorServices(ctx context.Context, services map[string]*RegistryService, meta map[string]map[string]string) error {
+	node, err := a.registry().ListNodeServices(ctx, a.Config.ClientName)
+	if err != nil {
+		return err
+	}
+	if node != nil {
+		for _, svc := range node.Services {
+			if !isMirroredService(svc.Service) || services[svc.ID] != nil {
+				continue
+			}
+			if err := a.registry().DeregisterService(ctx, a.Config.ClientName, svc.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, svc := range services {
+		if err := a.registerService(ctx, svc); err != nil {
+			return err
+		}
+		for _, key := range sortedKeys(meta[svc.ID]) {
+			if err := a.registry().SetServiceMeta(ctx, a.Config.ClientName, svc.ID, key, meta[svc.ID][key]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ServerProfilesStatus returns the state of this client on PMM servers of the profiles other than the selected one.
+func (a *Admin) ServerProfilesStatus(ctx context.Context) []ServerProfileStatus {
+	var statuses []ServerProfileStatus
+	for _, name := range a.serverProfileNames() {
+		p := a.serverProfileByName(name)
+		status := ServerProfileStatus{
+			Name:          name,
+			ServerAddress: p.ServerAddress,
+			Mirror:        p.Mirror,
+		}
+		if status.Name == "" {
+			status.Name = "(default)"
+		}
+		b, err := a.serverAdmin(ctx, name)
+		if err == nil {
+			var node *RegistryNode
+			if node, err = b.registry().ListNodeServices(ctx, a.Config.ClientName); err == nil && node != nil {
+				status.Services = len(node.Services)
+			}
+		}
+		if err != nil {
+			status.Err = strings.Split(err.Error(), "\n")[0]
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// serverProfilesTable formats server profile statuses as table.
+func serverProfilesTable(statuses []ServerProfileStatus) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVER PROFILE\tSERVER ADDRESS\tMIRROR\tSERVICES\tSTATUS")
+	for _, s := range statuses {
+		mirror := "NO"
+		if s.Mirror {
+			mirror = "YES"
+		}
+		services, status := fmt.Sprintf("%d", s.Services), "OK"
+		if s.Err != "" {
+			services, status = "-", "DOWN: "+s.Err
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.Name, s.ServerAddress, mirror, services, status)
+	}
+	w.Flush()
+	return buf.String()
+}
@@ -0,0 +1,182 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/percona/pmm-client/test/fakeapi"
+	"github.com/percona/pmm-client/test/fakeserver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerProfileConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	defer func(configFile string) { ConfigFile = configFile }(ConfigFile)
+	ConfigFile = filepath.Join(dir, "pmm.yml")
+	data := `server_address: prod.example.com
+client_address: 10.0.0.1
+client_name: db01
+server_user: pmm
+server_password: secret
+server_profiles:
+  staging:
+    server_address: staging.example.com
+    mirror: true
+`
+	assert.Nil(t, ioutil.WriteFile(ConfigFile, []byte(data), 0600))
+
+	admin := &Admin{ServerProfile: "staging"}
+	assert.Nil(t, admin.LoadConfig())
+	assert.True(t, admin.ServerProfileExists())
+	assert.Equal(t, "staging.example.com", admin.Config.ServerAddress)
+	assert.Equal(t, "", admin.Config.ServerUser)
+	assert.Equal(t, "db01", admin.Config.ClientName)
+	assert.Equal(t, []string{""}, admin.serverProfileNames())
+
+	// Changes of the selected profile are written to its section, the default server is kept.
+	admin.Config.ServerUser = "pmm"
+	admin.Config.ServerPassword = "secret"
+	assert.Nil(t, admin.writeConfig())
+	assert.Nil(t, admin.LoadConfig())
+	assert.Equal(t, ServerProfile{ServerAddress: "staging.example.com", ServerUser: "pmm", ServerPassword: "secret", Mirror: true},
+		admin.Config.ServerProfiles["staging"])
+
+	admin = &Admin{}
+	assert.Nil(t, admin.LoadConfig())
+	assert.Equal(t, "prod.example.com", admin.Config.ServerAddress)
+	assert.Equal(t, []string{"staging"}, admin.serverProfileNames())
+
+	admin = &Admin{ServerProfile: "test"}
+	assert.Nil(t, admin.LoadConfig())
+	assert.False(t, admin.ServerProfileExists())
+	assert.Equal(t, "", admin.Config.ServerAddress)
+
+	admin = &Admin{ServerProfile: "bad profile"}
+	assert.Error(t, admin.LoadConfig())
+}
+
+func TestMirrorServices(t *testing.T) {
+	api := fakeapi.New()
+	defer api.Close()
+
+	api.Append("/v1/catalog/node/db01", func(w http.ResponseWriter, r *http.Request) {
+		node := consul.CatalogNode{
+			Node: &consul.Node{Node: "db01"},
+			Services: map[string]*consul.AgentService{
+				"linux:metrics-42000": {ID: "linux:metrics-42000", Service: "linux:metrics", Port: 42000},
+				"mysql:metrics-42002": {ID: "mysql:metrics-42002", Service: "mysql:metrics", Port: 42002},
+				"mysql:queries-0":     {ID: "mysql:queries-0", Service: "mysql:queries"},
+			},
+		}
+		data, _ := json.Marshal(node)
+		w.Write(data)
+	})
+	var registered, deregistered, put, deleted []string
+	api.Append("/v1/catalog/register", func(w http.ResponseWriter, r *http.Request) {
+		var reg consul.CatalogRegistration
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &reg)
+		registered = append(registered, reg.Service.ID)
+		w.Write([]byte("true"))
+	})
+	api.Append("/v1/catalog/deregister", func(w http.ResponseWriter, r *http.Request) {
+		var dereg consul.CatalogDeregistration
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &dereg)
+		deregistered = append(deregistered, dereg.ServiceID)
+		w.Write([]byte("true"))
+	})
+	api.Append("/v1/kv/", func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		if r.Method == "DELETE" {
+			deleted = append(deleted, key)
+		} else {
+			put = append(put, key)
+		}
+		w.Write([]byte("true"))
+	})
+
+	admin := &Admin{Config: &Config{ClientName: "db01", ClientAddress: "10.0.0.1"}}
+	admin.consulAPI, _ = consul.NewClient(&consul.Config{Address: strings.TrimPrefix(api.URL(), "http://")})
+
+	services := map[string]*RegistryService{
+		"linux:metrics-42000": {ID: "linux:metrics-42000", Service: "linux:metrics", Port: 42000},
+	}
+	meta := map[string]map[string]string{
+		"linux:metrics-42000": {"db01/opts": "a"},
+		"mysql:metrics-42002": {"db01/dsn": "b"},
+	}
+	assert.Nil(t, admin.mirrorServices(context.Background(), services, meta))
+
+	// Queries service is left as is.
+	assert.Equal(t, []string{"mysql:metrics-42002"}, deregistered)
+	assert.Equal(t, []string{"db01/mysql:metrics-42002/"}, deleted)
+	assert.Equal(t, []string{"linux:metrics-42000"}, registered)
+	assert.Equal(t, []string{"db01/linux:metrics-42000/db01/opts"}, put)
+}
+
+func TestServerAdmin(t *testing.T) {
+	ctx := context.Background()
+	srv := fakeserver.New()
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "pmm-client")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// Admin of the other server works with the same local files, services and registry.
+	services := newFakeServices()
+	registry := &FileRegistry{File: filepath.Join(dir, "registry.json")}
+	admin := New(Options{Paths: Paths{BaseDir: dir}, NewService: services.New, Registry: registry, Backoff: Backoff{Retries: 1, Delay: time.Millisecond}})
+	admin.Config = &Config{
+		ServerAddress:  "prod.example.com",
+		ClientName:     "db01",
+		ServerProfiles: map[string]ServerProfile{"staging": {ServerAddress: srv.Address(), Mirror: true}},
+	}
+	b, err := admin.serverAdmin(ctx, "staging")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, srv.Address(), b.Config.ServerAddress)
+	assert.Equal(t, admin.Paths, b.Paths)
+	assert.Equal(t, admin.Backoff, b.Backoff)
+	assert.Equal(t, registry, b.registry())
+	assert.NotNil(t, b.NewService)
+	assert.Equal(t, "prod.example.com", b.defaultServer.ServerAddress)
+}
+
+func TestValidCredentials(t *testing.T) {
+	credentials := []string{"pmm:prod", "pmm:staging"}
+	assert.True(t, validCredentials(credentials, "pmm:prod"))
+	assert.True(t, validCredentials(credentials, "pmm:staging"))
+	assert.False(t, validCredentials(credentials, "pmm:test"))
+	assert.False(t, validCredentials(nil, "pmm:prod"))
+}
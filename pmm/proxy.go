@@ -0,0 +1,157 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// proxyFunc returns a function selecting the proxy for requests to PMM server.
+// Without proxy in the config, HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used.
+func (a *Admin) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	if a.Config.ServerProxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	proxyURL, err := a.proxyURL()
+	if err != nil {
+		return nil, err
+	}
+	noProxy := a.Config.ServerNoProxy
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(req.URL.Host, noProxy) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}, nil
+}
+
+// proxyURL returns proxy URL from the config including credentials.
+func (a *Admin) proxyURL() (*url.URL, error) {
+	proxyURL, err := parseProxy(a.Config.ServerProxy)
+	if err != nil {
+		return nil, err
+	}
+	if a.Config.ServerProxyUser != "" {
+		proxyURL.User = url.UserPassword(a.Config.ServerProxyUser, a.Config.ServerProxyPassword)
+	}
+	return proxyURL, nil
+}
+
+// proxyEnv returns environment variables to pass the proxy config to the other programs like qan-agent.
+func (a *Admin) proxyEnv() []string {
+	if a.Config.ServerProxy == "" {
+		return nil
+	}
+	proxyURL, err := a.proxyURL()
+	if err != nil {
+		return nil
+	}
+	env := []string{
+		fmt.Sprintf("HTTP_PROXY=%s", proxyURL),
+		fmt.Sprintf("HTTPS_PROXY=%s", proxyURL),
+	}
+	if a.Config.ServerNoProxy != "" {
+		env = append(env, fmt.Sprintf("NO_PROXY=%s", a.Config.ServerNoProxy))
+	}
+	return env
+}
+
+// serverProxy returns the proxy used to reach PMM server or nil if connection is direct.
+func (a *Admin) serverProxy() *url.URL {
+	proxy, err := a.proxyFunc()
+	if err != nil {
+		return nil
+	}
+	req, err := http.NewRequest("GET", a.serverURL, nil)
+	if err != nil {
+		return nil
+	}
+	proxyURL, _ := proxy(req)
+	return proxyURL
+}
+
+// parseProxy parse proxy address, the scheme defaults to http.
+func parseProxy(proxy string) (*url.URL, error) {
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	proxyURL, err := url.Parse(proxy)
+	if err != nil || proxyURL.Host == "" {
+		return nil, fmt.Errorf("Invalid proxy address %s, it should be in the form of [scheme://]host:port.", proxy)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("Unsupported proxy scheme %s, use http, https or socks5.", proxyURL.Scheme)
+	}
+	return proxyURL, nil
+}
+
+// bypassProxy check if host should be reached directly according to comma-separated no-proxy list.
+// The list may contain host names, domain suffixes, IP addresses, CIDR ranges and "*" to bypass all.
+// Loopback addresses are always reached directly.
+func bypassProxy(hostport, noProxy string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.IsLoopback() {
+		return true
+	}
+
+	for _, p := range strings.Split(noProxy, ",") {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		if p == "*" {
+			return true
+		}
+		if _, ipNet, err := net.ParseCIDR(p); err == nil {
+			if ip != nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, _, err := net.SplitHostPort(p); err == nil {
+			p = h
+		}
+		p = strings.Trim(p, "[]")
+		if pIP := net.ParseIP(p); pIP != nil {
+			if ip != nil && pIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		// Domain matches itself and its subdomains, leading dot is optional.
+		p = strings.TrimPrefix(p, ".")
+		if host == p || strings.HasSuffix(host, "."+p) {
+			return true
+		}
+	}
+	return false
+}
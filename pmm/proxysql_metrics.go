@@ -18,73 +18,67 @@
 package pmm
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/go-sql-driver/mysql"
-	consul "github.com/hashicorp/consul/api"
 	"github.com/percona/kardianos-service"
 )
 
 // AddProxySQLMetrics add proxysql service to monitoring.
-func (a *Admin) AddProxySQLMetrics(dsn string) error {
-	// Check if we have already this service on Consul.
-	consulSvc, err := a.getConsulService("proxysql:metrics", a.ServiceName)
+// Returned warnings are about things that did not fail adding the service.
+func (a *Admin) AddProxySQLMetrics(ctx context.Context, dsn string) ([]string, error) {
+	// Check if we have already this service registered.
+	registered, err := a.getRegisteredService(ctx, "proxysql:metrics", a.ServiceName)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if consulSvc != nil {
-		return ErrDuplicate
+	if registered != nil {
+		return nil, ErrDuplicate
 	}
 
-	if err := a.checkGlobalDuplicateService("proxysql:metrics", a.ServiceName); err != nil {
-		return err
+	if err := a.checkGlobalDuplicateService(ctx, "proxysql:metrics", a.ServiceName); err != nil {
+		return nil, err
 	}
 
 	// Choose port.
 	var port int
 	if a.ServicePort > 0 {
 		// The port is user defined.
-		port, err = a.choosePort(a.ServicePort, true)
+		port, err = a.choosePort(ctx, a.ServicePort, true)
 	} else {
 		// Choose first port available starting the given default one.
-		port, err = a.choosePort(42004, false)
+		port, err = a.choosePort(ctx, 42004, false)
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Add service to Consul.
+	// Register service.
 	serviceID := fmt.Sprintf("proxysql:metrics-%d", port)
-	srv := consul.AgentService{
+	srv := RegistryService{
 		ID:      serviceID,
 		Service: "proxysql:metrics",
-		Tags:    []string{fmt.Sprintf("alias_%s", a.ServiceName), "scheme_https"},
+		Tags:    a.labelTags([]string{fmt.Sprintf("alias_%s", a.ServiceName), "scheme_https"}),
 		Port:    port,
 	}
-	reg := consul.CatalogRegistration{
-		Node:    a.Config.ClientName,
-		Address: a.Config.ClientAddress,
-		Service: &srv,
-	}
-	if _, err := a.consulAPI.Catalog().Register(&reg, nil); err != nil {
-		return err
+	if err := a.registerService(ctx, &srv); err != nil {
+		return nil, err
 	}
 
-	// Add info to Consul KV.
-	d := &consul.KVPair{Key: fmt.Sprintf("%s/%s/dsn", a.Config.ClientName, serviceID),
-		Value: []byte(SanitizeDSN(dsn))}
-	a.consulAPI.KV().Put(d, nil)
+	// Add info to service metadata.
+	a.registry().SetServiceMeta(ctx, a.Config.ClientName, serviceID, metaDSN, SanitizeDSN(dsn))
 
 	// Check and generate certificate if needed.
 	if err := a.checkSSLCertificate(); err != nil {
-		return err
+		return nil, err
 	}
 
 	args := []string{
-		fmt.Sprintf("-web.listen-address=%s:%d", a.Config.BindAddress, port),
-		fmt.Sprintf("-web.auth-file=%s", ConfigFile),
-		fmt.Sprintf("-web.ssl-cert-file=%s", SSLCertFile),
-		fmt.Sprintf("-web.ssl-key-file=%s", SSLKeyFile),
+		fmt.Sprintf("-web.listen-address=%s", hostPort(a.Config.BindAddress, port)),
+		fmt.Sprintf("-web.auth-file=%s", a.authFile()),
+		fmt.Sprintf("-web.ssl-cert-file=%s", a.paths().SSLCertFile),
+		fmt.Sprintf("-web.ssl-key-file=%s", a.paths().SSLKeyFile),
 	}
 	// Add additional args passed to pmm-admin
 	args = append(args, a.Args...)
@@ -94,42 +88,35 @@ func (a *Admin) AddProxySQLMetrics(dsn string) error {
 		Name:        fmt.Sprintf("pmm-proxysql-metrics-%d", port),
 		DisplayName: "PMM Prometheus proxysql_exporter",
 		Description: "PMM Prometheus proxysql_exporter",
-		Executable:  fmt.Sprintf("%s/proxysql_exporter", PMMBaseDir),
+		Executable:  fmt.Sprintf("%s/proxysql_exporter", a.paths().BaseDir),
 		Arguments:   args,
 		Environment: []string{fmt.Sprintf("DATA_SOURCE_NAME=%s", dsn)},
 	}
-	if err := installService(svcConfig); err != nil {
-		return err
+	if err := a.installService(svcConfig); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return a.initHealthCheck(ctx, &srv), nil
 }
 
 // RemoveProxySQLMetrics remove proxysql service from monitoring.
-func (a *Admin) RemoveProxySQLMetrics() error {
-	// Check if we have this service on Consul.
-	consulSvc, err := a.getConsulService("proxysql:metrics", a.ServiceName)
+func (a *Admin) RemoveProxySQLMetrics(ctx context.Context) error {
+	// Check if we have this service registered.
+	registered, err := a.getRegisteredService(ctx, "proxysql:metrics", a.ServiceName)
 	if err != nil {
 		return err
 	}
-	if consulSvc == nil {
+	if registered == nil {
 		return ErrNoService
 	}
 
-	// Remove service from Consul.
-	dereg := consul.CatalogDeregistration{
-		Node:      a.Config.ClientName,
-		ServiceID: consulSvc.ID,
-	}
-	if _, err := a.consulAPI.Catalog().Deregister(&dereg, nil); err != nil {
+	// Deregister service.
+	if err := a.registry().DeregisterService(ctx, a.Config.ClientName, registered.ID); err != nil {
 		return err
 	}
 
-	prefix := fmt.Sprintf("%s/%s/", a.Config.ClientName, consulSvc.ID)
-	a.consulAPI.KV().DeleteTree(prefix, nil)
-
 	// Stop and uninstall service.
-	if err := uninstallService(fmt.Sprintf("pmm-proxysql-metrics-%d", consulSvc.Port)); err != nil {
+	if err := a.uninstallService(fmt.Sprintf("pmm-proxysql-metrics-%d", registered.Port)); err != nil {
 		return err
 	}
 
@@ -137,14 +124,14 @@ func (a *Admin) RemoveProxySQLMetrics() error {
 }
 
 // DetectProxySQL verify ProxySQL connection.
-func (a *Admin) DetectProxySQL(dsnString string) error {
+func (a *Admin) DetectProxySQL(ctx context.Context, dsnString string) error {
 	dsn, err := mysql.ParseDSN(dsnString)
 	if err != nil {
-		return fmt.Errorf("Bad dsn %s: %s", dsnString, err)
+		return fmt.Errorf("Bad dsn %s: %w", dsnString, err)
 	}
 
-	if err := testConnection(dsn.FormatDSN()); err != nil {
-		return fmt.Errorf("Cannot connect to ProxySQL using DSN %s: %s", dsnString, err)
+	if err := testConnection(ctx, dsn.FormatDSN()); err != nil {
+		return fmt.Errorf("Cannot connect to ProxySQL using DSN %s: %w", dsnString, err)
 	}
 
 	return nil
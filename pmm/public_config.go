@@ -0,0 +1,106 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ReadOnlyCommands are pmm-admin commands which members of PMMGroup can run without superuser privileges.
+var ReadOnlyCommands = []string{"info", "list", "check-network", "ping", "logs", "help"}
+
+// PublicConfigFile returns the copy of the config file without secrets readable by PMMGroup.
+func (a *Admin) PublicConfigFile() string {
+	ext := filepath.Ext(a.paths().ConfigFile)
+	return strings.TrimSuffix(a.paths().ConfigFile, ext) + "-public" + ext
+}
+
+// publicConfig returns the config without passwords, client key and Consul token.
+// Consul token file is readable by root only, so it is dropped too, the token can be passed in CONSUL_HTTP_TOKEN.
+func (c Config) publicConfig() Config {
+	c.ServerPassword = ""
+	c.ServerProxyPassword = ""
+	c.ServerClientCert = ""
+	c.ServerClientKey = ""
+	c.MySQLPassword = ""
+	c.ConsulToken = ""
+	c.ConsulTokenFile = ""
+	profiles := map[string]ServerProfile{}
+	for name, p := range c.ServerProfiles {
+		p.ServerPassword = ""
+		p.ServerProxyPassword = ""
+		p.ServerClientCert = ""
+		p.ServerClientKey = ""
+		p.ConsulToken = ""
+		p.ConsulTokenFile = ""
+		profiles[name] = p
+	}
+	if len(profiles) > 0 {
+		c.ServerProfiles = profiles
+	}
+	return c
+}
+
+// writePublicConfig write the config without secrets readable by PMMGroup if the group exists, by owner only otherwise.
+func (a *Admin) writePublicConfig(config Config) error {
+	bytes, err := yaml.Marshal(config.publicConfig())
+	if err != nil {
+		return err
+	}
+	file := a.PublicConfigFile()
+	if err := ioutil.WriteFile(file, bytes, 0600); err != nil {
+		return err
+	}
+	group, err := user.LookupGroup(PMMGroup)
+	if err != nil {
+		return nil
+	}
+	gid, err := strconv.Atoi(group.Gid)
+	if err != nil {
+		return err
+	}
+	if err := os.Chown(file, -1, gid); err != nil {
+		return err
+	}
+	return os.Chmod(file, 0640)
+}
+
+// readConfigFile read the config file, or its public copy if the config is not readable for the current user.
+// Server password is taken from ServerPasswordEnv environment variable then.
+func (a *Admin) readConfigFile() (data []byte, public bool, err error) {
+	data, err = ioutil.ReadFile(a.paths().ConfigFile)
+	if err == nil || !os.IsPermission(err) {
+		return data, false, err
+	}
+	if !FileExists(a.PublicConfigFile()) {
+		e := newError(KindPermission, fmt.Sprintf("Run 'pmm-admin config' with superuser privileges once to create its copy %s readable by %s group.",
+			a.PublicConfigFile(), PMMGroup), "%s.", err)
+		e.Err = err
+		return nil, false, e
+	}
+	data, err = ioutil.ReadFile(a.PublicConfigFile())
+	return data, true, err
+}
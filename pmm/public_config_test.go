@@ -0,0 +1,67 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestPublicConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	a := &Admin{Paths: Paths{ConfigFile: filepath.Join(dir, "pmm.yml")}}
+	assert.Equal(t, filepath.Join(dir, "pmm-public.yml"), a.PublicConfigFile())
+
+	config := Config{
+		ServerAddress:       "prod.example.com",
+		ClientName:          "db01",
+		ServerUser:          "pmm",
+		ServerPassword:      "secret",
+		ServerProxyPassword: "secret",
+		ServerClientKey:     "/etc/pmm/client.key",
+		MySQLPassword:       "secret",
+		ConsulTokenFile:     "/etc/pmm/consul-token",
+		ServerProfiles: map[string]ServerProfile{
+			"staging": {ServerAddress: "staging.example.com", ServerUser: "pmm", ServerPassword: "secret", ConsulTokenFile: "/etc/pmm/consul-token"},
+		},
+	}
+	assert.Nil(t, a.writePublicConfig(config))
+
+	data, err := ioutil.ReadFile(a.PublicConfigFile())
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "secret")
+	assert.NotContains(t, string(data), "client.key")
+	assert.NotContains(t, string(data), "consul-token")
+
+	public := Config{}
+	assert.Nil(t, yaml.Unmarshal(data, &public))
+	assert.Equal(t, "prod.example.com", public.ServerAddress)
+	assert.Equal(t, "pmm", public.ServerUser)
+	assert.Equal(t, "pmm", public.ServerProfiles["staging"].ServerUser)
+
+	// The original config is intact.
+	assert.Equal(t, "secret", config.ServerProfiles["staging"].ServerPassword)
+}
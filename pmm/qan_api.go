@@ -20,12 +20,16 @@ package pmm
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"time"
@@ -34,51 +38,60 @@ import (
 )
 
 type API struct {
-	headers     map[string]string
-	hostname    string
-	insecureSSL bool
-	apiTimeout  time.Duration
-	debug       bool // turns on logging requests with std logger
+	headers  map[string]string
+	hostname string
+	client   *http.Client
+	backoff  Backoff
 }
 
 type apiError struct {
 	Error string
 }
 
-func NewAPI(insecureFlag bool, timeout time.Duration, debug bool) *API {
+// NewAPI creates API client on top of the given transport shared with other PMM Server clients.
+func NewAPI(transport http.RoundTripper, timeout time.Duration, backoff Backoff) *API {
 	hostname, _ := os.Hostname()
 	a := &API{
-		headers:     nil,
-		hostname:    hostname,
-		insecureSSL: insecureFlag,
-		apiTimeout:  timeout,
-		debug:       debug,
+		headers:  nil,
+		hostname: hostname,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+		},
+		backoff: backoff,
 	}
 	return a
 }
 
+// NewTransport creates HTTP transport with keep-alive connections to be shared by API clients.
+// Proxy selects the proxy for a request, nil means direct connection.
+func NewTransport(tlsConfig *tls.Config, proxy func(*http.Request) (*url.URL, error), timeout time.Duration, debug bool) http.RoundTripper {
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+	}
+	var transport http.RoundTripper = &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	if debug {
+		// if api is in debug mode we should log every request and response
+		transport = utils.NewDebugRoundTripper(transport)
+	}
+	return transport
+}
+
 func (a *API) Hostname() string {
 	return a.hostname
 }
 
-func (a *API) Ping(url string) error {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return err
-	}
-	if a.headers != nil {
-		for k, v := range a.headers {
-			req.Header.Add(k, v)
-		}
-	}
-
-	client := a.NewClient()
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	_, err = ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
+func (a *API) Ping(ctx context.Context, url string) error {
+	resp, _, err := a.Get(ctx, url)
 	if err != nil {
 		return err
 	}
@@ -92,55 +105,20 @@ func (a *API) URL(paths ...string) string {
 	return strings.Join(paths, "/")
 }
 
-func (a *API) Get(url string) (*http.Response, []byte, error) {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, nil, err
-	}
-	if a.headers != nil {
-		for k, v := range a.headers {
-			req.Header.Add(k, v)
-		}
-	}
-
-	client := a.NewClient()
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, nil, err
-	}
-	defer resp.Body.Close()
-
-	var data []byte
-	if resp.Header.Get("Content-Type") == "application/x-gzip" {
-		buf := new(bytes.Buffer)
-		gz, err := gzip.NewReader(resp.Body)
-		if err != nil {
-			return nil, nil, fmt.Errorf("GET %s: gzip.NewReader: %s", url, err)
-		}
-		if _, err := io.Copy(buf, gz); err != nil {
-			return resp, nil, fmt.Errorf("GET %s: io.Copy: %s", url, err)
-		}
-		data = buf.Bytes()
-	} else {
-		data, err = ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return resp, nil, fmt.Errorf("GET %s: ioutil.ReadAll: %s", url, err)
-		}
-	}
-
-	return resp, data, nil
+func (a *API) Get(ctx context.Context, url string) (*http.Response, []byte, error) {
+	return a.send(ctx, "GET", url, nil)
 }
 
-func (a *API) Post(url string, data []byte) (*http.Response, []byte, error) {
-	return a.send("POST", url, data)
+func (a *API) Post(ctx context.Context, url string, data []byte) (*http.Response, []byte, error) {
+	return a.send(ctx, "POST", url, data)
 }
 
-func (a *API) Put(url string, data []byte) (*http.Response, []byte, error) {
-	return a.send("PUT", url, data)
+func (a *API) Put(ctx context.Context, url string, data []byte) (*http.Response, []byte, error) {
+	return a.send(ctx, "PUT", url, data)
 }
 
-func (a *API) Delete(url string) (*http.Response, []byte, error) {
-	return a.send("DELETE", url, nil)
+func (a *API) Delete(ctx context.Context, url string) (*http.Response, []byte, error) {
+	return a.send(ctx, "DELETE", url, nil)
 }
 
 func (a *API) Error(method, url string, gotStatusCode, expectedStatusCode int, content []byte) error {
@@ -154,60 +132,117 @@ func (a *API) Error(method, url string, gotStatusCode, expectedStatusCode int, c
 			errMsg += ": " + apiErr.Error
 		}
 	}
-	return fmt.Errorf(errMsg)
+	return errors.New(errMsg)
 }
 
-// NewClient creates new *http.Client tailored for this API
-func (a *API) NewClient() *http.Client {
-	transport := &http.Transport{}
-	if a.insecureSSL {
-		transport.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true,
-		}
-	}
-	client := &http.Client{
-		Timeout:   a.apiTimeout,
-		Transport: transport,
-	}
-	if a.debug {
-		// if api is in debug mode we should log every request and response
-		client.Transport = utils.NewDebugRoundTripper(client.Transport)
-	}
-	return client
+// Client returns *http.Client shared by all requests of this API.
+func (a *API) Client() *http.Client {
+	return a.client
 }
 
 // --------------------------------------------------------------------------
 
-func (a *API) send(method, url string, data []byte) (*http.Response, []byte, error) {
-	var req *http.Request
-	var err error
-	var body io.Reader
+// send sends the request retrying idempotent ones on temporary failures.
+func (a *API) send(ctx context.Context, method, url string, data []byte) (*http.Response, []byte, error) {
+	var resp *http.Response
+	var content []byte
+	err := a.backoff.retry(ctx, func() (bool, error) {
+		var err error
+		resp, content, err = a.do(ctx, method, url, data)
+		if !isIdempotent(method, url) || ctx.Err() != nil {
+			return false, err
+		}
+		if err != nil {
+			return isRetriable(err), err
+		}
+		switch resp.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true, nil
+		}
+		return false, nil
+	})
+	return resp, content, err
+}
 
+func (a *API) do(ctx context.Context, method, url string, data []byte) (*http.Response, []byte, error) {
+	var body io.Reader
 	if data != nil {
 		body = bytes.NewReader(data)
 	}
 
-	req, err = http.NewRequest(method, url, body)
+	req, err := http.NewRequest(method, url, body)
 	if err != nil {
 		return nil, nil, err
 	}
+	req = req.WithContext(ctx)
 	if a.headers != nil {
 		for k, v := range a.headers {
 			req.Header.Add(k, v)
 		}
 	}
 
-	client := a.NewClient()
-	resp, err := client.Do(req)
+	resp, err := a.client.Do(req)
 	if err != nil {
 		return resp, nil, err
 	}
+	defer resp.Body.Close()
 
-	content, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
-	if err != nil {
-		return resp, nil, err
+	var content []byte
+	if resp.Header.Get("Content-Type") == "application/x-gzip" {
+		buf := new(bytes.Buffer)
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s %s: gzip.NewReader: %w", method, url, err)
+		}
+		if _, err := io.Copy(buf, gz); err != nil {
+			return resp, nil, fmt.Errorf("%s %s: io.Copy: %w", method, url, err)
+		}
+		content = buf.Bytes()
+	} else {
+		content, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return resp, nil, fmt.Errorf("%s %s: ioutil.ReadAll: %w", method, url, err)
+		}
 	}
 
 	return resp, content, nil
 }
+
+// isIdempotent checks if request with given method to the URL can be safely retried.
+// Agent commands are sent with PUT, but they are actions like starting QAN, so they are not retried.
+func isIdempotent(method, rawURL string) bool {
+	switch method {
+	case "GET", "HEAD", "DELETE", "OPTIONS":
+		return true
+	case "PUT":
+		return !isAgentCmdURL(rawURL)
+	}
+	return false
+}
+
+// isAgentCmdURL checks if URL is QAN API endpoint of agent commands, i.e. .../agents/<uuid>/cmd.
+func isAgentCmdURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return true
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	n := len(parts)
+	return n >= 3 && parts[n-3] == "agents" && parts[n-1] == "cmd"
+}
+
+// isRetriable checks if request error may go away on retry: timeouts, refused or reset connections.
+// DNS errors are not retried as they are most likely caused by the wrong server address.
+func isRetriable(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		_, isDNSErr := opErr.Err.(*net.DNSError)
+		return !isDNSErr
+	}
+	return false
+}
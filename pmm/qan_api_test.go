@@ -0,0 +1,55 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsIdempotent(t *testing.T) {
+	assert.True(t, isIdempotent("GET", "http://pmm/qan-api/agents/1234/cmd"))
+	assert.True(t, isIdempotent("PUT", "http://pmm/qan-api/instances/1234"))
+	assert.False(t, isIdempotent("PUT", "http://pmm/qan-api/agents/1234/cmd"))
+	assert.False(t, isIdempotent("POST", "http://pmm/qan-api/instances"))
+}
+
+func TestAPINoRetryOfAgentCmd(t *testing.T) {
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	timeout := time.Second
+	api := NewAPI(NewTransport(&tls.Config{}, nil, timeout, false), timeout, Backoff{Retries: 2, Delay: time.Millisecond, MaxDelay: time.Millisecond})
+	ctx := context.Background()
+	api.Put(ctx, srv.URL+"/qan-api/instances/1234", []byte("{}"))
+	api.Put(ctx, srv.URL+"/qan-api/agents/1234/cmd", []byte("{}"))
+
+	// Agent command is sent once, the instance update is retried.
+	assert.Equal(t, 3, requests["/qan-api/instances/1234"])
+	assert.Equal(t, 1, requests["/qan-api/agents/1234/cmd"])
+}
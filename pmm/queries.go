@@ -18,6 +18,7 @@
 package pmm
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -25,6 +26,8 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/percona/pmm/proto"
@@ -32,10 +35,10 @@ import (
 )
 
 // deleteInstance delete instance on QAN API.
-func (a *Admin) deleteInstance(uuid string) error {
+func (a *Admin) deleteInstance(ctx context.Context, uuid string) error {
 	// Remove MySQL instance from QAN.
 	url := a.qanAPI.URL(a.serverURL, qanAPIBasePath, "instances", uuid)
-	resp, content, err := a.qanAPI.Delete(url)
+	resp, content, err := a.qanAPI.Delete(ctx, url)
 	if err != nil {
 		return err
 	}
@@ -46,11 +49,97 @@ func (a *Admin) deleteInstance(uuid string) error {
 	return nil
 }
 
+// getInstance get instance from QAN API by UUID.
+func (a *Admin) getInstance(ctx context.Context, uuid string) (proto.Instance, error) {
+	var in proto.Instance
+	url := a.qanAPI.URL(a.serverURL, qanAPIBasePath, "instances", uuid)
+	resp, bytes, err := a.qanAPI.Get(ctx, url)
+	if err != nil {
+		return in, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return in, errNoInstance
+	}
+	if resp.StatusCode != http.StatusOK {
+		return in, a.qanAPI.Error("GET", url, resp.StatusCode, http.StatusOK, bytes)
+	}
+
+	err = json.Unmarshal(bytes, &in)
+	return in, err
+}
+
+// createInstance create instance on QAN API and returns it with UUID assigned.
+func (a *Admin) createInstance(ctx context.Context, in proto.Instance) (proto.Instance, error) {
+	inBytes, _ := json.Marshal(in)
+	url := a.qanAPI.URL(a.serverURL, qanAPIBasePath, "instances")
+	resp, content, err := a.qanAPI.Post(ctx, url, inBytes)
+	if err != nil {
+		return in, err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return in, a.qanAPI.Error("POST", url, resp.StatusCode, http.StatusCreated, content)
+	}
+
+	// The URI of the new instance is reported in the Location header, fetch it to get UUID assigned.
+	// Do not call the returned URL as QAN API returns an invalid one.
+	var bytes []byte
+	t := strings.Split(resp.Header.Get("Location"), "/")
+	url = a.qanAPI.URL(url, t[len(t)-1])
+	resp, bytes, err = a.qanAPI.Get(ctx, url)
+	if err != nil {
+		return in, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return in, a.qanAPI.Error("GET", url, resp.StatusCode, http.StatusOK, bytes)
+	}
+
+	if err := json.Unmarshal(bytes, &in); err != nil {
+		return in, err
+	}
+
+	return in, err
+}
+
+// undeleteInstance undelete instance on QAN API, so its historical data stays linked.
+func (a *Admin) undeleteInstance(ctx context.Context, in proto.Instance) (proto.Instance, error) {
+	in.Deleted = time.Unix(1, 0)
+	cmdBytes, _ := json.Marshal(in)
+	url := a.qanAPI.URL(a.serverURL, qanAPIBasePath, "instances", in.UUID)
+	resp, content, err := a.qanAPI.Put(ctx, url, cmdBytes)
+	if err != nil {
+		return in, err
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		return in, a.qanAPI.Error("PUT", url, resp.StatusCode, http.StatusNoContent, content)
+	}
+
+	// Ensure it was undeleted.
+	// QAN API 1.0.4 didn't support changing "deleted" field.
+	url = a.qanAPI.URL(a.serverURL, qanAPIBasePath, "instances", in.UUID)
+	resp, bytes, err := a.qanAPI.Get(ctx, url)
+	if err != nil {
+		return in, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return in, a.qanAPI.Error("GET", url, resp.StatusCode, http.StatusOK, bytes)
+	}
+
+	if err := json.Unmarshal(bytes, &in); err != nil {
+		return in, err
+	}
+	// If it's not "1970-01-01 00:00:00 +0000 UTC", it was left deleted.
+	if in.Deleted.Year() != 1970 {
+		return in, errNoInstance
+	}
+
+	return in, nil
+}
+
 // getAgentInstance get agent instance from QAN API and return its parent_uuid.
-func (a *Admin) getAgentInstance(agentID string) (string, error) {
+func (a *Admin) getAgentInstance(ctx context.Context, agentID string) (string, error) {
 	var in proto.Instance
 	url := a.qanAPI.URL(a.serverURL, qanAPIBasePath, "instances", agentID)
-	resp, bytes, err := a.qanAPI.Get(url)
+	resp, bytes, err := a.qanAPI.Get(ctx, url)
 	if err != nil {
 		return "", err
 	}
@@ -69,6 +158,43 @@ func (a *Admin) getAgentInstance(agentID string) (string, error) {
 	return in.ParentUUID, nil
 }
 
+// ensureAgent register agent on QAN API if it is not registered or orphaned.
+// It returns agent UUID and parent_uuid of agent instance.
+func (a *Admin) ensureAgent(ctx context.Context) (agentID, parentUUID string, err error) {
+	// Register agent if config file does not exist.
+	agentConfigFile := fmt.Sprintf("%s/config/agent.conf", a.paths().AgentBaseDir)
+	if !FileExists(agentConfigFile) {
+		if err := a.registerAgent(ctx); err != nil {
+			return "", "", err
+		}
+	}
+
+	agentID, err = getAgentID(agentConfigFile)
+	if err != nil {
+		return "", "", err
+	}
+	// Get parent_uuid of agent instance.
+	parentUUID, err = a.getAgentInstance(ctx, agentID)
+	if err == errNoInstance {
+		// If agent is orphaned, let's re-register it.
+		if err := a.registerAgent(ctx); err != nil {
+			return "", "", err
+		}
+		// Get new agent id.
+		agentID, err = getAgentID(agentConfigFile)
+		if err != nil {
+			return "", "", err
+		}
+		// Get parent_uuid again.
+		parentUUID, err = a.getAgentInstance(ctx, agentID)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	return agentID, parentUUID, nil
+}
+
 // getAgentID read agent UUID from agent QAN config file.
 func getAgentID(configFile string) (string, error) {
 	jsonData, err := ioutil.ReadFile(configFile)
@@ -89,26 +215,29 @@ func getAgentID(configFile string) (string, error) {
 }
 
 // startQan enable QAN on agent through QAN API.
-func (a *Admin) startQAN(agentID string, config map[string]interface{}) error {
+func (a *Admin) startQAN(ctx context.Context, agentID string, config map[string]interface{}) error {
 	cmdName := "StartTool"
 	data, err := json.Marshal(config)
 	if err != nil {
 		return err
 	}
 
-	return a.sendQANCmd(agentID, cmdName, data)
+	return a.sendQANCmd(ctx, agentID, cmdName, data)
 }
 
 // stopQAN disable QAN on agent through QAN API.
-func (a *Admin) stopQAN(agentID, UUID string) error {
+func (a *Admin) stopQAN(ctx context.Context, agentID, UUID string) error {
 	cmdName := "StopTool"
 	data := []byte(UUID)
 
-	return a.sendQANCmd(agentID, cmdName, data)
+	return a.sendQANCmd(ctx, agentID, cmdName, data)
 }
 
+// agentConnectBackoff is used to wait for the agent to connect to QAN API.
+var agentConnectBackoff = Backoff{Retries: 10, Delay: 250 * time.Millisecond, MaxDelay: 2 * time.Second}
+
 // sendQANCmd sends cmd to agent throughq QAN API.
-func (a *Admin) sendQANCmd(agentID, cmdName string, data []byte) error {
+func (a *Admin) sendQANCmd(ctx context.Context, agentID, cmdName string, data []byte) error {
 	cmd := proto.Cmd{
 		User:    fmt.Sprintf("pmm-admin@%s", a.qanAPI.Hostname()),
 		Service: "qan",
@@ -122,32 +251,33 @@ func (a *Admin) sendQANCmd(agentID, cmdName string, data []byte) error {
 
 	// It takes a few seconds for agent to connect to QAN API once it is started via service manager.
 	// QAN API fails to start/stop unconnected agent for QAN, so we retry the request when getting 404 response.
-	for i := 0; i < 10; i++ {
-		resp, content, err := a.qanAPI.Put(url, cmdBytes)
+	var notConnected bool
+	err := agentConnectBackoff.retry(ctx, func() (bool, error) {
+		resp, content, err := a.qanAPI.Put(ctx, url, cmdBytes)
 		if err != nil {
-			return err
-		}
-		if resp.StatusCode == http.StatusNotFound {
-			time.Sleep(time.Second)
-			continue
+			return false, err
 		}
-		if resp.StatusCode == http.StatusOK {
-			return nil
+		notConnected = resp.StatusCode == http.StatusNotFound
+		if notConnected || resp.StatusCode == http.StatusOK {
+			return notConnected, nil
 		}
-		return a.qanAPI.Error("PUT", url, resp.StatusCode, http.StatusOK, content)
+		return false, a.qanAPI.Error("PUT", url, resp.StatusCode, http.StatusOK, content)
+	})
+	if err == nil && notConnected {
+		return errors.New("timeout waiting on agent to connect to API.")
 	}
-	return errors.New("timeout 10s waiting on agent to connect to API.")
+	return err
 }
 
 // registerAgent register agent on QAN API using agent installer.
-func (a *Admin) registerAgent() error {
+func (a *Admin) registerAgent(ctx context.Context) error {
 	// Remove agent dirs to ensure clean installation. Using full paths to avoid unexpected removals.
-	os.RemoveAll(fmt.Sprintf("%s/%s", AgentBaseDir, "config"))
-	os.RemoveAll(fmt.Sprintf("%s/%s", AgentBaseDir, "data"))
-	os.RemoveAll(fmt.Sprintf("%s/%s", AgentBaseDir, "instance"))
+	os.RemoveAll(fmt.Sprintf("%s/%s", a.paths().AgentBaseDir, "config"))
+	os.RemoveAll(fmt.Sprintf("%s/%s", a.paths().AgentBaseDir, "data"))
+	os.RemoveAll(fmt.Sprintf("%s/%s", a.paths().AgentBaseDir, "instance"))
 
-	path := fmt.Sprintf("%s/bin/percona-qan-agent-installer", AgentBaseDir)
-	args := []string{"-basedir", AgentBaseDir, "-mysql=false"}
+	path := fmt.Sprintf("%s/bin/percona-qan-agent-installer", a.paths().AgentBaseDir)
+	args := []string{"-basedir", a.paths().AgentBaseDir, "-mysql=false"}
 	if a.Config.ServerSSL {
 		args = append(args, "-use-ssl")
 	}
@@ -159,11 +289,58 @@ func (a *Admin) registerAgent() error {
 			fmt.Sprintf("-server-pass=%s", a.Config.ServerPassword))
 	}
 	args = append(args, fmt.Sprintf("%s/%s", a.serverURL, qanAPIBasePath))
-	if _, err := exec.Command(path, args...).Output(); err != nil {
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Env = append(os.Environ(), a.agentEnv()...)
+	if _, err := cmd.Output(); err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
-			return fmt.Errorf("problem with agent registration on QAN API: %s\n%s", err, exitErr.Stderr)
+			return fmt.Errorf("problem with agent registration on QAN API: %w\n%s", err, exitErr.Stderr)
 		}
-		return fmt.Errorf("problem with agent registration on QAN API: %s", err)
+		return fmt.Errorf("problem with agent registration on QAN API: %w", err)
+	}
+
+	// The installer resets agent dirs, so the environment file is written again.
+	if err := a.writeAgentEnvFile(); err != nil {
+		return fmt.Errorf("problem with agent environment file %s: %w", a.agentEnvFile(), err)
 	}
 	return nil
 }
+
+// agentEnv returns environment variables of qan-agent and its installer with the proxy config, CA bundle
+// and client certificate for mutual TLS. They have no options for them, Go programs take the proxy and CA bundle
+// from the environment, so the client certificate and key are passed the same way.
+func (a *Admin) agentEnv() []string {
+	env := a.proxyEnv()
+	if a.Config.ServerCAFile != "" {
+		env = append(env, "SSL_CERT_FILE="+a.Config.ServerCAFile)
+	}
+	if a.Config.ServerClientCert != "" {
+		env = append(env, "SSL_CLIENT_CERT_FILE="+a.Config.ServerClientCert, "SSL_CLIENT_KEY_FILE="+a.Config.ServerClientKey)
+	}
+	return env
+}
+
+// agentEnvFile returns the environment file of qan-agent service.
+func (a *Admin) agentEnvFile() string {
+	return a.paths().AgentBaseDir + "/config/agent.env"
+}
+
+// writeAgentEnvFile write environment variables for qan-agent service readable by owner only,
+// so proxy credentials don't end up in service files. The file is removed if there are none.
+func (a *Admin) writeAgentEnvFile() error {
+	file := a.agentEnvFile()
+	env := a.agentEnv()
+	if len(env) == 0 {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	var data string
+	for _, e := range env {
+		data += shellQuoteEnv(e) + "\n"
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, []byte(data), 0600)
+}
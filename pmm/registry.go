@@ -0,0 +1,163 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Registry keeps monitoring services of client nodes along with their metadata, e.g. DSN and QAN instance UUID.
+// PMM server discovers services to scrape from it.
+type Registry interface {
+	// Name returns name of the registry, one of Registries.
+	Name() string
+	// ListNodeServices returns the node with its services, nil if the node is not registered.
+	ListNodeServices(ctx context.Context, node string) (*RegistryNode, error)
+	// FindServiceByAlias returns nodes with services of the type monitoring the instance under alias,
+	// only the matching services are set on them.
+	FindServiceByAlias(ctx context.Context, serviceType, alias string) ([]*RegistryNode, error)
+	// RegisterService adds or replaces the service of the node by ID, the node is registered by address as needed.
+	// Metadata and health of the replaced service are kept.
+	RegisterService(ctx context.Context, node, address string, svc *RegistryService) error
+	// DeregisterService removes the service of the node along with its metadata and health.
+	DeregisterService(ctx context.Context, node, serviceID string) error
+
+	// GetServiceMeta returns metadata value of the service by key, empty if it is not set.
+	GetServiceMeta(ctx context.Context, node, serviceID, key string) (string, error)
+	// SetServiceMeta sets metadata value of the service by key.
+	SetServiceMeta(ctx context.Context, node, serviceID, key, value string) error
+	// ListServiceMeta returns metadata of the service with keys under prefix, relative to it.
+	ListServiceMeta(ctx context.Context, node, serviceID, prefix string) (map[string]string, error)
+	// DeleteServiceMeta removes metadata of the service with keys under prefix, all of it if prefix is empty.
+	DeleteServiceMeta(ctx context.Context, node, serviceID, prefix string) error
+
+	// SetServiceHealth sets health check of the service.
+	SetServiceHealth(ctx context.Context, node, address string, svc *RegistryService, check HealthCheck) error
+	// ListNodeHealth returns health status of services of the node by service ID.
+	ListNodeHealth(ctx context.Context, node string) (map[string]string, error)
+}
+
+// Registries are the names of registries accepted by the config.
+var Registries = []string{"consul", "file"}
+
+// RegistryNode is a client node with its monitoring services.
+type RegistryNode struct {
+	Name     string
+	Address  string
+	Services []*RegistryService // sorted by ID
+}
+
+// RegistryService is a monitoring service of a client node.
+// Field names follow Consul agent service as it was the first registry.
+type RegistryService struct {
+	ID      string   // <type>-<port>
+	Service string   // type, e.g. mysql:metrics
+	Tags    []string // alias_<name>, scheme_https, options and labels
+	Port    int
+}
+
+// Service metadata keys. Queries service handles many instances,
+// so their metadata is kept under instanceMetaKey of their alias.
+const (
+	metaDSN            = "dsn"
+	metaQANMySQLUUID   = "qan_mysql_uuid"
+	metaQANMongoDBUUID = "qan_mongodb_uuid"
+)
+
+// instanceMetaKey returns metadata key of the instance monitored by queries service under alias.
+func instanceMetaKey(alias, key string) string {
+	return alias + "/" + key
+}
+
+// instanceMetaPrefix returns metadata prefix of the instance monitored by queries service under alias.
+func instanceMetaPrefix(alias string) string {
+	return alias + "/"
+}
+
+// isQANUUIDMetaKey check if metadata key is QAN instance UUID of queries service instance.
+func isQANUUIDMetaKey(key string) bool {
+	return strings.HasSuffix(key, "/"+metaQANMySQLUUID) || strings.HasSuffix(key, "/"+metaQANMongoDBUUID)
+}
+
+// metaWithPrefix returns metadata with keys under prefix, relative to it.
+func metaWithPrefix(meta map[string]string, prefix string) map[string]string {
+	res := map[string]string{}
+	for key, value := range meta {
+		if strings.HasPrefix(key, prefix) {
+			res[key[len(prefix):]] = value
+		}
+	}
+	return res
+}
+
+// sortedKeys returns metadata keys sorted, the order Consul KV lists them in.
+func sortedKeys(meta map[string]string) []string {
+	var keys []string
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortServices sorts services by ID.
+func sortServices(services []*RegistryService) {
+	sort.Slice(services, func(i, j int) bool { return services[i].ID < services[j].ID })
+}
+
+// hasAlias check if service is tagged with alias.
+func (s *RegistryService) hasAlias(alias string) bool {
+	for _, tag := range s.Tags {
+		if tag == fmt.Sprintf("alias_%s", alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// newRegistry returns registry by name, Consul one if name is empty.
+func (a *Admin) newRegistry(name string) (Registry, error) {
+	switch name {
+	case "", "consul":
+		return &ConsulRegistry{Client: a.consulAPI}, nil
+	case "file":
+		return &FileRegistry{File: a.paths().RegistryFile}, nil
+	}
+	return nil, fmt.Errorf("Unknown registry %s, it should be one of: %s.", name, strings.Join(Registries, ", "))
+}
+
+// registry returns service registry of Admin: the one set explicitly or the one from the config.
+// Server APIs should be set up with SetAPI before it is used.
+func (a *Admin) registry() Registry {
+	if a.Registry != nil {
+		return a.Registry
+	}
+	name := ""
+	if a.Config != nil {
+		name = a.Config.Registry
+	}
+	r, err := a.newRegistry(name)
+	if err != nil {
+		// The config is validated on write, so fall back to Consul.
+		r, _ = a.newRegistry("")
+	}
+	return r
+}
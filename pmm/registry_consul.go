@@ -0,0 +1,211 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"fmt"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// ConsulRegistry keeps services in Consul catalog of PMM server and their metadata in Consul KV
+// under <client name>/<service ID>/.
+type ConsulRegistry struct {
+	Client *consul.Client
+}
+
+// consulNodePrefix returns Consul KV prefix of all metadata of the client.
+func consulNodePrefix(node string) string {
+	return node + "/"
+}
+
+// consulServicePrefix returns Consul KV prefix of metadata of the service.
+func consulServicePrefix(node, serviceID string) string {
+	return fmt.Sprintf("%s%s/", consulNodePrefix(node), serviceID)
+}
+
+// healthCheckID returns Consul check ID of a service, the same as Consul agent uses for service checks.
+func healthCheckID(serviceID string) string {
+	return "service:" + serviceID
+}
+
+// fromAgentService returns registry service of Consul service.
+func fromAgentService(svc *consul.AgentService) *RegistryService {
+	return &RegistryService{ID: svc.ID, Service: svc.Service, Tags: svc.Tags, Port: svc.Port}
+}
+
+// toAgentService returns Consul service of registry service.
+func toAgentService(svc *RegistryService) *consul.AgentService {
+	return &consul.AgentService{ID: svc.ID, Service: svc.Service, Tags: svc.Tags, Port: svc.Port}
+}
+
+// Name returns "consul".
+func (r *ConsulRegistry) Name() string {
+	return "consul"
+}
+
+// ListNodeServices returns Consul catalog node.
+func (r *ConsulRegistry) ListNodeServices(ctx context.Context, node string) (*RegistryNode, error) {
+	n, _, err := r.Client.Catalog().Node(node, consulQuery(ctx))
+	if err != nil {
+		return nil, consulError(err)
+	}
+	if n == nil {
+		return nil, nil
+	}
+	res := &RegistryNode{Name: node}
+	if n.Node != nil {
+		res.Address = n.Node.Address
+	}
+	for _, svc := range n.Services {
+		res.Services = append(res.Services, fromAgentService(svc))
+	}
+	sortServices(res.Services)
+	return res, nil
+}
+
+// FindServiceByAlias returns nodes with catalog services of the type tagged with alias.
+func (r *ConsulRegistry) FindServiceByAlias(ctx context.Context, serviceType, alias string) ([]*RegistryNode, error) {
+	services, _, err := r.Client.Catalog().Service(serviceType, fmt.Sprintf("alias_%s", alias), consulQuery(ctx))
+	if err != nil {
+		return nil, consulError(err)
+	}
+	var nodes []*RegistryNode
+	byName := map[string]*RegistryNode{}
+	for _, cs := range services {
+		n := byName[cs.Node]
+		if n == nil {
+			n = &RegistryNode{Name: cs.Node, Address: cs.Address}
+			byName[cs.Node] = n
+			nodes = append(nodes, n)
+		}
+		n.Services = append(n.Services, &RegistryService{
+			ID:      cs.ServiceID,
+			Service: cs.ServiceName,
+			Tags:    cs.ServiceTags,
+			Port:    cs.ServicePort,
+		})
+	}
+	return nodes, nil
+}
+
+// RegisterService registers catalog service, KV data is separate and not touched.
+func (r *ConsulRegistry) RegisterService(ctx context.Context, node, address string, svc *RegistryService) error {
+	reg := consul.CatalogRegistration{
+		Node:    node,
+		Address: address,
+		Service: toAgentService(svc),
+	}
+	if _, err := r.Client.Catalog().Register(&reg, consulWrite(ctx)); err != nil {
+		return consulError(err)
+	}
+	return nil
+}
+
+// DeregisterService deregisters catalog service with its checks and removes its KV data.
+func (r *ConsulRegistry) DeregisterService(ctx context.Context, node, serviceID string) error {
+	dereg := consul.CatalogDeregistration{
+		Node:      node,
+		ServiceID: serviceID,
+	}
+	if _, err := r.Client.Catalog().Deregister(&dereg, consulWrite(ctx)); err != nil {
+		return consulError(err)
+	}
+	return r.DeleteServiceMeta(ctx, node, serviceID, "")
+}
+
+// GetServiceMeta returns KV value.
+func (r *ConsulRegistry) GetServiceMeta(ctx context.Context, node, serviceID, key string) (string, error) {
+	kvp, _, err := r.Client.KV().Get(consulServicePrefix(node, serviceID)+key, consulQuery(ctx))
+	if err != nil {
+		return "", consulError(err)
+	}
+	if kvp == nil {
+		return "", nil
+	}
+	return string(kvp.Value), nil
+}
+
+// SetServiceMeta puts KV value.
+func (r *ConsulRegistry) SetServiceMeta(ctx context.Context, node, serviceID, key, value string) error {
+	d := &consul.KVPair{Key: consulServicePrefix(node, serviceID) + key, Value: []byte(value)}
+	if _, err := r.Client.KV().Put(d, consulWrite(ctx)); err != nil {
+		return consulError(err)
+	}
+	return nil
+}
+
+// ListServiceMeta lists KV values under prefix.
+func (r *ConsulRegistry) ListServiceMeta(ctx context.Context, node, serviceID, prefix string) (map[string]string, error) {
+	prefix = consulServicePrefix(node, serviceID) + prefix
+	kvs, _, err := r.Client.KV().List(prefix, consulQuery(ctx))
+	if err != nil {
+		return nil, consulError(err)
+	}
+	meta := map[string]string{}
+	for _, kvp := range kvs {
+		meta[kvp.Key[len(prefix):]] = string(kvp.Value)
+	}
+	return meta, nil
+}
+
+// DeleteServiceMeta deletes KV tree under prefix.
+func (r *ConsulRegistry) DeleteServiceMeta(ctx context.Context, node, serviceID, prefix string) error {
+	if _, err := r.Client.KV().DeleteTree(consulServicePrefix(node, serviceID)+prefix, consulWrite(ctx)); err != nil {
+		return consulError(err)
+	}
+	return nil
+}
+
+// SetServiceHealth registers catalog check of the service with the ID Consul agent uses for service checks.
+func (r *ConsulRegistry) SetServiceHealth(ctx context.Context, node, address string, svc *RegistryService, check HealthCheck) error {
+	reg := consul.CatalogRegistration{
+		Node:    node,
+		Address: address,
+		Check: &consul.AgentCheck{
+			Node:        node,
+			CheckID:     healthCheckID(svc.ID),
+			Name:        fmt.Sprintf("Service '%s' check", svc.Service),
+			Status:      check.Status,
+			Notes:       "Updated by pmm-admin from local service status and its metrics endpoint.",
+			Output:      check.Output,
+			ServiceID:   svc.ID,
+			ServiceName: svc.Service,
+		},
+	}
+	if _, err := r.Client.Catalog().Register(&reg, consulWrite(ctx)); err != nil {
+		return consulError(err)
+	}
+	return nil
+}
+
+// ListNodeHealth returns status of service checks of the node.
+func (r *ConsulRegistry) ListNodeHealth(ctx context.Context, node string) (map[string]string, error) {
+	checks, _, err := r.Client.Health().Node(node, consulQuery(ctx))
+	if err != nil {
+		return nil, consulError(err)
+	}
+	status := map[string]string{}
+	for _, check := range checks {
+		if check.ServiceID != "" && check.CheckID == healthCheckID(check.ServiceID) {
+			status[check.ServiceID] = check.Status
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,286 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileRegistry keeps services in a local JSON file, for clients which PMM server does not discover through its registry,
+// e.g. when the file is shipped to the server by other means or for testing.
+type FileRegistry struct {
+	File string
+}
+
+// fileRegistryData is the content of the registry file.
+type fileRegistryData struct {
+	Nodes map[string]*fileRegistryNode
+}
+
+type fileRegistryNode struct {
+	Address  string
+	Services map[string]*fileRegistryService
+}
+
+type fileRegistryService struct {
+	RegistryService
+	Meta   map[string]string `json:",omitempty"`
+	Health *HealthCheck      `json:",omitempty"`
+}
+
+// Name returns "file".
+func (r *FileRegistry) Name() string {
+	return "file"
+}
+
+// load reads the file, it is empty if the file does not exist.
+func (r *FileRegistry) load() (*fileRegistryData, error) {
+	data := &fileRegistryData{}
+	bytes, err := ioutil.ReadFile(r.File)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(bytes, data); err != nil {
+			return nil, fmt.Errorf("registry file %s is broken: %w", r.File, err)
+		}
+	}
+	if data.Nodes == nil {
+		data.Nodes = map[string]*fileRegistryNode{}
+	}
+	return data, nil
+}
+
+// save writes the file replacing it at once, so readers never see it partially written.
+func (r *FileRegistry) save(data *fileRegistryData) error {
+	bytes, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(r.File), filepath.Base(r.File)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bytes); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.File)
+}
+
+// update loads the file, calls f and saves the file if f succeeds.
+func (r *FileRegistry) update(f func(data *fileRegistryData) error) error {
+	data, err := r.load()
+	if err != nil {
+		return err
+	}
+	if err := f(data); err != nil {
+		return err
+	}
+	return r.save(data)
+}
+
+// service returns the service of the node, nil if there is none.
+func (d *fileRegistryData) service(node, serviceID string) *fileRegistryService {
+	if n := d.Nodes[node]; n != nil {
+		return n.Services[serviceID]
+	}
+	return nil
+}
+
+// registryNode returns registry node with the services matching f.
+func (n *fileRegistryNode) registryNode(name string, f func(svc *RegistryService) bool) *RegistryNode {
+	res := &RegistryNode{Name: name, Address: n.Address}
+	for _, svc := range n.Services {
+		if f(&svc.RegistryService) {
+			s := svc.RegistryService
+			res.Services = append(res.Services, &s)
+		}
+	}
+	sortServices(res.Services)
+	return res
+}
+
+// ListNodeServices returns the node from the file.
+func (r *FileRegistry) ListNodeServices(ctx context.Context, node string) (*RegistryNode, error) {
+	data, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	n := data.Nodes[node]
+	if n == nil {
+		return nil, nil
+	}
+	return n.registryNode(node, func(*RegistryService) bool { return true }), nil
+}
+
+// FindServiceByAlias searches services of all nodes in the file.
+func (r *FileRegistry) FindServiceByAlias(ctx context.Context, serviceType, alias string) ([]*RegistryNode, error) {
+	data, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range data.Nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var nodes []*RegistryNode
+	for _, name := range names {
+		n := data.Nodes[name].registryNode(name, func(svc *RegistryService) bool {
+			return svc.Service == serviceType && svc.hasAlias(alias)
+		})
+		if len(n.Services) > 0 {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes, nil
+}
+
+// RegisterService adds or replaces the service in the file.
+func (r *FileRegistry) RegisterService(ctx context.Context, node, address string, svc *RegistryService) error {
+	return r.update(func(data *fileRegistryData) error {
+		n := data.Nodes[node]
+		if n == nil {
+			n = &fileRegistryNode{Services: map[string]*fileRegistryService{}}
+			data.Nodes[node] = n
+		}
+		n.Address = address
+		if s := n.Services[svc.ID]; s != nil {
+			s.RegistryService = *svc
+			return nil
+		}
+		n.Services[svc.ID] = &fileRegistryService{RegistryService: *svc}
+		return nil
+	})
+}
+
+// DeregisterService removes the service from the file, the node is removed with its last service.
+func (r *FileRegistry) DeregisterService(ctx context.Context, node, serviceID string) error {
+	return r.update(func(data *fileRegistryData) error {
+		n := data.Nodes[node]
+		if n == nil {
+			return nil
+		}
+		delete(n.Services, serviceID)
+		if len(n.Services) == 0 {
+			delete(data.Nodes, node)
+		}
+		return nil
+	})
+}
+
+// GetServiceMeta returns metadata value from the file.
+func (r *FileRegistry) GetServiceMeta(ctx context.Context, node, serviceID, key string) (string, error) {
+	data, err := r.load()
+	if err != nil {
+		return "", err
+	}
+	if svc := data.service(node, serviceID); svc != nil {
+		return svc.Meta[key], nil
+	}
+	return "", nil
+}
+
+// SetServiceMeta sets metadata value in the file, the service should be registered.
+func (r *FileRegistry) SetServiceMeta(ctx context.Context, node, serviceID, key, value string) error {
+	return r.update(func(data *fileRegistryData) error {
+		svc := data.service(node, serviceID)
+		if svc == nil {
+			return fmt.Errorf("service %s is not registered", serviceID)
+		}
+		if svc.Meta == nil {
+			svc.Meta = map[string]string{}
+		}
+		svc.Meta[key] = value
+		return nil
+	})
+}
+
+// ListServiceMeta returns metadata from the file.
+func (r *FileRegistry) ListServiceMeta(ctx context.Context, node, serviceID, prefix string) (map[string]string, error) {
+	data, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	var meta map[string]string
+	if svc := data.service(node, serviceID); svc != nil {
+		meta = svc.Meta
+	}
+	return metaWithPrefix(meta, prefix), nil
+}
+
+// DeleteServiceMeta removes metadata from the file.
+func (r *FileRegistry) DeleteServiceMeta(ctx context.Context, node, serviceID, prefix string) error {
+	return r.update(func(data *fileRegistryData) error {
+		svc := data.service(node, serviceID)
+		if svc == nil {
+			return nil
+		}
+		for key := range svc.Meta {
+			if strings.HasPrefix(key, prefix) {
+				delete(svc.Meta, key)
+			}
+		}
+		if len(svc.Meta) == 0 {
+			svc.Meta = nil
+		}
+		return nil
+	})
+}
+
+// SetServiceHealth sets health check of the service in the file, the service should be registered.
+func (r *FileRegistry) SetServiceHealth(ctx context.Context, node, address string, svc *RegistryService, check HealthCheck) error {
+	return r.update(func(data *fileRegistryData) error {
+		s := data.service(node, svc.ID)
+		if s == nil {
+			return fmt.Errorf("service %s is not registered", svc.ID)
+		}
+		s.Health = &check
+		return nil
+	})
+}
+
+// ListNodeHealth returns health status of services from the file.
+func (r *FileRegistry) ListNodeHealth(ctx context.Context, node string) (map[string]string, error) {
+	data, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	status := map[string]string{}
+	if n := data.Nodes[node]; n != nil {
+		for id, svc := range n.Services {
+			if svc.Health != nil {
+				status[id] = svc.Health.Status
+			}
+		}
+	}
+	return status, nil
+}
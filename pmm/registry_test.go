@@ -0,0 +1,94 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstanceMetaKey(t *testing.T) {
+	assert.Equal(t, "db01/dsn", instanceMetaKey("db01", metaDSN))
+	assert.Equal(t, "db01/", instanceMetaPrefix("db01"))
+	assert.True(t, isQANUUIDMetaKey(instanceMetaKey("db01", metaQANMySQLUUID)))
+	assert.True(t, isQANUUIDMetaKey(instanceMetaKey("db01", metaQANMongoDBUUID)))
+	assert.False(t, isQANUUIDMetaKey(instanceMetaKey("db01", metaDSN)))
+}
+
+func TestFileRegistry(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "pmm-client")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	r := &FileRegistry{File: filepath.Join(dir, "registry.json")}
+	node, err := r.ListNodeServices(ctx, "db01")
+	assert.Nil(t, err)
+	assert.Nil(t, node)
+
+	svc := &RegistryService{ID: "mysql:metrics-42002", Service: "mysql:metrics", Tags: []string{"alias_db01"}, Port: 42002}
+	assert.Nil(t, r.RegisterService(ctx, "db01", "127.0.0.1", svc))
+	node, err = r.ListNodeServices(ctx, "db01")
+	assert.Nil(t, err)
+	assert.Equal(t, &RegistryNode{Name: "db01", Address: "127.0.0.1", Services: []*RegistryService{svc}}, node)
+
+	nodes, err := r.FindServiceByAlias(ctx, "mysql:metrics", "db01")
+	assert.Nil(t, err)
+	assert.Len(t, nodes, 1)
+	nodes, err = r.FindServiceByAlias(ctx, "mysql:metrics", "db02")
+	assert.Nil(t, err)
+	assert.Empty(t, nodes)
+
+	// Metadata.
+	assert.Nil(t, r.SetServiceMeta(ctx, "db01", svc.ID, instanceMetaKey("db01", metaDSN), "root@/"))
+	assert.Nil(t, r.SetServiceMeta(ctx, "db01", svc.ID, instanceMetaKey("db02", metaDSN), "root@tcp(db02)/"))
+	value, err := r.GetServiceMeta(ctx, "db01", svc.ID, instanceMetaKey("db01", metaDSN))
+	assert.Nil(t, err)
+	assert.Equal(t, "root@/", value)
+	meta, err := r.ListServiceMeta(ctx, "db01", svc.ID, instanceMetaPrefix("db02"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{metaDSN: "root@tcp(db02)/"}, meta)
+	assert.Nil(t, r.DeleteServiceMeta(ctx, "db01", svc.ID, instanceMetaPrefix("db02")))
+	meta, err = r.ListServiceMeta(ctx, "db01", svc.ID, "")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"db01/dsn": "root@/"}, meta)
+	err = r.SetServiceMeta(ctx, "db01", "mysql:queries-42001", metaDSN, "root@/")
+	assert.EqualError(t, err, "service mysql:queries-42001 is not registered")
+
+	// Health.
+	assert.Nil(t, r.SetServiceHealth(ctx, "db01", "127.0.0.1", svc, HealthCheck{ServiceID: svc.ID, Status: "critical"}))
+	status, err := r.ListNodeHealth(ctx, "db01")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{svc.ID: "critical"}, status)
+
+	// Deregister.
+	assert.Nil(t, r.DeregisterService(ctx, "db01", svc.ID))
+	assert.Nil(t, r.DeregisterService(ctx, "db01", svc.ID))
+	node, err = r.ListNodeServices(ctx, "db01")
+	assert.Nil(t, err)
+	assert.Nil(t, node)
+	fi, err := os.Stat(r.File)
+	if assert.Nil(t, err) {
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	}
+}
@@ -0,0 +1,227 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/percona/pmm/proto"
+)
+
+// renamePlan is the list of changes to rename client with.
+// Services are registered again under the new name along with their metadata and the old ones are removed,
+// so it works the same with any registry.
+type renamePlan struct {
+	oldName, newName string
+	services         []*RegistryService // services with alias tags renamed
+	meta             []renameMeta
+	instances        []renameInstance
+	problems         Errors // things which can't be renamed, skipped if forced
+}
+
+// renameMeta is service metadata to move.
+type renameMeta struct {
+	serviceID      string
+	oldKey, newKey string
+	value          string
+}
+
+// renameInstance is QAN instance to rename.
+type renameInstance struct {
+	old, new proto.Instance
+}
+
+// checkRename plan renaming of client with its services, their metadata and QAN instances.
+// Problems found fail the rename unless forced to skip them.
+func (a *Admin) checkRename(ctx context.Context, oldName, newName string, force bool) (*renamePlan, error) {
+	node, err := a.registry().ListNodeServices(ctx, oldName)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := a.planRename(ctx, node, oldName, newName)
+	if err != nil {
+		return nil, err
+	}
+	if len(plan.problems) > 0 && !force {
+		return nil, fmt.Errorf("Unable to rename client safely: %s.\nYou can add --force flag to rename it anyway skipping the above.", plan.problems)
+	}
+	return plan, nil
+}
+
+// planRename check every step of the rename and return the plan.
+func (a *Admin) planRename(ctx context.Context, node *RegistryNode, oldName, newName string) (*renamePlan, error) {
+	plan := &renamePlan{oldName: oldName, newName: newName}
+	if node == nil {
+		return plan, nil
+	}
+
+	for _, svc := range node.Services {
+		newSvc := *svc
+		newSvc.Tags = nil
+		for _, tag := range svc.Tags {
+			if tag == fmt.Sprintf("alias_%s", oldName) {
+				tag = fmt.Sprintf("alias_%s", newName)
+			}
+			newSvc.Tags = append(newSvc.Tags, tag)
+		}
+		plan.services = append(plan.services, &newSvc)
+
+		// Target name should not have any data of the service left.
+		left, err := a.registry().ListServiceMeta(ctx, newName, svc.ID, "")
+		if err != nil {
+			return nil, err
+		}
+		if keys := sortedKeys(left); len(keys) > 0 {
+			return nil, fmt.Errorf("Registry has data of client %s left, e.g. %s of %s, so you cannot change client name as requested.", newName, keys[0], svc.ID)
+		}
+
+		meta, err := a.registry().ListServiceMeta(ctx, oldName, svc.ID, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range sortedKeys(meta) {
+			plan.meta = append(plan.meta, renameMeta{
+				serviceID: svc.ID,
+				oldKey:    key,
+				newKey:    renameMetaKey(key, oldName, newName),
+				value:     meta[key],
+			})
+
+			if !isQANUUIDMetaKey(key) {
+				continue
+			}
+			uuid := meta[key]
+			in, err := a.getInstance(ctx, uuid)
+			if err != nil {
+				plan.problems = append(plan.problems, fmt.Errorf("QAN instance %s: %w", uuid, err))
+				continue
+			}
+			if in.Name != oldName {
+				// Instance was added with its own name.
+				continue
+			}
+			renamed := in
+			renamed.Name = newName
+			plan.instances = append(plan.instances, renameInstance{old: in, new: renamed})
+		}
+	}
+
+	return plan, nil
+}
+
+// applyRename put everything in place under the new name all-or-nothing, rolling back on failure.
+// The old services are left until cleanupRename, so the returned undo only has to remove the new ones.
+func (a *Admin) applyRename(ctx context.Context, plan *renamePlan) (_ func() error, err error) {
+	var undo []func() error
+	defer func() {
+		if err == nil {
+			return
+		}
+		if e := undoAll(undo); e != nil {
+			err = fmt.Errorf("Renaming failed: %w. Rolling back failed too: %s", err, e)
+			return
+		}
+		err = fmt.Errorf("Renaming failed, changes were rolled back: %w", err)
+	}()
+
+	for _, in := range plan.instances {
+		if err := a.putQANInstance(ctx, in.new); err != nil {
+			return nil, err
+		}
+		old := in.old
+		undo = append(undo, func() error { return a.putQANInstance(ctx, old) })
+	}
+
+	// The new services did not exist before, so they are removed along with their metadata on rollback.
+	for _, svc := range plan.services {
+		id := svc.ID
+		undo = append(undo, func() error { return a.registry().DeregisterService(ctx, plan.newName, id) })
+		if err := a.registry().RegisterService(ctx, plan.newName, a.Config.ClientAddress, svc); err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range plan.meta {
+		if err := a.registry().SetServiceMeta(ctx, plan.newName, m.serviceID, m.newKey, m.value); err != nil {
+			return nil, err
+		}
+	}
+
+	return func() error { return undoAll(undo) }, nil
+}
+
+// cleanupRename remove the old services and their metadata once the rename is done.
+// Leftovers of the old name do not fail the rename, returned warnings are about them.
+func (a *Admin) cleanupRename(ctx context.Context, plan *renamePlan) (warnings []string) {
+	var errs Errors
+	for _, svc := range plan.services {
+		if err := a.registry().DeregisterService(ctx, plan.oldName, svc.ID); err != nil {
+			errs = append(errs, fmt.Errorf("unable to deregister old service %s: %w", svc.ID, err))
+		}
+	}
+	for _, in := range plan.instances {
+		if err := a.renameInstanceFile(in.new.UUID, in.new.Name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		warnings = append(warnings, fmt.Sprintf("WARNING: client was renamed, but cleanup partially failed: %s", errs))
+	}
+	return warnings
+}
+
+// renameMetaKey rename client in service metadata key <service name>/<key>.
+// Service name is renamed only if it equals the client name as it defaults to it.
+func renameMetaKey(key, oldName, newName string) string {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) == 2 && parts[0] == oldName {
+		return newName + "/" + parts[1]
+	}
+	return key
+}
+
+// putQANInstance update instance on QAN API.
+func (a *Admin) putQANInstance(ctx context.Context, in proto.Instance) error {
+	bytes, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return a.updateInstance(ctx, in.UUID, bytes)
+}
+
+// renameInstanceFile rename instance in qan-agent instance file.
+func (a *Admin) renameInstanceFile(uuid, name string) error {
+	file := fmt.Sprintf("%s/instance/%s.json", a.paths().AgentBaseDir, uuid)
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	instance := proto.Instance{}
+	if err := json.Unmarshal(bytes, &instance); err != nil {
+		return err
+	}
+	instance.Name = name
+	bytes, err = json.MarshalIndent(instance, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, bytes, 0600)
+}
@@ -0,0 +1,150 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/percona/pmm-client/test/fakeapi"
+	"github.com/percona/pmm/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenameMetaKey(t *testing.T) {
+	assert.Equal(t, "db02/dsn", renameMetaKey("db01/dsn", "db01", "db02"))
+	assert.Equal(t, "db01-slave/dsn", renameMetaKey("db01-slave/dsn", "db01", "db02"))
+	assert.Equal(t, "db02/qan_mysql_uuid", renameMetaKey("db01/qan_mysql_uuid", "db01", "db02"))
+}
+
+func TestRenameClientRollback(t *testing.T) {
+	api := fakeapi.New()
+	defer api.Close()
+
+	instance := proto.Instance{UUID: "2b6c3eb3669943c160502874036968ba", Subsystem: "mysql", Name: "db01"}
+	var puts []proto.Instance
+	api.Append("/qan-api/instances/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			data, _ := json.Marshal(instance)
+			w.Write(data)
+		case "PUT":
+			var in proto.Instance
+			data, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(data, &in)
+			puts = append(puts, in)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	api.Append("/v1/kv/", func(w http.ResponseWriter, r *http.Request) {
+		var kvs consul.KVPairs
+		if strings.HasPrefix(r.URL.Path, "/v1/kv/db01/") {
+			kvs = consul.KVPairs{{Key: "db01/mysql:queries-0/db01/qan_mysql_uuid", Value: []byte(instance.UUID)}}
+		}
+		if r.URL.Query().Get("keys") != "" {
+			var keys []string
+			for _, kvp := range kvs {
+				keys = append(keys, kvp.Key)
+			}
+			data, _ := json.Marshal(keys)
+			w.Write(data)
+			return
+		}
+		data, _ := json.Marshal(kvs)
+		w.Write(data)
+	})
+	deregistered := ""
+	api.Append("/v1/catalog/register", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	api.Append("/v1/catalog/deregister", func(w http.ResponseWriter, r *http.Request) {
+		var dereg consul.CatalogDeregistration
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &dereg)
+		deregistered = dereg.Node
+		w.Write([]byte("true"))
+	})
+
+	timeout := time.Second
+	admin := &Admin{Config: &Config{ClientName: "db01"}, serverURL: api.URL()}
+	admin.qanAPI = NewAPI(NewTransport(&tls.Config{}, nil, timeout, false), timeout, NoRetry)
+	admin.consulAPI, _ = consul.NewClient(&consul.Config{Address: strings.TrimPrefix(api.URL(), "http://")})
+
+	node := &RegistryNode{Name: "db01", Services: []*RegistryService{
+		{ID: "mysql:queries-0", Service: "mysql:queries", Tags: []string{"alias_db01"}},
+	}}
+	plan, err := admin.planRename(context.Background(), node, "db01", "db02")
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = admin.applyRename(context.Background(), plan)
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "Renaming failed, changes were rolled back:"), err.Error())
+
+	// Instance was renamed and then renamed back, the new node was removed and the old one left in place.
+	if assert.Len(t, puts, 2) {
+		assert.Equal(t, "db02", puts[0].Name)
+		assert.Equal(t, "db01", puts[1].Name)
+	}
+	assert.Equal(t, "db02", deregistered)
+}
+
+func TestRenameClientFileRegistry(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "pmm-rename")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	r := &FileRegistry{File: filepath.Join(dir, "registry.json")}
+	svc := &RegistryService{ID: "mysql:metrics-42002", Service: "mysql:metrics", Tags: []string{"alias_db01"}, Port: 42002}
+	assert.Nil(t, r.RegisterService(ctx, "db01", "10.0.0.1", svc))
+	assert.Nil(t, r.SetServiceMeta(ctx, "db01", svc.ID, instanceMetaKey("db01", metaDSN), "root@tcp(localhost:3306)/"))
+
+	admin := &Admin{Config: &Config{ClientName: "db01", ClientAddress: "10.0.0.1"}, Registry: r}
+	plan, err := admin.checkRename(ctx, "db01", "db02", false)
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = admin.applyRename(ctx, plan)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Empty(t, admin.cleanupRename(ctx, plan))
+
+	node, err := r.ListNodeServices(ctx, "db02")
+	if assert.Nil(t, err) && assert.NotNil(t, node) && assert.Len(t, node.Services, 1) {
+		assert.Equal(t, []string{"alias_db02"}, node.Services[0].Tags)
+	}
+	meta, err := r.ListServiceMeta(ctx, "db02", svc.ID, "")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"db02/dsn": "root@tcp(localhost:3306)/"}, meta)
+	node, err = r.ListNodeServices(ctx, "db01")
+	assert.Nil(t, err)
+	if node != nil {
+		assert.Empty(t, node.Services)
+	}
+}
@@ -18,11 +18,21 @@
 package pmm
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
 	service "github.com/percona/kardianos-service"
 )
 
+// ServiceFactory creates system service, it is the backend services are installed and controlled with.
+type ServiceFactory func(i service.Interface, c *service.Config) (service.Service, error)
+
 var (
-	NewService func(i service.Interface, c *service.Config) (service.Service, error) = service.New
+	// NewService is the default service backend of Admin.
+	NewService ServiceFactory = service.New
 )
 
 // @todo don't use singleton init, use dependency injection
@@ -32,6 +42,7 @@ func init() {
 		NewService = func(i service.Interface, c *service.Config) (service.Service, error) {
 			return &dummyService{}, nil
 		}
+		detectServiceManager = func() string { return "" }
 	}
 }
 
@@ -68,78 +79,166 @@ func (p *program) run() error {
 	return nil
 }
 
-func installService(svcConfig *service.Config) error {
-	prg := &program{}
-	svc, err := NewService(prg, svcConfig)
-	if err != nil {
+func (a *Admin) installService(svcConfig *service.Config) error {
+	if err := a.prepareService(svcConfig); err != nil {
 		return err
 	}
-	if err := svc.Install(); err != nil {
+	m := a.serviceManager()
+	if err := m.Install(svcConfig); err != nil {
 		return err
 	}
-	if err := svc.Start(); err != nil {
+	// The saved config is only needed for backup, so it does not fail the installation.
+	a.saveServiceConfig(svcConfig)
+	if err := m.Start(svcConfig.Name); err != nil {
 		return err
 	}
 	return nil
 }
 
-func uninstallService(name string) error {
-	prg := &program{}
-	svcConfig := &service.Config{Name: name}
-	svc, err := NewService(prg, svcConfig)
-	if err != nil {
-		return err
-	}
-	if err := svc.Status(); err == nil {
-		if err := svc.Stop(); err != nil {
+func (a *Admin) uninstallService(name string) error {
+	m := a.serviceManager()
+	if m.Running(name) {
+		if err := m.Stop(name); err != nil {
 			return err
 		}
 	}
-	if err := svc.Uninstall(); err != nil {
+	if err := m.Uninstall(name); err != nil {
 		return err
 	}
+	os.Remove(a.serviceConfigFile(name))
 	return nil
 }
 
-func startService(name string) error {
-	prg := &program{}
-	svcConfig := &service.Config{Name: name}
-	svc, err := NewService(prg, svcConfig)
-	if err != nil {
-		return err
+func (a *Admin) startService(name string) error {
+	// pmm-admin may have written new qan-agent files since the service was installed.
+	if a.Config != nil && a.Config.ServiceUser != "" {
+		if err := a.chownServiceFiles(a.Config.ServiceUser); err != nil {
+			return err
+		}
 	}
-	if err := svc.Status(); err != nil {
-		if err := svc.Start(); err != nil {
+	m := a.serviceManager()
+	if !m.Running(name) {
+		if err := m.Start(name); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func stopService(name string) error {
-	prg := &program{}
-	svcConfig := &service.Config{Name: name}
-	svc, err := NewService(prg, svcConfig)
-	if err != nil {
-		return err
-	}
-	if err := svc.Status(); err == nil {
-		if err := svc.Stop(); err != nil {
+func (a *Admin) stopService(name string) error {
+	m := a.serviceManager()
+	if m.Running(name) {
+		if err := m.Stop(name); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func getServiceStatus(name string) bool {
-	prg := &program{}
-	svcConfig := &service.Config{Name: name}
-	svc, err := NewService(prg, svcConfig)
+func (a *Admin) getServiceStatus(name string) bool {
+	return a.serviceManager().Running(name)
+}
+
+// GetLocalServices finds any local PMM services.
+func (a *Admin) GetLocalServices() []string {
+	services, _ := a.serviceManager().Services()
+	return services
+}
+
+// serviceConfigFile returns the file system service config is saved to.
+func (a *Admin) serviceConfigFile(name string) string {
+	return fmt.Sprintf("%s/%s.json", a.paths().ServiceConfigDir, name)
+}
+
+// saveServiceConfig save system service config, it may contain passwords.
+func (a *Admin) saveServiceConfig(svcConfig *service.Config) error {
+	if err := os.MkdirAll(a.paths().ServiceConfigDir, 0700); err != nil {
+		return err
+	}
+	bytes, err := json.MarshalIndent(svcConfig, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(a.serviceConfigFile(svcConfig.Name), bytes, 0600)
+}
+
+// loadServiceConfig load system service config saved on installation.
+// Services installed by older pmm-admin have none, their config is parsed from the service file then.
+func (a *Admin) loadServiceConfig(name string) (*service.Config, error) {
+	bytes, err := ioutil.ReadFile(a.serviceConfigFile(name))
+	if os.IsNotExist(err) {
+		file := a.serviceManager().ServiceFile(name)
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		svcConfig := parseServiceFile(name, data)
+		if svcConfig == nil {
+			return nil, fmt.Errorf("unable to parse service file %s", file)
+		}
+		return svcConfig, nil
+	}
 	if err != nil {
-		return false
+		return nil, err
 	}
-	if err := svc.Status(); err != nil {
-		return false
+	svcConfig := &service.Config{}
+	if err := json.Unmarshal(bytes, svcConfig); err != nil {
+		return nil, err
+	}
+	return svcConfig, nil
+}
+
+// parseServiceFile parse system service config from systemd unit, upstart job or SysV init script
+// written by older pmm-admin. It returns nil if there is no command line in the file.
+func parseServiceFile(name string, data []byte) *service.Config {
+	svcConfig := &service.Config{Name: name, DisplayName: name, Description: name}
+	var cmdline string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "ExecStart="):
+			cmdline = strings.TrimPrefix(line, "ExecStart=")
+			if strings.HasPrefix(cmdline, "/bin/sh -c '") {
+				cmdline = strings.TrimSuffix(strings.TrimPrefix(cmdline, "/bin/sh -c '"), "'")
+			}
+		case strings.HasPrefix(line, "exec "):
+			cmdline = strings.TrimPrefix(line, "exec ")
+		case strings.HasPrefix(line, "cmd='"):
+			cmdline = strings.TrimSuffix(strings.TrimPrefix(line, "cmd='"), "'")
+		case strings.HasPrefix(line, "Environment="):
+			svcConfig.Environment = append(svcConfig.Environment, unquoteServiceValue(strings.TrimPrefix(line, "Environment=")))
+		case strings.HasPrefix(line, "env "):
+			svcConfig.Environment = append(svcConfig.Environment, unquoteServiceValue(strings.TrimPrefix(line, "env ")))
+		case strings.HasPrefix(line, "export "):
+			svcConfig.Environment = append(svcConfig.Environment, unquoteServiceValue(strings.TrimPrefix(line, "export ")))
+		case strings.HasPrefix(line, "User="):
+			svcConfig.UserName = strings.TrimPrefix(line, "User=")
+		case strings.HasPrefix(line, "Description="):
+			svcConfig.Description = strings.TrimPrefix(line, "Description=")
+		case strings.HasPrefix(line, "# Description:"):
+			svcConfig.Description = strings.TrimSpace(strings.TrimPrefix(line, "# Description:"))
+		case strings.HasPrefix(line, "# Short-Description:"):
+			svcConfig.DisplayName = strings.TrimSpace(strings.TrimPrefix(line, "# Short-Description:"))
+		case strings.HasPrefix(line, "description "):
+			svcConfig.DisplayName = unquoteServiceValue(strings.TrimPrefix(line, "description "))
+		}
+	}
+
+	// Arguments are written space separated without quoting, the output is redirected to the log.
+	cmdline = strings.TrimSuffix(cmdline, fmt.Sprintf(" >> /var/log/%s.log 2>&1", name))
+	fields := strings.Fields(cmdline)
+	if len(fields) == 0 {
+		return nil
+	}
+	svcConfig.Executable = strings.Replace(fields[0], `\x20`, " ", -1)
+	svcConfig.Arguments = fields[1:]
+	return svcConfig
+}
+
+// unquoteServiceValue remove double quotes of the value in service file.
+func unquoteServiceValue(s string) string {
+	if len(s) >= 2 && strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
+		s = strings.Replace(s[1:len(s)-1], `\"`, `"`, -1)
 	}
-	return true
+	return s
 }
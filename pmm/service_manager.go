@@ -0,0 +1,400 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	service "github.com/percona/kardianos-service"
+)
+
+// ServiceManager installs and controls system services of exporters and qan-agent.
+type ServiceManager interface {
+	// Name returns name of the service manager, e.g. linux-systemd or openrc.
+	Name() string
+	// Install creates service, it is started with Start.
+	Install(c *service.Config) error
+	Uninstall(name string) error
+	Start(name string) error
+	Stop(name string) error
+	Running(name string) bool
+	// Services returns names of installed PMM services, i.e. pmm-*.
+	Services() ([]string, error)
+	// ServiceFile returns the file service command line is kept in.
+	ServiceFile(name string) string
+	// Reload makes service manager pick up changed service files.
+	Reload() error
+}
+
+// ServiceManagers are the names of service managers accepted by NewServiceManager.
+// The first four are handled by the native backend of the platform.
+var ServiceManagers = []string{"systemd", "upstart", "sysv", "launchd", "openrc", "runit", "s6", "supervisord"}
+
+// detectServiceManager returns name of service manager running on the system, empty for the native one.
+var detectServiceManager = DetectServiceManager
+
+// NewServiceManager returns service manager by name, detected one if name is empty or "auto".
+// newService is the backend of the native service manager, NewService if nil.
+func NewServiceManager(name string, newService ServiceFactory) (ServiceManager, error) {
+	if name == "" || name == "auto" {
+		name = detectServiceManager()
+	}
+	switch name {
+	case "", "systemd", "upstart", "sysv", "launchd":
+		if newService == nil {
+			newService = NewService
+		}
+		return &NativeServiceManager{NewService: newService}, nil
+	case "openrc":
+		return &OpenRCServiceManager{}, nil
+	case "runit":
+		return &RunitServiceManager{}, nil
+	case "s6":
+		return &S6ServiceManager{}, nil
+	case "supervisord":
+		return &SupervisordServiceManager{}, nil
+	}
+	return nil, fmt.Errorf("Unknown service manager %s, it should be one of: auto, %s.", name, strings.Join(ServiceManagers, ", "))
+}
+
+// DetectServiceManager returns name of service manager which is not supported natively
+// if the system runs it, or empty string otherwise.
+// systemd takes precedence as other managers may run under it.
+func DetectServiceManager() string {
+	if FileExists(RootDir + "/run/systemd/system") {
+		return ""
+	}
+	if FileExists(RootDir + "/run/openrc") {
+		return "openrc"
+	}
+	comm, _ := ioutil.ReadFile("/proc/1/comm")
+	return serviceManagerOfInit(strings.TrimSpace(string(comm)))
+}
+
+// serviceManagerOfInit returns service manager by the name of the init process.
+func serviceManagerOfInit(comm string) string {
+	switch comm {
+	case "runit", "runsvdir":
+		return "runit"
+	case "s6-svscan":
+		return "s6"
+	case "supervisord":
+		return "supervisord"
+	}
+	return ""
+}
+
+// serviceManager returns service manager of Admin: the one set explicitly,
+// the one from the config, or the detected one.
+// Custom service factory implies the native service manager.
+func (a *Admin) serviceManager() ServiceManager {
+	if a.ServiceManager != nil {
+		return a.ServiceManager
+	}
+	name := ""
+	if a.Config != nil {
+		name = a.Config.ServiceManager
+	}
+	if name == "" && a.NewService != nil {
+		name = "systemd"
+	}
+	m, err := NewServiceManager(name, a.NewService)
+	if err != nil {
+		// The config is validated on write, so fall back to the native one.
+		m, _ = NewServiceManager("systemd", a.NewService)
+	}
+	return m
+}
+
+// NativeServiceManager is the service manager of the platform supported by kardianos-service:
+// systemd, upstart, SysV init or launchd.
+type NativeServiceManager struct {
+	NewService ServiceFactory
+}
+
+// Name returns name of the service manager platform.
+func (m *NativeServiceManager) Name() string {
+	return service.Platform()
+}
+
+func (m *NativeServiceManager) service(c *service.Config) (service.Service, error) {
+	return m.NewService(&program{}, c)
+}
+
+// Install creates service. systemd unit gets drop-in with sandboxing, resource limits and environment file if they are set.
+func (m *NativeServiceManager) Install(c *service.Config) error {
+	if m.Name() != "linux-systemd" {
+		c = inlineEnvironmentFile(c)
+	}
+	svc, err := m.service(c)
+	if err != nil {
+		return err
+	}
+	if err := svc.Install(); err != nil {
+		return err
+	}
+	file := m.ServiceFile(c.Name)
+	// Mocked service backend of tests writes no service file.
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil
+	}
+	if m.Name() != "linux-systemd" {
+		// Only systemd units can read the environment file, the service file is made private instead.
+		if optionString(c, optionEnvironmentFile) == "" {
+			return nil
+		}
+		return os.Chmod(file, fi.Mode()&0700)
+	}
+	if dropIn := systemdDropIn(c); dropIn != "" {
+		file := systemdDropInFile(file)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(file, []byte(dropIn), 0644); err != nil {
+			return err
+		}
+		return m.Reload()
+	}
+	return nil
+}
+
+// Uninstall removes service with its drop-in.
+func (m *NativeServiceManager) Uninstall(name string) error {
+	svc, err := m.service(&service.Config{Name: name})
+	if err != nil {
+		return err
+	}
+	if err := svc.Uninstall(); err != nil {
+		return err
+	}
+	file := systemdDropInFile(m.ServiceFile(name))
+	if FileExists(file) {
+		os.Remove(file)
+		// The dir may have drop-ins of the administrator.
+		os.Remove(filepath.Dir(file))
+	}
+	return nil
+}
+
+// Start starts service.
+func (m *NativeServiceManager) Start(name string) error {
+	svc, err := m.service(&service.Config{Name: name})
+	if err != nil {
+		return err
+	}
+	return svc.Start()
+}
+
+// Stop stops service.
+func (m *NativeServiceManager) Stop(name string) error {
+	svc, err := m.service(&service.Config{Name: name})
+	if err != nil {
+		return err
+	}
+	return svc.Stop()
+}
+
+// Running returns true if service is running.
+func (m *NativeServiceManager) Running(name string) bool {
+	svc, err := m.service(&service.Config{Name: name})
+	if err != nil {
+		return false
+	}
+	return svc.Status() == nil
+}
+
+// Services returns PMM services found in the dir of service files.
+func (m *NativeServiceManager) Services() ([]string, error) {
+	dir, extension := GetServiceDirAndExtension()
+	return findServices(dir, extension)
+}
+
+// ServiceFile returns service file in the dir of the platform.
+func (m *NativeServiceManager) ServiceFile(name string) string {
+	dir, extension := GetServiceDirAndExtension()
+	return fmt.Sprintf("%s/%s%s", dir, name, extension)
+}
+
+// Reload reloads systemd units. Only systemd caches them, the others read the files on start.
+func (m *NativeServiceManager) Reload() error {
+	if service.Platform() != "linux-systemd" {
+		return nil
+	}
+	return runServiceCommand("systemctl", "daemon-reload")
+}
+
+// findServices returns names of PMM services from files pmm-*<extension> in dir.
+func findServices(dir, extension string) ([]string, error) {
+	filesFound, err := filepath.Glob(fmt.Sprintf("%s/pmm-*%s", dir, extension))
+	if err != nil {
+		return nil, err
+	}
+	rService := regexp.MustCompile(fmt.Sprintf("^%s/(pmm-.+)%s$", regexp.QuoteMeta(dir), regexp.QuoteMeta(extension)))
+	var services []string
+	for _, f := range filesFound {
+		if data := rService.FindStringSubmatch(f); data != nil {
+			services = append(services, data[1])
+		}
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+// runServiceCommand runs command of service manager and returns its output in error if it fails.
+func runServiceCommand(name string, args ...string) error {
+	_, err := serviceCommandOutput(name, args...)
+	return err
+}
+
+// serviceCommandOutput runs command of service manager and returns its combined output.
+func serviceCommandOutput(name string, args ...string) (string, error) {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("%s %s: %w, %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+// serviceCommand returns absolute path of service executable and its shell-quoted arguments.
+// Executable defaults to the current one as in kardianos-service.
+func serviceCommand(c *service.Config) (executable, args string, err error) {
+	executable = c.Executable
+	if executable == "" {
+		if executable, err = os.Executable(); err != nil {
+			return "", "", err
+		}
+	}
+	if executable, err = filepath.Abs(executable); err != nil {
+		return "", "", err
+	}
+	var words []string
+	for _, arg := range c.Arguments {
+		words = append(words, shellQuote(arg))
+	}
+	return executable, strings.Join(words, " "), nil
+}
+
+// serviceLogFile returns the file output of services is appended to by non-native service managers.
+func serviceLogFile(name string) string {
+	return fmt.Sprintf("%s/var/log/%s.log", RootDir, name)
+}
+
+// shellUnsafe matches characters which need quoting in shell.
+var shellUnsafe = regexp.MustCompile(`[^\w@%+=:,./-]`)
+
+// shellQuote quotes word for shell if needed.
+func shellQuote(s string) string {
+	if s != "" && !shellUnsafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// shellScript returns body of sh run script executing service command with output appended to the log file.
+// setuid is the program to run the command as another user with, e.g. chpst -u.
+func shellScript(c *service.Config, setuid string) (string, error) {
+	executable, args, err := serviceCommand(c)
+	if err != nil {
+		return "", err
+	}
+	command := strings.TrimSpace(shellQuote(executable) + " " + args)
+	script := "#!/bin/sh\n"
+	if c.Description != "" {
+		script += fmt.Sprintf("# %s\n", c.Description)
+	}
+	if c.WorkingDirectory != "" {
+		script += fmt.Sprintf("cd %s || exit 1\n", shellQuote(c.WorkingDirectory))
+	}
+	for _, env := range c.Environment {
+		script += fmt.Sprintf("export %s\n", shellQuoteEnv(env))
+	}
+	script += sourceEnvironmentFile(c)
+	if c.UserName != "" {
+		command = fmt.Sprintf("%s %s %s", setuid, shellQuote(c.UserName), command)
+	}
+	script += fmt.Sprintf("exec %s >> %s 2>&1\n", command, shellQuote(serviceLogFile(c.Name)))
+	return script, nil
+}
+
+// shellQuoteEnv quotes value of environment variable var=value.
+func shellQuoteEnv(env string) string {
+	parts := strings.SplitN(env, "=", 2)
+	if len(parts) != 2 {
+		return shellQuote(env)
+	}
+	return parts[0] + "=" + shellQuote(parts[1])
+}
+
+// sourceEnvironmentFile returns sh lines exporting variables of the environment file if it exists.
+func sourceEnvironmentFile(c *service.Config) string {
+	file := optionString(c, optionEnvironmentFile)
+	if file == "" {
+		return ""
+	}
+	return fmt.Sprintf("if [ -f %s ]; then set -a; . %s; set +a; fi\n", shellQuote(file), shellQuote(file))
+}
+
+// inlineEnvironmentFile returns copy of service config with variables of the environment file added to its environment,
+// for service managers which can't read the file on their own.
+func inlineEnvironmentFile(c *service.Config) *service.Config {
+	file := optionString(c, optionEnvironmentFile)
+	if file == "" {
+		return c
+	}
+	copied := *c
+	copied.Environment = append([]string{}, c.Environment...)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return &copied
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) != 2 || strings.HasPrefix(parts[0], "#") {
+			continue
+		}
+		copied.Environment = append(copied.Environment, parts[0]+"="+shellUnquote(parts[1]))
+	}
+	return &copied
+}
+
+// shellUnquote removes quoting of shellQuote.
+func shellUnquote(s string) string {
+	if len(s) < 2 || !strings.HasPrefix(s, "'") || !strings.HasSuffix(s, "'") {
+		return s
+	}
+	return strings.Replace(s[1:len(s)-1], `'\''`, "'", -1)
+}
+
+// writeServiceFile writes service file creating its dir, it fails if the file exists.
+func writeServiceFile(file string, data string, mode os.FileMode) error {
+	if FileExists(file) {
+		return fmt.Errorf("Init already exists: %s", file)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, []byte(data), mode)
+}
@@ -0,0 +1,154 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	service "github.com/percona/kardianos-service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewServiceManager(t *testing.T) {
+	detected := ""
+	defer func(f func() string) { detectServiceManager = f }(detectServiceManager)
+	detectServiceManager = func() string { return detected }
+
+	for name, expected := range map[string]ServiceManager{
+		"":            &NativeServiceManager{},
+		"auto":        &NativeServiceManager{},
+		"systemd":     &NativeServiceManager{},
+		"sysv":        &NativeServiceManager{},
+		"openrc":      &OpenRCServiceManager{},
+		"runit":       &RunitServiceManager{},
+		"s6":          &S6ServiceManager{},
+		"supervisord": &SupervisordServiceManager{},
+	} {
+		m, err := NewServiceManager(name, nil)
+		assert.NoError(t, err, name)
+		assert.IsType(t, expected, m, name)
+	}
+
+	detected = "runit"
+	m, err := NewServiceManager("auto", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "runit", m.Name())
+
+	_, err = NewServiceManager("daemontools", nil)
+	assert.EqualError(t, err, "Unknown service manager daemontools, it should be one of: auto, systemd, upstart, sysv, launchd, openrc, runit, s6, supervisord.")
+
+	assert.Equal(t, "s6", serviceManagerOfInit("s6-svscan"))
+	assert.Equal(t, "runit", serviceManagerOfInit("runsvdir"))
+	assert.Equal(t, "", serviceManagerOfInit("systemd"))
+}
+
+func TestServiceScripts(t *testing.T) {
+	c := &service.Config{
+		Name:        "pmm-mysql-metrics-42002",
+		Description: "PMM Prometheus mysqld_exporter",
+		Executable:  "/usr/local/percona/pmm-client/mysqld_exporter",
+		Arguments:   []string{"-web.listen-address=10.0.0.1:42002", "-collect.info_schema.tables=true", "-web.auth-file=/a dir/pmm.yml"},
+		Environment: []string{"DATA_SOURCE_NAME=user:it's@tcp(10.0.0.1:3306)/?timeout=1s&x=10%"},
+	}
+	args := `-web.listen-address=10.0.0.1:42002 -collect.info_schema.tables=true '-web.auth-file=/a dir/pmm.yml'`
+
+	script, err := openrcScript(c)
+	assert.NoError(t, err)
+	assert.Equal(t, `#!/sbin/openrc-run
+
+description='PMM Prometheus mysqld_exporter'
+command=/usr/local/percona/pmm-client/mysqld_exporter
+command_args='-web.listen-address=10.0.0.1:42002 -collect.info_schema.tables=true '\''-web.auth-file=/a dir/pmm.yml'\'''
+command_background=true
+pidfile="/run/${RC_SVCNAME}.pid"
+output_log=/var/log/pmm-mysql-metrics-42002.log
+error_log=/var/log/pmm-mysql-metrics-42002.log
+export DATA_SOURCE_NAME='user:it'\''s@tcp(10.0.0.1:3306)/?timeout=1s&x=10%'
+
+depend() {
+	need net
+	after firewall
+}
+`, script)
+
+	c.UserName = "pmm"
+	c.Option = service.KeyValue{optionEnvironmentFile: "/a dir/proxy.env"}
+	script, err = shellScript(c, "chpst -u")
+	assert.NoError(t, err)
+	assert.Equal(t, `#!/bin/sh
+# PMM Prometheus mysqld_exporter
+export DATA_SOURCE_NAME='user:it'\''s@tcp(10.0.0.1:3306)/?timeout=1s&x=10%'
+if [ -f '/a dir/proxy.env' ]; then set -a; . '/a dir/proxy.env'; set +a; fi
+exec chpst -u pmm /usr/local/percona/pmm-client/mysqld_exporter `+args+` >> /var/log/pmm-mysql-metrics-42002.log 2>&1
+`, script)
+
+	program, err := supervisordProgram(c)
+	assert.NoError(t, err)
+	assert.Equal(t, `; PMM Prometheus mysqld_exporter
+[program:pmm-mysql-metrics-42002]
+command=/usr/local/percona/pmm-client/mysqld_exporter `+args+`
+user=pmm
+environment=DATA_SOURCE_NAME="user:it's@tcp(10.0.0.1:3306)/?timeout=1s&x=10%%"
+autostart=false
+autorestart=true
+redirect_stderr=true
+stdout_logfile=/var/log/pmm-mysql-metrics-42002.log
+stdout_logfile_maxbytes=0
+`, program)
+
+	// Listen address can be rewritten in any of them.
+	for _, s := range []string{script, program} {
+		assert.Contains(t, string(replaceListenAddress([]byte(s), "10.0.0.1", "10.0.0.2")), "-web.listen-address=10.0.0.2:42002")
+	}
+}
+
+func TestServiceStatus(t *testing.T) {
+	assert.True(t, isRunitRunning("run: /etc/service/pmm-linux-metrics-42000: (pid 123) 5s\n"))
+	assert.False(t, isRunitRunning("down: /etc/service/pmm-linux-metrics-42000: 3s, normally up\n"))
+	assert.True(t, isS6Running("up (pid 123) 5 seconds\n"))
+	assert.False(t, isS6Running("down (exitcode 1) 2 seconds, normally up, want up\n"))
+	assert.True(t, isSupervisordRunning("pmm-linux-metrics-42000          RUNNING   pid 123, uptime 0:00:05\n", "pmm-linux-metrics-42000"))
+	assert.False(t, isSupervisordRunning("pmm-linux-metrics-42000          STOPPED   Oct 18 10:00 AM\n", "pmm-linux-metrics-42000"))
+	assert.False(t, isSupervisordRunning("pmm-linux-metrics-42000: ERROR (no such process)\n", "pmm-linux-metrics-42000"))
+}
+
+func TestRunitServiceDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmm-runit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	m := &RunitServiceManager{ServiceDir: filepath.Join(dir, "sv"), ScanDir: filepath.Join(dir, "service")}
+
+	c := &service.Config{Name: "pmm-linux-metrics-42000", Executable: "/usr/local/percona/pmm-client/node_exporter"}
+	assert.NoError(t, m.Install(c))
+	assert.Error(t, m.Install(c))
+	assert.True(t, FileExists(filepath.Join(dir, "service", c.Name, "down")))
+	assert.Equal(t, filepath.Join(dir, "sv", c.Name, "run"), m.ServiceFile(c.Name))
+
+	services, err := m.Services()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{c.Name}, services)
+
+	assert.NoError(t, m.Uninstall(c.Name))
+	assert.False(t, FileExists(filepath.Join(dir, "service", c.Name)))
+	services, err = m.Services()
+	assert.NoError(t, err)
+	assert.Empty(t, services)
+}
@@ -0,0 +1,129 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	service "github.com/percona/kardianos-service"
+)
+
+// OpenRCServiceManager manages services with OpenRC init scripts.
+type OpenRCServiceManager struct {
+	InitDir  string // dir of init scripts, /etc/init.d by default
+	Runlevel string // runlevel services are added to, default by default
+}
+
+// Name returns "openrc".
+func (m *OpenRCServiceManager) Name() string {
+	return "openrc"
+}
+
+func (m *OpenRCServiceManager) initDir() string {
+	if m.InitDir != "" {
+		return m.InitDir
+	}
+	return RootDir + "/etc/init.d"
+}
+
+func (m *OpenRCServiceManager) runlevel() string {
+	if m.Runlevel != "" {
+		return m.Runlevel
+	}
+	return "default"
+}
+
+// Install writes init script and adds it to the runlevel.
+func (m *OpenRCServiceManager) Install(c *service.Config) error {
+	script, err := openrcScript(c)
+	if err != nil {
+		return err
+	}
+	if err := writeServiceFile(m.ServiceFile(c.Name), script, 0755); err != nil {
+		return err
+	}
+	return runServiceCommand("rc-update", "add", c.Name, m.runlevel())
+}
+
+// Uninstall removes service from the runlevel and removes its init script.
+func (m *OpenRCServiceManager) Uninstall(name string) error {
+	runServiceCommand("rc-update", "del", name, m.runlevel())
+	return os.Remove(m.ServiceFile(name))
+}
+
+// Start starts service.
+func (m *OpenRCServiceManager) Start(name string) error {
+	return runServiceCommand("rc-service", name, "start")
+}
+
+// Stop stops service.
+func (m *OpenRCServiceManager) Stop(name string) error {
+	return runServiceCommand("rc-service", name, "stop")
+}
+
+// Running returns true if service is started, rc-service exits with non-zero code otherwise.
+func (m *OpenRCServiceManager) Running(name string) bool {
+	return exec.Command("rc-service", name, "status").Run() == nil
+}
+
+// Services returns PMM services found in the dir of init scripts.
+func (m *OpenRCServiceManager) Services() ([]string, error) {
+	return findServices(m.initDir(), "")
+}
+
+// ServiceFile returns init script of service.
+func (m *OpenRCServiceManager) ServiceFile(name string) string {
+	return fmt.Sprintf("%s/%s", m.initDir(), name)
+}
+
+// Reload does nothing as OpenRC reads init scripts on start.
+func (m *OpenRCServiceManager) Reload() error {
+	return nil
+}
+
+// openrcScript returns OpenRC init script running service in background with output appended to its log.
+func openrcScript(c *service.Config) (string, error) {
+	executable, args, err := serviceCommand(c)
+	if err != nil {
+		return "", err
+	}
+
+	script := "#!/sbin/openrc-run\n\n"
+	script += fmt.Sprintf("description=%s\n", shellQuote(c.Description))
+	script += fmt.Sprintf("command=%s\n", shellQuote(executable))
+	// command_args are evaluated by openrc-run, so the quoting of single arguments is kept.
+	script += fmt.Sprintf("command_args=%s\n", shellQuote(args))
+	script += "command_background=true\n"
+	if c.UserName != "" {
+		script += fmt.Sprintf("command_user=%s\n", shellQuote(c.UserName))
+	}
+	if c.WorkingDirectory != "" {
+		script += fmt.Sprintf("directory=%s\n", shellQuote(c.WorkingDirectory))
+	}
+	script += "pidfile=\"/run/${RC_SVCNAME}.pid\"\n"
+	logFile := shellQuote(serviceLogFile(c.Name))
+	script += fmt.Sprintf("output_log=%s\nerror_log=%s\n", logFile, logFile)
+	for _, env := range c.Environment {
+		script += fmt.Sprintf("export %s\n", shellQuoteEnv(env))
+	}
+	script += sourceEnvironmentFile(c)
+	script += "\ndepend() {\n\tneed net\n\tafter firewall\n}\n"
+	return script, nil
+}
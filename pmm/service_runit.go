@@ -0,0 +1,156 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	service "github.com/percona/kardianos-service"
+)
+
+// superviseTimeout is how long to wait for supervisor to pick up new service dir.
+// runsvdir and s6-svscan scan their dir every 5 seconds.
+const superviseTimeout = 10 * time.Second
+
+// RunitServiceManager manages services with runit.
+// Service dirs are created in ServiceDir and linked into ScanDir watched by runsvdir.
+type RunitServiceManager struct {
+	ServiceDir string // /etc/sv by default
+	ScanDir    string // /etc/service, or /var/service if only it exists
+}
+
+// Name returns "runit".
+func (m *RunitServiceManager) Name() string {
+	return "runit"
+}
+
+func (m *RunitServiceManager) serviceDir() string {
+	if m.ServiceDir != "" {
+		return m.ServiceDir
+	}
+	return RootDir + "/etc/sv"
+}
+
+func (m *RunitServiceManager) scanDir() string {
+	if m.ScanDir != "" {
+		return m.ScanDir
+	}
+	if !FileExists(RootDir+"/etc/service") && FileExists(RootDir+"/var/service") {
+		return RootDir + "/var/service"
+	}
+	return RootDir + "/etc/service"
+}
+
+// Install creates service dir with run script and links it into the scan dir.
+// The service is marked down, so it is not started until Start.
+func (m *RunitServiceManager) Install(c *service.Config) error {
+	script, err := shellScript(c, "chpst -u")
+	if err != nil {
+		return err
+	}
+	return installServiceDir(m.serviceDir(), m.scanDir(), c.Name, script)
+}
+
+// Uninstall stops service and removes its dirs, runsvdir stops supervising it on the next scan.
+func (m *RunitServiceManager) Uninstall(name string) error {
+	runServiceCommand("sv", "down", filepath.Join(m.scanDir(), name))
+	return uninstallServiceDir(m.serviceDir(), m.scanDir(), name)
+}
+
+// Start starts service once runsvdir picks it up.
+func (m *RunitServiceManager) Start(name string) error {
+	dir := filepath.Join(m.scanDir(), name)
+	if err := startServiceDir(dir, "supervise/ok"); err != nil {
+		return err
+	}
+	return runServiceCommand("sv", "up", dir)
+}
+
+// Stop stops service.
+func (m *RunitServiceManager) Stop(name string) error {
+	return runServiceCommand("sv", "down", filepath.Join(m.scanDir(), name))
+}
+
+// Running returns true if sv reports service is running.
+func (m *RunitServiceManager) Running(name string) bool {
+	output, err := serviceCommandOutput("sv", "status", filepath.Join(m.scanDir(), name))
+	return err == nil && isRunitRunning(output)
+}
+
+// Services returns PMM services found in the service dir.
+func (m *RunitServiceManager) Services() ([]string, error) {
+	return findServices(m.serviceDir(), "")
+}
+
+// ServiceFile returns run script of service.
+func (m *RunitServiceManager) ServiceFile(name string) string {
+	return filepath.Join(m.serviceDir(), name, "run")
+}
+
+// Reload does nothing as run script is read on every start.
+func (m *RunitServiceManager) Reload() error {
+	return nil
+}
+
+// isRunitRunning parses sv status output, e.g. "run: /etc/service/pmm-linux-metrics-42000: (pid 123) 5s".
+func isRunitRunning(output string) bool {
+	return strings.HasPrefix(output, "run: ")
+}
+
+// installServiceDir creates dir of supervised service with run script and links it into the scan dir.
+// The down file keeps supervisor from starting the service as soon as it finds it.
+func installServiceDir(serviceDir, scanDir, name, script string) error {
+	dir := filepath.Join(serviceDir, name)
+	if err := writeServiceFile(filepath.Join(dir, "run"), script, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "down"), nil, 0644); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(scanDir, 0755); err != nil {
+		return err
+	}
+	return os.Symlink(dir, filepath.Join(scanDir, name))
+}
+
+// uninstallServiceDir removes link of supervised service from the scan dir and its dir.
+func uninstallServiceDir(serviceDir, scanDir, name string) error {
+	if err := os.Remove(filepath.Join(scanDir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(serviceDir, name))
+}
+
+// startServiceDir removes the down file of supervised service, so it is started on boot,
+// and waits for supervisor to create the control file.
+func startServiceDir(dir, controlFile string) error {
+	if err := os.Remove(filepath.Join(dir, "down")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for start := time.Now(); !FileExists(filepath.Join(dir, controlFile)); time.Sleep(100 * time.Millisecond) {
+		if time.Since(start) > superviseTimeout {
+			return fmt.Errorf("Service %s is not supervised after %s, check that its supervisor watches %s.", filepath.Base(dir), superviseTimeout, filepath.Dir(dir))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"path/filepath"
+	"strings"
+
+	service "github.com/percona/kardianos-service"
+)
+
+// S6ServiceManager manages services with s6.
+// Service dirs are created in ServiceDir and linked into ScanDir watched by s6-svscan.
+type S6ServiceManager struct {
+	ServiceDir string // /etc/s6/sv by default
+	ScanDir    string // /run/service by default
+}
+
+// Name returns "s6".
+func (m *S6ServiceManager) Name() string {
+	return "s6"
+}
+
+func (m *S6ServiceManager) serviceDir() string {
+	if m.ServiceDir != "" {
+		return m.ServiceDir
+	}
+	return RootDir + "/etc/s6/sv"
+}
+
+func (m *S6ServiceManager) scanDir() string {
+	if m.ScanDir != "" {
+		return m.ScanDir
+	}
+	return RootDir + "/run/service"
+}
+
+// Install creates service dir with run script, links it into the scan dir and makes s6-svscan pick it up.
+// The service is marked down, so it is not started until Start.
+func (m *S6ServiceManager) Install(c *service.Config) error {
+	script, err := shellScript(c, "s6-setuidgid")
+	if err != nil {
+		return err
+	}
+	if err := installServiceDir(m.serviceDir(), m.scanDir(), c.Name, script); err != nil {
+		return err
+	}
+	return m.Reload()
+}
+
+// Uninstall stops service, removes its dirs and makes s6-svscan forget it.
+func (m *S6ServiceManager) Uninstall(name string) error {
+	runServiceCommand("s6-svc", "-d", filepath.Join(m.scanDir(), name))
+	if err := uninstallServiceDir(m.serviceDir(), m.scanDir(), name); err != nil {
+		return err
+	}
+	return runServiceCommand("s6-svscanctl", "-an", m.scanDir())
+}
+
+// Start starts service once s6-supervise is running for it.
+func (m *S6ServiceManager) Start(name string) error {
+	dir := filepath.Join(m.scanDir(), name)
+	if err := startServiceDir(dir, "supervise/status"); err != nil {
+		return err
+	}
+	return runServiceCommand("s6-svc", "-u", dir)
+}
+
+// Stop stops service.
+func (m *S6ServiceManager) Stop(name string) error {
+	return runServiceCommand("s6-svc", "-d", filepath.Join(m.scanDir(), name))
+}
+
+// Running returns true if s6-svstat reports service is up.
+func (m *S6ServiceManager) Running(name string) bool {
+	output, err := serviceCommandOutput("s6-svstat", filepath.Join(m.scanDir(), name))
+	return err == nil && isS6Running(output)
+}
+
+// Services returns PMM services found in the service dir.
+func (m *S6ServiceManager) Services() ([]string, error) {
+	return findServices(m.serviceDir(), "")
+}
+
+// ServiceFile returns run script of service.
+func (m *S6ServiceManager) ServiceFile(name string) string {
+	return filepath.Join(m.serviceDir(), name, "run")
+}
+
+// Reload makes s6-svscan rescan its dir.
+func (m *S6ServiceManager) Reload() error {
+	return runServiceCommand("s6-svscanctl", "-a", m.scanDir())
+}
+
+// isS6Running parses s6-svstat output, e.g. "up (pid 123) 5 seconds".
+func isS6Running(output string) bool {
+	return strings.HasPrefix(output, "up ")
+}
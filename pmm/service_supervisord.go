@@ -0,0 +1,176 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	service "github.com/percona/kardianos-service"
+)
+
+// SupervisordServiceManager manages services as supervisord programs.
+type SupervisordServiceManager struct {
+	ConfDir   string // dir of included program files, /etc/supervisor/conf.d or /etc/supervisord.d by default
+	Extension string // extension of program files, .conf or .ini by default
+}
+
+// Name returns "supervisord".
+func (m *SupervisordServiceManager) Name() string {
+	return "supervisord"
+}
+
+// confDir returns dir and extension of program files included by the default config of Debian or RHEL package.
+func (m *SupervisordServiceManager) confDir() (dir, extension string) {
+	if m.ConfDir != "" {
+		return m.ConfDir, m.Extension
+	}
+	if !FileExists(RootDir+"/etc/supervisor/conf.d") && FileExists(RootDir+"/etc/supervisord.d") {
+		return RootDir + "/etc/supervisord.d", ".ini"
+	}
+	return RootDir + "/etc/supervisor/conf.d", ".conf"
+}
+
+// Install writes program file and adds program to supervisord.
+// Program is not started until Start, which also enables its autostart on boot.
+func (m *SupervisordServiceManager) Install(c *service.Config) error {
+	// The program file is private, so the environment file is added to it.
+	program, err := supervisordProgram(inlineEnvironmentFile(c))
+	if err != nil {
+		return err
+	}
+	if err := writeServiceFile(m.ServiceFile(c.Name), program, 0600); err != nil {
+		return err
+	}
+	if err := m.Reload(); err != nil {
+		return err
+	}
+	return runServiceCommand("supervisorctl", "add", c.Name)
+}
+
+// Uninstall removes program from supervisord and its program file.
+func (m *SupervisordServiceManager) Uninstall(name string) error {
+	runServiceCommand("supervisorctl", "remove", name)
+	if err := os.Remove(m.ServiceFile(name)); err != nil {
+		return err
+	}
+	return m.Reload()
+}
+
+// Start enables autostart of program, so it is started on boot, and starts it.
+func (m *SupervisordServiceManager) Start(name string) error {
+	if err := m.enableAutostart(name); err != nil {
+		return err
+	}
+	output, err := serviceCommandOutput("supervisorctl", "start", name)
+	if err != nil && !strings.Contains(output, "already started") {
+		return err
+	}
+	return nil
+}
+
+// Stop stops program.
+func (m *SupervisordServiceManager) Stop(name string) error {
+	output, err := serviceCommandOutput("supervisorctl", "stop", name)
+	if err != nil && !strings.Contains(output, "not running") {
+		return err
+	}
+	return nil
+}
+
+// Running returns true if supervisorctl reports program is running.
+// Its exit code is non-zero for stopped programs only since supervisor 4, so the output is parsed.
+func (m *SupervisordServiceManager) Running(name string) bool {
+	output, _ := serviceCommandOutput("supervisorctl", "status", name)
+	return isSupervisordRunning(output, name)
+}
+
+// Services returns PMM services found in the dir of program files.
+func (m *SupervisordServiceManager) Services() ([]string, error) {
+	return findServices(m.confDir())
+}
+
+// ServiceFile returns program file of service.
+func (m *SupervisordServiceManager) ServiceFile(name string) string {
+	dir, extension := m.confDir()
+	return fmt.Sprintf("%s/%s%s", dir, name, extension)
+}
+
+// enableAutostart flip autostart of installed program in its file, supervisord reads it on boot.
+func (m *SupervisordServiceManager) enableAutostart(name string) error {
+	file := m.ServiceFile(name)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(data), "\nautostart=false\n") {
+		return nil
+	}
+	program := strings.Replace(string(data), "\nautostart=false\n", "\nautostart=true\n", 1)
+	if err := writeServiceFile(file, program, 0600); err != nil {
+		return err
+	}
+	return m.Reload()
+}
+
+// Reload makes supervisord read changed program files, they take effect on the next start.
+func (m *SupervisordServiceManager) Reload() error {
+	return runServiceCommand("supervisorctl", "reread")
+}
+
+// supervisordProgram returns program section of service with output appended to its log.
+func supervisordProgram(c *service.Config) (string, error) {
+	executable, args, err := serviceCommand(c)
+	if err != nil {
+		return "", err
+	}
+	// supervisord splits command as shell does, "%" starts its own expansions.
+	command := strings.TrimSpace(shellQuote(executable) + " " + args)
+	command = strings.Replace(command, "%", "%%", -1)
+
+	program := fmt.Sprintf("; %s\n[program:%s]\n", c.Description, c.Name)
+	program += fmt.Sprintf("command=%s\n", command)
+	if c.UserName != "" {
+		program += fmt.Sprintf("user=%s\n", c.UserName)
+	}
+	if c.WorkingDirectory != "" {
+		program += fmt.Sprintf("directory=%s\n", c.WorkingDirectory)
+	}
+	if len(c.Environment) > 0 {
+		var env []string
+		for _, e := range c.Environment {
+			parts := strings.SplitN(e, "=", 2)
+			if len(parts) == 2 {
+				env = append(env, fmt.Sprintf("%s=\"%s\"", parts[0], strings.Replace(parts[1], "%", "%%", -1)))
+			}
+		}
+		program += fmt.Sprintf("environment=%s\n", strings.Join(env, ","))
+	}
+	// Autostart is enabled on start, so adding the program does not start it.
+	program += "autostart=false\nautorestart=true\nredirect_stderr=true\n"
+	program += fmt.Sprintf("stdout_logfile=%s\nstdout_logfile_maxbytes=0\n", serviceLogFile(c.Name))
+	return program, nil
+}
+
+// isSupervisordRunning parses supervisorctl status output, e.g. "pmm-linux-metrics-42000  RUNNING  pid 123, uptime 0:00:05".
+func isSupervisordRunning(output, name string) bool {
+	fields := strings.Fields(output)
+	return len(fields) > 1 && fields[0] == name && fields[1] == "RUNNING"
+}
@@ -0,0 +1,78 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"testing"
+
+	service "github.com/percona/kardianos-service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseServiceFile(t *testing.T) {
+	expected := &service.Config{
+		Name:        "pmm-linux-metrics-42000",
+		DisplayName: "PMM Prometheus node_exporter 42000",
+		Description: "PMM Prometheus node_exporter 42000",
+		Executable:  "/usr/local/percona/pmm-client/node_exporter",
+		Arguments:   []string{"-web.listen-address=192.168.56.10:42000", "-web.auth-file=/usr/local/percona/pmm-client/pmm.yml"},
+		Environment: []string{`DATA_SOURCE_NAME=pmm:"secret"@tcp(localhost:3306)/`},
+	}
+
+	systemd := `[Unit]
+Description=PMM Prometheus node_exporter 42000
+ConditionFileIsExecutable=/usr/local/percona/pmm-client/node_exporter
+After=network.target
+
+[Service]
+ExecStart=/bin/sh -c '/usr/local/percona/pmm-client/node_exporter -web.listen-address=192.168.56.10:42000 -web.auth-file=/usr/local/percona/pmm-client/pmm.yml >> /var/log/pmm-linux-metrics-42000.log 2>&1'
+User=pmm
+Environment="DATA_SOURCE_NAME=pmm:\"secret\"@tcp(localhost:3306)/"
+Restart=always
+`
+	c := *expected
+	c.DisplayName = c.Name
+	c.UserName = "pmm"
+	assert.Equal(t, &c, parseServiceFile("pmm-linux-metrics-42000", []byte(systemd)))
+
+	upstart := `# PMM Prometheus node_exporter 42000
+
+description "PMM Prometheus node_exporter 42000"
+
+env "DATA_SOURCE_NAME=pmm:\"secret\"@tcp(localhost:3306)/"
+
+# Start
+exec /usr/local/percona/pmm-client/node_exporter -web.listen-address=192.168.56.10:42000 -web.auth-file=/usr/local/percona/pmm-client/pmm.yml >> /var/log/pmm-linux-metrics-42000.log 2>&1
+`
+	c = *expected
+	c.Description = c.Name
+	assert.Equal(t, &c, parseServiceFile("pmm-linux-metrics-42000", []byte(upstart)))
+
+	sysv := `#!/bin/sh
+# Short-Description: PMM Prometheus node_exporter 42000
+# Description:       PMM Prometheus node_exporter 42000
+### END INIT INFO
+
+cmd='/usr/local/percona/pmm-client/node_exporter -web.listen-address=192.168.56.10:42000 -web.auth-file=/usr/local/percona/pmm-client/pmm.yml'
+
+export "DATA_SOURCE_NAME=pmm:\"secret\"@tcp(localhost:3306)/"
+`
+	assert.Equal(t, expected, parseServiceFile("pmm-linux-metrics-42000", []byte(sysv)))
+
+	assert.Nil(t, parseServiceFile("pmm-linux-metrics-42000", []byte("[Unit]\n")))
+}
@@ -0,0 +1,418 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	service "github.com/percona/kardianos-service"
+	"gopkg.in/yaml.v2"
+)
+
+// Service config options with systemd settings of monitoring services.
+// They are kept in the saved service config, so backup and conversion preserve them.
+const (
+	optionMemoryMax       = "MemoryMax"
+	optionCPUQuota        = "CPUQuota"
+	optionNice            = "Nice"
+	optionReadWritePaths  = "ReadWritePaths"
+	optionEnvironmentFile = "EnvironmentFile" // the file is optional, it is read by other service managers too
+)
+
+// ServiceLimits are resource limits of monitoring service, empty ones are not set.
+// They are supported with systemd only.
+type ServiceLimits struct {
+	MemoryMax string // e.g. 512M or 10%
+	CPUQuota  string // e.g. 50%
+	Nice      string // -20 to 19
+}
+
+var (
+	memoryMaxRe = regexp.MustCompile(`^(\d+[KMGT]?|\d+%|infinity)$`)
+	cpuQuotaRe  = regexp.MustCompile(`^\d+%$`)
+)
+
+// validate checks limits have the values systemd accepts.
+func (l ServiceLimits) validate() error {
+	if l.MemoryMax != "" && !memoryMaxRe.MatchString(l.MemoryMax) {
+		return fmt.Errorf("Invalid memory limit %s, it should be bytes with optional K, M, G, T suffix, percentage or infinity.", l.MemoryMax)
+	}
+	if l.CPUQuota != "" && !cpuQuotaRe.MatchString(l.CPUQuota) {
+		return fmt.Errorf("Invalid CPU quota %s, it should be percentage, e.g. 50%%.", l.CPUQuota)
+	}
+	if l.Nice != "" {
+		if n, err := strconv.Atoi(l.Nice); err != nil || n < -20 || n > 19 {
+			return fmt.Errorf("Invalid nice level %s, it should be from -20 to 19.", l.Nice)
+		}
+	}
+	return nil
+}
+
+// apply sets limits to service config options.
+func (l ServiceLimits) apply(svcConfig *service.Config) {
+	for key, value := range map[string]string{optionMemoryMax: l.MemoryMax, optionCPUQuota: l.CPUQuota, optionNice: l.Nice} {
+		if value == "" {
+			continue
+		}
+		if svcConfig.Option == nil {
+			svcConfig.Option = service.KeyValue{}
+		}
+		svcConfig.Option[key] = value
+	}
+}
+
+// optionString returns string option of service config.
+func optionString(svcConfig *service.Config, key string) string {
+	s, _ := svcConfig.Option[key].(string)
+	return s
+}
+
+// hasLimits returns true if service config has resource limits set.
+func hasLimits(svcConfig *service.Config) bool {
+	return optionString(svcConfig, optionMemoryMax) != "" || optionString(svcConfig, optionCPUQuota) != "" || optionString(svcConfig, optionNice) != ""
+}
+
+// systemdDropIn returns systemd unit drop-in with sandboxing of service running unprivileged, its resource limits
+// and environment file, or empty string if there is nothing to set.
+func systemdDropIn(svcConfig *service.Config) string {
+	var lines []string
+	if svcConfig.UserName != "" {
+		lines = append(lines,
+			"NoNewPrivileges=true",
+			"PrivateTmp=true",
+			"ProtectSystem=full",
+			"ProtectHome=read-only",
+			"CapabilityBoundingSet=",
+		)
+		// ReadWriteDirectories is understood by older systemd too.
+		if paths := optionString(svcConfig, optionReadWritePaths); paths != "" {
+			lines = append(lines, "ReadWriteDirectories="+paths)
+		}
+	}
+	for _, key := range []string{optionMemoryMax, optionCPUQuota, optionNice} {
+		if value := optionString(svcConfig, key); value != "" {
+			lines = append(lines, key+"="+value)
+		}
+	}
+	if file := optionString(svcConfig, optionEnvironmentFile); file != "" {
+		lines = append(lines, "EnvironmentFile=-"+file)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "# Created by pmm-admin.\n[Service]\n" + strings.Join(lines, "\n") + "\n"
+}
+
+// systemdDropInFile returns drop-in file of systemd unit file.
+func systemdDropInFile(unitFile string) string {
+	return unitFile + ".d/pmm.conf"
+}
+
+// prepareService sets the service user and resource limits of service config
+// and gives the user access to the files the service needs.
+func (a *Admin) prepareService(svcConfig *service.Config) error {
+	if a.Config != nil && svcConfig.UserName == "" {
+		svcConfig.UserName = a.Config.ServiceUser
+	}
+	if err := a.Limits.validate(); err != nil {
+		return err
+	}
+	a.Limits.apply(svcConfig)
+	if hasLimits(svcConfig) && a.serviceManager().Name() != "linux-systemd" {
+		return errors.New("Resource limits of services are supported with systemd only.")
+	}
+	if svcConfig.UserName == "" {
+		return nil
+	}
+
+	// qan-agent keeps its data under its base dir.
+	if strings.HasPrefix(svcConfig.Executable, a.paths().AgentBaseDir+"/") {
+		if svcConfig.Option == nil {
+			svcConfig.Option = service.KeyValue{}
+		}
+		svcConfig.Option[optionReadWritePaths] = a.paths().AgentBaseDir
+	}
+	if err := a.chownServiceFiles(svcConfig.UserName); err != nil {
+		return err
+	}
+	// Services append their output to the log, so it should be writable before the start.
+	return chownFile(serviceLogFile(svcConfig.Name), svcConfig.UserName, true)
+}
+
+// qanAgentDataDirs are dirs under AgentBaseDir qan-agent writes to.
+// Its binaries under bin/ are run by root, so they and the base dir are left owned by root.
+var qanAgentDataDirs = []string{"config", "instance", "data"}
+
+// chownServiceFiles gives service user the SSL certificate, the auth file,
+// and qan-agent data dirs and log files.
+func (a *Admin) chownServiceFiles(username string) error {
+	for _, file := range []string{a.paths().SSLCertFile, a.paths().SSLKeyFile, a.paths().AuthFile} {
+		if err := chownFile(file, username, false); err != nil {
+			return err
+		}
+	}
+	if !FileExists(a.paths().AgentBaseDir) {
+		return nil
+	}
+	u, err := lookupServiceUser(username)
+	if err != nil {
+		return err
+	}
+	for _, name := range qanAgentDataDirs {
+		dir := filepath.Join(a.paths().AgentBaseDir, name)
+		// qan-agent can't create missing dirs in the base dir owned by root.
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			return os.Lchown(path, u.uid, u.gid)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, src := range a.qanAgentLogSources() {
+		if err := os.Lchown(src.File, u.uid, u.gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serviceUser is uid and gid of system account.
+type serviceUser struct {
+	uid, gid int
+}
+
+// lookupServiceUser returns uid and gid of system account.
+func lookupServiceUser(username string) (serviceUser, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return serviceUser{}, fmt.Errorf("System user %s does not exist, run 'pmm-admin service-user %s' to create it.", username, username)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return serviceUser{}, err
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return serviceUser{}, err
+	}
+	return serviceUser{uid, gid}, nil
+}
+
+// chownFile gives file to system account, it is created if missing and create is set, skipped otherwise.
+func chownFile(file, username string, create bool) error {
+	if !FileExists(file) {
+		if !create {
+			return nil
+		}
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			return err
+		}
+		f.Close()
+	}
+	u, err := lookupServiceUser(username)
+	if err != nil {
+		return err
+	}
+	return os.Chown(file, u.uid, u.gid)
+}
+
+// ensureServiceUser creates system account without login and home dir if it does not exist.
+// PMMUser is created with its own PMMGroup, so it can read the public copy of the config file.
+func ensureServiceUser(username string) error {
+	if _, err := user.Lookup(username); err == nil {
+		return nil
+	}
+	var cmd *exec.Cmd
+	if _, err := exec.LookPath("useradd"); err == nil {
+		args := []string{"--system", "--no-create-home", "--home-dir", "/nonexistent", "--shell", "/sbin/nologin"}
+		if _, err := user.LookupGroup(username); err == nil {
+			args = append(args, "--gid", username)
+		} else {
+			args = append(args, "--user-group")
+		}
+		cmd = exec.Command("useradd", append(args, username)...)
+	} else {
+		// BusyBox on Alpine creates the group of the same name by default.
+		cmd = exec.Command("adduser", "-S", "-D", "-H", "-s", "/sbin/nologin", username)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("Unable to create system user %s: %w, %s", username, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// authFile returns the file with HTTP basic auth credentials for metric services:
+// the scoped one if services run unprivileged, the config file otherwise.
+func (a *Admin) authFile() string {
+	if a.Config != nil && a.Config.ServiceUser != "" {
+		return a.paths().AuthFile
+	}
+	return a.paths().ConfigFile
+}
+
+// writeAuthFile writes HTTP basic auth credentials of all servers readable by the service user only,
+// or removes the file if services run as root.
+func (a *Admin) writeAuthFile(config Config) error {
+	file := a.paths().AuthFile
+	if config.ServiceUser == "" {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	auth := newAuthConfig(config.serverProfile())
+	for name, p := range config.ServerProfiles {
+		if auth.ServerProfiles == nil {
+			auth.ServerProfiles = map[string]authConfig{}
+		}
+		auth.ServerProfiles[name] = newAuthConfig(p)
+	}
+	bytes, err := yaml.Marshal(auth)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, bytes, 0600); err != nil {
+		return err
+	}
+	return chownFile(file, config.ServiceUser, false)
+}
+
+// authConfig is the part of the config with credentials written to the auth file:
+// HTTP basic auth credentials and Consul ACL token serve-metrics uses to update health checks.
+// Server profiles are kept as serve-metrics accepts credentials of every server.
+type authConfig struct {
+	ServerUser     string                `yaml:"server_user,omitempty"`
+	ServerPassword string                `yaml:"server_password,omitempty"`
+	ConsulToken    string                `yaml:"consul_token,omitempty"`
+	ServerProfiles map[string]authConfig `yaml:"server_profiles,omitempty"`
+}
+
+// newAuthConfig returns credentials of the server. Consul ACL token is read from the token file
+// as it is readable by root only, an unreadable one is skipped.
+func newAuthConfig(p ServerProfile) authConfig {
+	auth := authConfig{ServerUser: p.ServerUser, ServerPassword: p.ServerPassword, ConsulToken: p.ConsulToken}
+	if p.ConsulTokenFile != "" {
+		auth.ConsulToken, _ = readConsulTokenFile(p.ConsulTokenFile)
+	}
+	return auth
+}
+
+// LoadAuthFile take credentials missing in the public copy of the config from the auth file,
+// so serve-metrics running unprivileged can access PMM server and Consul.
+// The file could also be the config file itself if services run as root.
+func (a *Admin) LoadAuthFile(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	auth := authConfig{}
+	if err := yaml.Unmarshal(data, &auth); err != nil {
+		return err
+	}
+	p := a.Config.serverProfile()
+	auth.apply(&p)
+	a.Config.setServerProfile(p)
+	for name, p := range a.Config.ServerProfiles {
+		auth.ServerProfiles[name].apply(&p)
+		a.Config.ServerProfiles[name] = p
+	}
+	return nil
+}
+
+// apply sets credentials to server profile if they are for its user.
+func (auth authConfig) apply(p *ServerProfile) {
+	if auth.ServerPassword != "" && auth.ServerUser == p.ServerUser {
+		p.ServerPassword = auth.ServerPassword
+	}
+	if auth.ConsulToken != "" {
+		p.ConsulToken = auth.ConsulToken
+		p.ConsulTokenFile = ""
+	}
+}
+
+// SetServiceUser makes monitoring services run as system account, or as root if username is empty or "root".
+// The account is created if missing. Installed services are reinstalled with sandboxing keeping their state,
+// so it also converts services installed by older pmm-admin. It returns the number of converted services.
+func (a *Admin) SetServiceUser(username string) (int, error) {
+	if username == "root" {
+		username = ""
+	}
+	if username != "" {
+		if err := ensureServiceUser(username); err != nil {
+			return 0, err
+		}
+	}
+	a.Config.ServiceUser = username
+	if err := a.writeConfig(); err != nil {
+		return 0, fmt.Errorf("Unable to write config file %s: %w", a.paths().ConfigFile, err)
+	}
+
+	var errs Errors
+	count := 0
+	for _, svcName := range a.GetLocalServices() {
+		svcConfig, err := a.loadServiceConfig(svcName)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w, please remove and add the service again", svcName, err))
+			continue
+		}
+		svcConfig.UserName = username
+		for i, arg := range svcConfig.Arguments {
+			for _, file := range []string{a.paths().ConfigFile, a.paths().AuthFile} {
+				arg = strings.Replace(arg, "web.auth-file="+file, "web.auth-file="+a.authFile(), 1)
+			}
+			svcConfig.Arguments[i] = arg
+		}
+
+		running := a.getServiceStatus(svcName)
+		if err := a.uninstallService(svcName); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", svcName, err))
+			continue
+		}
+		if err := a.installService(svcConfig); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w, please add the service again", svcName, err))
+			continue
+		}
+		if !running {
+			if err := a.stopService(svcName); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", svcName, err))
+			}
+		}
+		count++
+	}
+	if len(errs) > 0 {
+		return count, errs
+	}
+	return count, nil
+}
@@ -0,0 +1,170 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	service "github.com/percona/kardianos-service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceLimits(t *testing.T) {
+	assert.NoError(t, ServiceLimits{}.validate())
+	assert.NoError(t, ServiceLimits{MemoryMax: "512M", CPUQuota: "50%", Nice: "-5"}.validate())
+	assert.EqualError(t, ServiceLimits{MemoryMax: "512MB"}.validate(), "Invalid memory limit 512MB, it should be bytes with optional K, M, G, T suffix, percentage or infinity.")
+	assert.EqualError(t, ServiceLimits{CPUQuota: "0.5"}.validate(), "Invalid CPU quota 0.5, it should be percentage, e.g. 50%.")
+	assert.EqualError(t, ServiceLimits{Nice: "20"}.validate(), "Invalid nice level 20, it should be from -20 to 19.")
+
+	c := &service.Config{Name: "pmm-linux-metrics-42000"}
+	assert.Equal(t, "", systemdDropIn(c))
+
+	ServiceLimits{MemoryMax: "256M", Nice: "10"}.apply(c)
+	assert.Equal(t, service.KeyValue{"MemoryMax": "256M", "Nice": "10"}, c.Option)
+	assert.Equal(t, "# Created by pmm-admin.\n[Service]\nMemoryMax=256M\nNice=10\n", systemdDropIn(c))
+
+	c.UserName = "pmm"
+	c.Option[optionReadWritePaths] = "/usr/local/percona/qan-agent"
+	c.Option[optionEnvironmentFile] = "/usr/local/percona/qan-agent/config/proxy.env"
+	assert.Equal(t, `# Created by pmm-admin.
+[Service]
+NoNewPrivileges=true
+PrivateTmp=true
+ProtectSystem=full
+ProtectHome=read-only
+CapabilityBoundingSet=
+ReadWriteDirectories=/usr/local/percona/qan-agent
+MemoryMax=256M
+Nice=10
+EnvironmentFile=-/usr/local/percona/qan-agent/config/proxy.env
+`, systemdDropIn(c))
+	assert.Equal(t, "/etc/systemd/system/pmm-linux-metrics-42000.service.d/pmm.conf", systemdDropInFile("/etc/systemd/system/pmm-linux-metrics-42000.service"))
+}
+
+func TestAuthFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmm-auth")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	current, err := user.Current()
+	assert.NoError(t, err)
+
+	a := &Admin{Paths: Paths{BaseDir: dir}, Config: &Config{
+		ServerAddress:  "192.168.56.100",
+		ServerUser:     "pmm",
+		ServerPassword: "secret",
+		MySQLPassword:  "mysql-secret",
+		ServerProfiles: map[string]ServerProfile{"dr": {ServerAddress: "10.0.0.1", ServerUser: "dr", ServerPassword: "dr-secret"}},
+	}}
+	assert.Equal(t, filepath.Join(dir, "pmm.yml"), a.authFile())
+	assert.NoError(t, a.writeAuthFile(*a.Config))
+	assert.False(t, FileExists(filepath.Join(dir, "auth.yml")))
+
+	a.Config.ServiceUser = current.Username
+	assert.Equal(t, filepath.Join(dir, "auth.yml"), a.authFile())
+	assert.NoError(t, a.writeAuthFile(*a.Config))
+	data, err := ioutil.ReadFile(filepath.Join(dir, "auth.yml"))
+	assert.NoError(t, err)
+	assert.Equal(t, `server_user: pmm
+server_password: secret
+server_profiles:
+  dr:
+    server_user: dr
+    server_password: dr-secret
+`, string(data))
+	fi, err := os.Stat(filepath.Join(dir, "auth.yml"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode())
+
+	a.Config.ServiceUser = ""
+	assert.NoError(t, a.writeAuthFile(*a.Config))
+	assert.False(t, FileExists(filepath.Join(dir, "auth.yml")))
+}
+
+func TestChownServiceFiles(t *testing.T) {
+	nobody, err := user.Lookup("nobody")
+	if os.Getuid() != 0 || err != nil {
+		t.Skip("root and nobody user are required")
+	}
+	dir, err := ioutil.TempDir("", "pmm-chown")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a := &Admin{Paths: Paths{BaseDir: dir, AgentBaseDir: filepath.Join(dir, "qan-agent")}}
+	for _, name := range []string{"bin", "config", "instance"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(a.Paths.AgentBaseDir, name), 0755))
+	}
+	for _, name := range []string{"bin/percona-qan-agent-installer", "config/agent.conf", "agent.log"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(a.Paths.AgentBaseDir, name), nil, 0644))
+	}
+	if !assert.NoError(t, a.chownServiceFiles("nobody")) {
+		return
+	}
+
+	owner := func(name string) string {
+		fi, err := os.Lstat(filepath.Join(a.Paths.AgentBaseDir, name))
+		if err != nil {
+			return err.Error()
+		}
+		return strconv.Itoa(int(fi.Sys().(*syscall.Stat_t).Uid))
+	}
+	for _, name := range []string{"", "bin", "bin/percona-qan-agent-installer"} {
+		assert.Equal(t, "0", owner(name), name)
+	}
+	for _, name := range []string{"config", "config/agent.conf", "instance", "data", "agent.log"} {
+		assert.Equal(t, nobody.Uid, owner(name), name)
+	}
+}
+
+func TestLoadAuthFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmm-auth")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	current, err := user.Current()
+	assert.NoError(t, err)
+	tokenFile := filepath.Join(dir, "consul-token")
+	assert.NoError(t, ioutil.WriteFile(tokenFile, []byte("secret-token\n"), 0600))
+
+	config := Config{
+		ServerAddress:   "192.168.56.100",
+		ServerUser:      "pmm",
+		ServerPassword:  "secret",
+		ConsulTokenFile: tokenFile,
+		ServiceUser:     current.Username,
+		ServerProfiles:  map[string]ServerProfile{"dr": {ServerAddress: "10.0.0.1", ServerUser: "dr", ServerPassword: "dr-secret", ConsulToken: "dr-token"}},
+	}
+	a := &Admin{Paths: Paths{BaseDir: dir}}
+	if !assert.NoError(t, a.writeAuthFile(config)) {
+		return
+	}
+
+	public := config.publicConfig()
+	a.Config = &public
+	assert.NoError(t, a.LoadAuthFile(filepath.Join(dir, "auth.yml")))
+	assert.Equal(t, "secret", a.Config.ServerPassword)
+	assert.Equal(t, "secret-token", a.Config.ConsulToken)
+	assert.Equal(t, "", a.Config.ConsulTokenFile)
+	assert.Equal(t, "dr-secret", a.Config.ServerProfiles["dr"].ServerPassword)
+	assert.Equal(t, "dr-token", a.Config.ServerProfiles["dr"].ConsulToken)
+	assert.Equal(t, "10.0.0.1", a.Config.ServerProfiles["dr"].ServerAddress)
+}
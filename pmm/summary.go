@@ -0,0 +1,320 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultSummaryTimeout is the default timeout of a single summary collector.
+const DefaultSummaryTimeout = 30 * time.Second
+
+// summaryLogLines is the number of last lines of every log to include into summary.
+const summaryLogLines = 1000
+
+// summaryFile is a file of summary archive.
+type summaryFile struct {
+	Name string
+	Data []byte
+}
+
+// summaryCollector collects one or more files of summary archive.
+type summaryCollector struct {
+	Name    string
+	Collect func(ctx context.Context) ([]summaryFile, error)
+}
+
+// secretRegexps match passwords in DSNs, URIs, command-line flags, JSON and YAML.
+// Submatches are kept, the password between them is replaced.
+// Passwords may have any characters, so DSN and URI ones are scrubbed up to the last @ before the host,
+// and values of password keys up to the closing quote or the end of line.
+var secretRegexps = []*regexp.Regexp{
+	regexp.MustCompile(`([\w.%-]+:)\S.*(@(?:tcp|unix)?\()`),
+	regexp.MustCompile(`(\w+://[^:/@\s]+:)\S.*(@)`),
+	regexp.MustCompile(`(?i)("?[\w.-]*(?:password|passwd|secret|token)"?\s*[:=]\s*")(?:[^"\\]|\\.)*(")`),
+	regexp.MustCompile(`(?i)("?[\w.-]*(?:password|passwd|secret|token)"?\s*[:=]\s*')(?:[^']|'')*(')`),
+	regexp.MustCompile(`(?i)("?[\w.-]*(?:password|passwd|secret|token)"?\s*[:=]\s*)[^\s"'].*()`),
+}
+
+// scrubSecrets replace passwords with "***".
+func scrubSecrets(data []byte) []byte {
+	for _, re := range secretRegexps {
+		data = re.ReplaceAll(data, []byte("${1}***${2}"))
+	}
+	return data
+}
+
+// Summary collect diagnostic data into tar.gz archive with passwords scrubbed.
+// Every collector has its own timeout, failed ones leave an error file in the archive and are returned as errors.
+// APIs are expected to be set with SetAPI, PMM server data is skipped otherwise.
+func (a *Admin) Summary(ctx context.Context, filename string, timeout time.Duration) error {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+
+	now := time.Now()
+	dir := fmt.Sprintf("pmm-summary-%s-%s", a.Config.ClientName, now.Format("20060102-150405"))
+	var errs Errors
+	for _, c := range a.summaryCollectors() {
+		files, err := runSummaryCollector(ctx, c, timeout)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.Name, err))
+			files = append(files, summaryFile{Name: c.Name + ".error", Data: []byte(err.Error() + "\n")})
+		}
+		for _, file := range files {
+			data := scrubSecrets(file.Data)
+			hdr := &tar.Header{
+				Name:    path.Join(dir, file.Name),
+				Mode:    0600,
+				Size:    int64(len(data)),
+				ModTime: now,
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if _, err := tw.Write(data); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// runSummaryCollector run collector and give up on it after timeout.
+func runSummaryCollector(ctx context.Context, c summaryCollector, timeout time.Duration) ([]summaryFile, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		files []summaryFile
+		err   error
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		files, err := c.Collect(ctx)
+		resCh <- result{files, err}
+	}()
+
+	select {
+	case res := <-resCh:
+		return res.files, res.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("timeout after %s", timeout)
+	}
+}
+
+// summaryCollectors returns collectors of summary archive.
+func (a *Admin) summaryCollectors() []summaryCollector {
+	return []summaryCollector{
+		{"system", a.collectSystem},
+		{"pmm.yml", a.collectConfig},
+		{"list", a.collectCommand("list.txt", "list")},
+		{"check-network", a.collectCommand("check-network.txt", "check-network")},
+		{"services", a.collectServices},
+		{"qan-agent", a.collectQANAgent},
+		{"logs", a.collectLogs},
+		{"consul", a.collectConsul},
+	}
+}
+
+// collectSystem collects OS and runtime info.
+func (a *Admin) collectSystem(ctx context.Context) ([]summaryFile, error) {
+	buf := &bytes.Buffer{}
+	hostname, _ := os.Hostname()
+	fmt.Fprintf(buf, "%-15s | %s\n", "PMM Client", Version)
+	fmt.Fprintf(buf, "%-15s | %s\n", "Hostname", hostname)
+	fmt.Fprintf(buf, "%-15s | %s/%s\n", "OS/Arch", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(buf, "%-15s | %s\n", "Go Version", runtime.Version())
+	fmt.Fprintf(buf, "%-15s | %s\n", "Service Manager", a.serviceManager().Name())
+	fmt.Fprintf(buf, "%-15s | %s\n", "Time", time.Now().Format(time.RFC3339))
+	if out, err := exec.CommandContext(ctx, "uname", "-a").Output(); err == nil {
+		fmt.Fprintf(buf, "%-15s | %s", "Kernel", out)
+	}
+	files := []summaryFile{{"system.txt", buf.Bytes()}}
+	if data, err := ioutil.ReadFile("/etc/os-release"); err == nil {
+		files = append(files, summaryFile{"os-release", data})
+	}
+	return files, nil
+}
+
+// collectConfig collects config file with passwords removed.
+func (a *Admin) collectConfig(ctx context.Context) ([]summaryFile, error) {
+	data, err := ioutil.ReadFile(a.paths().ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	for _, p := range []*string{&config.ServerPassword, &config.ServerProxyPassword, &config.MySQLPassword, &config.ConsulToken} {
+		if *p != "" {
+			*p = "***"
+		}
+	}
+	for name, p := range config.ServerProfiles {
+		for _, s := range []*string{&p.ServerPassword, &p.ServerProxyPassword, &p.ConsulToken} {
+			if *s != "" {
+				*s = "***"
+			}
+		}
+		config.ServerProfiles[name] = p
+	}
+	data, err = yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	return []summaryFile{{"pmm.yml", data}}, nil
+}
+
+// collectCommand returns collector of pmm-admin command output.
+// The command is run as a separate process to capture its output and not to exit on its errors.
+func (a *Admin) collectCommand(name string, args ...string) func(ctx context.Context) ([]summaryFile, error) {
+	return func(ctx context.Context) ([]summaryFile, error) {
+		bin, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		cmdArgs := append(append([]string{}, args...), "--config-file", a.paths().ConfigFile)
+		output, err := exec.CommandContext(ctx, bin, cmdArgs...).CombinedOutput()
+		if err != nil {
+			output = append(output, fmt.Sprintf("\n%s\n", err)...)
+		}
+		return []summaryFile{{name, output}}, nil
+	}
+}
+
+// collectServices collects system service files of monitoring services.
+func (a *Admin) collectServices(ctx context.Context) ([]summaryFile, error) {
+	m := a.serviceManager()
+	var files []summaryFile
+	for _, svcName := range a.GetLocalServices() {
+		file := m.ServiceFile(svcName)
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return files, err
+		}
+		name := svcName + strings.TrimPrefix(filepath.Base(file), svcName)
+		files = append(files, summaryFile{path.Join("services", name), data})
+	}
+	return files, nil
+}
+
+// collectQANAgent collects qan-agent config and instance files.
+func (a *Admin) collectQANAgent(ctx context.Context) ([]summaryFile, error) {
+	found, _ := filepath.Glob(a.paths().AgentBaseDir + "/config/*.conf")
+	instances, _ := filepath.Glob(a.paths().AgentBaseDir + "/instance/*.json")
+	var files []summaryFile
+	for _, f := range append(found, instances...) {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return files, err
+		}
+		rel, _ := filepath.Rel(a.paths().AgentBaseDir, f)
+		files = append(files, summaryFile{path.Join("qan-agent", filepath.ToSlash(rel)), data})
+	}
+	return files, nil
+}
+
+// collectLogs collects last lines of logs of monitoring services and qan-agent.
+func (a *Admin) collectLogs(ctx context.Context) ([]summaryFile, error) {
+	var sources []logSource
+	for _, svcName := range a.GetLocalServices() {
+		src, err := a.serviceLogSource(svcName)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, src)
+	}
+	sources = append(sources, a.qanAgentLogSources()...)
+
+	var files []summaryFile
+	for _, src := range sources {
+		buf := &bytes.Buffer{}
+		if err := printLogs(ctx, []logSource{src}, LogOptions{Lines: summaryLogLines}, buf, false); err != nil {
+			return files, err
+		}
+		name := src.Name
+		if src.File != "" {
+			name = filepath.Base(src.File)
+		}
+		files = append(files, summaryFile{path.Join("logs", name), buf.Bytes()})
+	}
+	return files, nil
+}
+
+// collectConsul collects Consul catalog node and KV tree of this client.
+func (a *Admin) collectConsul(ctx context.Context) ([]summaryFile, error) {
+	if a.consulAPI == nil {
+		return nil, errors.New("PMM server is not reachable")
+	}
+	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, consulQuery(ctx))
+	if err != nil {
+		return nil, err
+	}
+	nodeData, err := json.MarshalIndent(node, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	files := []summaryFile{{"consul/node.json", nodeData}}
+
+	kvs, _, err := a.consulAPI.KV().List(consulNodePrefix(a.Config.ClientName), consulQuery(ctx))
+	if err != nil {
+		return files, err
+	}
+	kv := map[string]string{}
+	for _, kvp := range kvs {
+		kv[kvp.Key] = string(kvp.Value)
+	}
+	kvData, err := json.MarshalIndent(kv, "", "  ")
+	if err != nil {
+		return files, err
+	}
+	return append(files, summaryFile{"consul/kv.json", kvData}), nil
+}
@@ -0,0 +1,125 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// TimeDrift is the result of time synchronization check between NTP server, PMM server and client.
+// Drifts are absolute values, NTP ones are set only if NTPServer is not empty.
+type TimeDrift struct {
+	NTPServer         string        // NTP server that responded
+	NTPError          error         // error of the last NTP server if none responded
+	NTPTime           time.Time     // NTP time at the moment of ClientTime
+	ServerTime        time.Time     // PMM server time from X-Server-Time header
+	ClientTime        time.Time     // PMM client time at the moment server reported its time
+	RTT               time.Duration // round trip of the request to PMM server
+	ServerDrift       time.Duration // PMM server to NTP server
+	ClientDrift       time.Duration // PMM client to NTP server
+	ClientServerDrift time.Duration // PMM client to PMM server
+	Threshold         time.Duration // maximum allowed drift
+}
+
+// serverTimeResolution is the resolution of X-Server-Time header.
+const serverTimeResolution = time.Second
+
+// CheckTimeDrift measure time drift between PMM client, PMM server and NTP servers if not disabled.
+// PMM server time is taken from X-Server-Time header and compensated by half of the request round trip.
+func (a *Admin) CheckTimeDrift(ctx context.Context) (*TimeDrift, error) {
+	td := &TimeDrift{Threshold: a.Config.TimeDriftThreshold}
+	if td.Threshold == 0 {
+		td.Threshold = DefaultTimeDriftThreshold
+	}
+
+	// Measure round trip of a single request, a connection may be established beforehand.
+	conn := &networkTransport{rtp: a.qanAPI.Client().Transport}
+	client := &http.Client{Transport: conn, Timeout: a.Timeout}
+	req, err := http.NewRequest("GET", a.qanAPI.URL(a.serverURL, "v1/status/leader"), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	t := resp.Header.Get("X-Server-Time")
+	if t == "" {
+		return nil, errors.New("PMM server does not report its time.")
+	}
+	td.ServerTime, err = parseServerTime(t)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse PMM server time %s: %w", t, err)
+	}
+
+	// Server reported the time truncated to seconds around the middle of the round trip.
+	td.RTT = conn.reqEnd.Sub(conn.reqStart)
+	td.ClientTime = conn.reqStart.Add(td.RTT / 2)
+	td.ClientServerDrift = absDuration(td.ServerTime.Add(serverTimeResolution / 2).Sub(td.ClientTime))
+
+	if a.Config.NTPDisabled {
+		return td, nil
+	}
+	servers := a.Config.NTPServers
+	if len(servers) == 0 {
+		servers = []string{DefaultNTPServer}
+	}
+	for _, server := range servers {
+		// ClockOffset is already compensated by NTP round trip.
+		r, err := ntp.QueryWithOptions(server, ntp.QueryOptions{Timeout: a.Timeout})
+		if err == nil && r.Stratum == 0 {
+			err = fmt.Errorf("NTP server %s is not synchronized", server)
+		}
+		if err != nil {
+			td.NTPError = err
+			continue
+		}
+		td.NTPServer = server
+		td.NTPError = nil
+		td.NTPTime = td.ClientTime.Add(r.ClockOffset)
+		td.ClientDrift = absDuration(r.ClockOffset)
+		td.ServerDrift = absDuration(td.ServerTime.Add(serverTimeResolution / 2).Sub(td.NTPTime))
+		break
+	}
+	return td, nil
+}
+
+// parseServerTime parse X-Server-Time header value, either unix timestamp or nginx time.
+func parseServerTime(t string) (time.Time, error) {
+	if s, err := strconv.ParseInt(t, 10, 64); err == nil {
+		return time.Unix(s, 0), nil
+	}
+	return time.Parse("Monday, 02-Jan-2006 15:04:05 MST", t)
+}
+
+// absDuration returns absolute value of duration.
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,45 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseServerTime(t *testing.T) {
+	expected := time.Date(2017, 6, 1, 10, 20, 30, 0, time.UTC)
+
+	ts, err := parseServerTime("1496312430")
+	assert.Nil(t, err)
+	assert.True(t, expected.Equal(ts))
+
+	ts, err = parseServerTime("Thursday, 01-Jun-2017 10:20:30 UTC")
+	assert.Nil(t, err)
+	assert.True(t, expected.Equal(ts))
+
+	_, err = parseServerTime("yesterday")
+	assert.Error(t, err)
+}
+
+func TestAbsDuration(t *testing.T) {
+	assert.Equal(t, time.Second, absDuration(-time.Second))
+	assert.Equal(t, time.Second, absDuration(time.Second))
+}
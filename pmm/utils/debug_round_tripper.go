@@ -48,6 +48,17 @@ func (v *verboseRoundTripper) RoundTrip(req *http.Request) (resp *http.Response,
 	return resp, err
 }
 
+// CancelRequest cancels an in-flight request if parent supports it,
+// so the wrapper can be used by clients that require it (e.g. Prometheus API).
+func (v *verboseRoundTripper) CancelRequest(req *http.Request) {
+	type canceler interface {
+		CancelRequest(*http.Request)
+	}
+	if c, ok := v.parent.(canceler); ok {
+		c.CancelRequest(req)
+	}
+}
+
 // dumpRequest returns string representation of request
 func dumpRequest(req *http.Request) string {
 	reqDump, err := httputil.DumpRequestOut(req, true)
@@ -32,7 +32,7 @@ type FakeApi struct {
 }
 
 func New() *FakeApi {
-	fakeApi := &FakeApi{}
+	fakeApi := &FakeApi{ctx: context.Background()}
 	fakeApi.serveMux = http.NewServeMux()
 	fakeApi.testServer = httptest.NewServer(fakeApi.serveMux)
 	return fakeApi
@@ -146,7 +146,10 @@ func (f *FakeApi) AppendConsulV1CatalogRegister() {
 			if err != nil {
 				panic(fmt.Sprintf("error unmarshaling body: %s", err))
 			}
-			v[rr.Service.Service] = rr
+			// Health checks are registered without service.
+			if rr.Service != nil {
+				v[rr.Service.Service] = rr
+			}
 
 			w.WriteHeader(http.StatusOK)
 		default:
@@ -169,6 +172,32 @@ func (f *FakeApi) AppendConsulV1KV() {
 	})
 }
 
+func (f *FakeApi) AppendConsulV1Session() {
+	f.Append("/v1/session/", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PUT" && r.URL.Path == "/v1/session/create":
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"ID":"42"}`))
+		case r.Method == "PUT":
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("true"))
+		default:
+			w.WriteHeader(600)
+		}
+	})
+	f.Append("/v1/kv/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			w.WriteHeader(http.StatusNotFound)
+		case "PUT":
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("true"))
+		default:
+			w.WriteHeader(600)
+		}
+	})
+}
+
 func (f *FakeApi) AppendQanAPIInstances(protoInstances []*proto.Instance) {
 	instances := map[string]*proto.Instance{}
 	for i := range protoInstances {
@@ -0,0 +1,585 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package fakeserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func (s *Server) consulHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/v1/status/leader", s.handleLeader)
+	mux.HandleFunc("/v1/catalog/register", s.handleRegister)
+	mux.HandleFunc("/v1/catalog/deregister", s.handleDeregister)
+	mux.HandleFunc("/v1/catalog/nodes", s.handleNodes)
+	mux.HandleFunc("/v1/catalog/node/", s.handleNode)
+	mux.HandleFunc("/v1/catalog/service/", s.handleService)
+	mux.HandleFunc("/v1/health/node/", s.handleHealthNode)
+	mux.HandleFunc("/v1/kv/", s.handleKV)
+	mux.HandleFunc("/v1/session/create", s.handleSessionCreate)
+	mux.HandleFunc("/v1/session/destroy/", s.handleSessionDestroy)
+	mux.HandleFunc("/v1/session/renew/", s.handleSessionRenew)
+}
+
+// ACL is Consul ACL policy of a token. Rules are name prefixes, empty prefix matches all names.
+// Write access implies read access.
+type ACL struct {
+	NodeRead  []string
+	NodeWrite []string
+	KeyRead   []string
+	KeyWrite  []string
+}
+
+// SetACL enables Consul ACLs with default deny policy and the tokens, nil disables ACLs.
+// Like Consul, catalog reads are filtered while KV reads of denied keys fail with 403.
+func (s *Server) SetACL(tokens map[string]ACL) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acl = tokens
+}
+
+// allowed check if the request token has access to the name by rules of its policy, mutex should be held.
+func (s *Server) allowed(r *http.Request, name string, rules ...func(ACL) []string) bool {
+	if s.acl == nil {
+		return true
+	}
+	token := r.Header.Get("X-Consul-Token")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	policy, ok := s.acl[token]
+	if !ok {
+		return false
+	}
+	for _, rule := range rules {
+		for _, prefix := range rule(policy) {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func nodeRead(p ACL) []string  { return p.NodeRead }
+func nodeWrite(p ACL) []string { return p.NodeWrite }
+func keyRead(p ACL) []string   { return p.KeyRead }
+func keyWrite(p ACL) []string  { return p.KeyWrite }
+
+// permissionDenied write Consul 403 response.
+func permissionDenied(w http.ResponseWriter) {
+	http.Error(w, "Permission denied", http.StatusForbidden)
+}
+
+// Node returns Consul catalog node with its services, nil if there is no such node.
+func (s *Server) Node(name string) *api.CatalogNode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.catalogNode(name)
+}
+
+// Checks returns Consul health checks of node sorted by ID.
+func (s *Server) Checks(name string) []*api.HealthCheck {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.healthChecks(name)
+}
+
+// healthChecks returns copies of health checks of node sorted by ID, mutex should be held.
+func (s *Server) healthChecks(name string) []*api.HealthCheck {
+	checks := []*api.HealthCheck{}
+	n, ok := s.nodes[name]
+	if !ok {
+		return checks
+	}
+	for _, check := range n.checks {
+		checkCopy := *check
+		checks = append(checks, &checkCopy)
+	}
+	sort.Slice(checks, func(i, j int) bool { return checks[i].CheckID < checks[j].CheckID })
+	return checks
+}
+
+// KV returns Consul KV data.
+func (s *Server) KV() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kv := map[string]string{}
+	for k, v := range s.kv {
+		kv[k] = string(v)
+	}
+	return kv
+}
+
+// PutKV sets Consul KV key.
+func (s *Server) PutKV(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kv[key] = []byte(value)
+	s.index++
+}
+
+// catalogNode returns a copy of node, mutex should be held.
+func (s *Server) catalogNode(name string) *api.CatalogNode {
+	n, ok := s.nodes[name]
+	if !ok {
+		return nil
+	}
+	nodeCopy := n.node
+	cn := &api.CatalogNode{Node: &nodeCopy, Services: map[string]*api.AgentService{}}
+	for id, svc := range n.services {
+		svcCopy := *svc
+		cn.Services[id] = &svcCopy
+	}
+	return cn
+}
+
+// writeConsul write Consul read response with the query meta headers.
+func (s *Server) writeConsul(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("X-Consul-Index", fmt.Sprintf("%d", s.index))
+	w.Header().Set("X-Consul-LastContact", "0")
+	w.Header().Set("X-Consul-KnownLeader", "true")
+	writeJSON(w, http.StatusOK, v)
+}
+
+func (s *Server) handleLeader(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, "127.0.0.1:8300")
+}
+
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	reg := api.CatalogRegistration{}
+	if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if reg.Node == "" || reg.Address == "" {
+		http.Error(w, "Must provide node and address", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.allowed(r, reg.Node, nodeWrite) {
+		permissionDenied(w)
+		return
+	}
+	// Service check should be registered along with the service or after it.
+	if check := reg.Check; check != nil && check.ServiceID != "" {
+		known := reg.Service != nil && reg.Service.ID == check.ServiceID
+		if n, ok := s.nodes[reg.Node]; ok && n.services[check.ServiceID] != nil {
+			known = true
+		}
+		if !known {
+			http.Error(w, fmt.Sprintf("Unknown service '%s'", check.ServiceID), http.StatusInternalServerError)
+			return
+		}
+	}
+	s.index++
+	n, ok := s.nodes[reg.Node]
+	if !ok {
+		n = &node{services: map[string]*api.AgentService{}, checks: map[string]*api.HealthCheck{}}
+		n.node.CreateIndex = s.index
+		s.nodes[reg.Node] = n
+	}
+	n.node.Node = reg.Node
+	n.node.Address = reg.Address
+	n.node.Datacenter = "dc1"
+	if reg.TaggedAddresses != nil {
+		n.node.TaggedAddresses = reg.TaggedAddresses
+	}
+	if reg.NodeMeta != nil {
+		n.node.Meta = reg.NodeMeta
+	}
+	n.node.ModifyIndex = s.index
+	if svc := reg.Service; svc != nil {
+		if svc.ID == "" {
+			svc.ID = svc.Service
+		}
+		if old, ok := n.services[svc.ID]; ok {
+			svc.CreateIndex = old.CreateIndex
+		} else {
+			svc.CreateIndex = s.index
+		}
+		svc.ModifyIndex = s.index
+		n.services[svc.ID] = svc
+	}
+	if check := reg.Check; check != nil {
+		if check.Status == "" {
+			check.Status = api.HealthCritical
+		}
+		hc := &api.HealthCheck{
+			Node:        reg.Node,
+			CheckID:     check.CheckID,
+			Name:        check.Name,
+			Status:      check.Status,
+			Notes:       check.Notes,
+			Output:      check.Output,
+			ServiceID:   check.ServiceID,
+			ServiceName: check.ServiceName,
+		}
+		if svc := n.services[check.ServiceID]; svc != nil {
+			hc.ServiceTags = svc.Tags
+		}
+		n.checks[check.CheckID] = hc
+	}
+	writeJSON(w, http.StatusOK, true)
+}
+
+func (s *Server) handleDeregister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	dereg := api.CatalogDeregistration{}
+	if err := json.NewDecoder(r.Body).Decode(&dereg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.allowed(r, dereg.Node, nodeWrite) {
+		permissionDenied(w)
+		return
+	}
+	s.index++
+	if dereg.ServiceID == "" && dereg.CheckID == "" {
+		delete(s.nodes, dereg.Node)
+	} else if n, ok := s.nodes[dereg.Node]; ok {
+		// Checks of the service are removed with it.
+		if dereg.ServiceID != "" {
+			delete(n.services, dereg.ServiceID)
+			for id, check := range n.checks {
+				if check.ServiceID == dereg.ServiceID {
+					delete(n.checks, id)
+				}
+			}
+		}
+		delete(n.checks, dereg.CheckID)
+	}
+	writeJSON(w, http.StatusOK, true)
+}
+
+func (s *Server) handleNodes(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nodes := []*api.Node{}
+	for _, name := range s.nodeNames() {
+		if !s.allowed(r, name, nodeRead, nodeWrite) {
+			continue
+		}
+		n := s.nodes[name].node
+		nodes = append(nodes, &n)
+	}
+	s.writeConsul(w, nodes)
+}
+
+func (s *Server) handleNode(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := strings.TrimPrefix(r.URL.Path, "/v1/catalog/node/")
+	if !s.allowed(r, name, nodeRead, nodeWrite) {
+		s.writeConsul(w, nil)
+		return
+	}
+	s.writeConsul(w, s.catalogNode(name))
+}
+
+func (s *Server) handleService(w http.ResponseWriter, r *http.Request) {
+	service := strings.TrimPrefix(r.URL.Path, "/v1/catalog/service/")
+	tag := r.URL.Query().Get("tag")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	services := []*api.CatalogService{}
+	for _, name := range s.nodeNames() {
+		if !s.allowed(r, name, nodeRead, nodeWrite) {
+			continue
+		}
+		n := s.nodes[name]
+		for _, svc := range n.services {
+			if svc.Service != service || (tag != "" && !hasTag(svc.Tags, tag)) {
+				continue
+			}
+			services = append(services, &api.CatalogService{
+				Node:                     n.node.Node,
+				Address:                  n.node.Address,
+				Datacenter:               n.node.Datacenter,
+				TaggedAddresses:          n.node.TaggedAddresses,
+				NodeMeta:                 n.node.Meta,
+				ServiceID:                svc.ID,
+				ServiceName:              svc.Service,
+				ServiceAddress:           svc.Address,
+				ServiceTags:              svc.Tags,
+				ServicePort:              svc.Port,
+				ServiceEnableTagOverride: svc.EnableTagOverride,
+				CreateIndex:              svc.CreateIndex,
+				ModifyIndex:              svc.ModifyIndex,
+			})
+		}
+	}
+	s.writeConsul(w, services)
+}
+
+func (s *Server) handleHealthNode(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/v1/health/node/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.allowed(r, name, nodeRead, nodeWrite) {
+		s.writeConsul(w, []*api.HealthCheck{})
+		return
+	}
+	s.writeConsul(w, s.healthChecks(name))
+}
+
+func (s *Server) handleKV(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	q := r.URL.Query()
+	_, recurse := q["recurse"]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case "GET":
+		if _, ok := q["keys"]; ok {
+			keys := []string{}
+			for _, k := range s.kvKeys(key) {
+				if !s.allowed(r, k, keyRead, keyWrite) {
+					continue
+				}
+				if sep := q.Get("separator"); sep != "" {
+					if i := strings.Index(k[len(key):], sep); i != -1 {
+						k = k[:len(key)+i+len(sep)]
+					}
+				}
+				if len(keys) == 0 || keys[len(keys)-1] != k {
+					keys = append(keys, k)
+				}
+			}
+			if len(keys) == 0 {
+				http.NotFound(w, r)
+				return
+			}
+			s.writeConsul(w, keys)
+			return
+		}
+
+		keys := []string{key}
+		if recurse {
+			keys = s.kvKeys(key)
+		} else if !s.allowed(r, key, keyRead, keyWrite) {
+			permissionDenied(w)
+			return
+		}
+		pairs := api.KVPairs{}
+		for _, k := range keys {
+			if !s.allowed(r, k, keyRead, keyWrite) {
+				continue
+			}
+			if v, ok := s.kv[k]; ok {
+				pairs = append(pairs, &api.KVPair{Key: k, Value: v, Session: s.locks[k]})
+			}
+		}
+		if len(pairs) == 0 {
+			w.Header().Set("X-Consul-Index", fmt.Sprintf("%d", s.index))
+			http.NotFound(w, r)
+			return
+		}
+		s.writeConsul(w, pairs)
+	case "PUT":
+		if !s.allowed(r, key, keyWrite) {
+			permissionDenied(w)
+			return
+		}
+		value, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if session := q.Get("acquire"); session != "" {
+			if _, ok := s.sessions[session]; !ok {
+				http.Error(w, "invalid session", http.StatusInternalServerError)
+				return
+			}
+			if holder := s.locks[key]; holder != "" && holder != session {
+				writeJSON(w, http.StatusOK, false)
+				return
+			}
+			s.locks[key] = session
+		}
+		if session := q.Get("release"); session != "" {
+			if s.locks[key] != session {
+				writeJSON(w, http.StatusOK, false)
+				return
+			}
+			delete(s.locks, key)
+		}
+		// Modify indexes of keys are not kept, so check-and-set only checks if the key exists.
+		if cas := q.Get("cas"); cas != "" {
+			_, exists := s.kv[key]
+			if index, _ := strconv.ParseUint(cas, 10, 64); (index == 0) == exists {
+				writeJSON(w, http.StatusOK, false)
+				return
+			}
+		}
+		s.kv[key] = value
+		s.index++
+		writeJSON(w, http.StatusOK, true)
+	case "DELETE":
+		if !s.allowed(r, key, keyWrite) {
+			permissionDenied(w)
+			return
+		}
+		if recurse {
+			for _, k := range s.kvKeys(key) {
+				delete(s.kv, k)
+				delete(s.locks, k)
+			}
+		} else {
+			delete(s.kv, key)
+			delete(s.locks, key)
+		}
+		s.index++
+		writeJSON(w, http.StatusOK, true)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Locks returns Consul sessions by KV key they hold.
+func (s *Server) Locks() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make(map[string]string, len(s.locks))
+	for k, v := range s.locks {
+		res[k] = v
+	}
+	return res
+}
+
+func (s *Server) handleSessionCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// LockDelay is sent as a string, so only the used fields are decoded.
+	var req struct {
+		Name     string
+		TTL      string
+		Behavior string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entry := &api.SessionEntry{Name: req.Name, TTL: req.TTL, Behavior: req.Behavior}
+	if entry.Behavior == "" {
+		entry.Behavior = api.SessionBehaviorRelease
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index++
+	entry.ID = fmt.Sprintf("session-%d", s.index)
+	s.sessions[entry.ID] = entry
+	s.writeConsul(w, map[string]string{"ID": entry.ID})
+}
+
+func (s *Server) handleSessionDestroy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/v1/session/destroy/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.sessions[id]; ok {
+		for k, session := range s.locks {
+			if session != id {
+				continue
+			}
+			delete(s.locks, k)
+			if entry.Behavior == api.SessionBehaviorDelete {
+				delete(s.kv, k)
+			}
+		}
+		delete(s.sessions, id)
+		s.index++
+	}
+	writeJSON(w, http.StatusOK, true)
+}
+
+func (s *Server) handleSessionRenew(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/v1/session/renew/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.sessions[id]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s.writeConsul(w, []*api.SessionEntry{entry})
+}
+
+// kvKeys returns sorted keys with the prefix, mutex should be held.
+func (s *Server) kvKeys(prefix string) []string {
+	var keys []string
+	for k := range s.kv {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// nodeNames returns sorted node names, mutex should be held.
+func (s *Server) nodeNames() []string {
+	var names []string
+	for name := range s.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package fakeserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/percona/pmm-client/pmm/managed"
+)
+
+// gRPC codes pmm-managed reports errors with.
+const (
+	codeInvalidArgument = 3
+	codeNotFound        = 5
+	codeAlreadyExists   = 6
+)
+
+func (s *Server) managedHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/managed/v0/scrape-configs", s.handleScrapeConfigs)
+	mux.HandleFunc("/managed/v0/scrape-configs/", s.handleScrapeConfig)
+}
+
+// ScrapeConfigs returns pmm-managed scrape configs sorted by job name.
+func (s *Server) ScrapeConfigs() []managed.APIScrapeConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var configs []managed.APIScrapeConfig
+	for _, cfg := range s.sortedScrapeConfigs() {
+		configs = append(configs, *cfg)
+	}
+	return configs
+}
+
+// writeManagedError write error response of pmm-managed.
+func writeManagedError(w http.ResponseWriter, status, code int, format string, args ...interface{}) {
+	writeJSON(w, status, &managed.Error{Err: fmt.Sprintf(format, args...), Code: code})
+}
+
+func (s *Server) handleScrapeConfigs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, &managed.APIScrapeConfigsListResponse{ScrapeConfigs: s.sortedScrapeConfigs()})
+	case "POST":
+		req := managed.APIScrapeConfigsCreateRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ScrapeConfig == nil || req.ScrapeConfig.JobName == "" {
+			writeManagedError(w, http.StatusBadRequest, codeInvalidArgument, "invalid scrape config")
+			return
+		}
+		if _, ok := s.scrapeConfigs[req.ScrapeConfig.JobName]; ok {
+			writeManagedError(w, http.StatusConflict, codeAlreadyExists, "scrape config with job name %q already exist", req.ScrapeConfig.JobName)
+			return
+		}
+		s.scrapeConfigs[req.ScrapeConfig.JobName] = req.ScrapeConfig
+		writeJSON(w, http.StatusOK, struct{}{})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleScrapeConfig(w http.ResponseWriter, r *http.Request) {
+	// /managed/v0/scrape-configs/<job>[/static-targets]
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/managed/v0/scrape-configs/"), "/"), "/")
+	jobName := parts[0]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cfg, ok := s.scrapeConfigs[jobName]
+	if !ok {
+		writeManagedError(w, http.StatusNotFound, codeNotFound, "scrape config with job name %q not found", jobName)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "static-targets" {
+		req := managed.APIScrapeConfigsAddStaticTargetsRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeManagedError(w, http.StatusBadRequest, codeInvalidArgument, "%s", err)
+			return
+		}
+		if len(cfg.StaticConfigs) == 0 {
+			cfg.StaticConfigs = []*managed.APIStaticConfig{{}}
+		}
+		sc := cfg.StaticConfigs[0]
+		switch r.Method {
+		case "POST":
+			for _, t := range req.Targets {
+				if !hasTag(sc.Targets, t) {
+					sc.Targets = append(sc.Targets, t)
+				}
+			}
+		case "DELETE":
+			var targets []string
+			for _, t := range sc.Targets {
+				if !hasTag(req.Targets, t) {
+					targets = append(targets, t)
+				}
+			}
+			sc.Targets = targets
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, struct{}{})
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, map[string]*managed.APIScrapeConfig{"scrape_config": cfg})
+	case "DELETE":
+		delete(s.scrapeConfigs, jobName)
+		writeJSON(w, http.StatusOK, struct{}{})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// sortedScrapeConfigs returns scrape configs sorted by job name, mutex should be held.
+func (s *Server) sortedScrapeConfigs() []*managed.APIScrapeConfig {
+	configs := []*managed.APIScrapeConfig{}
+	for _, cfg := range s.scrapeConfigs {
+		configs = append(configs, cfg)
+	}
+	sort.Slice(configs, func(i, j int) bool { return configs[i].JobName < configs[j].JobName })
+	return configs
+}
@@ -0,0 +1,158 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package fakeserver
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+var (
+	// upQueryRe matches the queries of "up" series with optional label matchers and range.
+	upQueryRe = regexp.MustCompile(`^up(?:\{(.*)\})?(\[\w+\])?$`)
+	// labelMatcherRe matches label equality matcher.
+	labelMatcherRe = regexp.MustCompile(`(\w+)\s*=\s*"([^"]*)"`)
+)
+
+func (s *Server) prometheusHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/prometheus/api/v1/query", s.handleQuery)
+	mux.HandleFunc("/prometheus/api/v1/series", s.handleSeries)
+}
+
+// SetTargetUp sets the value of "up" series of Prometheus target by job and instance.
+// Targets of metric services registered on Consul are up by default.
+func (s *Server) SetTargetUp(job, instance string, up bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down[job+"/"+instance] = !up
+}
+
+// DeletedSeries returns the series matchers of delete requests.
+func (s *Server) DeletedSeries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.deletedSeries...)
+}
+
+// upSeries returns "up" series of Prometheus targets matching the labels, mutex should be held.
+// Every metric service registered on Consul is a target with its type as job and name as instance.
+func (s *Server) upSeries(match model.LabelSet) []model.Metric {
+	var series []model.Metric
+	for _, name := range s.nodeNames() {
+		for _, svc := range s.nodes[name].services {
+			if !strings.HasSuffix(svc.Service, ":metrics") {
+				continue
+			}
+			instance := name
+			for _, tag := range svc.Tags {
+				if strings.HasPrefix(tag, "alias_") {
+					instance = tag[6:]
+				}
+			}
+			m := model.Metric{
+				model.MetricNameLabel: "up",
+				"job":                 model.LabelValue(strings.Split(svc.Service, ":")[0]),
+				"instance":            model.LabelValue(instance),
+			}
+			matched := true
+			for k, v := range match {
+				if m[k] != v {
+					matched = false
+				}
+			}
+			if matched {
+				series = append(series, m)
+			}
+		}
+	}
+	return series
+}
+
+// parseMatchers parse label equality matchers.
+func parseMatchers(s string) model.LabelSet {
+	ls := model.LabelSet{}
+	for _, m := range labelMatcherRe.FindAllStringSubmatch(s, -1) {
+		ls[model.LabelName(m[1])] = model.LabelValue(m[2])
+	}
+	return ls
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	now := model.TimeFromUnixNano(time.Now().UnixNano())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result model.Value = model.Vector{}
+	if m := upQueryRe.FindStringSubmatch(strings.TrimSpace(r.Form.Get("query"))); m != nil {
+		series := s.upSeries(parseMatchers(m[1]))
+		if m[2] != "" {
+			matrix := model.Matrix{}
+			for _, metric := range series {
+				value := model.SampleValue(s.upValue(metric))
+				matrix = append(matrix, &model.SampleStream{Metric: metric, Values: []model.SamplePair{{Timestamp: now, Value: value}}})
+			}
+			result = matrix
+		} else {
+			vector := model.Vector{}
+			for _, metric := range series {
+				vector = append(vector, &model.Sample{Metric: metric, Value: model.SampleValue(s.upValue(metric)), Timestamp: now})
+			}
+			result = vector
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"resultType": result.Type().String(),
+			"result":     result,
+		},
+	})
+}
+
+// upValue returns the value of "up" series, mutex should be held.
+func (s *Server) upValue(metric model.Metric) float64 {
+	if s.down[string(metric["job"])+"/"+string(metric["instance"])] {
+		return 0
+	}
+	return 1
+}
+
+func (s *Server) handleSeries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted := 0
+	for _, match := range r.URL.Query()["match[]"] {
+		s.deletedSeries = append(s.deletedSeries, match)
+		deleted += len(s.upSeries(parseMatchers(match)))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   map[string]int{"numDeleted": deleted},
+	})
+}
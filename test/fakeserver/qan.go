@@ -0,0 +1,180 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package fakeserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/percona/pmm/proto"
+)
+
+func (s *Server) qanHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/qan-api/ping", s.handleQANPing)
+	mux.HandleFunc("/qan-api/instances", s.handleInstances)
+	mux.HandleFunc("/qan-api/instances/", s.handleInstances)
+	mux.HandleFunc("/qan-api/agents/", s.handleAgents)
+}
+
+// AddInstance adds QAN instance, UUID is generated if empty. It returns the instance UUID.
+// Agent instances should be added for agents registered with qan-agent installer.
+func (s *Server) AddInstance(in proto.Instance) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if in.UUID == "" {
+		in.UUID = newUUID()
+	}
+	s.instances[in.UUID] = in
+	return in.UUID
+}
+
+// Instances returns QAN instances including deleted ones sorted by UUID.
+func (s *Server) Instances() []proto.Instance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedInstances()
+}
+
+// Commands returns the commands sent to agent.
+func (s *Server) Commands(agentUUID string) []proto.Cmd {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]proto.Cmd(nil), s.commands[agentUUID]...)
+}
+
+func (s *Server) handleQANPing(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Percona-Qan-Api-Version", "fakeserver")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleInstances(w http.ResponseWriter, r *http.Request) {
+	uuid := strings.Trim(strings.TrimPrefix(r.URL.Path, "/qan-api/instances"), "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == "GET" && uuid == "":
+		// Lookup by type, name and parent_uuid.
+		q := r.URL.Query()
+		for _, in := range s.sortedInstances() {
+			if (q.Get("type") == "" || in.Subsystem == q.Get("type")) &&
+				(q.Get("name") == "" || in.Name == q.Get("name")) &&
+				(q.Get("parent_uuid") == "" || in.ParentUUID == q.Get("parent_uuid")) {
+				writeJSON(w, http.StatusOK, in)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	case r.Method == "GET":
+		in, ok := s.instances[uuid]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, in)
+	case r.Method == "POST" && uuid == "":
+		in := proto.Instance{}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, existing := range s.instances {
+			if existing.Subsystem == in.Subsystem && existing.Name == in.Name && existing.ParentUUID == in.ParentUUID && !deleted(existing) {
+				w.Header().Set("Location", s.URL()+"/qan-api/instances/"+existing.UUID)
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+		}
+		in.UUID = newUUID()
+		in.Created = time.Now().UTC()
+		s.instances[in.UUID] = in
+		w.Header().Set("Location", s.URL()+"/qan-api/instances/"+in.UUID)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "PUT":
+		if _, ok := s.instances[uuid]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		in := proto.Instance{}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		in.UUID = uuid
+		s.instances[uuid] = in
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "DELETE":
+		in, ok := s.instances[uuid]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		// QAN API keeps deleted instances to link historical data, they can be undeleted with PUT.
+		in.Deleted = time.Now().UTC()
+		s.instances[uuid] = in
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleAgents(w http.ResponseWriter, r *http.Request) {
+	// /qan-api/agents/<uuid>/<action>
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/qan-api/agents/"), "/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	uuid, action := parts[0], parts[1]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case action == "cmd" && r.Method == "PUT":
+		cmd := proto.Cmd{}
+		if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cmd.AgentUUID = uuid
+		s.commands[uuid] = append(s.commands[uuid], cmd)
+		writeJSON(w, http.StatusOK, cmd.Reply(nil))
+	case action == "status" && r.Method == "GET":
+		writeJSON(w, http.StatusOK, map[string]string{"agent": "Running"})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// sortedInstances returns instances sorted by UUID, mutex should be held.
+func (s *Server) sortedInstances() []proto.Instance {
+	var instances []proto.Instance
+	for _, in := range s.instances {
+		instances = append(instances, in)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].UUID < instances[j].UUID })
+	return instances
+}
+
+// deleted check if instance is deleted, undeleted ones have the deletion time set to the epoch.
+func deleted(in proto.Instance) bool {
+	return in.Deleted.Year() > 1970
+}
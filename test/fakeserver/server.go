@@ -0,0 +1,180 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+// Package fakeserver provides in-memory PMM server for tests.
+// It keeps the state of Consul catalog with health checks and KV, QAN API instances and agent commands,
+// pmm-managed scrape configs and Prometheus "up" series, so pmm-admin flows can run end-to-end without real server.
+package fakeserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/percona/pmm/proto"
+
+	"github.com/percona/pmm-client/pmm/managed"
+)
+
+// Server is in-memory PMM server listening on local address.
+// It is safe to use concurrently with requests being served.
+type Server struct {
+	srv *httptest.Server
+
+	mu             sync.Mutex
+	user, password string        // HTTP basic auth credentials, not required if empty
+	remoteIP       string        // reported in X-Remote-IP header, the client address if empty
+	timeOffset     time.Duration // added to the time reported in X-Server-Time header
+	index          uint64        // Consul raft index, incremented on every write
+	nodes          map[string]*node
+	kv             map[string][]byte
+	sessions       map[string]*api.SessionEntry
+	locks          map[string]string // Consul sessions by KV key they hold
+	acl            map[string]ACL    // Consul ACL policies by token, ACLs are disabled if nil
+	instances      map[string]proto.Instance
+	commands       map[string][]proto.Cmd
+	scrapeConfigs  map[string]*managed.APIScrapeConfig
+	down           map[string]bool // Prometheus targets by job/instance reported as down
+	deletedSeries  []string
+}
+
+// node is Consul catalog node with its services.
+type node struct {
+	node     api.Node
+	services map[string]*api.AgentService
+	checks   map[string]*api.HealthCheck
+}
+
+// New starts fake PMM server, it should be closed with Close.
+func New() *Server {
+	s := &Server{
+		index:         1,
+		nodes:         map[string]*node{},
+		kv:            map[string][]byte{},
+		sessions:      map[string]*api.SessionEntry{},
+		locks:         map[string]string{},
+		instances:     map[string]proto.Instance{},
+		commands:      map[string][]proto.Cmd{},
+		scrapeConfigs: map[string]*managed.APIScrapeConfig{},
+		down:          map[string]bool{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleRoot)
+	s.consulHandlers(mux)
+	s.qanHandlers(mux)
+	s.managedHandlers(mux)
+	s.prometheusHandlers(mux)
+	s.srv = httptest.NewServer(s.nginx(mux))
+	return s
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// URL returns base URL of the server.
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Address returns host:port of the server to configure pmm-admin with.
+func (s *Server) Address() string {
+	u, _ := url.Parse(s.srv.URL)
+	return u.Host
+}
+
+// SetCredentials enables HTTP basic authentication, empty user disables it.
+func (s *Server) SetCredentials(user, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user, s.password = user, password
+}
+
+// SetRemoteIP sets the address reported in X-Remote-IP header instead of the client address.
+func (s *Server) SetRemoteIP(ip string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remoteIP = ip
+}
+
+// SetTimeOffset sets the difference of the server time reported in X-Server-Time header from the real time.
+func (s *Server) SetTimeOffset(offset time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timeOffset = offset
+}
+
+// nginx adds the headers PMM server nginx adds and checks HTTP basic authentication.
+func (s *Server) nginx(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		user, password, remoteIP, now := s.user, s.password, s.remoteIP, time.Now().Add(s.timeOffset)
+		s.mu.Unlock()
+
+		if remoteIP == "" {
+			remoteIP, _, _ = net.SplitHostPort(r.RemoteAddr)
+		}
+		w.Header().Set("X-Remote-IP", remoteIP)
+		w.Header().Set("X-Server-Time", fmt.Sprintf("%d", now.Unix()))
+
+		if user != "" {
+			if u, p, ok := r.BasicAuth(); !ok || u != user || p != password {
+				w.Header().Set("WWW-Authenticate", `Basic realm="PMM Server"`)
+				http.Error(w, "401 Authorization Required", http.StatusUnauthorized)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeJSON write value as JSON response.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+// newUUID returns random UUID in the format of QAN API.
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}